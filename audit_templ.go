@@ -0,0 +1,521 @@
+// Code generated by templ@v0.2.334 DO NOT EDIT.
+
+package main
+
+//lint:file-ignore SA4006 This context is only used if a nested component is present.
+
+import "github.com/a-h/templ"
+import "context"
+import "io"
+import "bytes"
+
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+
+import (
+	"acb/db-interface/sheets"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+func auditValue(cell sheets.Cell) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_1 := templ.GetChildren(ctx)
+		if var_1 == nil {
+			var_1 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		if cell.NotNull {
+			var var_2 string = cell.Value
+			_, err = templBuffer.WriteString(templ.EscapeString(var_2))
+			if err != nil {
+				return err
+			}
+		} else {
+			_, err = templBuffer.WriteString("<span class=\"has-text-grey-light\">")
+			if err != nil {
+				return err
+			}
+			var_3 := `NULL`
+			_, err = templBuffer.WriteString(var_3)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</span>")
+			if err != nil {
+				return err
+			}
+		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
+
+func auditFilterSelect(name, label, selected string, values []string) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_4 := templ.GetChildren(ctx)
+		if var_4 == nil {
+			var_4 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		_, err = templBuffer.WriteString("<div class=\"select\"><select name=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString(name))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\" onchange=\"this.form.submit()\"><option value=\"\">")
+		if err != nil {
+			return err
+		}
+		var var_5 string = label
+		_, err = templBuffer.WriteString(templ.EscapeString(var_5))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</option>")
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			_, err = templBuffer.WriteString("<option value=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(value))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"")
+			if err != nil {
+				return err
+			}
+			if value == selected {
+				_, err = templBuffer.WriteString(" selected")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString(">")
+			if err != nil {
+				return err
+			}
+			var var_6 string = value
+			_, err = templBuffer.WriteString(templ.EscapeString(var_6))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</option>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</select></div>")
+		if err != nil {
+			return err
+		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
+
+func auditPageLink(filter sheets.AuditFilter, page int, text string) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_7 := templ.GetChildren(ctx)
+		if var_7 == nil {
+			var_7 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		_, err = templBuffer.WriteString("<a class=\"button\" href=\"")
+		if err != nil {
+			return err
+		}
+		var var_8 templ.SafeURL = templ.URL(fmt.Sprintf("/audit?sheet=%d&table=%s&user=%s&page=%d",
+			filter.SheetId, url.QueryEscape(filter.TableName), url.QueryEscape(filter.UserName), page))
+		_, err = templBuffer.WriteString(templ.EscapeString(string(var_8)))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\">")
+		if err != nil {
+			return err
+		}
+		var var_9 string = text
+		_, err = templBuffer.WriteString(templ.EscapeString(var_9))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</a>")
+		if err != nil {
+			return err
+		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
+
+func auditLog(user sheets.User, visible map[int]sheets.Sheet, filter sheets.AuditFilter, entries []sheets.AuditEntry, tableNames, userNames []string, page int, more bool) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_10 := templ.GetChildren(ctx)
+		if var_10 == nil {
+			var_10 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		_, err = templBuffer.WriteString("<!doctype html><html><head><link rel=\"stylesheet\" href=\"https://cdn.jsdelivr.net/npm/bulma@0.9.4/css/bulma.min.css\"><link rel=\"stylesheet\" href=\"/static/index.css\"></head><body><section class=\"section\"><form method=\"get\" action=\"/audit\" class=\"flex audit-filters\"><a href=\"/\" class=\"button\">")
+		if err != nil {
+			return err
+		}
+		var_11 := `Back to Sheets`
+		_, err = templBuffer.WriteString(var_11)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</a><div class=\"select\"><select name=\"sheet\" onchange=\"this.form.submit()\"><option value=\"0\">")
+		if err != nil {
+			return err
+		}
+		var_12 := `All sheets`
+		_, err = templBuffer.WriteString(var_12)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</option>")
+		if err != nil {
+			return err
+		}
+		for _, id := range filter.SheetIds {
+			_, err = templBuffer.WriteString("<option value=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(id)))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"")
+			if err != nil {
+				return err
+			}
+			if id == filter.SheetId {
+				_, err = templBuffer.WriteString(" selected")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString(">")
+			if err != nil {
+				return err
+			}
+			var var_13 string = visible[id].VisibleName()
+			_, err = templBuffer.WriteString(templ.EscapeString(var_13))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</option>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</select></div>")
+		if err != nil {
+			return err
+		}
+		err = auditFilterSelect("table", "All tables", filter.TableName, tableNames).Render(ctx, templBuffer)
+		if err != nil {
+			return err
+		}
+		err = auditFilterSelect("user", "All users", filter.UserName, userNames).Render(ctx, templBuffer)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</form><table class=\"table is-striped is-narrow audit-log\"><thead><tr><th>")
+		if err != nil {
+			return err
+		}
+		var_14 := `When`
+		_, err = templBuffer.WriteString(var_14)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</th><th>")
+		if err != nil {
+			return err
+		}
+		var_15 := `User`
+		_, err = templBuffer.WriteString(var_15)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</th><th>")
+		if err != nil {
+			return err
+		}
+		var_16 := `Sheet`
+		_, err = templBuffer.WriteString(var_16)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</th><th>")
+		if err != nil {
+			return err
+		}
+		var_17 := `Table`
+		_, err = templBuffer.WriteString(var_17)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</th><th>")
+		if err != nil {
+			return err
+		}
+		var_18 := `Row`
+		_, err = templBuffer.WriteString(var_18)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</th><th>")
+		if err != nil {
+			return err
+		}
+		var_19 := `Change`
+		_, err = templBuffer.WriteString(var_19)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</th><th>")
+		if err != nil {
+			return err
+		}
+		var_20 := `Column`
+		_, err = templBuffer.WriteString(var_20)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</th><th>")
+		if err != nil {
+			return err
+		}
+		var_21 := `Old Value`
+		_, err = templBuffer.WriteString(var_21)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</th><th>")
+		if err != nil {
+			return err
+		}
+		var_22 := `New Value`
+		_, err = templBuffer.WriteString(var_22)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</th></tr></thead><tbody>")
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			_, err = templBuffer.WriteString("<tr><td>")
+			if err != nil {
+				return err
+			}
+			var var_23 string = entry.ChangedAt.Format("2006-01-02 15:04:05")
+			_, err = templBuffer.WriteString(templ.EscapeString(var_23))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</td><td>")
+			if err != nil {
+				return err
+			}
+			var var_24 string = entry.UserName
+			_, err = templBuffer.WriteString(templ.EscapeString(var_24))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</td><td><a href=\"")
+			if err != nil {
+				return err
+			}
+			var var_25 templ.SafeURL = templ.URL(fmt.Sprintf("/?sheet_id=%d", entry.SheetId))
+			_, err = templBuffer.WriteString(templ.EscapeString(string(var_25)))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\">")
+			if err != nil {
+				return err
+			}
+			var var_26 string = visible[entry.SheetId].VisibleName()
+			_, err = templBuffer.WriteString(templ.EscapeString(var_26))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a></td><td>")
+			if err != nil {
+				return err
+			}
+			var var_27 string = entry.TableName
+			_, err = templBuffer.WriteString(templ.EscapeString(var_27))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</td><td>")
+			if err != nil {
+				return err
+			}
+			var var_28 string = entry.PrimaryKey
+			_, err = templBuffer.WriteString(templ.EscapeString(var_28))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</td><td>")
+			if err != nil {
+				return err
+			}
+			var var_29 string = entry.Action
+			_, err = templBuffer.WriteString(templ.EscapeString(var_29))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</td><td>")
+			if err != nil {
+				return err
+			}
+			var var_30 string = entry.ColumnName
+			_, err = templBuffer.WriteString(templ.EscapeString(var_30))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</td>")
+			if err != nil {
+				return err
+			}
+			if entry.ValuesHidden {
+				_, err = templBuffer.WriteString("<td colspan=\"2\" class=\"has-text-grey-light\">")
+				if err != nil {
+					return err
+				}
+				var_31 := `Hidden by database permissions`
+				_, err = templBuffer.WriteString(var_31)
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("</td>")
+				if err != nil {
+					return err
+				}
+			} else {
+				_, err = templBuffer.WriteString("<td>")
+				if err != nil {
+					return err
+				}
+				err = auditValue(entry.OldValue).Render(ctx, templBuffer)
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("</td> <td>")
+				if err != nil {
+					return err
+				}
+				err = auditValue(entry.NewValue).Render(ctx, templBuffer)
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("</td>")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString("</tr>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</tbody></table>")
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			_, err = templBuffer.WriteString("<p>")
+			if err != nil {
+				return err
+			}
+			var_32 := `No changes have been made through these sheets.`
+			_, err = templBuffer.WriteString(var_32)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</p>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("<div class=\"flex audit-filters\">")
+		if err != nil {
+			return err
+		}
+		if page > 0 {
+			err = auditPageLink(filter, page-1, "Newer").Render(ctx, templBuffer)
+			if err != nil {
+				return err
+			}
+		}
+		if more {
+			err = auditPageLink(filter, page+1, "Older").Render(ctx, templBuffer)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</div></section></body></html>")
+		if err != nil {
+			return err
+		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
@@ -0,0 +1,109 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// An OpenID Connect provider which users can log in through
+type Provider struct {
+	Issuer   string
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// The claims a user is named after, in order of preference
+type claims struct {
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email"`
+	Name              string `json:"name"`
+}
+
+// Discovers the provider's endpoints from issuer. The provider redirects back
+// to redirectURL, which is handled by the login callback.
+func NewProvider(ctx context.Context, issuer, clientID, clientSecret, redirectURL string) (*Provider, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		Issuer: issuer,
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+// Configures the provider from RS_OIDC_ISSUER, RS_OIDC_CLIENT_ID, RS_OIDC_CLIENT_SECRET
+// and RS_OIDC_REDIRECT_URL. Returns nil if RS_OIDC_ISSUER isn't set.
+func ProviderFromEnv(ctx context.Context) (*Provider, error) {
+	issuer := os.Getenv("RS_OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+	return NewProvider(ctx, issuer,
+		os.Getenv("RS_OIDC_CLIENT_ID"),
+		os.Getenv("RS_OIDC_CLIENT_SECRET"),
+		os.Getenv("RS_OIDC_REDIRECT_URL"))
+}
+
+// Where to send the user to log in. The provider returns state to the callback,
+// and includes nonce in the ID token.
+func (p *Provider) AuthCodeURL(state, nonce string) string {
+	return p.config.AuthCodeURL(state, oidc.Nonce(nonce))
+}
+
+// Exchanges the code passed to the callback for the ID token, and returns the
+// user's unique subject and a name for them
+func (p *Provider) Exchange(ctx context.Context, code, nonce string) (string, string, error) {
+	token, err := p.config.Exchange(ctx, code)
+	if err != nil {
+		return "", "", err
+	}
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", "", errors.New("no ID token returned")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIdToken)
+	if err != nil {
+		return "", "", err
+	}
+	if idToken.Nonce != nonce {
+		return "", "", errors.New("ID token has the wrong nonce")
+	}
+
+	c := claims{}
+	err = idToken.Claims(&c)
+	if err != nil {
+		return "", "", err
+	}
+	name := idToken.Subject
+	for _, claim := range []string{c.PreferredUsername, c.Email, c.Name} {
+		if claim != "" {
+			name = claim
+			break
+		}
+	}
+	return idToken.Issuer + " " + idToken.Subject, name, nil
+}
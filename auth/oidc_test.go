@@ -0,0 +1,179 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// A local OpenID Connect provider, which logs everyone in as the same user
+type standIn struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	codes  map[string]string // Nonces by code
+	claims map[string]interface{}
+}
+
+func newStandIn(t *testing.T) *standIn {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &standIn{key: key, codes: make(map[string]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                s.URL,
+			"authorization_endpoint":                s.URL + "/authorize",
+			"token_endpoint":                        s.URL + "/token",
+			"jwks_uri":                              s.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		code := RandomString()
+		s.codes[code] = r.FormValue("nonce")
+		redirect := r.FormValue("redirect_uri") + "?" + url.Values{
+			"code":  {code},
+			"state": {r.FormValue("state")},
+		}.Encode()
+		http.Redirect(w, r, redirect, http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		nonce, ok := s.codes[r.FormValue("code")]
+		if !ok {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		clientId, _, _ := r.BasicAuth()
+		claims := map[string]interface{}{
+			"iss":   s.URL,
+			"sub":   "42",
+			"aud":   clientId,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": nonce,
+		}
+		for k, v := range s.claims {
+			claims[k] = v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     s.sign(t, claims),
+		})
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *standIn) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// Follows the redirect to the provider and returns the code passed to the callback
+func authorize(t *testing.T, provider *Provider, state, nonce string) string {
+	client := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(provider.AuthCodeURL(state, nonce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback.Path != "/oidc/callback" || callback.Query().Get("state") != state {
+		t.Fatalf("Unexpected callback: %s", callback)
+	}
+	return callback.Query().Get("code")
+}
+
+func TestProvider(t *testing.T) {
+	s := newStandIn(t)
+	defer s.Close()
+	ctx := context.Background()
+	provider, err := NewProvider(ctx, s.URL, "sheets", "secret", "http://localhost:8080/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := authorize(t, provider, "state", "nonce")
+	subject, name, err := provider.Exchange(ctx, code, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != s.URL+" 42" || name != "42" {
+		t.Errorf("Unexpected user %s, %s", subject, name)
+	}
+
+	s.claims = map[string]interface{}{"email": "ann@example.com", "name": "Ann"}
+	code = authorize(t, provider, "state", "nonce")
+	_, name, err = provider.Exchange(ctx, code, "nonce")
+	if err != nil || name != "ann@example.com" {
+		t.Errorf("Unexpected name %s: %v", name, err)
+	}
+
+	code = authorize(t, provider, "state", "nonce")
+	_, _, err = provider.Exchange(ctx, code, "other nonce")
+	if err == nil {
+		t.Error("Accepted an ID token with the wrong nonce")
+	}
+
+	s.claims = map[string]interface{}{"aud": "other client"}
+	code = authorize(t, provider, "state", "nonce")
+	_, _, err = provider.Exchange(ctx, code, "nonce")
+	if err == nil {
+		t.Error("Accepted an ID token for another client")
+	}
+
+	_, _, err = provider.Exchange(ctx, "unknown code", "nonce")
+	if err == nil {
+		t.Error("Exchanged an unknown code")
+	}
+}
@@ -0,0 +1,118 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signs cookie values so that they can only have been set by the server
+type Signer struct {
+	key []byte
+}
+
+var ErrInvalidCookie = errors.New("invalid or expired cookie")
+
+func NewSigner(key []byte) Signer {
+	return Signer{key: key}
+}
+
+// Signs with RS_SESSION_KEY, or a random key if it isn't set, in which case
+// everyone is logged out when the server restarts
+func SignerFromEnv() Signer {
+	key := os.Getenv("RS_SESSION_KEY")
+	if key != "" {
+		return NewSigner([]byte(key))
+	}
+	log.Println("RS_SESSION_KEY is not set, sessions will end when the server restarts")
+	return NewSigner(randomBytes(32))
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Returns a random string, e.g. for the OIDC state and nonce
+func RandomString() string {
+	return hex.EncodeToString(randomBytes(16))
+}
+
+func (s Signer) mac(payload string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Signs value as <value>.<expiry>.<mac>, with the value base64 encoded
+func (s Signer) Sign(value string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(value)) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + s.mac(payload)
+}
+
+// Returns the value signed by Sign, unless it has expired by now
+func (s Signer) Verify(signed string, now time.Time) (string, error) {
+	i := strings.LastIndex(signed, ".")
+	if i == -1 || !hmac.Equal([]byte(signed[i+1:]), []byte(s.mac(signed[:i]))) {
+		return "", ErrInvalidCookie
+	}
+	encoded, expiresStr, _ := strings.Cut(signed[:i], ".")
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return "", ErrInvalidCookie
+	}
+	value, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	return string(value), nil
+}
+
+func (s Signer) SetCookie(w http.ResponseWriter, name, value string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    s.Sign(value, time.Now().Add(maxAge)),
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Returns the value of a cookie set by SetCookie
+func (s Signer) Cookie(r *http.Request, name string) (string, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	return s.Verify(cookie.Value, time.Now())
+}
+
+func ClearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{Name: name, Path: "/", MaxAge: -1})
+}
@@ -0,0 +1,52 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	signer := NewSigner([]byte("key"))
+	now := time.Now()
+	signed := signer.Sign("1.2 x", now.Add(time.Hour))
+
+	value, err := signer.Verify(signed, now)
+	if err != nil || value != "1.2 x" {
+		t.Errorf("Unexpected value %s: %v", value, err)
+	}
+
+	_, err = signer.Verify(signed, now.Add(2*time.Hour))
+	if err != ErrInvalidCookie {
+		t.Error("Accepted an expired value")
+	}
+
+	_, err = NewSigner([]byte("other key")).Verify(signed, now)
+	if err != ErrInvalidCookie {
+		t.Error("Accepted a value signed with another key")
+	}
+
+	forged := signer.Sign("2", now.Add(time.Hour))
+	_, err = signer.Verify(signed[:len(signed)-43]+forged[len(forged)-43:], now)
+	if err != ErrInvalidCookie {
+		t.Error("Accepted a value with another value's signature")
+	}
+
+	for _, invalid := range []string{"", ".", "a.b", "a.b.c.d"} {
+		_, err = signer.Verify(invalid, now)
+		if err != ErrInvalidCookie {
+			t.Errorf("Accepted %q", invalid)
+		}
+	}
+}
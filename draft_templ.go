@@ -0,0 +1,275 @@
+// Code generated by templ@v0.2.334 DO NOT EDIT.
+
+package main
+
+//lint:file-ignore SA4006 This context is only used if a nested component is present.
+
+import "github.com/a-h/templ"
+import "context"
+import "io"
+import "bytes"
+
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+
+import (
+	"acb/db-interface/sheets"
+	"fmt"
+	"strconv"
+)
+
+// Counts the draft's changes and commits or discards them, updating as cells are set
+
+func draftBar(sheet sheets.Sheet, numCols int) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_1 := templ.GetChildren(ctx)
+		if var_1 == nil {
+			var_1 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		_, err = templBuffer.WriteString("<tr id=\"draft-bar\" class=\"has-scrolling-content draft-bar\" hx-get=\"/draft\" hx-vals=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("{\"bar\":true,\"cols\":%d}", numCols)))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\" hx-trigger=\"draftChanged from:body\" hx-target=\"this\" hx-swap=\"outerHTML\"><td colspan=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(numCols)))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\" class=\"has-scrolling-content\"><div class=\"flex center scrolling-content-container\"><span>")
+		if err != nil {
+			return err
+		}
+		var_2 := `Draft: `
+		_, err = templBuffer.WriteString(var_2)
+		if err != nil {
+			return err
+		}
+		var var_3 string = strconv.Itoa(len(sheet.DraftChanges()))
+		_, err = templBuffer.WriteString(templ.EscapeString(var_3))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(" ")
+		if err != nil {
+			return err
+		}
+		var_4 := `changes`
+		_, err = templBuffer.WriteString(var_4)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</span><button hx-get=\"/draft\" hx-vals=\"{&#34;bar&#34;: &#34;&#34;}\" hx-target=\"#modal\" hx-swap=\"outerHTML\" class=\"button is-light\">")
+		if err != nil {
+			return err
+		}
+		var_5 := `Review`
+		_, err = templBuffer.WriteString(var_5)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</button><button hx-post=\"/draft\" hx-vals=\"{&#34;action&#34;: &#34;commit&#34;}\" hx-target=\"#table\" hx-swap=\"innerHTML\" class=\"button is-primary\">")
+		if err != nil {
+			return err
+		}
+		var_6 := `Commit`
+		_, err = templBuffer.WriteString(var_6)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</button><button hx-post=\"/draft\" hx-vals=\"{&#34;action&#34;: &#34;discard&#34;}\" hx-target=\"#table\" hx-swap=\"innerHTML\" hx-confirm=\"Discard every change in the draft?\" class=\"button is-light\">")
+		if err != nil {
+			return err
+		}
+		var_7 := `Discard`
+		_, err = templBuffer.WriteString(var_7)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</button></div></td></tr>")
+		if err != nil {
+			return err
+		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
+
+// Shows the rows inserted in the draft above the loaded rows
+
+func draftInsertRows(sheet sheets.Sheet, cols [][]sheets.Column) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_8 := templ.GetChildren(ctx)
+		if var_8 == nil {
+			var_8 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		for _, values := range sheet.DraftInserts() {
+			_, err = templBuffer.WriteString("<tr class=\"is-draft\">")
+			if err != nil {
+				return err
+			}
+			for i, tcols := range cols {
+				for _, col := range tcols {
+					var var_9 = []any{templ.KV("is-null", values[sheet.TableNames[i]][col.Name] == "")}
+					err = templ.RenderCSSItems(ctx, templBuffer, var_9...)
+					if err != nil {
+						return err
+					}
+					_, err = templBuffer.WriteString("<td class=\"")
+					if err != nil {
+						return err
+					}
+					_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_9).String()))
+					if err != nil {
+						return err
+					}
+					_, err = templBuffer.WriteString("\"><span>")
+					if err != nil {
+						return err
+					}
+					var var_10 string = values[sheet.TableNames[i]][col.Name]
+					_, err = templBuffer.WriteString(templ.EscapeString(var_10))
+					if err != nil {
+						return err
+					}
+					_, err = templBuffer.WriteString("</span></td>")
+					if err != nil {
+						return err
+					}
+				}
+			}
+			for range sheet.ExtraCols {
+				_, err = templBuffer.WriteString("<td></td>")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString("</tr>")
+			if err != nil {
+				return err
+			}
+		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
+
+func draftModal(changes []sheets.DraftChange) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_11 := templ.GetChildren(ctx)
+		if var_11 == nil {
+			var_11 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		_, err = templBuffer.WriteString("<div id=\"modal\" class=\"modal is-active\" hx-target=\"#modal\" onclick=\"event.stopPropagation()\"><div class=\"modal-content box\"><label>")
+		if err != nil {
+			return err
+		}
+		var_12 := `Changes in the draft`
+		_, err = templBuffer.WriteString(var_12)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</label><ol>")
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			for _, line := range change.Describe() {
+				var var_13 = []any{"draft-" + change.Action}
+				err = templ.RenderCSSItems(ctx, templBuffer, var_13...)
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("<li class=\"")
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_13).String()))
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("\">")
+				if err != nil {
+					return err
+				}
+				var var_14 string = line
+				_, err = templBuffer.WriteString(templ.EscapeString(var_14))
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("</li>")
+				if err != nil {
+					return err
+				}
+			}
+		}
+		_, err = templBuffer.WriteString("</ol>")
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			_, err = templBuffer.WriteString("<p>")
+			if err != nil {
+				return err
+			}
+			var_15 := `No changes have been made yet.`
+			_, err = templBuffer.WriteString(var_15)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</p>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</div><button class=\"modal-close\"></button></div>")
+		if err != nil {
+			return err
+		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
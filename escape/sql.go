@@ -28,6 +28,55 @@ type SafeSQL struct {
 	raw string
 }
 
+var CountAll = SafeSQL{"COUNT(*)"}
+
+func (safe SafeSQL) String() string {
+	return safe.raw
+}
+
+// Describes how a database spells the SQL built by this package. Positional
+// parameters are always written $1, $2, ... and left to the driver to rewrite.
+type Dialect struct {
+	Name string
+	// Surrounds each part of an identifier, which may not contain it
+	quote string
+	// Names of the sqlTypes in this dialect, where they differ
+	castTypes map[string]string
+	// Whether INSERT supports RETURNING
+	Returning bool
+	// Whether a backslash in a string constant escapes the next character
+	backslashEscapes bool
+	// Whether NULLs sort before other values in ascending order
+	nullsFirst bool
+}
+
+var Postgres = Dialect{
+	Name:      "postgres",
+	quote:     "\"",
+	Returning: true,
+}
+
+var SQLite = Dialect{
+	Name:  "sqlite",
+	quote: "\"",
+	castTypes: map[string]string{
+		"numeric": "REAL",
+	},
+	Returning:  true,
+	nullsFirst: true,
+}
+
+var MySQL = Dialect{
+	Name:  "mysql",
+	quote: "`",
+	castTypes: map[string]string{
+		"text":    "CHAR",
+		"numeric": "DOUBLE",
+	},
+	backslashEscapes: true,
+	nullsFirst:       true,
+}
+
 var operators = []string{
 	"=", "<", "<=", ">", ">=", "LIKE",
 }
@@ -47,36 +96,36 @@ func isValidForLabel(name string) bool {
 	return true
 }
 
-func escapeIdentifier(identifier string) (string, error) {
+func (d Dialect) escapeIdentifier(identifier string) (string, error) {
 	parts := strings.Split(identifier, ".")
 	escaped := []string{}
 	for _, part := range parts {
-		if strings.Contains(part, "\"") {
+		if strings.Contains(part, d.quote) {
 			return "", fmt.Errorf("Illegal identifier: %s", identifier)
 		}
-		escaped = append(escaped, fmt.Sprintf("\"%s\"", part))
+		escaped = append(escaped, d.quote+part+d.quote)
 	}
 	return strings.Join(escaped, "."), nil
 }
 
-func EscapeIdentifier(identifier string) (SafeSQL, error) {
-	safe, err := escapeIdentifier(identifier)
+func (d Dialect) EscapeIdentifier(identifier string) (SafeSQL, error) {
+	safe, err := d.escapeIdentifier(identifier)
 	return SafeSQL{safe}, err
 }
 
-func isConstant(raw string) bool {
+func (d Dialect) isConstant(raw string) bool {
 	_, err := strconv.Atoi(raw)
 	if err == nil {
 		return true
 	}
 	unSingleQuoted := strings.Trim(raw, "'")
 	if raw == "'"+unSingleQuoted+"'" && !strings.Contains(unSingleQuoted, "'") {
-		return true
+		return !d.backslashEscapes || !strings.Contains(unSingleQuoted, "\\")
 	}
 	unParenthesized := strings.TrimLeft(strings.TrimRight(raw, ")"), "(")
 	if raw == "("+unParenthesized+")" {
 		for _, item := range strings.Split(unParenthesized, ",") {
-			if !isConstant(item) {
+			if !d.isConstant(item) {
 				return false
 			}
 		}
@@ -85,15 +134,15 @@ func isConstant(raw string) bool {
 	return false
 }
 
-func escapeIdentifierOrConstant(raw string) (string, error) {
-	if isConstant(raw) {
+func (d Dialect) escapeIdentifierOrConstant(raw string) (string, error) {
+	if d.isConstant(raw) {
 		return raw, nil
 	}
-	return escapeIdentifier(raw)
+	return d.escapeIdentifier(raw)
 }
 
-func MakeCast(identifier, castType, alias string) (SafeSQL, error) {
-	safe, err := escapeIdentifier(identifier)
+func (d Dialect) MakeCast(identifier, castType, alias string) (SafeSQL, error) {
+	safe, err := d.escapeIdentifier(identifier)
 	if err != nil {
 		return SafeSQL{}, err
 	}
@@ -101,10 +150,13 @@ func MakeCast(identifier, castType, alias string) (SafeSQL, error) {
 		if !slices.Contains(sqlTypes, castType) {
 			return SafeSQL{}, fmt.Errorf("Unsupported SQL type: %s", castType)
 		}
+		if name, ok := d.castTypes[castType]; ok {
+			castType = name
+		}
 		safe = fmt.Sprintf("CAST(%s AS %s)", safe, castType)
 	}
 	if alias != "" {
-		alias, err = escapeIdentifier(alias)
+		alias, err = d.escapeIdentifier(alias)
 		if err != nil {
 			return SafeSQL{}, err
 		}
@@ -113,20 +165,20 @@ func MakeCast(identifier, castType, alias string) (SafeSQL, error) {
 	return SafeSQL{safe}, nil
 }
 
-func MakeNotNull(identifer string) (SafeSQL, error) {
-	safe, err := escapeIdentifier(identifer)
+func (d Dialect) MakeNotNull(identifer string) (SafeSQL, error) {
+	safe, err := d.escapeIdentifier(identifer)
 	if err != nil {
 		return SafeSQL{}, err
 	}
 	return SafeSQL{fmt.Sprintf("%s IS NOT NULL", safe)}, nil
 }
 
-func MakeClause(lhs, operator, rhs string) (SafeSQL, error) {
-	lhsSafe, err := escapeIdentifierOrConstant(lhs)
+func (d Dialect) MakeClause(lhs, operator, rhs string) (SafeSQL, error) {
+	lhsSafe, err := d.escapeIdentifierOrConstant(lhs)
 	if err != nil {
 		return SafeSQL{}, fmt.Errorf("Illegal left-hand side of clause: %s (%w)", lhs, err)
 	}
-	rhsSafe, err := escapeIdentifierOrConstant(rhs)
+	rhsSafe, err := d.escapeIdentifierOrConstant(rhs)
 	if err != nil {
 		return SafeSQL{}, fmt.Errorf("Illegal right-hand side of clause: %s (%w)", rhs, err)
 	}
@@ -136,18 +188,27 @@ func MakeClause(lhs, operator, rhs string) (SafeSQL, error) {
 	return SafeSQL{fmt.Sprintf("%s %s %s", lhsSafe, operator, rhsSafe)}, nil
 }
 
-func MakeFilterClause(lhs, filter string) (SafeSQL, error) {
+// Compares identifier to the nth positional parameter
+func (d Dialect) MakeParamClause(identifier string, n int) (SafeSQL, error) {
+	safe, err := d.escapeIdentifier(identifier)
+	if err != nil {
+		return SafeSQL{}, err
+	}
+	return SafeSQL{fmt.Sprintf("%s = $%d", safe, n)}, nil
+}
+
+func (d Dialect) MakeFilterClause(lhs, filter string) (SafeSQL, error) {
 	for _, operator := range operators {
 		suffix, found := strings.CutPrefix(filter, operator)
 		if found {
-			return MakeClause(lhs, operator, strings.TrimLeft(suffix, " "))
+			return d.MakeClause(lhs, operator, strings.TrimLeft(suffix, " "))
 		}
 	}
 	return SafeSQL{}, fmt.Errorf("No supported filter operator in: %s", filter)
 }
 
-func MakeOrderExpr(identifier string, ascending bool) (SafeSQL, error) {
-	safe, err := escapeIdentifier(identifier)
+func (d Dialect) MakeOrderExpr(identifier string, ascending bool) (SafeSQL, error) {
+	safe, err := d.escapeIdentifier(identifier)
 	if err != nil {
 		return SafeSQL{}, err
 	}
@@ -155,17 +216,66 @@ func MakeOrderExpr(identifier string, ascending bool) (SafeSQL, error) {
 	if ascending {
 		orderDirection = " ASC"
 	}
+	if d.nullsFirst {
+		// Sort NULLs as PostgreSQL does, after every other value
+		return SafeSQL{safe + " IS NULL" + orderDirection + ", " + safe + orderDirection}, nil
+	}
 	return SafeSQL{safe + orderDirection}, nil
 }
 
-func toJoinClause(fkey fkeys.ForeignKey, tableName string) (string, error) {
-	tableIdent, err := escapeIdentifier(tableName)
+type KeysetColumn struct {
+	Identifier string
+	Ascending  bool
+	// Whether the cursor's value is NULL, in which case it takes no parameter
+	IsNull bool
+}
+
+// Selects the rows which come after a cursor row when sorted by cols with MakeOrderExpr,
+// where NULLs sort last in ascending order and first in descending order.
+// Non-NULL cursor values are passed as parameters numbered from firstParam.
+func (d Dialect) MakeKeysetClause(cols []KeysetColumn, firstParam int) (SafeSQL, error) {
+	param := firstParam
+	equalities := []string{}
+	alternatives := []string{}
+	for _, col := range cols {
+		safe, err := d.escapeIdentifier(col.Identifier)
+		if err != nil {
+			return SafeSQL{}, err
+		}
+		var after, equal string
+		if col.IsNull {
+			equal = safe + " IS NULL"
+			if !col.Ascending {
+				after = safe + " IS NOT NULL"
+			}
+		} else {
+			equal = fmt.Sprintf("%s = $%d", safe, param)
+			if col.Ascending {
+				after = fmt.Sprintf("(%s > $%d OR %s IS NULL)", safe, param, safe)
+			} else {
+				after = fmt.Sprintf("%s < $%d", safe, param)
+			}
+			param++
+		}
+		if after != "" {
+			alternatives = append(alternatives, strings.Join(append(slices.Clone(equalities), after), " AND "))
+		}
+		equalities = append(equalities, equal)
+	}
+	if len(alternatives) == 0 {
+		return SafeSQL{"FALSE"}, nil
+	}
+	return SafeSQL{"(" + strings.Join(alternatives, " OR ") + ")"}, nil
+}
+
+func (d Dialect) toJoinClause(fkey fkeys.ForeignKey, tableName string) (string, error) {
+	tableIdent, err := d.escapeIdentifier(tableName)
 	if err != nil {
 		return "", err
 	}
 	pairs := make([]string, len(fkey.SourceColNames))
 	for i, sourceCol := range fkey.SourceColNames {
-		clause, err := MakeClause(fkey.SourceTableName+"."+sourceCol, "=", fkey.TargetTableName+"."+fkey.TargetColNames[i])
+		clause, err := d.MakeClause(fkey.SourceTableName+"."+sourceCol, "=", fkey.TargetTableName+"."+fkey.TargetColNames[i])
 		if err != nil {
 			return "", fmt.Errorf("Illegal clause in JOIN: %w", err)
 		}
@@ -179,14 +289,14 @@ func joinSafeSQL(parts []SafeSQL, sep string) string {
 	for i, part := range parts {
 		unwrapped[i] = part.raw
 	}
-	return strings.Join(unwrapped, ", ")
+	return strings.Join(unwrapped, sep)
 }
 
-func MakeSelectStmt(tableNames []string, joins []fkeys.ForeignKey, columns, filterClauses, orderClauses []SafeSQL, limit bool) (string, error) {
+func (d Dialect) MakeSelectStmt(tableNames []string, joins []fkeys.ForeignKey, columns, filterClauses, orderClauses []SafeSQL, limit bool) (string, error) {
 	fromClause := " FROM " + tableNames[0]
 	for i, fkey := range joins {
 		tableName := tableNames[i+1]
-		joinClause, err := toJoinClause(fkey, tableName)
+		joinClause, err := d.toJoinClause(fkey, tableName)
 		if err != nil {
 			return "", err
 		}
@@ -206,8 +316,47 @@ func MakeSelectStmt(tableNames []string, joins []fkeys.ForeignKey, columns, filt
 	return query, nil
 }
 
-func MakeInsertStmt(tableName string, values map[string]interface{}, returning []string) (string, error) {
-	identifier, err := escapeIdentifier(tableName)
+// Selects columns from the first row whose identifier equals the first positional parameter
+func (d Dialect) MakeLookupStmt(tableNames []string, joins []fkeys.ForeignKey, identifier string, columns []SafeSQL) (string, error) {
+	clause, err := d.MakeParamClause(identifier, 1)
+	if err != nil {
+		return "", err
+	}
+	query, err := d.MakeSelectStmt(tableNames, joins, columns, []SafeSQL{clause}, nil, false)
+	if err != nil {
+		return "", err
+	}
+	return query + " LIMIT 1", nil
+}
+
+// Selects the position, counting from 1, of the first row whose identifier equals the
+// first positional parameter, among the rows matching filterClauses sorted by
+// orderClauses. Only positions between the second and third parameters are searched.
+func (d Dialect) MakeMatchStmt(tableNames []string, joins []fkeys.ForeignKey, identifier string, filterClauses, orderClauses []SafeSQL) (string, error) {
+	key, err := d.MakeCast(identifier, "", "match_key")
+	if err != nil {
+		return "", err
+	}
+	position := SafeSQL{"ROW_NUMBER() OVER (ORDER BY " + joinSafeSQL(orderClauses, ", ") + ") AS match_row"}
+	if len(orderClauses) == 0 {
+		position = SafeSQL{"ROW_NUMBER() OVER () AS match_row"}
+	}
+	subquery, err := d.MakeSelectStmt(tableNames, joins, []SafeSQL{position, key}, filterClauses, nil, false)
+	if err != nil {
+		return "", err
+	}
+	clause, err := d.MakeParamClause("sq.match_key", 1)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(
+		"SELECT sq.match_row FROM (%s) sq WHERE %s AND sq.match_row BETWEEN $2 AND $3 ORDER BY sq.match_row LIMIT 1",
+		subquery,
+		clause), nil
+}
+
+func (d Dialect) MakeInsertStmt(tableName string, values map[string]interface{}, returning []string) (string, error) {
+	identifier, err := d.escapeIdentifier(tableName)
 	if err != nil {
 		return "", err
 	}
@@ -215,6 +364,9 @@ func MakeInsertStmt(tableName string, values map[string]interface{}, returning [
 	if len(values) == 0 {
 		return "", fmt.Errorf("All fields are empty")
 	}
+	if len(returning) > 0 && !d.Returning {
+		return "", fmt.Errorf("%s does not support INSERT ... RETURNING", d.Name)
+	}
 
 	colIdentifiers := make([]string, len(values))
 	valueLabels := make([]string, len(values))
@@ -228,7 +380,7 @@ func MakeInsertStmt(tableName string, values map[string]interface{}, returning [
 
 	returningCasts := make([]string, len(returning))
 	for i, colName := range returning {
-		cast, err := MakeCast(colName, "text", "")
+		cast, err := d.MakeCast(colName, "text", "")
 		if err != nil {
 			return "", err
 		}
@@ -247,8 +399,11 @@ func MakeInsertStmt(tableName string, values map[string]interface{}, returning [
 	return query, nil
 }
 
-func MakeUpdateStmt(tableName string, values, primaryKeys map[string]string) (string, error) {
-	identifier, err := escapeIdentifier(tableName)
+// Builds an UPDATE of the row identified by primaryKeys which only matches while each
+// column in originals still has its original value, compared as text, or is NULL
+// if the original is nil. Adds the parameters for the WHERE clause to values.
+func (d Dialect) MakeUpdateStmt(tableName string, values, primaryKeys map[string]string, originals map[string]*string) (string, error) {
+	identifier, err := d.escapeIdentifier(tableName)
 	if err != nil {
 		return "", err
 	}
@@ -263,13 +418,35 @@ func MakeUpdateStmt(tableName string, values, primaryKeys map[string]string) (st
 
 	whereClauses := make([]string, len(primaryKeys))
 	for i, key := range maps.Keys(primaryKeys) {
-		colIdentifier, err := escapeIdentifier(key)
+		colIdentifier, err := d.escapeIdentifier(key)
 		if err != nil {
 			return "", err
 		}
 		whereClauses[i] = fmt.Sprintf("%s = :%s", colIdentifier, key)
 		values[key] = primaryKeys[key]
 	}
+	originalKeys := maps.Keys(originals)
+	slices.Sort(originalKeys)
+	for _, key := range originalKeys {
+		if !isValidForLabel(key) {
+			return "", fmt.Errorf("Invalid column name: %s", key)
+		}
+		if originals[key] == nil {
+			colIdentifier, err := d.escapeIdentifier(key)
+			if err != nil {
+				return "", err
+			}
+			whereClauses = append(whereClauses, colIdentifier+" IS NULL")
+			continue
+		}
+		cast, err := d.MakeCast(key, "text", "")
+		if err != nil {
+			return "", err
+		}
+		param := "original_" + key
+		whereClauses = append(whereClauses, fmt.Sprintf("%s = :%s", cast, param))
+		values[param] = *originals[key]
+	}
 
 	query := fmt.Sprintf(
 		"UPDATE %s SET %s WHERE %s",
@@ -279,3 +456,28 @@ func MakeUpdateStmt(tableName string, values, primaryKeys map[string]string) (st
 	log.Println("Executing:", query)
 	return query, nil
 }
+
+func (d Dialect) MakeDeleteStmt(tableName string, primaryKeys []string) (string, error) {
+	identifier, err := d.escapeIdentifier(tableName)
+	if err != nil {
+		return "", err
+	}
+	if len(primaryKeys) == 0 {
+		return "", fmt.Errorf("Cannot delete from %s without a primary key", tableName)
+	}
+
+	whereClauses := make([]SafeSQL, len(primaryKeys))
+	for i, key := range primaryKeys {
+		whereClauses[i], err = d.MakeParamClause(key, i+1)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	query := fmt.Sprintf(
+		"DELETE FROM %s WHERE %s",
+		identifier,
+		joinSafeSQL(whereClauses, " AND "))
+	log.Println("Executing:", query)
+	return query, nil
+}
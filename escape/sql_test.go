@@ -26,14 +26,14 @@ func expectSuccess(t *testing.T, actual, expected string, err error) {
 }
 
 func TestEscapeIdentifier(t *testing.T) {
-	quoted, err := escapeIdentifier("foo")
+	quoted, err := Postgres.escapeIdentifier("foo")
 	expectSuccess(t, quoted, "\"foo\"", err)
 
-	quoted, err = escapeIdentifier("foo.bar.baz")
+	quoted, err = Postgres.escapeIdentifier("foo.bar.baz")
 	expectSuccess(t, quoted, "\"foo\".\"bar\".\"baz\"", err)
 
 	evil := "\"foo\"; DELETE FROM users;--"
-	quoted, err = escapeIdentifier(evil)
+	quoted, err = Postgres.escapeIdentifier(evil)
 	if err == nil {
 		t.Errorf("%s should have errored, returned: %s", evil, quoted)
 	}
@@ -41,42 +41,165 @@ func TestEscapeIdentifier(t *testing.T) {
 
 func TestIsConstant(t *testing.T) {
 	good := "100"
-	if !isConstant(good) {
+	if !Postgres.isConstant(good) {
 		t.Error(good)
 	}
 
 	good = "'foo'"
-	if !isConstant(good) {
+	if !Postgres.isConstant(good) {
 		t.Error(good)
 	}
 
 	evil := "bar; DROP TABLE users;--"
-	if isConstant(evil) {
+	if Postgres.isConstant(evil) {
 		t.Error(evil)
 	}
 }
 
 func TestMakeFilterClause(t *testing.T) {
-	clause, err := MakeFilterClause("foo", "<1")
+	clause, err := Postgres.MakeFilterClause("foo", "<1")
 	expectSuccess(t, clause.raw, "\"foo\" < 1", err)
 
-	clause, err = MakeFilterClause("1", "< foo")
+	clause, err = Postgres.MakeFilterClause("1", "< foo")
 	expectSuccess(t, clause.raw, "1 < \"foo\"", err)
 
-	clause, err = MakeFilterClause("foo", "= bar")
+	clause, err = Postgres.MakeFilterClause("foo", "= bar")
 	expectSuccess(t, clause.raw, "\"foo\" = \"bar\"", err)
 
-	clause, err = MakeFilterClause("foo", "LIKE 'baz%'")
+	clause, err = Postgres.MakeFilterClause("foo", "LIKE 'baz%'")
 	expectSuccess(t, clause.raw, "\"foo\" LIKE 'baz%'", err)
 
 	evil := "= bar; DELETE FROM users;--"
-	clause, err = MakeFilterClause("foo", evil)
+	clause, err = Postgres.MakeFilterClause("foo", evil)
 	// Quoting makes this safe, although presumably it's not a real column name
 	expectSuccess(t, clause.raw, "\"foo\" = \"bar; DELETE FROM users;--\"", err)
 
 	evil = "= bar\"; DELETE FROM users;--"
-	clause, err = MakeFilterClause("foo", evil)
+	clause, err = Postgres.MakeFilterClause("foo", evil)
 	if err == nil {
 		t.Errorf("%s should have errored, returned: %s", evil, clause.raw)
 	}
-}
\ No newline at end of file
+}
+func TestMakeSelectStmt(t *testing.T) {
+	first, err := Postgres.MakeParamClause("id", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Postgres.MakeParamClause("name", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := Postgres.MakeSelectStmt([]string{"public.foo"}, nil, []SafeSQL{CountAll}, []SafeSQL{first, second}, nil, false)
+	expectSuccess(t, query, "SELECT COUNT(*) FROM public.foo WHERE \"id\" = $1 AND \"name\" = $2", err)
+}
+
+func TestMakeLookupStmt(t *testing.T) {
+	cast, err := Postgres.MakeCast("public.customers.tier", "text", "val")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := Postgres.MakeLookupStmt([]string{"public.customers"}, nil, "public.customers.id", []SafeSQL{cast})
+	expectSuccess(t, query, "SELECT CAST(\"public\".\"customers\".\"tier\" AS text) AS \"val\" FROM public.customers"+
+		" WHERE \"public\".\"customers\".\"id\" = $1 LIMIT 1", err)
+
+	_, err = Postgres.MakeLookupStmt([]string{"public.customers"}, nil, "id\"; DROP TABLE users;--", []SafeSQL{cast})
+	if err == nil {
+		t.Error("Unexpected success with an invalid identifier")
+	}
+}
+
+func TestMakeMatchStmt(t *testing.T) {
+	order, err := Postgres.MakeOrderExpr("id", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := Postgres.MakeMatchStmt([]string{"public.foo"}, nil, "name", nil, []SafeSQL{order})
+	expectSuccess(t, query, "SELECT sq.match_row FROM (SELECT ROW_NUMBER() OVER (ORDER BY \"id\" ASC) AS match_row,"+
+		" \"name\" AS \"match_key\" FROM public.foo) sq WHERE \"sq\".\"match_key\" = $1"+
+		" AND sq.match_row BETWEEN $2 AND $3 ORDER BY sq.match_row LIMIT 1", err)
+}
+
+func TestMakeDeleteStmt(t *testing.T) {
+	query, err := Postgres.MakeDeleteStmt("public.foo", []string{"id", "version"})
+	expectSuccess(t, query, "DELETE FROM \"public\".\"foo\" WHERE \"id\" = $1 AND \"version\" = $2", err)
+
+	query, err = Postgres.MakeDeleteStmt("public.foo", []string{})
+	if err == nil {
+		t.Errorf("Delete without a primary key should have errored, returned: %s", query)
+	}
+}
+
+func TestMakeUpdateStmt(t *testing.T) {
+	name := "pen"
+	values := map[string]string{"name": "pencil"}
+	query, err := Postgres.MakeUpdateStmt("public.foo", values, map[string]string{"id": "1"},
+		map[string]*string{"name": &name, "price": nil})
+	expectSuccess(t, query, "UPDATE \"public\".\"foo\" SET name = :name WHERE \"id\" = :id"+
+		" AND CAST(\"name\" AS text) = :original_name AND \"price\" IS NULL", err)
+	if values["id"] != "1" || values["original_name"] != "pen" {
+		t.Errorf("Unexpected parameters: %v", values)
+	}
+
+	_, err = Postgres.MakeUpdateStmt("public.foo", map[string]string{"name": "pencil"}, map[string]string{"id": "1"},
+		map[string]*string{"name\"; DROP TABLE users;--": nil})
+	if err == nil {
+		t.Error("Unexpected success with an invalid identifier")
+	}
+}
+
+func TestMakeKeysetClause(t *testing.T) {
+	clause, err := Postgres.MakeKeysetClause([]KeysetColumn{
+		{Identifier: "name", Ascending: true},
+		{Identifier: "id", Ascending: true},
+	}, 3)
+	expectSuccess(t, clause.raw,
+		"((\"name\" > $3 OR \"name\" IS NULL) OR \"name\" = $3 AND (\"id\" > $4 OR \"id\" IS NULL))", err)
+
+	clause, err = Postgres.MakeKeysetClause([]KeysetColumn{
+		{Identifier: "total", Ascending: false, IsNull: true},
+		{Identifier: "id", Ascending: false},
+	}, 1)
+	expectSuccess(t, clause.raw,
+		"(\"total\" IS NOT NULL OR \"total\" IS NULL AND \"id\" < $1)", err)
+
+	// Nothing sorts after NULL in ascending order
+	clause, err = Postgres.MakeKeysetClause([]KeysetColumn{{Identifier: "id", Ascending: true, IsNull: true}}, 1)
+	expectSuccess(t, clause.raw, "FALSE", err)
+
+	_, err = Postgres.MakeKeysetClause([]KeysetColumn{{Identifier: "id\"; DROP TABLE users;--"}}, 1)
+	if err == nil {
+		t.Error("Unexpected success with an invalid identifier")
+	}
+}
+
+func TestMySQL(t *testing.T) {
+	quoted, err := MySQL.escapeIdentifier("foo.bar")
+	expectSuccess(t, quoted, "`foo`.`bar`", err)
+
+	evil := "foo`; DELETE FROM users;--"
+	quoted, err = MySQL.escapeIdentifier(evil)
+	if err == nil {
+		t.Errorf("%s should have errored, returned: %s", evil, quoted)
+	}
+
+	cast, err := MySQL.MakeCast("foo", "text", "bar")
+	expectSuccess(t, cast.raw, "CAST(`foo` AS CHAR) AS `bar`", err)
+
+	// A trailing backslash would escape the closing quote
+	evil = "'foo\\'"
+	if MySQL.isConstant(evil) {
+		t.Error(evil)
+	}
+	if !Postgres.isConstant(evil) {
+		t.Error(evil)
+	}
+
+	// NULLs sort last as on PostgreSQL
+	order, err := MySQL.MakeOrderExpr("foo", false)
+	expectSuccess(t, order.raw, "`foo` IS NULL DESC, `foo` DESC", err)
+
+	query, err := MySQL.MakeInsertStmt("foo.bar", map[string]interface{}{"baz": 1}, []string{"id"})
+	if err == nil {
+		t.Errorf("Insert with RETURNING should have errored, returned: %s", query)
+	}
+}
@@ -19,6 +19,13 @@ type ForeignKey struct {
 	TargetTableName string
 	SourceColNames  []string
 	TargetColNames  []string
+	// One of CASCADE, RESTRICT, NO ACTION, SET NULL or SET DEFAULT
+	OnDelete string
+}
+
+// Whether deleting a referenced row fails while rows reference it
+func (fkey ForeignKey) RestrictsDelete() bool {
+	return fkey.OnDelete == "RESTRICT" || fkey.OnDelete == "NO ACTION"
 }
 
 func (fkey ForeignKey) ToString() string {
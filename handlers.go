@@ -15,23 +15,42 @@ package main
 import (
 	"acb/db-interface/fkeys"
 	"acb/db-interface/sheets"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"mime"
 	"net/http"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/a-h/templ"
 	"golang.org/x/exp/maps"
 )
 
+// Fields which keep the sheet on the same page across requests
+const pageFields = "[name=limit],[name=offset],[name=scroll]"
+
 func writeError(w http.ResponseWriter, text string) {
 	w.Header().Add("Content-Type", "text/html")
 	w.WriteHeader(http.StatusBadRequest)
 	w.Write([]byte("<span class=\"has-text-danger\">" + text + "</span>"))
 }
 
+// Refuses requests which aren't POSTed, so that following or prefetching a link
+// can't change anything. Returns whether the request was POSTed.
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
 func mustGetInt(r *http.Request, key string) int {
 	str := r.FormValue(key)
 	i, err := strconv.Atoi(str)
@@ -39,11 +58,33 @@ func mustGetInt(r *http.Request, key string) int {
 	return i
 }
 
+// The values posted when a table cell is edited, including the value it was loaded with
+func cellVals(tableName, colName string, row int, original sheets.Cell) string {
+	vals := map[string]interface{}{
+		"table_name": tableName,
+		"col_name":   colName,
+		"row":        row,
+		"original":   original.Value,
+	}
+	if !original.NotNull {
+		vals["original_null"] = "true"
+	}
+	b, err := json.Marshal(vals)
+	sheets.Check(err)
+	return string(b)
+}
+
+func isConflict(err error) bool {
+	var conflict *sheets.ConflictError
+	return errors.As(err, &conflict)
+}
+
 func getSheet(r *http.Request, required bool) (sheets.Sheet, error) {
 	sheetIdStr := r.FormValue("sheet_id")
 	if sheetIdStr != "" {
 		sheetId, err := strconv.Atoi(sheetIdStr)
-		return sheets.SheetMap[sheetId], err
+		sheet, _ := sheets.Store.Get(sheetId)
+		return sheet, err
 	}
 	if required {
 		return sheets.Sheet{}, errors.New("missing sheet_id")
@@ -51,17 +92,117 @@ func getSheet(r *http.Request, required bool) (sheets.Sheet, error) {
 	return sheets.Sheet{}, nil
 }
 
+// The role needed on a sheet for each path which changes it. Every other path
+// only needs the sheet to be viewable.
+var requiredRoles = map[string]sheets.Role{
+	"/modal":            sheets.Editor,
+	"/new-row":          sheets.Editor,
+	"/add-row":          sheets.Editor,
+	"/delete-row":       sheets.Editor,
+	"/add-column":       sheets.Editor,
+	"/rename-column":    sheets.Editor,
+	"/delete-column":    sheets.Editor,
+	"/set-column-prefs": sheets.Editor,
+	"/unhide-columns":   sheets.Editor,
+	"/clear-filters":    sheets.Editor,
+	"/set-cell":         sheets.Editor,
+	"/set-extra-cell":   sheets.Editor,
+	"/set-name":         sheets.Editor,
+	"/fill-column-down": sheets.Editor,
+	"/import":           sheets.Editor,
+	"/undo":             sheets.Editor,
+	"/redo":             sheets.Editor,
+	"/draft":            sheets.Editor,
+	"/refresh-schema":   sheets.Editor,
+	"/set-timezone":     sheets.Editor,
+	"/share":            sheets.Owner,
+	"/share-link":       sheets.Owner,
+}
+
+// The paths in requiredRoles which show a form on GET, and only change the
+// sheet once the form is posted
+var formPaths = map[string]bool{
+	"/modal":      true,
+	"/new-row":    true,
+	"/delete-row": true,
+	"/draft":      true,
+	"/import":     true,
+	"/share":      true,
+}
+
+// Calls f with the sheet opened for the logged in user, or for anyone following
+// a link to it. Refuses the request unless the user's role allows it, and
+// refuses changes which aren't posted, since links from other sites can GET.
 func withSheet(f func(sheets.Sheet, http.ResponseWriter, *http.Request), required bool) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		sheet, err := getSheet(r, required)
+		if _, ok := requiredRoles[r.URL.Path]; ok && !formPaths[r.URL.Path] && !requirePost(w, r) {
+			return
+		}
+		user, err := sessionUser(r)
+		linkedSheetId := 0
+		if err != nil && r.FormValue("link") != "" {
+			linkedSheetId, err = sheets.LinkedSheetId(r.FormValue("link"), time.Now())
+		}
+		if err != nil {
+			refuseUnauthenticated(w, r)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), userKey{}, user))
+		if changesSheet(r) {
+			sheetId, _ := strconv.Atoi(r.FormValue("sheet_id"))
+			defer sheets.Store.Lock(sheetId)()
+		}
+		sheet, err := getSheet(r, required && linkedSheetId == 0)
 		if err != nil {
 			writeError(w, err.Error())
 			return
 		}
-		f(sheet, w, r)
+
+		role := sheet.Role(user)
+		if linkedSheetId != 0 {
+			if sheet.Id == 0 {
+				sheet, _ = sheets.Store.Get(linkedSheetId)
+			}
+			if sheet.Id != linkedSheetId {
+				refuseUnauthenticated(w, r)
+				return
+			}
+			role = sheets.Viewer
+			// Rows are loaded with the owner's database role
+			if sheet.OwnerId != 0 {
+				owner, err := sheets.GetUser(sheet.OwnerId)
+				sheets.Check(err)
+				sheet = sheet.WithUser(owner)
+			}
+		} else {
+			sheet = sheet.WithUser(user).WithHistory(sessionHistory(r))
+			if sheet.Id != 0 {
+				sheet = sheet.WithDraft(sessionDraft(r, sheet.Id))
+			}
+		}
+		requiredRole, ok := requiredRoles[r.URL.Path]
+		if !ok {
+			requiredRole = sheets.Viewer
+		}
+		if sheet.Id != 0 && role < requiredRole {
+			log.Printf("Refused %s to %s on sheet %d", r.URL.Path, user.Name, sheet.Id)
+			writeError(w, "You don't have permission to change this sheet")
+			return
+		}
+		f(sheet.WithRole(role), w, r)
 	}
 }
 
+// Whether the request can change the sheet, in which case it holds the sheet's
+// lock so that it starts from the changes made before it
+func changesSheet(r *http.Request) bool {
+	_, ok := requiredRoles[r.URL.Path]
+	return ok || r.Method == "POST"
+}
+
+// The most rows a page can show, since every row is loaded and rendered at once
+const maxLimit = 1000
+
 func withSheetAndLimit(f func(sheets.Sheet, int, http.ResponseWriter, *http.Request)) func(w http.ResponseWriter, r *http.Request) {
 	g := func (sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
 		str := r.FormValue("limit")
@@ -71,12 +212,57 @@ func withSheetAndLimit(f func(sheets.Sheet, int, http.ResponseWriter, *http.Requ
 		} else if err != nil {
 			writeError(w, err.Error())
 			return
+		} else if limit < 1 || limit > maxLimit {
+			writeError(w, fmt.Sprintf("Can only show between 1 and %d rows", maxLimit))
+			return
 		}
 		f(sheet, limit, w, r)
 	}
 	return withSheet(g, true)
 }
 
+func getOffset(r *http.Request) int {
+	offset, err := strconv.Atoi(r.FormValue("offset"))
+	if err != nil || offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+// Returns the cursor to load the page from, if the pager sent one
+func getCursor(r *http.Request) *sheets.Cursor {
+	if r.FormValue("cursor") == "" {
+		return nil
+	}
+	cursor := sheets.Cursor{Before: r.FormValue("before") == "true"}
+	err := json.Unmarshal([]byte(r.FormValue("cursor")), &cursor.Values)
+	if err != nil {
+		log.Printf("Ignoring invalid cursor %s: %s", r.FormValue("cursor"), err)
+		return nil
+	}
+	return &cursor
+}
+
+// Returns hx-vals which load the page at offset, from cursor if it isn't empty
+func pageVals(offset int, cursor string, before bool) string {
+	vals := map[string]interface{}{"offset": offset}
+	if cursor != "" {
+		vals["cursor"] = cursor
+		vals["before"] = before
+	}
+	encoded, err := json.Marshal(vals)
+	sheets.Check(err)
+	return string(encoded)
+}
+
+// Loading backwards from an empty cursor loads the last page
+func lastPageCursor(sheet sheets.Sheet) string {
+	if sheet.UsesKeyset() {
+		return "[]"
+	}
+	return ""
+}
+
 func parseColFields(r *http.Request, prefix string) (map[string]map[string]string, error) {
 	sheets.Check(r.ParseForm())
 	values := make(map[string]map[string]string)
@@ -129,39 +315,89 @@ func handleDeleteCol(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *ht
 	reRenderSheet(sheet, limit, w, r)
 }
 
-func handleSetExtraCell(sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
+func handleSetExtraCell(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
 	i := mustGetInt(r, "i")
 	j := mustGetInt(r, "j")
 	formula := r.FormValue("formula")
 
-	cell, err := sheet.SetCell(i, j, formula)
+	// The cells which reference this one are recalculated on the page shown,
+	// and only the cells they reference are evaluated along with them
+	err := sheet.LoadPageFormulas(limit, getOffset(r), getCursor(r))
+	if err != nil {
+		writeError(w, err.Error())
+		return
+	}
+	cell, recalculated, err := sheet.SetCell(i, j, formula)
 	if err != nil {
 		writeError(w, err.Error())
 		return
 	}
 
+	triggerRecalculated(w, recalculated)
 	handler := templ.Handler(extraCell(i, j, cell))
 	handler.ServeHTTP(w, r)
 }
 
+// Has the page show the new values of spreadsheet cells after a cell they
+// reference changed
+func triggerRecalculated(w http.ResponseWriter, cells []sheets.ExtraCell) {
+	if len(cells) == 0 {
+		return
+	}
+	trigger, err := json.Marshal(map[string]any{"cellsRecalculated": map[string]any{"cells": cells}})
+	sheets.Check(err)
+	w.Header().Set("HX-Trigger", string(trigger))
+}
+
 func reRenderSheet(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
+	renderSheet(sheet, limit, nil, w, r)
+}
+
+// Renders the sheet with changeErr, the error of the change made before, above its rows
+func renderSheet(sheet sheets.Sheet, limit int, changeErr error, w http.ResponseWriter, r *http.Request) {
 	if sheet.TableFullName() == "" {
 		writeError(w, "No table name provided")
 		return
 	}
-	err := sheet.LoadRows(limit, 0)
+	err := sheet.LoadPage(limit, getOffset(r), getCursor(r))
+	if err == nil && sheet.RowCount == 0 && sheet.Offset > 0 {
+		// Filtering or deleting rows can leave the page past the last row
+		err = sheet.LoadRows(limit, sheet.LastPageOffset(limit))
+	}
+	if err == nil {
+		err = changeErr
+	}
 	cols := sheet.OrderedCols(nil)
 	numCols := 0
 	for _, tcols := range cols {
 		numCols += len(tcols)
 	}
-	component := sheetTable(sheet, cols, numCols, err)
+	component := sheetTable(sheet, cols, numCols, limit, r.FormValue("scroll") == "infinite", err)
 	handler := templ.Handler(component)
 	handler.ServeHTTP(w, r)
 }
 
+// Appends the next page of rows when scrolling infinitely
+func handleRows(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
+	err := sheet.LoadPage(limit, getOffset(r), getCursor(r))
+	if err != nil {
+		writeError(w, err.Error())
+		return
+	}
+	cols := sheet.OrderedCols(nil)
+	numCols := 0
+	for _, tcols := range cols {
+		numCols += len(tcols)
+	}
+	templ.Handler(sheetRows(sheet, cols, numCols, true)).ServeHTTP(w, r)
+}
+
 func handleSetTable(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
+		if !sheet.CanEdit() {
+			writeError(w, "You don't have permission to change this sheet")
+			return
+		}
 		tableName := r.FormValue("table_name")
 		if tableName == "" {
 			writeError(w, "No table name provided")
@@ -174,6 +410,10 @@ func handleSetTable(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *htt
 }
 
 func handleNewRow(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
+	if sheet.ReadOnly() {
+		writeError(w, "The sheet is read-only")
+		return
+	}
 	cols := sheet.OrderedCols(nil)
 	tableName := r.FormValue("table_name")
 	tableIndex := slices.Index(sheet.TableNames, tableName)
@@ -193,30 +433,27 @@ func handleNewRow(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.
 			writeError(w, "Unrecognized table")
 			return
 		}
-		// TODO: cache
-		err := sheet.LoadRows(limit, 0)
+		rowIndex = mustGetInt(r, "row")
+		rowCells, err := sheet.RowAt(rowIndex)
 		if err != nil {
 			writeError(w, err.Error())
 			return
 		}
-		cells := sheet.Cells[tableIndex]
-		for rowIndex, _ = range cells[0] {
-			match := true
-			for colIndex, col := range cols[tableIndex] {
-				row[colIndex] = cells[colIndex][rowIndex]
-				pkValue, ok := pks[tableName][col.Name]
-				if ok && pkValue != row[colIndex].Value {
-					match = false
-					break
-				}
-			}
-			if match {
-				break
+		if rowCells == nil {
+			writeError(w, "Row no longer exists")
+			return
+		}
+		for colIndex, col := range cols[tableIndex] {
+			row[colIndex] = rowCells[tableIndex][colIndex]
+			pkValue, ok := pks[tableName][col.Name]
+			if ok && pkValue != row[colIndex].Value {
+				writeError(w, "The row has moved, please refresh the sheet")
+				return
 			}
 		}
 	}
 
-	component := newRow(sheet.TableNames, tableName, cols, numCols, row, rowIndex)
+	component := newRow(sheet, tableName, cols, numCols, row, rowIndex)
 	templ.Handler(component).ServeHTTP(w, r)
 }
 
@@ -228,6 +465,11 @@ func handleAddRow(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.
 		return
 	}
 
+	if sheet.Drafting() {
+		sheet.DraftInsert(values, pks)
+		reRenderSheet(sheet, limit, w, r)
+		return
+	}
 	err = sheet.InsertMultipleRows(values, pks)
 	if err != nil {
 		writeError(w, err.Error())
@@ -237,8 +479,229 @@ func handleAddRow(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.
 	reRenderSheet(sheet, limit, w, r)
 }
 
+func handleUndo(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
+	renderSheet(sheet, limit, sheet.Undo(), w, r)
+}
+
+func handleRedo(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
+	renderSheet(sheet, limit, sheet.Redo(), w, r)
+}
+
+// Shows the draft for review, or starts draft mode, commits the draft or
+// discards it, as posted in action
+func handleDraft(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		if r.FormValue("bar") != "" {
+			templ.Handler(draftBar(sheet, mustGetInt(r, "cols"))).ServeHTTP(w, r)
+		} else {
+			templ.Handler(draftModal(sheet.DraftChanges())).ServeHTTP(w, r)
+		}
+		return
+	}
+	var err error
+	switch r.FormValue("action") {
+	case "start":
+		if !sheet.Drafting() {
+			draft := &sheets.Draft{}
+			setSessionDraft(r, sheet.Id, draft)
+			sheet = sheet.WithDraft(draft)
+		}
+	case "commit":
+		if sheet.Drafting() {
+			err = sheet.CommitDraft()
+		}
+		if err == nil {
+			setSessionDraft(r, sheet.Id, nil)
+			sheet = sheet.WithDraft(nil)
+		}
+	case "discard":
+		if sheet.Drafting() {
+			sheet.DiscardDraft()
+		}
+		setSessionDraft(r, sheet.Id, nil)
+		sheet = sheet.WithDraft(nil)
+	}
+	renderSheet(sheet, limit, err, w, r)
+}
+
+// Streams the changes to the sheet's tables as server-sent events, until the
+// browser disconnects
+var keepaliveInterval = 30 * time.Second
+
+// Whether the request can still view the sheet, since it may have been
+// unshared, its link expired or the user deleted while streaming its changes
+func canStillView(sheetId int, r *http.Request) bool {
+	sheet, ok := sheets.Store.Get(sheetId)
+	if !ok {
+		return false
+	}
+	user, err := sessionUser(r)
+	if err != nil {
+		linkedSheetId, err := sheets.LinkedSheetId(r.FormValue("link"), time.Now())
+		return err == nil && linkedSheetId == sheetId
+	}
+	return sheet.Role(user) >= sheets.Viewer
+}
+
+func handleEvents(sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || sheet.Id == 0 {
+		writeError(w, "Cannot stream changes to this sheet")
+		return
+	}
+	changes, unsubscribe := sheet.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if !canStillView(sheet.Id, r) {
+				log.Printf("Closed the changes stream of sheet %d", sheet.Id)
+				return
+			}
+			fmt.Fprint(w, ": keepalive\n\n")
+		case change := <-changes:
+			data, err := json.Marshal(change)
+			sheets.Check(err)
+			event := "cell"
+			if change.ReloadRows() {
+				event = "rows"
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+		}
+		flusher.Flush()
+	}
+}
+
+func handleDeleteRow(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
+	tableName := r.FormValue("table_name")
+	pks := getPKs(r)[tableName]
+	if r.Method != "POST" {
+		rowIndex, _ := strconv.Atoi(r.FormValue("row"))
+		effects, err := sheet.DeletePreview(tableName, pks)
+		templ.Handler(deletePreview(tableName, rowIndex, effects, err)).ServeHTTP(w, r)
+		return
+	}
+
+	if sheet.Drafting() {
+		sheet.DraftDelete(tableName, pks)
+		reRenderSheet(sheet, limit, w, r)
+		return
+	}
+	err := sheet.DeleteRows(map[string]map[string]string{tableName: pks})
+	if err != nil {
+		writeError(w, err.Error())
+		return
+	}
+
+	reRenderSheet(sheet, limit, w, r)
+}
+
 func handleIndex(sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
-	templ.Handler(index(sheet, sheets.SheetMap)).ServeHTTP(w, r)
+	user := requestUser(r)
+	visible := sheets.VisibleSheets(user)
+	if user.Id == 0 {
+		// Following a link only shows the linked sheet
+		visible = map[int]sheets.Sheet{sheet.Id: sheet}
+	}
+	templ.Handler(index(sheet, visible, user, r.FormValue("link"))).ServeHTTP(w, r)
+}
+
+func handleExport(sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
+	if sheet.TableFullName() == "" {
+		writeError(w, "No table name provided")
+		return
+	}
+	format := r.FormValue("format")
+	export, err := sheet.NewExport(format, r.FormValue("formulas") == "true")
+	if err != nil {
+		writeError(w, err.Error())
+		return
+	}
+
+	exportFormat := sheets.ExportFormats[format]
+	w.Header().Set("Content-Type", exportFormat.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": sheet.VisibleName() + exportFormat.Extension,
+	}))
+	err = export.Write(w)
+	if err != nil {
+		log.Printf("Error exporting sheet %d: %s", sheet.Id, err)
+	}
+}
+
+func parseImportMapping(r *http.Request) map[int]string {
+	mapping := make(map[int]string)
+	for key, value := range r.Form {
+		indexStr, found := strings.CutPrefix(key, "map-")
+		if !found || value[0] == "" {
+			continue
+		}
+		index, err := strconv.Atoi(indexStr)
+		if err == nil {
+			mapping[index] = value[0]
+		}
+	}
+	return mapping
+}
+
+func handleImport(sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		templ.Handler(importUploadModal(sheet, nil)).ServeHTTP(w, r)
+		return
+	}
+
+	importId := r.FormValue("import_id")
+	var file sheets.ImportFile
+	var mapping map[int]string
+	if importId == "" {
+		upload, header, err := r.FormFile("file")
+		if err != nil {
+			templ.Handler(importUploadModal(sheet, err)).ServeHTTP(w, r)
+			return
+		}
+		defer upload.Close()
+		file, err = sheets.ParseImportFile(header.Filename, upload)
+		if err != nil {
+			templ.Handler(importUploadModal(sheet, err)).ServeHTTP(w, r)
+			return
+		}
+		importId = sheets.SavePendingImport(file)
+		mapping = sheet.DefaultImportMapping(file.Headers)
+	} else {
+		var ok bool
+		file, ok = sheets.GetPendingImport(importId)
+		if !ok {
+			templ.Handler(importUploadModal(sheet, errors.New("Upload expired, please try again"))).ServeHTTP(w, r)
+			return
+		}
+		mapping = parseImportMapping(r)
+	}
+
+	commit := r.FormValue("commit") == "true"
+	result, err := sheet.Import(file, mapping, !commit)
+	if result.Committed {
+		sheets.DeletePendingImport(importId)
+	}
+	component := importModal(sheet, importId, file, sheet.ImportTargets(), mapping, result, err)
+	templ.Handler(component).ServeHTTP(w, r)
+}
+
+// Reloads the tables of the sheet's database, after a migration changed them
+func handleRefreshSchema(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
+	sheet.Connection().RefreshCatalog()
+	if sheet.TableFullName() == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	reRenderSheet(sheet, limit, w, r)
 }
 
 func handleSetColPref(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
@@ -275,6 +738,7 @@ func handleSetColPref(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *h
 }
 
 func handleUnhideCols(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
+	sheet.LoadPrefs()
 	for _, pref := range sheet.PrefsMap {
 		pref.Hide = false
 		sheet.SavePref(pref)
@@ -284,6 +748,7 @@ func handleUnhideCols(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *h
 }
 
 func handleClearFilters(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
+	sheet.LoadPrefs()
 	for _, pref := range sheet.PrefsMap {
 		pref.Filter = ""
 		sheet.SavePref(pref)
@@ -293,20 +758,46 @@ func handleClearFilters(sheet sheets.Sheet, limit int, w http.ResponseWriter, r
 }
 
 func handleModal(sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
+	// A sheet's connection can only be chosen before its table is
+	connectionName := r.FormValue("connection")
+	if connectionName != "" && sheet.Id == 0 {
+		if _, ok := sheets.Connections[connectionName]; !ok {
+			writeError(w, "No such connection "+connectionName)
+			return
+		}
+		sheet.ConnectionName = connectionName
+	}
+	if sheet.Id == 0 {
+		sheet.OwnerId = requestUser(r).Id
+	}
+	tableMap := sheet.Connection().Tables()
+
 	tableName := r.FormValue("table_name")
-	if tableName != "" {
+	if r.Header.Get("HX-Trigger-Name") == "connection" {
+		// The selected table belongs to the previous connection
+		tableName = ""
+	}
+	if _, ok := tableMap[tableName]; ok && r.Method == "POST" {
 		sheet.SetTable(tableName)
-		sheet.LoadJoins()
+		err := sheet.LoadJoins()
+		if err != nil {
+			writeError(w, err.Error())
+			return
+		}
 	}
 
-	tableNames := maps.Keys(sheets.TableMap)
+	tableNames := maps.Keys(tableMap)
 	slices.Sort(tableNames)
 
 	fkeyOidsSeen := make(map[int64]bool)
 	options := make(map[string]map[int64]fkeys.ForeignKey)
 	for _, name := range sheet.TableNames {
 		options[name] = make(map[int64]fkeys.ForeignKey)
-		for oid, fkey := range sheets.TableMap[name].Fkeys {
+		table := sheet.Connection().LoadedTable(name)
+		if table == nil {
+			continue
+		}
+		for oid, fkey := range table.Fkeys {
 			if !fkeyOidsSeen[oid] {
 				fkeyOidsSeen[oid] = true
 				options[name][oid] = fkey
@@ -317,7 +808,7 @@ func handleModal(sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
 	_, addJoin := r.Form["add_join"]
 	if addJoin || r.Method != "POST" {
 		log.Printf("Available fkeys: %v", options)
-		templ.Handler(modal(sheet, tableNames, options, addJoin)).ServeHTTP(w, r)
+		templ.Handler(modal(sheet, sheets.ConnectionNames(), tableNames, options, addJoin)).ServeHTTP(w, r)
 		return
 	}
 
@@ -345,7 +836,47 @@ func handleModal(sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	templ.Handler(modal(sheet, tableNames, options, addJoin)).ServeHTTP(w, r)
+	templ.Handler(modal(sheet, sheets.ConnectionNames(), tableNames, options, addJoin)).ServeHTTP(w, r)
+}
+
+// Shows who the sheet is shared with, and changes their role if one is posted
+func handleShare(sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
+	var err error
+	if r.Method == "POST" {
+		role := sheets.NoRole
+		role, err = sheets.ParseRole(r.FormValue("role"))
+		if err == nil {
+			err = sheet.Share(r.FormValue("user_name"), role)
+		}
+	}
+	renderShareModal(sheet, err, w, r)
+}
+
+// Creates a read-only link which expires after the posted number of days,
+// or revokes one
+func handleShareLink(sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
+	var err error
+	revoke := r.FormValue("revoke")
+	if revoke != "" {
+		sheet.DeleteLink(revoke)
+	} else {
+		days := 0
+		days, err = strconv.Atoi(r.FormValue("days"))
+		if err == nil && days > 0 {
+			sheet.CreateLink(time.Now().AddDate(0, 0, days))
+		}
+	}
+	renderShareModal(sheet, err, w, r)
+}
+
+func renderShareModal(sheet sheets.Sheet, err error, w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	linkURL := scheme + "://" + r.Host + "/?link="
+	component := shareModal(sheet, sheet.Shares(), sheet.Links(time.Now()), linkURL, err)
+	templ.Handler(component).ServeHTTP(w, r)
 }
 
 func handleSetName(sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
@@ -355,19 +886,62 @@ func handleSetName(sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte{})
 }
 
-func handleSetCell(sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
+func handleSetTimezone(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
+	err := sheet.SetTimezone(r.FormValue("timezone"))
+	renderSheet(sheet, limit, err, w, r)
+}
+
+func handleSetCell(sheet sheets.Sheet, limit int, w http.ResponseWriter, r *http.Request) {
 	tableName := r.FormValue("table_name")
 	name := r.FormValue("col_name")
 	value := r.FormValue("value")
-	col := sheets.TableMap[tableName].Cols[name]
-	rowStr := r.FormValue("row")
-	row, err := strconv.Atoi(rowStr)
-	sheets.Check(err)
+	table := sheet.Connection().LoadedTable(tableName)
+	if table == nil {
+		writeError(w, "No such table "+tableName)
+		return
+	}
+	col, ok := table.Cols[name]
+	if !ok {
+		writeError(w, "No column "+name+" on "+tableName)
+		return
+	}
+	row, err := strconv.Atoi(r.FormValue("row"))
+	if err != nil {
+		writeError(w, "Invalid row "+r.FormValue("row"))
+		return
+	}
+
+	original := sheets.Cell{r.FormValue("original"), r.FormValue("original_null") == ""}
 
-	err = sheet.UpdateRows(
-		map[string]map[string]string{tableName: {name: value}},
-		map[string]map[string]string{tableName: getPKs(r)[tableName]})
-	cell := tableCell(tableName, col, row, sheets.Cell{value, value != ""}, err)
+	if sheet.Drafting() {
+		sheet.DraftUpdate(tableName, name, value, original, getPKs(r)[tableName])
+		w.Header().Set("HX-Trigger", "draftChanged")
+		templ.Handler(tableCell(sheet, tableName, col, row, sheets.Cell{value, value != ""}, original, nil)).ServeHTTP(w, r)
+		return
+	}
+	stored, err := sheet.UpdateCell(tableName, name, value, original, getPKs(r)[tableName])
+	var conflict *sheets.ConflictError
+	switch {
+	case errors.As(err, &conflict):
+		// Shows what the database has now, which is saved over if edited again
+		err = fmt.Errorf("%w. Your value was: %s", err, value)
+		stored, original = conflict.Current, conflict.Current
+	case err != nil:
+		stored = sheets.Cell{value, value != ""}
+	default:
+		original = stored
+		if len(sheet.ExtraCols) > 0 {
+			// Reloading the page recalculates the formulas which reference the cell
+			err = sheet.LoadPage(limit, getOffset(r), getCursor(r))
+			if err != nil {
+				log.Printf("Error recalculating cells referencing %s.%s: %s", tableName, name, err)
+				err = nil
+			} else {
+				triggerRecalculated(w, sheet.Dependents(tableName, name, row))
+			}
+		}
+	}
+	cell := tableCell(sheet, tableName, col, row, stored, original, err)
 	templ.Handler(cell).ServeHTTP(w, r)
 }
 
@@ -375,7 +949,46 @@ func handleFillColumnDown(sheet sheets.Sheet, limit int, w http.ResponseWriter,
 	i := mustGetInt(r, "i")
 	j := mustGetInt(r, "j")
 	formula := r.FormValue("formula")
-	sheet.FillColumnDown(i, j, formula)
 
-	reRenderSheet(sheet, limit, w, r)
+	// Fills to the end of the page shown
+	err := sheet.LoadPage(limit, getOffset(r), getCursor(r))
+	if err == nil {
+		err = sheet.FillColumnDown(i, j, formula)
+	}
+	renderSheet(sheet, limit, err, w, r)
+}
+
+// Number of audit log entries shown per page
+const auditPageSize = 100
+
+// Shows the changes made through the sheets the logged in user can see
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	user, err := sessionUser(r)
+	if err != nil {
+		refuseUnauthenticated(w, r)
+		return
+	}
+	// Only the changes made through sheets the user can edit are shown
+	visible := sheets.EditableSheets(user)
+	filter := sheets.AuditFilter{
+		TableName: r.FormValue("table"),
+		UserName:  r.FormValue("user"),
+		SheetIds:  maps.Keys(visible),
+	}
+	slices.Sort(filter.SheetIds)
+	valuesFilter := filter
+	filter.SheetId, _ = strconv.Atoi(r.FormValue("sheet"))
+	page, _ := strconv.Atoi(r.FormValue("page"))
+	page = max(page, 0)
+	// One more entry than is shown tells whether there is a next page
+	entries := sheets.LoadAuditLog(filter, auditPageSize+1, page*auditPageSize)
+	more := len(entries) > auditPageSize
+	if more {
+		entries = entries[:auditPageSize]
+	}
+	valuesFilter.TableName, valuesFilter.UserName = "", ""
+	templ.Handler(auditLog(user, visible, filter, entries,
+		sheets.AuditLogValues("tablename", valuesFilter),
+		sheets.AuditLogValues("username", valuesFilter),
+		page, more)).ServeHTTP(w, r)
 }
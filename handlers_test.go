@@ -0,0 +1,347 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package main
+
+import (
+	"acb/db-interface/auth"
+	"acb/db-interface/sheets"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Sends requests to handlers as a logged in user
+type requester func(handler http.HandlerFunc, method, path string, form url.Values) *httptest.ResponseRecorder
+
+// Opens a SQLite database with a table of 20 items, and a sheet on it owned by
+// the user whom requests are sent as
+func setupHandlers(t *testing.T) (sheets.Sheet, requester) {
+	path := filepath.Join(t.TempDir(), "handlers.db")
+	db, err := sql.Open("sqlite", path)
+	sheets.Check(err)
+	_, err = db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)")
+	sheets.Check(err)
+	for i := 1; i <= 20; i++ {
+		_, err = db.Exec("INSERT INTO items (name) VALUES ($1)", fmt.Sprintf("item %d", i))
+		sheets.Check(err)
+	}
+	sheets.Check(db.Close())
+
+	t.Setenv("DATABASE_URL", "sqlite://"+path)
+	sheets.Open()
+	t.Cleanup(sheets.Close)
+	sheets.InitSheetsTables()
+	sheets.InitPrefsTable()
+	sheets.LoadSheets()
+	signer = auth.NewSigner([]byte("test key"))
+	user, err := sheets.SetPassword("ann", "secret")
+	sheets.Check(err)
+	cookie := &http.Cookie{
+		Name:  sessionCookie,
+		Value: signer.Sign(fmt.Sprintf("%d session", user.Id), time.Now().Add(time.Hour)),
+	}
+
+	sheet := sheets.Sheet{OwnerId: user.Id}
+	sheet.SetTable("main.items")
+	request := func(handler http.HandlerFunc, method, path string, form url.Values) *httptest.ResponseRecorder {
+		if !form.Has("sheet_id") {
+			form.Set("sheet_id", fmt.Sprint(sheet.Id))
+		}
+		r := httptest.NewRequest(method, path+"?"+form.Encode(), nil)
+		if method == "POST" {
+			r = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	return sheet, request
+}
+
+// Run with -race to check that requests on the same sheet don't share state
+func TestConcurrentHandlers(t *testing.T) {
+	sheet, send := setupHandlers(t)
+	request := func(handler http.HandlerFunc, method, path string, form url.Values) {
+		w := send(handler, method, path, form)
+		if w.Code != http.StatusOK && w.Code != http.StatusNoContent {
+			t.Errorf("%s %s: %d %s", method, path, w.Code, w.Body)
+		}
+	}
+
+	const clients = 8
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			request(withSheetAndLimit(handleSetTable), "GET", "/table", url.Values{})
+			request(withSheetAndLimit(handleAddCol), "POST", "/add-column", url.Values{})
+			request(withSheetAndLimit(handleSetExtraCell), "POST", "/set-extra-cell", url.Values{
+				"i": {"0"}, "j": {fmt.Sprint(i)}, "formula": {"=1+1"},
+			})
+			request(withSheetAndLimit(handleSetColPref), "POST", "/set-column-prefs", url.Values{
+				"table_name": {"main.items"}, "col_name": {"name"}, "sorton": {"true"}, "ascending": {fmt.Sprint(i%2 == 0)},
+			})
+			request(withSheetAndLimit(handleSetCell), "POST", "/set-cell", url.Values{
+				"table_name": {"main.items"}, "col_name": {"name"}, "row": {fmt.Sprint(i)},
+				"value": {fmt.Sprintf("changed %d", i)}, "original": {fmt.Sprintf("item %d", i+1)},
+				"pk-main.items id": {fmt.Sprint(i + 1)},
+			})
+			request(withSheetAndLimit(handleRows), "GET", "/rows", url.Values{"offset": {"10"}})
+			request(withSheet(handleModal, false), "GET", "/modal", url.Values{})
+		}(i)
+	}
+	wg.Wait()
+
+	stored, _ := sheets.Store.Get(sheet.Id)
+	if len(stored.ExtraCols) != clients {
+		t.Errorf("Expected %d spreadsheet columns, got %d", clients, len(stored.ExtraCols))
+	}
+	stored.LoadRows(100, 0)
+	if len(stored.ExtraCols) != clients {
+		t.Errorf("Expected %d saved spreadsheet columns, got %d", clients, len(stored.ExtraCols))
+	}
+	for j := 0; j < clients; j++ {
+		if stored.ExtraCols[0].Cells[j].Value != "2" {
+			t.Errorf("Expected 2 in row %d, got %+v", j, stored.ExtraCols[0].Cells[j])
+		}
+	}
+}
+
+func TestFillColumnDown(t *testing.T) {
+	sheet, request := setupHandlers(t)
+	request(withSheetAndLimit(handleAddCol), "POST", "/add-column", url.Values{})
+	w := request(withSheetAndLimit(handleFillColumnDown), "POST", "/fill-column-down", url.Values{
+		"i": {"0"}, "j": {"1"}, "formula": {"=id2*2"}, "limit": {"10"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+
+	stored, _ := sheets.Store.Get(sheet.Id)
+	stored.LoadRows(10, 0)
+	cells := stored.ExtraCols[0].Cells
+	if cells[0].Formula != "" {
+		t.Errorf("Filled above the cell: %+v", cells[0])
+	}
+	for j := 1; j < 10; j++ {
+		if expected := fmt.Sprint((j + 1) * 2); cells[j].Value != expected {
+			t.Errorf("Expected %s in row %d, got %+v", expected, j, cells[j])
+		}
+	}
+}
+
+func TestPostOnly(t *testing.T) {
+	_, request := setupHandlers(t)
+	for path, handler := range map[string]http.HandlerFunc{
+		"/undo":       withSheetAndLimit(handleUndo),
+		"/redo":       withSheetAndLimit(handleRedo),
+		"/share-link": withSheet(handleShareLink, true),
+	} {
+		w := request(handler, "GET", path, url.Values{"days": {"1"}})
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("GET %s returned %d", path, w.Code)
+		}
+		w = request(handler, "POST", path, url.Values{"days": {"1"}})
+		if w.Code != http.StatusOK {
+			t.Errorf("POST %s returned %d: %s", path, w.Code, w.Body)
+		}
+	}
+
+	for path := range requiredRoles {
+		if formPaths[path] {
+			continue
+		}
+		handler := withSheet(func(sheet sheets.Sheet, w http.ResponseWriter, r *http.Request) {
+			t.Errorf("GET %s reached its handler", path)
+		}, true)
+		w := request(handler, "GET", path, url.Values{})
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("GET %s returned %d", path, w.Code)
+		}
+	}
+}
+
+func TestEventsEndWhenUnshared(t *testing.T) {
+	_, request := setupHandlers(t)
+	keepaliveInterval = 10 * time.Millisecond
+	defer func() { keepaliveInterval = 30 * time.Second }()
+	bob, err := sheets.SetPassword("bob", "secret")
+	sheets.Check(err)
+	shared := sheets.Sheet{OwnerId: bob.Id}
+	shared.SetTable("main.items")
+	sheets.Check(shared.Share("ann", sheets.Viewer))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- request(withSheet(handleEvents, true), "GET", "/events", url.Values{"sheet_id": {fmt.Sprint(shared.Id)}})
+	}()
+	select {
+	case w := <-done:
+		t.Fatalf("Stream ended while shared: %d %s", w.Code, w.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	sheets.Check(shared.Share("ann", sheets.NoRole))
+	select {
+	case w := <-done:
+		if !strings.Contains(w.Body.String(), "keepalive") {
+			t.Errorf("Expected keepalives before the stream ended, got %s", w.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stream still open after unsharing")
+	}
+}
+
+func TestEvictSessions(t *testing.T) {
+	now := time.Now()
+	historiesLock.Lock()
+	histories["old"] = sessionHistoryEntry{&sheets.History{}, now.Add(-sessionAge - time.Minute)}
+	histories["recent"] = sessionHistoryEntry{&sheets.History{}, now.Add(-time.Hour)}
+	evictHistories(now)
+	_, old := histories["old"]
+	_, recent := histories["recent"]
+	delete(histories, "recent")
+	historiesLock.Unlock()
+	if old || !recent {
+		t.Errorf("Expected only the recent history to be kept, kept old %t and recent %t", old, recent)
+	}
+
+	draftsLock.Lock()
+	drafts[draftKey{"old", 1}] = sessionDraftEntry{&sheets.Draft{}, now.Add(-sessionAge - time.Minute)}
+	drafts[draftKey{"recent", 1}] = sessionDraftEntry{&sheets.Draft{}, now.Add(-time.Hour)}
+	evictDrafts(now)
+	_, old = drafts[draftKey{"old", 1}]
+	_, recent = drafts[draftKey{"recent", 1}]
+	delete(drafts, draftKey{"recent", 1})
+	draftsLock.Unlock()
+	if old || !recent {
+		t.Errorf("Expected only the recent draft to be kept, kept old %t and recent %t", old, recent)
+	}
+}
+
+func TestViewerCannotSetTimezone(t *testing.T) {
+	_, request := setupHandlers(t)
+	bob, err := sheets.SetPassword("bob", "secret")
+	sheets.Check(err)
+	shared := sheets.Sheet{OwnerId: bob.Id}
+	shared.SetTable("main.items")
+	sheets.Check(shared.Share("ann", sheets.Viewer))
+
+	request(withSheetAndLimit(handleSetTimezone), "POST", "/set-timezone", url.Values{
+		"sheet_id": {fmt.Sprint(shared.Id)}, "timezone": {"Europe/Paris"},
+	})
+	stored, _ := sheets.Store.Get(shared.Id)
+	if stored.Timezone != shared.Timezone {
+		t.Errorf("Viewer changed the timezone to %s", stored.Timezone)
+	}
+}
+
+func TestLimitRange(t *testing.T) {
+	_, request := setupHandlers(t)
+	for limit, code := range map[string]int{
+		"":     http.StatusOK,
+		"1":    http.StatusOK,
+		"1000": http.StatusOK,
+		"0":    http.StatusBadRequest,
+		"-5":   http.StatusBadRequest,
+		"1001": http.StatusBadRequest,
+		"many": http.StatusBadRequest,
+	} {
+		w := request(withSheetAndLimit(handleRows), "GET", "/rows", url.Values{"limit": {limit}})
+		if w.Code != code {
+			t.Errorf("Limit %q returned %d, expected %d: %s", limit, w.Code, code, w.Body)
+		}
+	}
+}
+
+func TestDroppedTable(t *testing.T) {
+	sheet, request := setupHandlers(t)
+	db, err := sql.Open("sqlite", strings.TrimPrefix(os.Getenv("DATABASE_URL"), "sqlite://"))
+	sheets.Check(err)
+	_, err = db.Exec("DROP TABLE items")
+	sheets.Check(err)
+	sheets.Check(db.Close())
+	sheet.Connection().RefreshCatalog()
+
+	w := request(withSheetAndLimit(handleSetTable), "GET", "/table", url.Values{})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "no longer exists") {
+		t.Errorf("Unexpected response to a dropped table: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestSetCellUnknownColumn(t *testing.T) {
+	_, request := setupHandlers(t)
+	for _, form := range []url.Values{
+		{"table_name": {"main.missing"}, "col_name": {"name"}, "row": {"0"}},
+		{"table_name": {"main.items"}, "col_name": {"missing"}, "row": {"0"}},
+		{"table_name": {"main.items"}, "col_name": {"name"}, "row": {"first"}},
+	} {
+		form.Set("value", "changed")
+		form.Set("pk-main.items id", "1")
+		w := request(withSheetAndLimit(handleSetCell), "POST", "/set-cell", form)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Setting %v returned %d: %s", form, w.Code, w.Body)
+		}
+	}
+}
+
+func TestDiscardDraft(t *testing.T) {
+	sheet, request := setupHandlers(t)
+	request(withSheetAndLimit(handleDraft), "POST", "/draft", url.Values{"action": {"start"}})
+	draftsLock.Lock()
+	var draft *sheets.Draft
+	for key, entry := range drafts {
+		if key.sheetId == sheet.Id {
+			draft = entry.draft
+		}
+	}
+	draftsLock.Unlock()
+	if draft == nil {
+		t.Fatal("Draft not started")
+	}
+	request(withSheetAndLimit(handleSetCell), "POST", "/set-cell", url.Values{
+		"table_name": {"main.items"}, "col_name": {"name"}, "row": {"0"},
+		"value": {"changed"}, "original": {"item 1"}, "pk-main.items id": {"1"},
+	})
+	if len(sheet.WithDraft(draft).DraftChanges()) != 1 {
+		t.Fatal("Change not drafted")
+	}
+
+	w := request(withSheetAndLimit(handleDraft), "POST", "/draft", url.Values{"action": {"discard"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	if len(sheet.WithDraft(draft).DraftChanges()) != 0 {
+		t.Error("Draft not discarded")
+	}
+	draftsLock.Lock()
+	for key := range drafts {
+		if key.sheetId == sheet.Id {
+			t.Error("Session still drafting")
+		}
+	}
+	draftsLock.Unlock()
+}
@@ -0,0 +1,465 @@
+// Code generated by templ@v0.2.334 DO NOT EDIT.
+
+package main
+
+//lint:file-ignore SA4006 This context is only used if a nested component is present.
+
+import "github.com/a-h/templ"
+import "context"
+import "io"
+import "bytes"
+
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+
+import (
+	"acb/db-interface/sheets"
+	"fmt"
+	"strconv"
+)
+
+func importUploadModal(sheet sheets.Sheet, err error) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_1 := templ.GetChildren(ctx)
+		if var_1 == nil {
+			var_1 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		_, err = templBuffer.WriteString("<div id=\"modal\" class=\"modal is-active\" hx-target=\"#modal\" onclick=\"event.stopPropagation()\"><div class=\"modal-content box\"><form hx-post=\"/import\" hx-encoding=\"multipart/form-data\" hx-swap=\"outerHTML\" class=\"flex\"><input name=\"sheet_id\" type=\"hidden\" value=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(sheet.Id)))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\"><label>")
+		if err != nil {
+			return err
+		}
+		var_2 := `File`
+		_, err = templBuffer.WriteString(var_2)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</label><input name=\"file\" type=\"file\" accept=\".csv,.tsv,.xlsx\" class=\"import-file\"><button class=\"button is-primary\">")
+		if err != nil {
+			return err
+		}
+		var_3 := `Upload`
+		_, err = templBuffer.WriteString(var_3)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</button></form>")
+		if err != nil {
+			return err
+		}
+		if err != nil {
+			_, err = templBuffer.WriteString("<span class=\"has-text-danger\">")
+			if err != nil {
+				return err
+			}
+			var var_4 string = err.Error()
+			_, err = templBuffer.WriteString(templ.EscapeString(var_4))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</span>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</div><button class=\"modal-close\"></button></div>")
+		if err != nil {
+			return err
+		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
+
+func importModal(sheet sheets.Sheet, importId string, file sheets.ImportFile, targets []string, mapping map[int]string, result sheets.ImportResult, err error) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_5 := templ.GetChildren(ctx)
+		if var_5 == nil {
+			var_5 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		_, err = templBuffer.WriteString("<div id=\"modal\" class=\"modal is-active\" hx-target=\"#modal\" onclick=\"event.stopPropagation()\"><div class=\"modal-content box import-modal\"><form hx-post=\"/import\" hx-trigger=\"change\" hx-swap=\"outerHTML\"><input name=\"sheet_id\" type=\"hidden\" value=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(sheet.Id)))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\"><input name=\"import_id\" type=\"hidden\" value=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString(importId))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\"><label>")
+		if err != nil {
+			return err
+		}
+		var_6 := `Columns in `
+		_, err = templBuffer.WriteString(var_6)
+		if err != nil {
+			return err
+		}
+		var var_7 string = file.Name
+		_, err = templBuffer.WriteString(templ.EscapeString(var_7))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</label><div class=\"import-preview\"><table><thead><tr>")
+		if err != nil {
+			return err
+		}
+		for _, header := range file.Headers {
+			_, err = templBuffer.WriteString("<th>")
+			if err != nil {
+				return err
+			}
+			var var_8 string = header
+			_, err = templBuffer.WriteString(templ.EscapeString(var_8))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</th>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</tr><tr>")
+		if err != nil {
+			return err
+		}
+		for i := range file.Headers {
+			_, err = templBuffer.WriteString("<th><div class=\"select\"><select name=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("map-%d", i)))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"")
+			if err != nil {
+				return err
+			}
+			if result.Committed {
+				_, err = templBuffer.WriteString(" disabled")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString("><option value=\"\">")
+			if err != nil {
+				return err
+			}
+			var_9 := `Skip`
+			_, err = templBuffer.WriteString(var_9)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</option>")
+			if err != nil {
+				return err
+			}
+			for _, target := range targets {
+				_, err = templBuffer.WriteString("<option value=\"")
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString(templ.EscapeString(target))
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("\"")
+				if err != nil {
+					return err
+				}
+				if mapping[i] == target {
+					_, err = templBuffer.WriteString(" selected")
+					if err != nil {
+						return err
+					}
+				}
+				_, err = templBuffer.WriteString(">")
+				if err != nil {
+					return err
+				}
+				var var_10 string = target
+				_, err = templBuffer.WriteString(templ.EscapeString(var_10))
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("</option>")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString("</select></div></th>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</tr></thead><tbody>")
+		if err != nil {
+			return err
+		}
+		for j, row := range file.Rows[:min(len(file.Rows), 10)] {
+			var var_11 = []any{templ.KV("has-background-danger-light", result.Errors[j] != nil)}
+			err = templ.RenderCSSItems(ctx, templBuffer, var_11...)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("<tr class=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_11).String()))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\">")
+			if err != nil {
+				return err
+			}
+			for _, value := range row {
+				_, err = templBuffer.WriteString("<td>")
+				if err != nil {
+					return err
+				}
+				var var_12 string = value
+				_, err = templBuffer.WriteString(templ.EscapeString(var_12))
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("</td>")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString("</tr>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</tbody></table></div>")
+		if err != nil {
+			return err
+		}
+		if err != nil {
+			_, err = templBuffer.WriteString("<p class=\"has-text-danger\">")
+			if err != nil {
+				return err
+			}
+			var var_13 string = err.Error()
+			_, err = templBuffer.WriteString(templ.EscapeString(var_13))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</p>")
+			if err != nil {
+				return err
+			}
+		} else if result.Committed {
+			_, err = templBuffer.WriteString("<p>")
+			if err != nil {
+				return err
+			}
+			var_14 := `Imported `
+			_, err = templBuffer.WriteString(var_14)
+			if err != nil {
+				return err
+			}
+			var var_15 string = strconv.Itoa(result.Inserted)
+			_, err = templBuffer.WriteString(templ.EscapeString(var_15))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(" ")
+			if err != nil {
+				return err
+			}
+			var_16 := `rows.`
+			_, err = templBuffer.WriteString(var_16)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</p>")
+			if err != nil {
+				return err
+			}
+		} else {
+			_, err = templBuffer.WriteString("<p>")
+			if err != nil {
+				return err
+			}
+			var var_17 string = strconv.Itoa(result.Inserted)
+			_, err = templBuffer.WriteString(templ.EscapeString(var_17))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(" ")
+			if err != nil {
+				return err
+			}
+			var_18 := `of `
+			_, err = templBuffer.WriteString(var_18)
+			if err != nil {
+				return err
+			}
+			var var_19 string = strconv.Itoa(len(file.Rows))
+			_, err = templBuffer.WriteString(templ.EscapeString(var_19))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(" ")
+			if err != nil {
+				return err
+			}
+			var_20 := `rows are ready to import.`
+			_, err = templBuffer.WriteString(var_20)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</p> <ul class=\"has-text-danger\">")
+			if err != nil {
+				return err
+			}
+			for _, j := range result.ErrorRows(50) {
+				_, err = templBuffer.WriteString("<li>")
+				if err != nil {
+					return err
+				}
+				var_21 := `Row `
+				_, err = templBuffer.WriteString(var_21)
+				if err != nil {
+					return err
+				}
+				var var_22 string = strconv.Itoa(j + 1)
+				_, err = templBuffer.WriteString(templ.EscapeString(var_22))
+				if err != nil {
+					return err
+				}
+				var_23 := `: `
+				_, err = templBuffer.WriteString(var_23)
+				if err != nil {
+					return err
+				}
+				var var_24 string = result.Errors[j].Error()
+				_, err = templBuffer.WriteString(templ.EscapeString(var_24))
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("</li>")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString("</ul>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("<div class=\"flex full-width mt center\">")
+		if err != nil {
+			return err
+		}
+		if result.Committed {
+			_, err = templBuffer.WriteString("<a href=\"")
+			if err != nil {
+				return err
+			}
+			var var_25 templ.SafeURL = templ.SafeURL("?sheet_id=" + strconv.Itoa(sheet.Id))
+			_, err = templBuffer.WriteString(templ.EscapeString(string(var_25)))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\" class=\"button is-primary\">")
+			if err != nil {
+				return err
+			}
+			var_26 := `Ok`
+			_, err = templBuffer.WriteString(var_26)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a>")
+			if err != nil {
+				return err
+			}
+		} else {
+			_, err = templBuffer.WriteString("<button hx-post=\"/import\" hx-vals=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString("{\"commit\":\"true\"}"))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"")
+			if err != nil {
+				return err
+			}
+			if err != nil || len(result.Errors) > 0 {
+				_, err = templBuffer.WriteString(" disabled")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString(" class=\"button is-primary\">")
+			if err != nil {
+				return err
+			}
+			var_27 := `Import`
+			_, err = templBuffer.WriteString(var_27)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</button>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</div></form></div><button class=\"modal-close\"></button></div>")
+		if err != nil {
+			return err
+		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
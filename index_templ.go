@@ -27,7 +27,7 @@ import (
 	"fmt"
 )
 
-func toolbar(sheet sheets.Sheet, sheets map[int]sheets.Sheet) templ.Component {
+func toolbar(sheet sheets.Sheet, sheets map[int]sheets.Sheet, user sheets.User, link string) templ.Component {
 	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
 		templBuffer, templIsBuffer := w.(*bytes.Buffer)
 		if !templIsBuffer {
@@ -40,7 +40,7 @@ func toolbar(sheet sheets.Sheet, sheets map[int]sheets.Sheet) templ.Component {
 			var_1 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		_, err = templBuffer.WriteString("<div class=\"toolbar-group\"><button hx-get=\"/table\" hx-target=\"#table\" hx-trigger=\"click,load\"")
+		_, err = templBuffer.WriteString("<div class=\"toolbar-group\"><button id=\"refresh\" hx-get=\"/table\" hx-target=\"#table\" hx-trigger=\"click,load\"")
 		if err != nil {
 			return err
 		}
@@ -59,21 +59,73 @@ func toolbar(sheet sheets.Sheet, sheets map[int]sheets.Sheet) templ.Component {
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString("</button></div><div class=\"dropdown-menu\"><div class=\"dropdown-content\"><a hx-get=\"/modal\" hx-target=\"#modal\" hx-swap=\"outerHTML\" class=\"dropdown-item\">")
+		_, err = templBuffer.WriteString("</button></div><div class=\"dropdown-menu\"><div class=\"dropdown-content\">")
 		if err != nil {
 			return err
 		}
-		var_3 := `Tables & Joins`
-		_, err = templBuffer.WriteString(var_3)
-		if err != nil {
-			return err
+		if !sheet.ReadOnly() {
+			_, err = templBuffer.WriteString("<a id=\"undo\" hx-post=\"/undo\" hx-target=\"#table\" class=\"dropdown-item\">")
+			if err != nil {
+				return err
+			}
+			var_3 := `Undo`
+			_, err = templBuffer.WriteString(var_3)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a> <a id=\"redo\" hx-post=\"/redo\" hx-target=\"#table\" class=\"dropdown-item\">")
+			if err != nil {
+				return err
+			}
+			var_4 := `Redo`
+			_, err = templBuffer.WriteString(var_4)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a> <a hx-post=\"/draft\" hx-vals=\"{&#34;action&#34;: &#34;start&#34;}\" hx-target=\"#table\" class=\"dropdown-item\">")
+			if err != nil {
+				return err
+			}
+			var_5 := `Draft Mode`
+			_, err = templBuffer.WriteString(var_5)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a>")
+			if err != nil {
+				return err
+			}
+		}
+		if sheet.CanEdit() {
+			_, err = templBuffer.WriteString("<a hx-get=\"/modal\" hx-target=\"#modal\" hx-swap=\"outerHTML\" class=\"dropdown-item\">")
+			if err != nil {
+				return err
+			}
+			var_6 := `Tables & Joins`
+			_, err = templBuffer.WriteString(var_6)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a> <a hx-post=\"/refresh-schema\" hx-target=\"#table\" class=\"dropdown-item\">")
+			if err != nil {
+				return err
+			}
+			var_7 := `Refresh Schema`
+			_, err = templBuffer.WriteString(var_7)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a>")
+			if err != nil {
+				return err
+			}
 		}
-		_, err = templBuffer.WriteString("</a><a hx-post=\"/unhide-columns\" hx-target=\"#table\" class=\"dropdown-item\">")
+		_, err = templBuffer.WriteString("<a hx-post=\"/unhide-columns\" hx-target=\"#table\" class=\"dropdown-item\">")
 		if err != nil {
 			return err
 		}
-		var_4 := `Show All Columns`
-		_, err = templBuffer.WriteString(var_4)
+		var_8 := `Show All Columns`
+		_, err = templBuffer.WriteString(var_8)
 		if err != nil {
 			return err
 		}
@@ -81,8 +133,8 @@ func toolbar(sheet sheets.Sheet, sheets map[int]sheets.Sheet) templ.Component {
 		if err != nil {
 			return err
 		}
-		var_5 := `Clear All Filters`
-		_, err = templBuffer.WriteString(var_5)
+		var_9 := `Clear All Filters`
+		_, err = templBuffer.WriteString(var_9)
 		if err != nil {
 			return err
 		}
@@ -90,27 +142,33 @@ func toolbar(sheet sheets.Sheet, sheets map[int]sheets.Sheet) templ.Component {
 		if err != nil {
 			return err
 		}
-		var_6 := `Open`
-		_, err = templBuffer.WriteString(var_6)
-		if err != nil {
-			return err
-		}
-		_, err = templBuffer.WriteString("</button></div><div class=\"dropdown-menu\"><div class=\"dropdown-content\"><a hx-get=\"/modal\" hx-target=\"#modal\" hx-swap=\"outerHTML\" hx-include=\"unset\" class=\"dropdown-item\">")
+		var_10 := `Open`
+		_, err = templBuffer.WriteString(var_10)
 		if err != nil {
 			return err
 		}
-		var_7 := `+ New`
-		_, err = templBuffer.WriteString(var_7)
+		_, err = templBuffer.WriteString("</button></div><div class=\"dropdown-menu\"><div class=\"dropdown-content\">")
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString("</a>")
-		if err != nil {
-			return err
+		if user.Id != 0 {
+			_, err = templBuffer.WriteString("<a hx-get=\"/modal\" hx-target=\"#modal\" hx-swap=\"outerHTML\" hx-include=\"unset\" class=\"dropdown-item\">")
+			if err != nil {
+				return err
+			}
+			var_11 := `+ New`
+			_, err = templBuffer.WriteString(var_11)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a>")
+			if err != nil {
+				return err
+			}
 		}
 		for _, s := range sheets {
-			var var_8 = []any{"dropdown-item", templ.KV("is-active", s.Id == sheet.Id)}
-			err = templ.RenderCSSItems(ctx, templBuffer, var_8...)
+			var var_12 = []any{"dropdown-item", templ.KV("is-active", s.Id == sheet.Id)}
+			err = templ.RenderCSSItems(ctx, templBuffer, var_12...)
 			if err != nil {
 				return err
 			}
@@ -118,8 +176,8 @@ func toolbar(sheet sheets.Sheet, sheets map[int]sheets.Sheet) templ.Component {
 			if err != nil {
 				return err
 			}
-			var var_9 templ.SafeURL = templ.SafeURL(fmt.Sprintf("/?sheet_id=%d", s.Id))
-			_, err = templBuffer.WriteString(templ.EscapeString(string(var_9)))
+			var var_13 templ.SafeURL = templ.SafeURL(fmt.Sprintf("/?sheet_id=%d", s.Id))
+			_, err = templBuffer.WriteString(templ.EscapeString(string(var_13)))
 			if err != nil {
 				return err
 			}
@@ -127,7 +185,7 @@ func toolbar(sheet sheets.Sheet, sheets map[int]sheets.Sheet) templ.Component {
 			if err != nil {
 				return err
 			}
-			_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_8).String()))
+			_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_12).String()))
 			if err != nil {
 				return err
 			}
@@ -135,8 +193,8 @@ func toolbar(sheet sheets.Sheet, sheets map[int]sheets.Sheet) templ.Component {
 			if err != nil {
 				return err
 			}
-			var var_10 string = s.VisibleName()
-			_, err = templBuffer.WriteString(templ.EscapeString(var_10))
+			var var_14 string = s.VisibleName()
+			_, err = templBuffer.WriteString(templ.EscapeString(var_14))
 			if err != nil {
 				return err
 			}
@@ -144,63 +202,181 @@ func toolbar(sheet sheets.Sheet, sheets map[int]sheets.Sheet) templ.Component {
 			if err != nil {
 				return err
 			}
-			var_11 := `- `
-			_, err = templBuffer.WriteString(var_11)
+			var_15 := `- `
+			_, err = templBuffer.WriteString(var_15)
 			if err != nil {
 				return err
 			}
-			var var_12 string = fmt.Sprintf("%d", s.Id)
-			_, err = templBuffer.WriteString(templ.EscapeString(var_12))
+			var var_16 string = fmt.Sprintf("%d", s.Id)
+			_, err = templBuffer.WriteString(templ.EscapeString(var_16))
 			if err != nil {
 				return err
 			}
+			if s.ConnectionLabel() != "" {
+				_, err = templBuffer.WriteString("<span class=\"tag ml-2\">")
+				if err != nil {
+					return err
+				}
+				var var_17 string = s.ConnectionLabel()
+				_, err = templBuffer.WriteString(templ.EscapeString(var_17))
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("</span>")
+				if err != nil {
+					return err
+				}
+			}
 			_, err = templBuffer.WriteString("</a>")
 			if err != nil {
 				return err
 			}
 		}
-		_, err = templBuffer.WriteString("</div></div></div><button disabled>")
+		_, err = templBuffer.WriteString("</div></div></div><div class=\"dropdown is-hoverable\"><div class=\"dropdown-trigger\"><button aria-haspopup=\"true\" aria-controls=\"dropdown-menu\"")
+		if err != nil {
+			return err
+		}
+		if sheet.TableFullName() == "" {
+			_, err = templBuffer.WriteString(" disabled")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString(">")
+		if err != nil {
+			return err
+		}
+		var_18 := `Export`
+		_, err = templBuffer.WriteString(var_18)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</button></div><div class=\"dropdown-menu\"><form action=\"/export\" method=\"get\" class=\"dropdown-content\"><input name=\"sheet_id\" type=\"hidden\" value=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("%d", sheet.Id)))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\">")
+		if err != nil {
+			return err
+		}
+		if link != "" {
+			_, err = templBuffer.WriteString("<input name=\"link\" type=\"hidden\" value=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(link))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\">")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("<button name=\"format\" value=\"csv\" class=\"dropdown-item\">")
 		if err != nil {
 			return err
 		}
-		var_13 := `Export`
-		_, err = templBuffer.WriteString(var_13)
+		var_19 := `CSV`
+		_, err = templBuffer.WriteString(var_19)
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString("</button><div class=\"dropdown is-hoverable\"><div class=\"dropdown-trigger\"><button aria-haspopup=\"true\" aria-controls=\"dropdown-menu\">")
+		_, err = templBuffer.WriteString("</button><button name=\"format\" value=\"tsv\" class=\"dropdown-item\">")
 		if err != nil {
 			return err
 		}
-		var_14 := `Insert`
-		_, err = templBuffer.WriteString(var_14)
+		var_20 := `TSV`
+		_, err = templBuffer.WriteString(var_20)
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString("</button></div><div class=\"dropdown-menu\"><div class=\"dropdown-content\"><a hx-get=\"/new-row\" hx-target=\"tbody\" hx-swap=\"afterbegin\" class=\"dropdown-item\">")
+		_, err = templBuffer.WriteString("</button><button name=\"format\" value=\"xlsx\" class=\"dropdown-item\">")
 		if err != nil {
 			return err
 		}
-		var_15 := `Row`
-		_, err = templBuffer.WriteString(var_15)
+		var_21 := `XLSX`
+		_, err = templBuffer.WriteString(var_21)
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString("</a><a hx-post=\"/add-column\" hx-target=\"#table\" hx-trigger=\"click\" class=\"dropdown-item\">")
+		_, err = templBuffer.WriteString("</button><label class=\"dropdown-item\"><input name=\"formulas\" type=\"checkbox\" value=\"true\"> ")
 		if err != nil {
 			return err
 		}
-		var_16 := `Column`
-		_, err = templBuffer.WriteString(var_16)
+		var_22 := `Include formulas`
+		_, err = templBuffer.WriteString(var_22)
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString("</a></div></div></div><button hx-get=\"/static/help.html\" hx-target=\"body\" hx-swap=\"beforeend\">")
+		_, err = templBuffer.WriteString("</label></form></div></div><div class=\"dropdown is-hoverable\"><div class=\"dropdown-trigger\"><button aria-haspopup=\"true\" aria-controls=\"dropdown-menu\">")
 		if err != nil {
 			return err
 		}
-		var_17 := `Help`
-		_, err = templBuffer.WriteString(var_17)
+		var_23 := `Insert`
+		_, err = templBuffer.WriteString(var_23)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</button></div><div class=\"dropdown-menu\"><div class=\"dropdown-content\">")
+		if err != nil {
+			return err
+		}
+		if !sheet.ReadOnly() {
+			_, err = templBuffer.WriteString("<a hx-get=\"/new-row\" hx-target=\"tbody\" hx-swap=\"afterbegin\" class=\"dropdown-item\">")
+			if err != nil {
+				return err
+			}
+			var_24 := `Row`
+			_, err = templBuffer.WriteString(var_24)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a>")
+			if err != nil {
+				return err
+			}
+		}
+		if sheet.CanEdit() {
+			_, err = templBuffer.WriteString("<a hx-post=\"/add-column\" hx-target=\"#table\" hx-trigger=\"click\" class=\"dropdown-item\">")
+			if err != nil {
+				return err
+			}
+			var_25 := `Column`
+			_, err = templBuffer.WriteString(var_25)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a>")
+			if err != nil {
+				return err
+			}
+		}
+		if !sheet.ReadOnly() {
+			_, err = templBuffer.WriteString("<a hx-get=\"/import\" hx-target=\"#modal\" hx-swap=\"outerHTML\" class=\"dropdown-item\">")
+			if err != nil {
+				return err
+			}
+			var_26 := `Rows from File`
+			_, err = templBuffer.WriteString(var_26)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</div></div></div><button hx-get=\"/static/help.html\" hx-target=\"body\" hx-swap=\"beforeend\">")
+		if err != nil {
+			return err
+		}
+		var_27 := `Help`
+		_, err = templBuffer.WriteString(var_27)
 		if err != nil {
 			return err
 		}
@@ -212,16 +388,120 @@ func toolbar(sheet sheets.Sheet, sheets map[int]sheets.Sheet) templ.Component {
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString("\"></div><div class=\"toolbar-group\"><button disabled>")
+		_, err = templBuffer.WriteString("\">")
 		if err != nil {
 			return err
 		}
-		var_18 := `Share`
-		_, err = templBuffer.WriteString(var_18)
+		if sheet.TableFullName() != "" {
+			_, err = templBuffer.WriteString("<input hx-post=\"/set-timezone\" hx-target=\"#table\" name=\"timezone\" value=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(sheet.Timezone))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\" placeholder=\"UTC\" title=\"Time zone of the sheet&#39;s dates, e.g. Europe/Paris\"")
+			if err != nil {
+				return err
+			}
+			if !sheet.CanEdit() {
+				_, err = templBuffer.WriteString(" disabled")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString(">")
+			if err != nil {
+				return err
+			}
+		}
+		if sheet.TableFullName() != "" && sheet.ReadOnly() {
+			_, err = templBuffer.WriteString("<span class=\"tag ml-2\">")
+			if err != nil {
+				return err
+			}
+			var_28 := `Read-only`
+			_, err = templBuffer.WriteString(var_28)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</span>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</div><div class=\"toolbar-group\"><button hx-get=\"/share\" hx-target=\"#modal\" hx-swap=\"outerHTML\"")
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString("</button></div>")
+		if sheet.Id == 0 || !sheet.CanShare() {
+			_, err = templBuffer.WriteString(" disabled")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString(">")
+		if err != nil {
+			return err
+		}
+		var_29 := `Share`
+		_, err = templBuffer.WriteString(var_29)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</button>")
+		if err != nil {
+			return err
+		}
+		if user.Id == 0 {
+			_, err = templBuffer.WriteString("<a href=\"/login\" class=\"button\">")
+			if err != nil {
+				return err
+			}
+			var_30 := `Log In`
+			_, err = templBuffer.WriteString(var_30)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a>")
+			if err != nil {
+				return err
+			}
+		} else {
+			_, err = templBuffer.WriteString("<div class=\"dropdown is-hoverable is-right\"><div class=\"dropdown-trigger\"><button aria-haspopup=\"true\" aria-controls=\"dropdown-menu\">")
+			if err != nil {
+				return err
+			}
+			var var_31 string = user.Name
+			_, err = templBuffer.WriteString(templ.EscapeString(var_31))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</button></div><div class=\"dropdown-menu\"><div class=\"dropdown-content\"><a href=\"/audit\" class=\"dropdown-item\">")
+			if err != nil {
+				return err
+			}
+			var_32 := `Audit Log`
+			_, err = templBuffer.WriteString(var_32)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a><a href=\"/logout\" class=\"dropdown-item\">")
+			if err != nil {
+				return err
+			}
+			var_33 := `Log Out`
+			_, err = templBuffer.WriteString(var_33)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a></div></div></div>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</div>")
 		if err != nil {
 			return err
 		}
@@ -232,7 +512,7 @@ func toolbar(sheet sheets.Sheet, sheets map[int]sheets.Sheet) templ.Component {
 	})
 }
 
-func index(sheet sheets.Sheet, sheets map[int]sheets.Sheet) templ.Component {
+func index(sheet sheets.Sheet, sheets map[int]sheets.Sheet, user sheets.User, link string) templ.Component {
 	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
 		templBuffer, templIsBuffer := w.(*bytes.Buffer)
 		if !templIsBuffer {
@@ -240,17 +520,17 @@ func index(sheet sheets.Sheet, sheets map[int]sheets.Sheet) templ.Component {
 			defer templ.ReleaseBuffer(templBuffer)
 		}
 		ctx = templ.InitializeContext(ctx)
-		var_19 := templ.GetChildren(ctx)
-		if var_19 == nil {
-			var_19 = templ.NopComponent
+		var_34 := templ.GetChildren(ctx)
+		if var_34 == nil {
+			var_34 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
 		_, err = templBuffer.WriteString("<!doctype html><html><head><script src=\"https://unpkg.com/htmx.org@1.9.5\">")
 		if err != nil {
 			return err
 		}
-		var_20 := ``
-		_, err = templBuffer.WriteString(var_20)
+		var_35 := ``
+		_, err = templBuffer.WriteString(var_35)
 		if err != nil {
 			return err
 		}
@@ -258,46 +538,54 @@ func index(sheet sheets.Sheet, sheets map[int]sheets.Sheet) templ.Component {
 		if err != nil {
 			return err
 		}
-		var_21 := ``
-		_, err = templBuffer.WriteString(var_21)
+		var_36 := ``
+		_, err = templBuffer.WriteString(var_36)
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString("</script><script src=\"/static/index.js\"></script><link rel=\"stylesheet\" href=\"https://cdn.jsdelivr.net/npm/bulma@0.9.4/css/bulma.min.css\"><link rel=\"stylesheet\" href=\"/static/index.css\"></head><body hx-include=\"[name=sheet_id]\"><div id=\"toolbar\">")
+		_, err = templBuffer.WriteString("</script><script src=\"/static/index.js\"></script><link rel=\"stylesheet\" href=\"https://cdn.jsdelivr.net/npm/bulma@0.9.4/css/bulma.min.css\"><link rel=\"stylesheet\" href=\"/static/index.css\"></head><body hx-include=\"")
 		if err != nil {
 			return err
 		}
-		err = toolbar(sheet, sheets).Render(ctx, templBuffer)
+		_, err = templBuffer.WriteString(templ.EscapeString("[name=sheet_id],[name=link]," + pageFields))
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString("</div><div class=\"scrollable\" hx-target=\"#table\" hx-ext=\"response-targets\"><table id=\"table\" hx-trigger=\"click\"></table><div id=\"limit-row\" class=\"flex center scrolling-content-container\"><label>")
+		_, err = templBuffer.WriteString("\"><div id=\"toolbar\">")
 		if err != nil {
 			return err
 		}
-		var_22 := `Showing`
-		_, err = templBuffer.WriteString(var_22)
+		err = toolbar(sheet, sheets, user, link).Render(ctx, templBuffer)
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString(" <input name=\"limit\" inputmode=\"numeric\" pattern=\"[0-9]*\" value=\"100\" hx-get=\"/table\"> ")
+		_, err = templBuffer.WriteString("</div><div class=\"scrollable\" hx-target=\"#table\" hx-ext=\"response-targets\"><table id=\"table\" hx-trigger=\"click\"></table></div><div id=\"modal\"></div><input name=\"sheet_id\" type=\"hidden\" value=\"")
 		if err != nil {
 			return err
 		}
-		var_23 := `rows`
-		_, err = templBuffer.WriteString(var_23)
+		_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("%d", sheet.Id)))
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString("</label></div></div><div id=\"modal\"></div><input name=\"sheet_id\" type=\"hidden\" value=\"")
+		_, err = templBuffer.WriteString("\">")
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("%d", sheet.Id)))
-		if err != nil {
-			return err
+		if link != "" {
+			_, err = templBuffer.WriteString("<input name=\"link\" type=\"hidden\" value=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(link))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\">")
+			if err != nil {
+				return err
+			}
 		}
-		_, err = templBuffer.WriteString("\"></body></html>")
+		_, err = templBuffer.WriteString("</body></html>")
 		if err != nil {
 			return err
 		}
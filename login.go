@@ -0,0 +1,275 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package main
+
+import (
+	"acb/db-interface/auth"
+	"acb/db-interface/sheets"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-h/templ"
+)
+
+const sessionCookie = "rs_session"
+const stateCookie = "rs_oidc_state"
+const sessionAge = 7 * 24 * time.Hour
+
+var signer auth.Signer
+
+// Set if users can log in through an OIDC provider
+var provider *auth.Provider
+
+type userKey struct{}
+
+// An undo history, kept until its session logs out or goes unused for sessionAge
+type sessionHistoryEntry struct {
+	history *sheets.History
+	usedAt  time.Time
+}
+
+var histories = make(map[string]sessionHistoryEntry)
+var historiesLock sync.Mutex
+
+// Drafts of sheets in draft mode by session
+type draftKey struct {
+	sessionId string
+	sheetId   int
+}
+
+type sessionDraftEntry struct {
+	draft  *sheets.Draft
+	usedAt time.Time
+}
+
+var drafts = make(map[draftKey]sessionDraftEntry)
+var draftsLock sync.Mutex
+
+// Returns the user who sent a request which passed through withSheet
+func requestUser(r *http.Request) sheets.User {
+	return r.Context().Value(userKey{}).(sheets.User)
+}
+
+// The session cookie holds the user's id and a random session id
+func sessionUser(r *http.Request) (sheets.User, error) {
+	value, err := signer.Cookie(r, sessionCookie)
+	if err != nil {
+		return sheets.User{}, err
+	}
+	idStr, _, _ := strings.Cut(value, " ")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return sheets.User{}, err
+	}
+	return sheets.GetUser(id)
+}
+
+func sessionId(r *http.Request) string {
+	value, _ := signer.Cookie(r, sessionCookie)
+	_, id, _ := strings.Cut(value, " ")
+	return id
+}
+
+// Returns the undo history of the request's session
+func sessionHistory(r *http.Request) *sheets.History {
+	id := sessionId(r)
+	if id == "" {
+		return nil
+	}
+	historiesLock.Lock()
+	defer historiesLock.Unlock()
+	now := time.Now()
+	evictHistories(now)
+	entry, ok := histories[id]
+	if !ok {
+		entry.history = &sheets.History{}
+	}
+	entry.usedAt = now
+	histories[id] = entry
+	return entry.history
+}
+
+// Forgets the histories of sessions unused for sessionAge before now. The
+// caller holds historiesLock.
+func evictHistories(now time.Time) {
+	for id, entry := range histories {
+		if now.Sub(entry.usedAt) > sessionAge {
+			delete(histories, id)
+		}
+	}
+}
+
+// Sends the browser to the login page, including from htmx requests
+func refuseUnauthenticated(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("HX-Request") != "" {
+		w.Header().Set("HX-Redirect", "/login")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func startSession(w http.ResponseWriter, r *http.Request, user sheets.User) {
+	log.Printf("Logged in %s", user.Name)
+	signer.SetCookie(w, sessionCookie, strconv.Itoa(user.Id)+" "+auth.RandomString(), sessionAge)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func providerName() string {
+	if provider == nil {
+		return ""
+	}
+	u, err := url.Parse(provider.Issuer)
+	if err != nil || u.Host == "" {
+		return provider.Issuer
+	}
+	return u.Host
+}
+
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		templ.Handler(login(providerName(), nil)).ServeHTTP(w, r)
+		return
+	}
+	user, err := sheets.Login(r.FormValue("name"), r.FormValue("password"))
+	if err != nil {
+		templ.Handler(login(providerName(), err), templ.WithStatus(http.StatusUnauthorized)).ServeHTTP(w, r)
+		return
+	}
+	startSession(w, r, user)
+}
+
+// Returns the session's draft of the sheet, nil if it's not in draft mode
+func sessionDraft(r *http.Request, sheetId int) *sheets.Draft {
+	draftsLock.Lock()
+	defer draftsLock.Unlock()
+	now := time.Now()
+	evictDrafts(now)
+	key := draftKey{sessionId(r), sheetId}
+	entry, ok := drafts[key]
+	if !ok {
+		return nil
+	}
+	entry.usedAt = now
+	drafts[key] = entry
+	return entry.draft
+}
+
+// Puts the sheet in draft mode for the session, or takes it out if draft is nil
+func setSessionDraft(r *http.Request, sheetId int, draft *sheets.Draft) {
+	draftsLock.Lock()
+	defer draftsLock.Unlock()
+	now := time.Now()
+	evictDrafts(now)
+	if draft == nil {
+		delete(drafts, draftKey{sessionId(r), sheetId})
+	} else {
+		drafts[draftKey{sessionId(r), sheetId}] = sessionDraftEntry{draft, now}
+	}
+}
+
+// Forgets the drafts of sessions unused for sessionAge before now. The caller
+// holds draftsLock.
+func evictDrafts(now time.Time) {
+	for key, entry := range drafts {
+		if now.Sub(entry.usedAt) > sessionAge {
+			delete(drafts, key)
+		}
+	}
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	historiesLock.Lock()
+	delete(histories, sessionId(r))
+	historiesLock.Unlock()
+	draftsLock.Lock()
+	for key := range drafts {
+		if key.sessionId == sessionId(r) {
+			delete(drafts, key)
+		}
+	}
+	draftsLock.Unlock()
+	auth.ClearCookie(w, sessionCookie)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if provider == nil {
+		http.NotFound(w, r)
+		return
+	}
+	state, nonce := auth.RandomString(), auth.RandomString()
+	signer.SetCookie(w, stateCookie, state+" "+nonce, 10*time.Minute)
+	http.Redirect(w, r, provider.AuthCodeURL(state, nonce), http.StatusFound)
+}
+
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if provider == nil {
+		http.NotFound(w, r)
+		return
+	}
+	value, err := signer.Cookie(r, stateCookie)
+	state, nonce, _ := strings.Cut(value, " ")
+	if err == nil && (state == "" || r.FormValue("state") != state) {
+		err = errors.New("Login expired, please try again")
+	}
+	subject, name := "", ""
+	if err == nil {
+		subject, name, err = provider.Exchange(r.Context(), r.FormValue("code"), nonce)
+	}
+	user := sheets.User{}
+	if err == nil {
+		user, err = sheets.OIDCUser(subject, name)
+	}
+	auth.ClearCookie(w, stateCookie)
+	if err != nil {
+		log.Printf("OIDC login failed: %s", err)
+		templ.Handler(login(providerName(), err), templ.WithStatus(http.StatusUnauthorized)).ServeHTTP(w, r)
+		return
+	}
+	startSession(w, r, user)
+}
+
+// Adds a user with the password read from stdin, or changes their password
+func addUser(name string) {
+	fmt.Fprintf(os.Stderr, "Password for %s: ", name)
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		sheets.Check(err)
+	}
+	_, err = sheets.SetPassword(name, strings.TrimRight(password, "\r\n"))
+	sheets.Check(err)
+	log.Printf("Set the password of %s", name)
+}
+
+// Sets up sessions and the OIDC provider, if RS_OIDC_ISSUER is set
+func initAuth() {
+	signer = auth.SignerFromEnv()
+	var err error
+	provider, err = auth.ProviderFromEnv(context.Background())
+	sheets.Check(err)
+	if provider == nil && !sheets.HasUsers() {
+		log.Println("No users can log in, add one with --add-user <name>")
+	}
+}
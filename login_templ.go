@@ -0,0 +1,117 @@
+// Code generated by templ@v0.2.334 DO NOT EDIT.
+
+package main
+
+//lint:file-ignore SA4006 This context is only used if a nested component is present.
+
+import "github.com/a-h/templ"
+import "context"
+import "io"
+import "bytes"
+
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+
+func login(provider string, err error) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_1 := templ.GetChildren(ctx)
+		if var_1 == nil {
+			var_1 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		_, err = templBuffer.WriteString("<!doctype html><html><head><link rel=\"stylesheet\" href=\"https://cdn.jsdelivr.net/npm/bulma@0.9.4/css/bulma.min.css\"><link rel=\"stylesheet\" href=\"/static/index.css\"></head><body><section class=\"section\"><div class=\"box login\"><form method=\"post\" action=\"/login\"><div class=\"field\"><label class=\"label\">")
+		if err != nil {
+			return err
+		}
+		var_2 := `User name`
+		_, err = templBuffer.WriteString(var_2)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</label><input name=\"name\" class=\"input\" autofocus></div><div class=\"field\"><label class=\"label\">")
+		if err != nil {
+			return err
+		}
+		var_3 := `Password`
+		_, err = templBuffer.WriteString(var_3)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</label><input name=\"password\" type=\"password\" class=\"input\"></div>")
+		if err != nil {
+			return err
+		}
+		if err != nil {
+			_, err = templBuffer.WriteString("<p class=\"has-text-danger\">")
+			if err != nil {
+				return err
+			}
+			var var_4 string = err.Error()
+			_, err = templBuffer.WriteString(templ.EscapeString(var_4))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</p>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("<div class=\"flex full-width mt center\"><button class=\"button is-primary\">")
+		if err != nil {
+			return err
+		}
+		var_5 := `Log In`
+		_, err = templBuffer.WriteString(var_5)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</button></div></form>")
+		if err != nil {
+			return err
+		}
+		if provider != "" {
+			_, err = templBuffer.WriteString("<div class=\"flex full-width mt center\"><a href=\"/oidc/login\" class=\"button is-light\">")
+			if err != nil {
+				return err
+			}
+			var_6 := `Log In with `
+			_, err = templBuffer.WriteString(var_6)
+			if err != nil {
+				return err
+			}
+			var var_7 string = provider
+			_, err = templBuffer.WriteString(templ.EscapeString(var_7))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</a></div>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</div></section></body></html>")
+		if err != nil {
+			return err
+		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
@@ -14,14 +14,15 @@ package main
 
 import (
 	"acb/db-interface/sheets"
+	"log"
 	"net/http"
 	"os"
 	"slices"
 )
 
 func main() {
-	conn := sheets.Open()
-	defer conn.Close()
+	sheets.Open()
+	defer sheets.Close()
 
 	createExampleTable := slices.Contains(os.Args[1:], "--create-example-tables")
 	if createExampleTable {
@@ -35,20 +36,58 @@ func main() {
 
 	sheets.LoadSheets()
 
+	i := slices.Index(os.Args, "--add-user")
+	if i != -1 && i+1 < len(os.Args) {
+		addUser(os.Args[i+1])
+		return
+	}
+	i = slices.Index(os.Args, "--set-db-role")
+	if i != -1 && i+2 < len(os.Args) {
+		sheets.Check(sheets.SetDBRole(os.Args[i+1], os.Args[i+2]))
+		log.Printf("Set the database role of %s to %s", os.Args[i+1], os.Args[i+2])
+		return
+	}
+	i = slices.Index(os.Args, "--claim-sheets")
+	if i != -1 && i+1 < len(os.Args) {
+		claimed, err := sheets.ClaimSheets(os.Args[i+1])
+		sheets.Check(err)
+		log.Printf("%s now owns %d sheets", os.Args[i+1], claimed)
+		return
+	}
+	initAuth()
+
+	http.HandleFunc("/login", handleLogin)
+	http.HandleFunc("/logout", handleLogout)
+	http.HandleFunc("/oidc/login", handleOIDCLogin)
+	http.HandleFunc("/oidc/callback", handleOIDCCallback)
+
 	http.HandleFunc("/modal", withSheet(handleModal, false))
 	http.HandleFunc("/table", withSheetAndLimit(handleSetTable))
+	http.HandleFunc("/rows", withSheetAndLimit(handleRows))
 	http.HandleFunc("/new-row", withSheetAndLimit(handleNewRow))
 	http.HandleFunc("/add-row", withSheetAndLimit(handleAddRow))
+	http.HandleFunc("/delete-row", withSheetAndLimit(handleDeleteRow))
+	http.HandleFunc("/undo", withSheetAndLimit(handleUndo))
+	http.HandleFunc("/redo", withSheetAndLimit(handleRedo))
+	http.HandleFunc("/draft", withSheetAndLimit(handleDraft))
+	http.HandleFunc("/events", withSheet(handleEvents, true))
+	http.HandleFunc("/refresh-schema", withSheetAndLimit(handleRefreshSchema))
 	http.HandleFunc("/add-column", withSheetAndLimit(handleAddCol))
 	http.HandleFunc("/rename-column", withSheetAndLimit(handleRenameCol))
 	http.HandleFunc("/delete-column", withSheetAndLimit(handleDeleteCol))
 	http.HandleFunc("/set-column-prefs", withSheetAndLimit(handleSetColPref))
 	http.HandleFunc("/unhide-columns", withSheetAndLimit(handleUnhideCols))
 	http.HandleFunc("/clear-filters", withSheetAndLimit(handleClearFilters))
-	http.HandleFunc("/set-cell", withSheet(handleSetCell, true))
-	http.HandleFunc("/set-extra-cell", withSheet(handleSetExtraCell, true))
+	http.HandleFunc("/set-cell", withSheetAndLimit(handleSetCell))
+	http.HandleFunc("/set-extra-cell", withSheetAndLimit(handleSetExtraCell))
 	http.HandleFunc("/set-name", withSheet(handleSetName, true))
+	http.HandleFunc("/set-timezone", withSheetAndLimit(handleSetTimezone))
 	http.HandleFunc("/fill-column-down", withSheetAndLimit(handleFillColumnDown))
+	http.HandleFunc("/export", withSheet(handleExport, true))
+	http.HandleFunc("/import", withSheet(handleImport, true))
+	http.HandleFunc("/share", withSheet(handleShare, true))
+	http.HandleFunc("/share-link", withSheet(handleShareLink, true))
+	http.HandleFunc("/audit", handleAudit)
 
 	fs := http.FileServer(http.Dir("static"))
 	http.Handle("/static/", http.StripPrefix("/static/", fs))
@@ -112,7 +112,7 @@ func fkeySelect(index int, sheet sheets.Sheet, options map[string]map[int64]fkey
 	})
 }
 
-func modal(sheet sheets.Sheet, tableNames []string, options map[string]map[int64]fkeys.ForeignKey, addJoin bool) templ.Component {
+func modal(sheet sheets.Sheet, connectionNames []string, tableNames []string, options map[string]map[int64]fkeys.ForeignKey, addJoin bool) templ.Component {
 	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
 		templBuffer, templIsBuffer := w.(*bytes.Buffer)
 		if !templIsBuffer {
@@ -133,12 +133,78 @@ func modal(sheet sheets.Sheet, tableNames []string, options map[string]map[int64
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString("\"><label>")
+		_, err = templBuffer.WriteString("\">")
 		if err != nil {
 			return err
 		}
-		var_5 := `Tables`
-		_, err = templBuffer.WriteString(var_5)
+		if len(connectionNames) > 1 {
+			_, err = templBuffer.WriteString("<label>")
+			if err != nil {
+				return err
+			}
+			var_5 := `Connection`
+			_, err = templBuffer.WriteString(var_5)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</label> <div class=\"dropdown-list\"><div class=\"select connection-select\"><select name=\"connection\" hx-post=\"/modal\"")
+			if err != nil {
+				return err
+			}
+			if sheet.Id != 0 {
+				_, err = templBuffer.WriteString(" disabled")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString(">")
+			if err != nil {
+				return err
+			}
+			for _, name := range connectionNames {
+				_, err = templBuffer.WriteString("<option value=\"")
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString(templ.EscapeString(name))
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("\"")
+				if err != nil {
+					return err
+				}
+				if name == sheet.Connection().Name {
+					_, err = templBuffer.WriteString(" selected")
+					if err != nil {
+						return err
+					}
+				}
+				_, err = templBuffer.WriteString(">")
+				if err != nil {
+					return err
+				}
+				var var_6 string = name
+				_, err = templBuffer.WriteString(templ.EscapeString(var_6))
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("</option>")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString("</select></div></div>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("<label>")
+		if err != nil {
+			return err
+		}
+		var_7 := `Tables`
+		_, err = templBuffer.WriteString(var_7)
 		if err != nil {
 			return err
 		}
@@ -169,8 +235,8 @@ func modal(sheet sheets.Sheet, tableNames []string, options map[string]map[int64
 			if err != nil {
 				return err
 			}
-			var var_6 string = tableName
-			_, err = templBuffer.WriteString(templ.EscapeString(var_6))
+			var var_8 string = tableName
+			_, err = templBuffer.WriteString(templ.EscapeString(var_8))
 			if err != nil {
 				return err
 			}
@@ -199,8 +265,8 @@ func modal(sheet sheets.Sheet, tableNames []string, options map[string]map[int64
 		if err != nil {
 			return err
 		}
-		var_7 := `+ Join`
-		_, err = templBuffer.WriteString(var_7)
+		var_9 := `+ Join`
+		_, err = templBuffer.WriteString(var_9)
 		if err != nil {
 			return err
 		}
@@ -208,8 +274,8 @@ func modal(sheet sheets.Sheet, tableNames []string, options map[string]map[int64
 		if err != nil {
 			return err
 		}
-		var var_8 templ.SafeURL = templ.SafeURL("?sheet_id=" + strconv.Itoa(sheet.Id))
-		_, err = templBuffer.WriteString(templ.EscapeString(string(var_8)))
+		var var_10 templ.SafeURL = templ.SafeURL("?sheet_id=" + strconv.Itoa(sheet.Id))
+		_, err = templBuffer.WriteString(templ.EscapeString(string(var_10)))
 		if err != nil {
 			return err
 		}
@@ -217,8 +283,8 @@ func modal(sheet sheets.Sheet, tableNames []string, options map[string]map[int64
 		if err != nil {
 			return err
 		}
-		var_9 := `Ok`
-		_, err = templBuffer.WriteString(var_9)
+		var_11 := `Ok`
+		_, err = templBuffer.WriteString(var_11)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,328 @@
+// Code generated by templ@v0.2.334 DO NOT EDIT.
+
+package main
+
+//lint:file-ignore SA4006 This context is only used if a nested component is present.
+
+import "github.com/a-h/templ"
+import "context"
+import "io"
+import "bytes"
+
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+
+import (
+	"acb/db-interface/sheets"
+	"strconv"
+)
+
+func roleSelect(selected sheets.Role) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_1 := templ.GetChildren(ctx)
+		if var_1 == nil {
+			var_1 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		_, err = templBuffer.WriteString("<div class=\"select\"><select name=\"role\">")
+		if err != nil {
+			return err
+		}
+		for _, role := range []sheets.Role{sheets.Viewer, sheets.Editor, sheets.Owner} {
+			_, err = templBuffer.WriteString("<option value=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(role.String()))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"")
+			if err != nil {
+				return err
+			}
+			if role == selected {
+				_, err = templBuffer.WriteString(" selected")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString(">")
+			if err != nil {
+				return err
+			}
+			var var_2 string = role.String()
+			_, err = templBuffer.WriteString(templ.EscapeString(var_2))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</option>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</select></div>")
+		if err != nil {
+			return err
+		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
+
+func shareModal(sheet sheets.Sheet, shares []sheets.Share, links []sheets.Link, linkURL string, err error) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_3 := templ.GetChildren(ctx)
+		if var_3 == nil {
+			var_3 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		_, err = templBuffer.WriteString("<div id=\"modal\" class=\"modal is-active\" hx-target=\"#modal\" onclick=\"event.stopPropagation()\"><div class=\"modal-content box\"><label>")
+		if err != nil {
+			return err
+		}
+		var_4 := `Shared with`
+		_, err = templBuffer.WriteString(var_4)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</label>")
+		if err != nil {
+			return err
+		}
+		for _, share := range shares {
+			_, err = templBuffer.WriteString("<form hx-post=\"/share\" hx-trigger=\"change\" class=\"flex share-row\"><input name=\"sheet_id\" type=\"hidden\" value=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(sheet.Id)))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"><input name=\"user_name\" type=\"hidden\" value=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(share.UserName))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"><span class=\"share-name\">")
+			if err != nil {
+				return err
+			}
+			var var_5 string = share.UserName
+			_, err = templBuffer.WriteString(templ.EscapeString(var_5))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</span>")
+			if err != nil {
+				return err
+			}
+			err = roleSelect(share.Role).Render(ctx, templBuffer)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("<button hx-post=\"/share\" hx-vals=\"{&#34;role&#34;: &#34;&#34;}\" class=\"button is-light\">")
+			if err != nil {
+				return err
+			}
+			var_6 := `Remove`
+			_, err = templBuffer.WriteString(var_6)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</button></form>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("<form hx-post=\"/share\" class=\"flex share-row\"><input name=\"sheet_id\" type=\"hidden\" value=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(sheet.Id)))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\"><input name=\"user_name\" placeholder=\"User name\" class=\"share-name\">")
+		if err != nil {
+			return err
+		}
+		err = roleSelect(sheets.Viewer).Render(ctx, templBuffer)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("<button class=\"button is-primary\">")
+		if err != nil {
+			return err
+		}
+		var_7 := `Share`
+		_, err = templBuffer.WriteString(var_7)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</button></form><label>")
+		if err != nil {
+			return err
+		}
+		var_8 := `Read-only links`
+		_, err = templBuffer.WriteString(var_8)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</label>")
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			_, err = templBuffer.WriteString("<form hx-post=\"/share-link\" class=\"flex share-row\"><input name=\"sheet_id\" type=\"hidden\" value=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(sheet.Id)))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"><input name=\"revoke\" type=\"hidden\" value=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(link.Token))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"><input value=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(linkURL + link.Token))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\" readonly class=\"share-link\"><span>")
+			if err != nil {
+				return err
+			}
+			var_9 := `Expires `
+			_, err = templBuffer.WriteString(var_9)
+			if err != nil {
+				return err
+			}
+			var var_10 string = link.Expires.Format("2006-01-02 15:04")
+			_, err = templBuffer.WriteString(templ.EscapeString(var_10))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</span><button class=\"button is-light\">")
+			if err != nil {
+				return err
+			}
+			var_11 := `Revoke`
+			_, err = templBuffer.WriteString(var_11)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</button></form>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("<form hx-post=\"/share-link\" class=\"flex share-row\"><input name=\"sheet_id\" type=\"hidden\" value=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(sheet.Id)))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\"><div class=\"select\"><select name=\"days\"><option value=\"1\">")
+		if err != nil {
+			return err
+		}
+		var_12 := `1 day`
+		_, err = templBuffer.WriteString(var_12)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</option><option value=\"7\" selected>")
+		if err != nil {
+			return err
+		}
+		var_13 := `7 days`
+		_, err = templBuffer.WriteString(var_13)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</option><option value=\"30\">")
+		if err != nil {
+			return err
+		}
+		var_14 := `30 days`
+		_, err = templBuffer.WriteString(var_14)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</option></select></div><button class=\"button is-primary\">")
+		if err != nil {
+			return err
+		}
+		var_15 := `Create Link`
+		_, err = templBuffer.WriteString(var_15)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</button></form>")
+		if err != nil {
+			return err
+		}
+		if err != nil {
+			_, err = templBuffer.WriteString("<span class=\"has-text-danger\">")
+			if err != nil {
+				return err
+			}
+			var var_16 string = err.Error()
+			_, err = templBuffer.WriteString(templ.EscapeString(var_16))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</span>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</div><button class=\"modal-close\"></button></div>")
+		if err != nil {
+			return err
+		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
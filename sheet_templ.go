@@ -222,7 +222,9 @@ func extraColHeader(i int, name string) templ.Component {
 	})
 }
 
-func tableCell(tableName string, col sheets.Column, row int, cell sheets.Cell, err error) templ.Component {
+// Shows cell, which is saved unless the database no longer has original
+
+func tableCell(sheet sheets.Sheet, tableName string, col sheets.Column, row int, cell, original sheets.Cell, err error) templ.Component {
 	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
 		templBuffer, templIsBuffer := w.(*bytes.Buffer)
 		if !templIsBuffer {
@@ -235,12 +237,12 @@ func tableCell(tableName string, col sheets.Column, row int, cell sheets.Cell, e
 			var_8 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		if col.IsPrimaryKey {
+		if col.IsPrimaryKey && !sheet.ReadOnly() {
 			_, err = templBuffer.WriteString("<div hx-get=\"/new-row\" hx-trigger=\"click\" hx-vals=\"")
 			if err != nil {
 				return err
 			}
-			_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("{\"table_name\":\"%s\"}", tableName)))
+			_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("{\"table_name\":\"%s\",\"row\":%d}", tableName, row)))
 			if err != nil {
 				return err
 			}
@@ -273,9 +275,24 @@ func tableCell(tableName string, col sheets.Column, row int, cell sheets.Cell, e
 			if err != nil {
 				return err
 			}
+		} else if col.IsPrimaryKey || !sheet.Writable(tableName) {
+			_, err = templBuffer.WriteString("<span>")
+			if err != nil {
+				return err
+			}
+			var var_10 string = cell.Value
+			_, err = templBuffer.WriteString(templ.EscapeString(var_10))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</span>")
+			if err != nil {
+				return err
+			}
 		} else {
-			var var_10 = []any{templ.KV("is-danger", err != nil)}
-			err = templ.RenderCSSItems(ctx, templBuffer, var_10...)
+			var var_11 = []any{templ.KV("is-danger", err != nil && !isConflict(err)), templ.KV("is-warning", isConflict(err)),
+				templ.KV("is-draft", err == nil && cell != original)}
+			err = templ.RenderCSSItems(ctx, templBuffer, var_11...)
 			if err != nil {
 				return err
 			}
@@ -283,7 +300,7 @@ func tableCell(tableName string, col sheets.Column, row int, cell sheets.Cell, e
 			if err != nil {
 				return err
 			}
-			_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("{\"table_name\":\"%s\",\"col_name\":\"%s\",\"row\":%d}", tableName, col.Name, row)))
+			_, err = templBuffer.WriteString(templ.EscapeString(cellVals(tableName, col.Name, row, original)))
 			if err != nil {
 				return err
 			}
@@ -291,7 +308,7 @@ func tableCell(tableName string, col sheets.Column, row int, cell sheets.Cell, e
 			if err != nil {
 				return err
 			}
-			_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("[name=sheet_id],tr[data-row=\"%d\"] [data-table=\"%s\"][name^=pk-]", row, tableName)))
+			_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("[name=sheet_id],%s,tr[data-row=\"%d\"] [data-table=\"%s\"][name^=pk-]", pageFields, row, tableName)))
 			if err != nil {
 				return err
 			}
@@ -303,11 +320,29 @@ func tableCell(tableName string, col sheets.Column, row int, cell sheets.Cell, e
 			if err != nil {
 				return err
 			}
-			_, err = templBuffer.WriteString("\" size=\"1\" class=\"")
+			_, err = templBuffer.WriteString("\" size=\"1\"")
+			if err != nil {
+				return err
+			}
+			if err != nil {
+				_, err = templBuffer.WriteString(" title=\"")
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString(templ.EscapeString(err.Error()))
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("\"")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString(" class=\"")
 			if err != nil {
 				return err
 			}
-			_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_10).String()))
+			_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_11).String()))
 			if err != nil {
 				return err
 			}
@@ -331,13 +366,13 @@ func extraCell(i, j int, cell sheets.SheetCell) templ.Component {
 			defer templ.ReleaseBuffer(templBuffer)
 		}
 		ctx = templ.InitializeContext(ctx)
-		var_11 := templ.GetChildren(ctx)
-		if var_11 == nil {
-			var_11 = templ.NopComponent
+		var_12 := templ.GetChildren(ctx)
+		if var_12 == nil {
+			var_12 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		var var_12 = []any{templ.KV("is-null", !cell.NotNull)}
-		err = templ.RenderCSSItems(ctx, templBuffer, var_12...)
+		var var_13 = []any{templ.KV("is-null", !cell.NotNull)}
+		err = templ.RenderCSSItems(ctx, templBuffer, var_13...)
 		if err != nil {
 			return err
 		}
@@ -345,7 +380,15 @@ func extraCell(i, j int, cell sheets.SheetCell) templ.Component {
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_12).String()))
+		_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_13).String()))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\" data-extra-col=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(i)))
 		if err != nil {
 			return err
 		}
@@ -373,12 +416,47 @@ func extraCell(i, j int, cell sheets.SheetCell) templ.Component {
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString("\"><span class=\"extra-cell-value\">")
+		_, err = templBuffer.WriteString("\">")
 		if err != nil {
 			return err
 		}
-		var var_13 string = cell.Value
-		_, err = templBuffer.WriteString(templ.EscapeString(var_13))
+		var var_14 = []any{"extra-cell-value", templ.KV("has-text-danger", cell.Error != nil)}
+		err = templ.RenderCSSItems(ctx, templBuffer, var_14...)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("<span class=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_14).String()))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\"")
+		if err != nil {
+			return err
+		}
+		if cell.Error != nil {
+			_, err = templBuffer.WriteString(" title=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(cell.Error.Cause))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString(">")
+		if err != nil {
+			return err
+		}
+		var var_15 string = cell.Value
+		_, err = templBuffer.WriteString(templ.EscapeString(var_15))
 		if err != nil {
 			return err
 		}
@@ -393,7 +471,7 @@ func extraCell(i, j int, cell sheets.SheetCell) templ.Component {
 	})
 }
 
-func newRow(tableNames []string, tableName string, cols [][]sheets.Column, numCols int, cells []sheets.Cell, rowIndex int) templ.Component {
+func newRow(sheet sheets.Sheet, tableName string, cols [][]sheets.Column, numCols int, cells []sheets.Cell, rowIndex int) templ.Component {
 	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
 		templBuffer, templIsBuffer := w.(*bytes.Buffer)
 		if !templIsBuffer {
@@ -401,9 +479,9 @@ func newRow(tableNames []string, tableName string, cols [][]sheets.Column, numCo
 			defer templ.ReleaseBuffer(templBuffer)
 		}
 		ctx = templ.InitializeContext(ctx)
-		var_14 := templ.GetChildren(ctx)
-		if var_14 == nil {
-			var_14 = templ.NopComponent
+		var_16 := templ.GetChildren(ctx)
+		if var_16 == nil {
+			var_16 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
 		_, err = templBuffer.WriteString("<tr id=\"new-row\">")
@@ -412,9 +490,9 @@ func newRow(tableNames []string, tableName string, cols [][]sheets.Column, numCo
 		}
 		for i, tcols := range cols {
 			for j, col := range tcols {
-				if tableNames[i] == tableName && len(cells) > 0 {
-					var var_15 = []any{templ.KV("is-null", len(cells) > 0 && !cells[j].NotNull)}
-					err = templ.RenderCSSItems(ctx, templBuffer, var_15...)
+				if sheet.TableNames[i] == tableName && len(cells) > 0 {
+					var var_17 = []any{templ.KV("is-null", len(cells) > 0 && !cells[j].NotNull)}
+					err = templ.RenderCSSItems(ctx, templBuffer, var_17...)
 					if err != nil {
 						return err
 					}
@@ -422,7 +500,7 @@ func newRow(tableNames []string, tableName string, cols [][]sheets.Column, numCo
 					if err != nil {
 						return err
 					}
-					_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_15).String()))
+					_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_17).String()))
 					if err != nil {
 						return err
 					}
@@ -430,8 +508,8 @@ func newRow(tableNames []string, tableName string, cols [][]sheets.Column, numCo
 					if err != nil {
 						return err
 					}
-					var var_16 string = cells[j].Value
-					_, err = templBuffer.WriteString(templ.EscapeString(var_16))
+					var var_18 string = cells[j].Value
+					_, err = templBuffer.WriteString(templ.EscapeString(var_18))
 					if err != nil {
 						return err
 					}
@@ -439,12 +517,17 @@ func newRow(tableNames []string, tableName string, cols [][]sheets.Column, numCo
 					if err != nil {
 						return err
 					}
+				} else if !sheet.Writable(sheet.TableNames[i]) {
+					_, err = templBuffer.WriteString("<td style=\"border-bottom: none\"></td>")
+					if err != nil {
+						return err
+					}
 				} else {
 					_, err = templBuffer.WriteString("<td style=\"border-bottom: none\"><input name=\"")
 					if err != nil {
 						return err
 					}
-					_, err = templBuffer.WriteString(templ.EscapeString("column-" + tableNames[i] + " " + col.Name))
+					_, err = templBuffer.WriteString(templ.EscapeString("column-" + sheet.TableNames[i] + " " + col.Name))
 					if err != nil {
 						return err
 					}
@@ -467,7 +550,7 @@ func newRow(tableNames []string, tableName string, cols [][]sheets.Column, numCo
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("[name=sheet_id],#new-row,tr[data-row=\"%d\"] [data-table=\"%s\"][name^=pk-]", rowIndex, tableName)))
+		_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("[name=sheet_id],%s,#new-row,tr[data-row=\"%d\"] [data-table=\"%s\"][name^=pk-]", pageFields, rowIndex, tableName)))
 		if err != nil {
 			return err
 		}
@@ -475,12 +558,47 @@ func newRow(tableNames []string, tableName string, cols [][]sheets.Column, numCo
 		if err != nil {
 			return err
 		}
-		var_17 := `Add`
-		_, err = templBuffer.WriteString(var_17)
+		var_19 := `Add`
+		_, err = templBuffer.WriteString(var_19)
 		if err != nil {
 			return err
 		}
-		_, err = templBuffer.WriteString("</button><span id=\"new-row-err\"></span></div></td></tr>")
+		_, err = templBuffer.WriteString("</button>")
+		if err != nil {
+			return err
+		}
+		if len(cells) > 0 && sheet.Writable(tableName) {
+			_, err = templBuffer.WriteString("<button hx-get=\"/delete-row\" hx-include=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("[name=sheet_id],tr[data-row=\"%d\"] [data-table=\"%s\"][name^=pk-]", rowIndex, tableName)))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\" hx-vals=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("{\"table_name\":\"%s\",\"row\":%d}", tableName, rowIndex)))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\" hx-target=\"#new-row-err\" class=\"button is-light\">")
+			if err != nil {
+				return err
+			}
+			var_20 := `Delete`
+			_, err = templBuffer.WriteString(var_20)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</button>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("<span id=\"new-row-err\"></span></div></td></tr>")
 		if err != nil {
 			return err
 		}
@@ -491,7 +609,7 @@ func newRow(tableNames []string, tableName string, cols [][]sheets.Column, numCo
 	})
 }
 
-func sheetTable(sheet sheets.Sheet, cols [][]sheets.Column, numCols int, loadingErr error) templ.Component {
+func deletePreview(tableName string, rowIndex int, effects []sheets.DeleteEffect, err error) templ.Component {
 	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
 		templBuffer, templIsBuffer := w.(*bytes.Buffer)
 		if !templIsBuffer {
@@ -499,22 +617,52 @@ func sheetTable(sheet sheets.Sheet, cols [][]sheets.Column, numCols int, loading
 			defer templ.ReleaseBuffer(templBuffer)
 		}
 		ctx = templ.InitializeContext(ctx)
-		var_18 := templ.GetChildren(ctx)
-		if var_18 == nil {
-			var_18 = templ.NopComponent
+		var_21 := templ.GetChildren(ctx)
+		if var_21 == nil {
+			var_21 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		_, err = templBuffer.WriteString("<thead><tr>")
 		if err != nil {
-			return err
-		}
-		for i, tableName := range sheet.TableNames {
-			if len(cols[i]) > 0 {
-				_, err = templBuffer.WriteString("<th colspan=\"")
+			var var_22 string = err.Error()
+			_, err = templBuffer.WriteString(templ.EscapeString(var_22))
+			if err != nil {
+				return err
+			}
+		} else {
+			_, err = templBuffer.WriteString("<div class=\"delete-preview\"><p>")
+			if err != nil {
+				return err
+			}
+			var_23 := `Delete this row from `
+			_, err = templBuffer.WriteString(var_23)
+			if err != nil {
+				return err
+			}
+			var var_24 string = tableName
+			_, err = templBuffer.WriteString(templ.EscapeString(var_24))
+			if err != nil {
+				return err
+			}
+			var_25 := `?`
+			_, err = templBuffer.WriteString(var_25)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</p><ul>")
+			if err != nil {
+				return err
+			}
+			for _, effect := range effects {
+				var var_26 = []any{templ.KV("has-text-danger", effect.Blocks())}
+				err = templ.RenderCSSItems(ctx, templBuffer, var_26...)
 				if err != nil {
 					return err
 				}
-				_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(len(cols[i]))))
+				_, err = templBuffer.WriteString("<li class=\"")
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_26).String()))
 				if err != nil {
 					return err
 				}
@@ -522,91 +670,112 @@ func sheetTable(sheet sheets.Sheet, cols [][]sheets.Column, numCols int, loading
 				if err != nil {
 					return err
 				}
-				var var_19 string = tableName
-				_, err = templBuffer.WriteString(templ.EscapeString(var_19))
+				var var_27 string = effect.Summary()
+				_, err = templBuffer.WriteString(templ.EscapeString(var_27))
 				if err != nil {
 					return err
 				}
-				_, err = templBuffer.WriteString("</th>")
+				for i := range effect.Rows {
+					_, err = templBuffer.WriteString("<span class=\"tag\">")
+					if err != nil {
+						return err
+					}
+					var var_28 string = effect.RowLabel(i)
+					_, err = templBuffer.WriteString(templ.EscapeString(var_28))
+					if err != nil {
+						return err
+					}
+					_, err = templBuffer.WriteString("</span>")
+					if err != nil {
+						return err
+					}
+				}
+				if effect.Count > len(effect.Rows) {
+					_, err = templBuffer.WriteString("<span>")
+					if err != nil {
+						return err
+					}
+					var var_29 string = fmt.Sprintf("and %d more", effect.Count-len(effect.Rows))
+					_, err = templBuffer.WriteString(templ.EscapeString(var_29))
+					if err != nil {
+						return err
+					}
+					_, err = templBuffer.WriteString("</span>")
+					if err != nil {
+						return err
+					}
+				}
+				_, err = templBuffer.WriteString("</li>")
 				if err != nil {
 					return err
 				}
 			}
-		}
-		if len(sheet.ExtraCols) > 0 {
-			_, err = templBuffer.WriteString("<th colspan=\"")
+			_, err = templBuffer.WriteString("</ul><button hx-post=\"/delete-row\" hx-include=\"")
 			if err != nil {
 				return err
 			}
-			_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(len(sheet.ExtraCols))))
+			_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("[name=sheet_id],%s,tr[data-row=\"%d\"] [data-table=\"%s\"][name^=pk-]", pageFields, rowIndex, tableName)))
 			if err != nil {
 				return err
 			}
-			_, err = templBuffer.WriteString("\">")
+			_, err = templBuffer.WriteString("\" hx-vals=\"")
 			if err != nil {
 				return err
 			}
-			var_20 := `spreadsheet`
-			_, err = templBuffer.WriteString(var_20)
+			_, err = templBuffer.WriteString(templ.EscapeString(fmt.Sprintf("{\"table_name\":\"%s\"}", tableName)))
 			if err != nil {
 				return err
 			}
-			_, err = templBuffer.WriteString("</th>")
+			_, err = templBuffer.WriteString("\" hx-target-400=\"#new-row-err\"")
 			if err != nil {
 				return err
 			}
-		}
-		_, err = templBuffer.WriteString("</tr><tr id=\"header-row\">")
-		if err != nil {
-			return err
-		}
-		for i, tcols := range cols {
-			for _, col := range tcols {
-				err = colHeader(sheet.TableNames[i], col, sheet.PrefsMap[sheet.TableNames[i]+"."+col.Name]).Render(ctx, templBuffer)
+			if sheets.DeleteBlocked(effects) {
+				_, err = templBuffer.WriteString(" disabled")
 				if err != nil {
 					return err
 				}
 			}
-		}
-		for i, col := range sheet.ExtraCols {
-			err = extraColHeader(i, col.Name).Render(ctx, templBuffer)
-			if err != nil {
-				return err
-			}
-		}
-		_, err = templBuffer.WriteString("</tr></thead><tbody>")
-		if err != nil {
-			return err
-		}
-		if loadingErr != nil {
-			_, err = templBuffer.WriteString("<tr><td colspan=\"")
-			if err != nil {
-				return err
-			}
-			_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(numCols)))
-			if err != nil {
-				return err
-			}
-			_, err = templBuffer.WriteString("\"><span class=\"has-text-danger\">")
+			_, err = templBuffer.WriteString(" class=\"button is-danger is-light\">")
 			if err != nil {
 				return err
 			}
-			var var_21 string = loadingErr.Error()
-			_, err = templBuffer.WriteString(templ.EscapeString(var_21))
+			var_30 := `Confirm`
+			_, err = templBuffer.WriteString(var_30)
 			if err != nil {
 				return err
 			}
-			_, err = templBuffer.WriteString("</span></td></tr>")
+			_, err = templBuffer.WriteString("</button></div>")
 			if err != nil {
 				return err
 			}
 		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
+
+func sheetRows(sheet sheets.Sheet, cols [][]sheets.Column, numCols int, infinite bool) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_31 := templ.GetChildren(ctx)
+		if var_31 == nil {
+			var_31 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
 		for j := 0; j < sheet.RowCount; j++ {
 			_, err = templBuffer.WriteString("<tr class=\"body-row\" data-row=\"")
 			if err != nil {
 				return err
 			}
-			_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(j)))
+			_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(sheet.Offset + j)))
 			if err != nil {
 				return err
 			}
@@ -616,8 +785,9 @@ func sheetTable(sheet sheets.Sheet, cols [][]sheets.Column, numCols int, loading
 			}
 			for i, tableCols := range sheet.Cells {
 				for k, cells := range tableCols {
-					var var_22 = []any{templ.KV("is-null", !cells[j].NotNull)}
-					err = templ.RenderCSSItems(ctx, templBuffer, var_22...)
+					var var_32 = []any{templ.KV("is-null", !sheet.DisplayedCell(cols, i, k, j).NotNull),
+						templ.KV("is-draft-deleted", sheet.RowDeleted(cols, i, j))}
+					err = templ.RenderCSSItems(ctx, templBuffer, var_32...)
 					if err != nil {
 						return err
 					}
@@ -625,7 +795,15 @@ func sheetTable(sheet sheets.Sheet, cols [][]sheets.Column, numCols int, loading
 					if err != nil {
 						return err
 					}
-					_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_22).String()))
+					_, err = templBuffer.WriteString(templ.EscapeString(templ.CSSClasses(var_32).String()))
+					if err != nil {
+						return err
+					}
+					_, err = templBuffer.WriteString("\" data-col=\"")
+					if err != nil {
+						return err
+					}
+					_, err = templBuffer.WriteString(templ.EscapeString(sheet.TableNames[i] + " " + cols[i][k].Name))
 					if err != nil {
 						return err
 					}
@@ -633,8 +811,8 @@ func sheetTable(sheet sheets.Sheet, cols [][]sheets.Column, numCols int, loading
 					if err != nil {
 						return err
 					}
-					var var_23 string = cells[j].Value
-					_, err = templBuffer.WriteString(templ.EscapeString(var_23))
+					var var_33 string = sheet.DisplayedCell(cols, i, k, j).Value
+					_, err = templBuffer.WriteString(templ.EscapeString(var_33))
 					if err != nil {
 						return err
 					}
@@ -642,7 +820,7 @@ func sheetTable(sheet sheets.Sheet, cols [][]sheets.Column, numCols int, loading
 					if err != nil {
 						return err
 					}
-					err = tableCell(sheet.TableNames[i], cols[i][k], j, cells[j], nil).Render(ctx, templBuffer)
+					err = tableCell(sheet, sheet.TableNames[i], cols[i][k], sheet.Offset+j, sheet.DisplayedCell(cols, i, k, j), cells[j], nil).Render(ctx, templBuffer)
 					if err != nil {
 						return err
 					}
@@ -683,7 +861,7 @@ func sheetTable(sheet sheets.Sheet, cols [][]sheets.Column, numCols int, loading
 				}
 			}
 			for i, extraCol := range sheet.ExtraCols {
-				err = extraCell(i, j, extraCol.Cells[j]).Render(ctx, templBuffer)
+				err = extraCell(i, sheet.Offset+j, extraCol.Cells[j]).Render(ctx, templBuffer)
 				if err != nil {
 					return err
 				}
@@ -693,7 +871,447 @@ func sheetTable(sheet sheets.Sheet, cols [][]sheets.Column, numCols int, loading
 				return err
 			}
 		}
-		_, err = templBuffer.WriteString("</tbody>")
+		if infinite && sheet.RowCount > 0 && sheet.Offset+sheet.RowCount < sheet.TotalRows {
+			_, err = templBuffer.WriteString("<tr hx-get=\"/rows\" hx-trigger=\"intersect once\" hx-target=\"this\" hx-swap=\"outerHTML\" hx-vals=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(pageVals(sheet.Offset+sheet.RowCount, sheet.PageCursor(true), false)))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"><td colspan=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(numCols + len(sheet.ExtraCols))))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\">")
+			if err != nil {
+				return err
+			}
+			var_34 := `Loading...`
+			_, err = templBuffer.WriteString(var_34)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</td></tr>")
+			if err != nil {
+				return err
+			}
+		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
+
+func pager(sheet sheets.Sheet, numCols int, limit int, infinite bool) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_35 := templ.GetChildren(ctx)
+		if var_35 == nil {
+			var_35 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		_, err = templBuffer.WriteString("<tr id=\"pager\" class=\"has-scrolling-content\"><td colspan=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(numCols + len(sheet.ExtraCols))))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\" class=\"has-scrolling-content\"><div class=\"flex center scrolling-content-container\"><input name=\"offset\" type=\"hidden\" value=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(sheet.Offset)))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\">")
+		if err != nil {
+			return err
+		}
+		if infinite {
+			_, err = templBuffer.WriteString("<span>")
+			if err != nil {
+				return err
+			}
+			var var_36 string = sheet.TotalSummary()
+			_, err = templBuffer.WriteString(templ.EscapeString(var_36))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</span>")
+			if err != nil {
+				return err
+			}
+		} else {
+			_, err = templBuffer.WriteString("<button hx-get=\"/table\" hx-vals=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString("{\"offset\":0}"))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"")
+			if err != nil {
+				return err
+			}
+			if sheet.Offset == 0 {
+				_, err = templBuffer.WriteString(" disabled")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString(">")
+			if err != nil {
+				return err
+			}
+			var_37 := `First`
+			_, err = templBuffer.WriteString(var_37)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</button> ")
+			if err != nil {
+				return err
+			}
+			if sheet.Offset > limit {
+				_, err = templBuffer.WriteString("<button hx-get=\"/table\" hx-vals=\"")
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString(templ.EscapeString(pageVals(sheet.Offset-limit, sheet.PageCursor(false), true)))
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("\">")
+				if err != nil {
+					return err
+				}
+				var_38 := `Prev`
+				_, err = templBuffer.WriteString(var_38)
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("</button>")
+				if err != nil {
+					return err
+				}
+			} else {
+				_, err = templBuffer.WriteString("<button hx-get=\"/table\" hx-vals=\"")
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString(templ.EscapeString("{\"offset\":0}"))
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("\"")
+				if err != nil {
+					return err
+				}
+				if sheet.Offset == 0 {
+					_, err = templBuffer.WriteString(" disabled")
+					if err != nil {
+						return err
+					}
+				}
+				_, err = templBuffer.WriteString(">")
+				if err != nil {
+					return err
+				}
+				var_39 := `Prev`
+				_, err = templBuffer.WriteString(var_39)
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("</button>")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString(" <span>")
+			if err != nil {
+				return err
+			}
+			var var_40 string = sheet.PageSummary()
+			_, err = templBuffer.WriteString(templ.EscapeString(var_40))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</span> <button hx-get=\"/table\" hx-vals=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(pageVals(sheet.Offset+sheet.RowCount, sheet.PageCursor(true), false)))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"")
+			if err != nil {
+				return err
+			}
+			if sheet.Offset+sheet.RowCount >= sheet.TotalRows {
+				_, err = templBuffer.WriteString(" disabled")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString(">")
+			if err != nil {
+				return err
+			}
+			var_41 := `Next`
+			_, err = templBuffer.WriteString(var_41)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</button> <button hx-get=\"/table\" hx-vals=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(pageVals(sheet.LastPageOffset(limit), lastPageCursor(sheet), true)))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"")
+			if err != nil {
+				return err
+			}
+			if sheet.Offset+sheet.RowCount >= sheet.TotalRows {
+				_, err = templBuffer.WriteString(" disabled")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = templBuffer.WriteString(">")
+			if err != nil {
+				return err
+			}
+			var_42 := `Last`
+			_, err = templBuffer.WriteString(var_42)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</button>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("<label>")
+		if err != nil {
+			return err
+		}
+		var_43 := `Show`
+		_, err = templBuffer.WriteString(var_43)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(" <input name=\"limit\" inputmode=\"numeric\" pattern=\"[0-9]*\" value=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(limit)))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\" hx-get=\"/table\"> ")
+		if err != nil {
+			return err
+		}
+		var_44 := `rows`
+		_, err = templBuffer.WriteString(var_44)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</label><label><input name=\"scroll\" type=\"checkbox\" value=\"infinite\"")
+		if err != nil {
+			return err
+		}
+		if infinite {
+			_, err = templBuffer.WriteString(" checked")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString(" hx-get=\"/table\" hx-vals=\"")
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString(templ.EscapeString("{\"offset\":0}"))
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("\"> ")
+		if err != nil {
+			return err
+		}
+		var_45 := `Infinite scroll`
+		_, err = templBuffer.WriteString(var_45)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</label></div></td></tr>")
+		if err != nil {
+			return err
+		}
+		if !templIsBuffer {
+			_, err = templBuffer.WriteTo(w)
+		}
+		return err
+	})
+}
+
+func sheetTable(sheet sheets.Sheet, cols [][]sheets.Column, numCols int, limit int, infinite bool, loadingErr error) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) (err error) {
+		templBuffer, templIsBuffer := w.(*bytes.Buffer)
+		if !templIsBuffer {
+			templBuffer = templ.GetBuffer()
+			defer templ.ReleaseBuffer(templBuffer)
+		}
+		ctx = templ.InitializeContext(ctx)
+		var_46 := templ.GetChildren(ctx)
+		if var_46 == nil {
+			var_46 = templ.NopComponent
+		}
+		ctx = templ.ClearChildren(ctx)
+		_, err = templBuffer.WriteString("<thead><tr>")
+		if err != nil {
+			return err
+		}
+		for i, tableName := range sheet.TableNames {
+			if len(cols[i]) > 0 {
+				_, err = templBuffer.WriteString("<th colspan=\"")
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(len(cols[i]))))
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("\">")
+				if err != nil {
+					return err
+				}
+				var var_47 string = tableName
+				_, err = templBuffer.WriteString(templ.EscapeString(var_47))
+				if err != nil {
+					return err
+				}
+				_, err = templBuffer.WriteString("</th>")
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if len(sheet.ExtraCols) > 0 {
+			_, err = templBuffer.WriteString("<th colspan=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(len(sheet.ExtraCols))))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\">")
+			if err != nil {
+				return err
+			}
+			var_48 := `spreadsheet`
+			_, err = templBuffer.WriteString(var_48)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</th>")
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</tr><tr id=\"header-row\">")
+		if err != nil {
+			return err
+		}
+		for i, tcols := range cols {
+			for _, col := range tcols {
+				err = colHeader(sheet.TableNames[i], col, sheet.PrefsMap[sheet.TableNames[i]+"."+col.Name]).Render(ctx, templBuffer)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for i, col := range sheet.ExtraCols {
+			err = extraColHeader(i, col.Name).Render(ctx, templBuffer)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = templBuffer.WriteString("</tr></thead><tbody>")
+		if err != nil {
+			return err
+		}
+		if loadingErr != nil {
+			_, err = templBuffer.WriteString("<tr><td colspan=\"")
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(templ.EscapeString(strconv.Itoa(numCols)))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("\"><span class=\"has-text-danger\">")
+			if err != nil {
+				return err
+			}
+			var var_49 string = loadingErr.Error()
+			_, err = templBuffer.WriteString(templ.EscapeString(var_49))
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString("</span></td></tr>")
+			if err != nil {
+				return err
+			}
+		}
+		if sheet.Drafting() {
+			err = draftBar(sheet, numCols+len(sheet.ExtraCols)).Render(ctx, templBuffer)
+			if err != nil {
+				return err
+			}
+			_, err = templBuffer.WriteString(" ")
+			if err != nil {
+				return err
+			}
+			err = draftInsertRows(sheet, cols).Render(ctx, templBuffer)
+			if err != nil {
+				return err
+			}
+		}
+		err = sheetRows(sheet, cols, numCols, infinite).Render(ctx, templBuffer)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</tbody><tfoot>")
+		if err != nil {
+			return err
+		}
+		err = pager(sheet, numCols, limit, infinite).Render(ctx, templBuffer)
+		if err != nil {
+			return err
+		}
+		_, err = templBuffer.WriteString("</tfoot>")
 		if err != nil {
 			return err
 		}
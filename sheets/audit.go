@@ -0,0 +1,305 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/exp/maps"
+)
+
+const (
+	AuditInsert = "insert"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// A change to one column of a row made through a sheet
+type AuditEntry struct {
+	Id             int
+	ChangedAt      time.Time
+	UserName       string
+	SheetId        int
+	ConnectionName string
+	TableName      string
+	// The row's primary key as a JSON object
+	PrimaryKey string
+	Action     string
+	ColumnName string
+	OldValue   Cell
+	NewValue   Cell
+	// Whether the values were left out when loading the entry, because its
+	// connection only shows values to the database roles which can select them
+	ValuesHidden bool
+}
+
+// Restricts the audit log to one sheet, table or user where set
+type AuditFilter struct {
+	SheetId   int
+	TableName string
+	UserName  string
+	// The sheets whose changes may be shown at all, or nil for every sheet
+	SheetIds []int
+}
+
+func initAuditTable() {
+	meta.MustExec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS db_interface.audit_log (
+			id %s PRIMARY KEY
+			, changedat BIGINT NOT NULL
+			, userid INT
+			, username VARCHAR(255) NOT NULL
+			, sheetid INT NOT NULL
+			, connectionname VARCHAR(255) NOT NULL
+			, tablename VARCHAR(255) NOT NULL
+			, primarykey TEXT NOT NULL
+			, "action" VARCHAR(16) NOT NULL
+			, columnname VARCHAR(255) NOT NULL
+			, oldvalue TEXT
+			, newvalue TEXT
+		)`,
+		metaDialect.sqlType("SERIAL")))
+	log.Println("Audit log table exists")
+}
+
+func primaryKeyJSON(primaryKeys map[string]string) string {
+	// Map keys are sorted, so a row is always written the same way
+	b, err := json.Marshal(primaryKeys)
+	Check(err)
+	return string(b)
+}
+
+// A row inserted, updated or deleted through a sheet, with the values of the
+// columns which changed. OldValues is empty for an insert and NewValues for a delete.
+type rowChange struct {
+	TableName            string
+	Key                  map[string]string
+	ColNames             []string
+	OldValues, NewValues []Cell
+}
+
+// Returns an audit log entry for each column of rows, leaving out the NULLs of
+// inserted rows
+func (sheet *Sheet) auditEntries(action string, rows []rowChange) []AuditEntry {
+	entries := []AuditEntry{}
+	for _, row := range rows {
+		for i, colName := range row.ColNames {
+			entry := AuditEntry{
+				UserName:       sheet.user.Name,
+				SheetId:        sheet.Id,
+				ConnectionName: sheet.Connection().Name,
+				TableName:      row.TableName,
+				PrimaryKey:     primaryKeyJSON(row.Key),
+				Action:         action,
+				ColumnName:     colName,
+			}
+			if action != AuditInsert {
+				entry.OldValue = row.OldValues[i]
+			}
+			if action != AuditDelete {
+				entry.NewValue = row.NewValues[i]
+			}
+			if action == AuditInsert && !entry.NewValue.NotNull {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Selects colNames from the row of table identified by primaryKeys
+func (table *Table) selectRow(tx *sqlx.Tx, colNames []string, primaryKeys map[string]string) ([]Cell, error) {
+	keyNames := maps.Keys(primaryKeys)
+	slices.Sort(keyNames)
+	rows, err := table.connection.selectKeyedCells(tx, table.FullName(), colNames, keyNames, keyValues(keyNames, primaryKeys), 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("Row %v of %s no longer exists", primaryKeys, table.FullName())
+	}
+	return rows[0], nil
+}
+
+func nullString(cell Cell) sql.NullString {
+	return sql.NullString{String: cell.Value, Valid: cell.NotNull}
+}
+
+func (sheet *Sheet) insertAuditEntries(tx sqlx.Ext, entries []AuditEntry) ([]int, error) {
+	ids := make([]int, len(entries))
+	now := time.Now().Unix()
+	userId := sql.NullInt64{Int64: int64(sheet.user.Id), Valid: sheet.user.Id != 0}
+	for i, entry := range entries {
+		var err error
+		ids[i], err = metaDialect.insertReturningId(tx, `
+			INSERT INTO db_interface.audit_log (
+				changedat
+				, userid
+				, username
+				, sheetid
+				, connectionname
+				, tablename
+				, primarykey
+				, "action"
+				, columnname
+				, oldvalue
+				, newvalue
+			) VALUES (
+				$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+			)`,
+			now,
+			userId,
+			entry.UserName,
+			entry.SheetId,
+			entry.ConnectionName,
+			entry.TableName,
+			entry.PrimaryKey,
+			entry.Action,
+			entry.ColumnName,
+			nullString(entry.OldValue),
+			nullString(entry.NewValue))
+		if err != nil {
+			return nil, fmt.Errorf("Cannot write the audit log: %w", err)
+		}
+	}
+	return ids, nil
+}
+
+// Commits the changes made in tx along with their audit log entries. Where the
+// metadata is saved in the sheet's database, the entries are written in tx. Otherwise
+// they're committed to the metadata first, and deleted again if tx fails to commit,
+// so that no change is ever missing from the audit log. Rolls back tx on failure.
+// The entries are never written as the user's database role, which would let
+// users write entries of their own.
+func (sheet *Sheet) commitAudited(tx *sqlx.Tx, entries []AuditEntry) error {
+	if sheet.Connection().hasMetadata() {
+		err := sheet.resetRole(tx)
+		if err == nil {
+			_, err = sheet.insertAuditEntries(tx, entries)
+		}
+		if err != nil {
+			Check(tx.Rollback())
+			return err
+		}
+		err = tx.Commit()
+		if err == nil {
+			sheet.Connection().publishEntries(entries)
+		}
+		return err
+	}
+
+	metaTx := meta.MustBegin()
+	ids, err := sheet.insertAuditEntries(metaTx, entries)
+	if err != nil {
+		Check(metaTx.Rollback())
+		Check(tx.Rollback())
+		return err
+	}
+	Commit(metaTx)
+	err = tx.Commit()
+	if err != nil {
+		for _, id := range ids {
+			meta.MustExec("DELETE FROM db_interface.audit_log WHERE id = $1", id)
+		}
+	} else {
+		sheet.Connection().publishEntries(entries)
+	}
+	return err
+}
+
+// Returns the WHERE clause matching filter and its parameters
+func (filter AuditFilter) where() (string, []interface{}) {
+	clauses := []string{"1 = 1"}
+	args := []interface{}{}
+	addClause := func(column string, value interface{}) {
+		args = append(args, value)
+		clauses = append(clauses, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if filter.SheetId != 0 {
+		addClause("sheetid", filter.SheetId)
+	}
+	if filter.TableName != "" {
+		addClause("tablename", filter.TableName)
+	}
+	if filter.UserName != "" {
+		addClause("username", filter.UserName)
+	}
+	if filter.SheetIds != nil {
+		params := []string{"NULL"}
+		for _, id := range filter.SheetIds {
+			args = append(args, id)
+			params = append(params, fmt.Sprintf("$%d", len(args)))
+		}
+		clauses = append(clauses, fmt.Sprintf("sheetid IN (%s)", strings.Join(params, ", ")))
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+// Loads up to limit audit log entries matching filter, newest first. The values
+// of entries made on connections with database roles are left out.
+func LoadAuditLog(filter AuditFilter, limit, offset int) []AuditEntry {
+	where, args := filter.where()
+	args = append(args, limit, offset)
+	rows, err := meta.Query(fmt.Sprintf(`
+		SELECT id, changedat, username, sheetid, connectionname, tablename
+			, primarykey, "action", columnname, oldvalue, newvalue
+		FROM db_interface.audit_log
+		WHERE %s
+		ORDER BY id DESC
+		LIMIT $%d OFFSET $%d`,
+		where, len(args)-1, len(args)),
+		args...)
+	Check(err)
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		entry := AuditEntry{}
+		var changedAt int64
+		var oldValue, newValue sql.NullString
+		Check(rows.Scan(&entry.Id, &changedAt, &entry.UserName, &entry.SheetId, &entry.ConnectionName,
+			&entry.TableName, &entry.PrimaryKey, &entry.Action, &entry.ColumnName, &oldValue, &newValue))
+		entry.ChangedAt = time.Unix(changedAt, 0)
+		c, ok := Connections[entry.ConnectionName]
+		entry.ValuesHidden = !ok || c.UsesRoles
+		if !entry.ValuesHidden {
+			entry.OldValue = Cell{oldValue.String, oldValue.Valid}
+			entry.NewValue = Cell{newValue.String, newValue.Valid}
+		}
+		entries = append(entries, entry)
+	}
+	Check(rows.Err())
+	return entries
+}
+
+// Lists the distinct values of an audit log column among the entries matching
+// filter, to filter by
+func AuditLogValues(column string, filter AuditFilter) []string {
+	if column != "tablename" && column != "username" {
+		panic("Cannot list audit log column " + column)
+	}
+	where, args := filter.where()
+	values := []string{}
+	Check(meta.Select(&values, fmt.Sprintf(
+		"SELECT DISTINCT %s FROM db_interface.audit_log WHERE %s ORDER BY %s", column, where, column),
+		args...))
+	return values
+}
@@ -0,0 +1,132 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	c := setupTestDB(t, "items (id SERIAL PRIMARY KEY, name VARCHAR(255), price INTEGER CHECK (price >= 0))")
+
+	ann, _ := SetPassword("ann", "secret")
+	sheet := Sheet{OwnerId: ann.Id}
+	sheet.SetTable("test.items")
+	sheet.LoadPrefs()
+	sheet = sheet.WithUser(ann)
+
+	Check(sheet.InsertMultipleRows(map[string]map[string]string{
+		"test.items": {"name": "pen", "price": ""},
+	}, make(map[string]map[string]string)))
+	Check(sheet.UpdateRows(map[string]map[string]string{
+		"test.items": {"name": "pencil", "price": "2"},
+	}, map[string]map[string]string{
+		"test.items": {"id": "1"},
+	}))
+	err := sheet.UpdateRows(map[string]map[string]string{
+		"test.items": {"price": "-1"},
+	}, map[string]map[string]string{
+		"test.items": {"id": "1"},
+	})
+	if err == nil {
+		t.Fatal("Update violating a check constraint succeeded")
+	}
+	Check(sheet.DeleteRows(map[string]map[string]string{
+		"test.items": {"id": "1"},
+	}))
+
+	type change struct {
+		action, column string
+		old, new       Cell
+	}
+	// Newest first
+	expected := []change{
+		{AuditDelete, "price", Cell{"2", true}, Cell{}},
+		{AuditDelete, "name", Cell{"pencil", true}, Cell{}},
+		{AuditDelete, "id", Cell{"1", true}, Cell{}},
+		{AuditUpdate, "price", Cell{}, Cell{"2", true}},
+		{AuditUpdate, "name", Cell{"pen", true}, Cell{"pencil", true}},
+		{AuditInsert, "name", Cell{}, Cell{"pen", true}},
+	}
+	entries := LoadAuditLog(AuditFilter{}, 100, 0)
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d audit log entries, got %+v", len(expected), entries)
+	}
+	for i, entry := range entries {
+		actual := change{entry.Action, entry.ColumnName, entry.OldValue, entry.NewValue}
+		if actual != expected[i] {
+			t.Errorf("Expected entry %d to be %+v, got %+v", i, expected[i], actual)
+		}
+		if entry.UserName != "ann" || entry.SheetId != sheet.Id || entry.TableName != "test.items" ||
+			entry.PrimaryKey != `{"id":"1"}` {
+			t.Errorf("Unexpected entry %+v", entry)
+		}
+	}
+
+	if len(LoadAuditLog(AuditFilter{}, 2, 5)) != 1 {
+		t.Error("Audit log not paged")
+	}
+	if len(LoadAuditLog(AuditFilter{UserName: "bob"}, 100, 0)) != 0 ||
+		len(LoadAuditLog(AuditFilter{TableName: "test.items", SheetId: sheet.Id}, 100, 0)) != len(expected) {
+		t.Error("Audit log not filtered by user, table or sheet")
+	}
+	if len(LoadAuditLog(AuditFilter{SheetIds: []int{}}, 100, 0)) != 0 {
+		t.Error("Audit log shows sheets which aren't visible")
+	}
+	c.UsesRoles = true
+	for _, entry := range LoadAuditLog(AuditFilter{}, 100, 0) {
+		if !entry.ValuesHidden || entry.OldValue != (Cell{}) || entry.NewValue != (Cell{}) {
+			t.Errorf("Values shown with database roles: %+v", entry)
+		}
+	}
+	c.UsesRoles = false
+	users := AuditLogValues("username", AuditFilter{})
+	if len(users) != 1 || users[0] != "ann" {
+		t.Errorf("Unexpected users in the audit log: %v", users)
+	}
+}
+
+// Entries are written as the connection's user, so roles need no privileges on the log
+func TestAuditLogWithRoles(t *testing.T) {
+	c := setupTestDB(t, "items (id SERIAL PRIMARY KEY, name VARCHAR(255))")
+	if _, ok := c.dialect.(postgresDialect); !ok {
+		t.Skip("Only Postgres has database roles")
+	}
+	c.db.MustExec("CREATE ROLE rs_items_editor")
+	defer c.db.MustExec("DROP ROLE rs_items_editor")
+	defer c.db.MustExec("DROP OWNED BY rs_items_editor")
+	c.db.MustExec("GRANT rs_items_editor TO CURRENT_USER")
+	c.db.MustExec("GRANT USAGE ON SCHEMA test TO rs_items_editor")
+	c.db.MustExec("GRANT SELECT, INSERT, UPDATE ON test.items TO rs_items_editor")
+	c.db.MustExec("GRANT USAGE ON ALL SEQUENCES IN SCHEMA test TO rs_items_editor")
+	c.UsesRoles = true
+	defer func() { c.UsesRoles = false }()
+
+	ann, _ := SetPassword("ann", "secret")
+	Check(SetDBRole("ann", "rs_items_editor"))
+	ann, _ = GetUser(ann.Id)
+	sheet := Sheet{OwnerId: ann.Id}
+	sheet.SetTable("test.items")
+	sheet.LoadPrefs()
+	sheet = sheet.WithUser(ann)
+	err := sheet.InsertMultipleRows(map[string]map[string]string{
+		"test.items": {"name": "pen"},
+	}, make(map[string]map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := LoadAuditLog(AuditFilter{}, 100, 0)
+	if len(entries) != 1 || entries[0].UserName != "ann" {
+		t.Errorf("Unexpected audit log entries: %+v", entries)
+	}
+}
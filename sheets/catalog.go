@@ -0,0 +1,122 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// The channel the event trigger installed by RS_SCHEMA_TRIGGER notifies
+const schemaChannel = "db_interface_ddl"
+
+// How often the catalog fingerprint is checked unless RS_SCHEMA_POLL is set
+const defaultSchemaPoll = time.Minute
+
+// Hashes the rows of a query returning one text column
+func hashRows(db sqlx.Queryer, query string) (string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	hash := sha256.New()
+	for rows.Next() {
+		var row string
+		err = rows.Scan(&row)
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte(row + "\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), rows.Err()
+}
+
+// Reloads the tables, so that tables, columns and constraints changed since they
+// were loaded are used without restarting. Sheets whose tables were dropped report
+// it when they are next loaded.
+func (c *Connection) RefreshCatalog() {
+	c.loadTables()
+	Store.refreshTables(c)
+	c.liveLock.Lock()
+	c.liveTables = make(map[string]bool)
+	c.liveLock.Unlock()
+	for _, sheet := range Store.All() {
+		if sheet.Connection() == c {
+			sheet.installLiveTriggers()
+		}
+	}
+}
+
+// Refreshes the catalog if its fingerprint changed since the tables were loaded
+func (c *Connection) checkCatalog() {
+	fingerprint, err := c.dialect.catalogFingerprint(c.db)
+	if err != nil {
+		log.Printf("Cannot check the catalog of %s: %s", c.Name, err)
+		return
+	}
+	c.catalogLock.RLock()
+	changed := fingerprint != c.fingerprint
+	c.catalogLock.RUnlock()
+	if changed {
+		log.Printf("The catalog of %s changed", c.Name)
+		c.RefreshCatalog()
+	}
+}
+
+// Checks the catalog every interval until the connection is closed
+func (c *Connection) pollCatalog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.closed:
+				return
+			case <-ticker.C:
+				c.checkCatalog()
+			}
+		}
+	}()
+}
+
+// Installs an event trigger which notifies the listener after every DDL command.
+// Creating event triggers needs a superuser.
+func (c *Connection) installSchemaTrigger() bool {
+	c.dialect.createSchema(c.db, "db_interface")
+	tx := c.db.MustBegin()
+	_, err := tx.Exec(`
+		CREATE OR REPLACE FUNCTION db_interface.notify_ddl() RETURNS event_trigger AS $$
+		BEGIN
+			PERFORM pg_notify('` + schemaChannel + `', '');
+		END $$ LANGUAGE plpgsql`)
+	if err == nil {
+		_, err = tx.Exec("DROP EVENT TRIGGER IF EXISTS db_interface_ddl")
+	}
+	if err == nil {
+		_, err = tx.Exec(`CREATE EVENT TRIGGER db_interface_ddl ON ddl_command_end
+			EXECUTE FUNCTION db_interface.notify_ddl()`)
+	}
+	if err != nil {
+		Check(tx.Rollback())
+		log.Printf("Cannot install the schema event trigger on %s: %s", c.Name, err)
+		return false
+	}
+	Commit(tx)
+	log.Printf("Installed the schema event trigger on %s", c.Name)
+	return true
+}
@@ -0,0 +1,63 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRefreshCatalog(t *testing.T) {
+	t.Setenv("RS_SCHEMA_POLL", "0")
+	c := setupTestDB(t,
+		"customers (id SERIAL PRIMARY KEY, name VARCHAR(255))",
+		"orders (id SERIAL PRIMARY KEY, customer_id INTEGER REFERENCES test.customers (id))")
+	c.db.MustExec("INSERT INTO test.customers (name) VALUES ('ann')")
+
+	sheet := Sheet{}
+	sheet.SetTable("test.orders")
+	joined := Sheet{}
+	joined.SetTable("test.customers")
+	joined.LoadJoins()
+	for oid := range joined.Table.Fkeys {
+		Check(joined.SetJoin(0, oid))
+	}
+	Check(joined.LoadRows(100, 0))
+	if len(joined.TableNames) != 2 {
+		t.Fatalf("Expected customers joined to orders, got %v", joined.TableNames)
+	}
+
+	c.checkCatalog()
+	if c.Table("test.customers") != joined.Table {
+		t.Error("Catalog refreshed without changes")
+	}
+
+	c.db.MustExec("ALTER TABLE test.customers ADD COLUMN email TEXT")
+	c.checkCatalog()
+	joined, _ = Store.Get(joined.Id)
+	Check(joined.LoadRows(100, 0))
+	if _, ok := joined.Table.Cols["email"]; !ok {
+		t.Errorf("Added column not loaded: %v", joined.Table.Cols)
+	}
+
+	c.db.MustExec("DROP TABLE test.orders")
+	c.RefreshCatalog()
+	for _, id := range []int{sheet.Id, joined.Id} {
+		loaded, _ := Store.Get(id)
+		err := loaded.LoadRows(100, 0)
+		if err == nil || !strings.Contains(err.Error(), "no longer exists") {
+			t.Errorf("Expected the sheet on a dropped table to fail, got %v", err)
+		}
+		loaded.OrderedCols(nil)
+	}
+}
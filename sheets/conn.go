@@ -13,23 +13,233 @@
 package sheets
 
 import (
-	"github.com/jmoiron/sqlx"
+	"acb/db-interface/escape"
+	"fmt"
+	"log"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"golang.org/x/exp/maps"
 )
 
-var conn *sqlx.DB
+// A database which sheets can be opened on
+type Connection struct {
+	Name    string
+	db      *sqlx.DB
+	dialect Dialect
+	// The database's tables by full name, which cache their columns and constraints
+	tableMap map[string]*Table
+	// Changes whenever the tables, columns or constraints do
+	fingerprint string
+	catalogLock sync.RWMutex
+	// Held while loading a table's columns and constraints
+	loadLock sync.Mutex
+	// Whether every transaction is read-only, except those writing to writableTables
+	ReadOnly       bool
+	writableTables []string
+	// Whether rows are loaded and written as the database role of the user a sheet is opened for
+	UsesRoles bool
+	// The schema of tables named without one, found when first needed
+	schema     string
+	schemaOnce sync.Once
+	// Whether triggers are installed on sheets' tables to push changes made outside of sheets
+	LiveTriggers bool
+	liveLock     sync.Mutex
+	liveTables   map[string]bool
+	listener     *pq.Listener
+	// Closed when the connection is
+	closed chan struct{}
+}
+
+// The connection opened from DATABASE_URL. Every DATABASE_URL_<NAME> variable
+// opens another connection, named <name> in lower case.
+// RS_READ_ONLY makes every connection read-only, and RS_READ_ONLY_<NAME> one of
+// them. RS_WRITABLE_TABLES_<NAME> lists the tables which can still be written to,
+// separated by commas, and makes the rest of that connection read-only.
+// RS_DB_ROLES, or RS_DB_ROLES_<NAME> for one connection, runs users' queries as
+// their database role. RS_LIVE_TRIGGERS, or RS_LIVE_TRIGGERS_<NAME>, installs
+// triggers on PostgreSQL tables opened in sheets, so that changes made outside of
+// sheets are pushed to the browsers showing them.
+// The catalog is checked for changes every RS_SCHEMA_POLL, or RS_SCHEMA_POLL_<NAME>,
+// which is a duration like 30s, or 0 to never check. RS_SCHEMA_TRIGGER or
+// RS_SCHEMA_TRIGGER_<NAME> installs a PostgreSQL event trigger to check after DDL.
+const DefaultConnectionName = "default"
+
+var Connections = make(map[string]*Connection)
+
+// Where sheets, column prefs and spreadsheet cells are saved. This is the default
+// connection unless RS_METADATA_URL is set, in which case the db_interface schema is
+// created there and the databases being edited are only accessed through their own tables.
+var meta *sqlx.DB
+var metaDialect Dialect = postgresDialect{}
+
+func openConnection(name, url string) *Connection {
+	d, err := dialectFor(url)
+	Check(err)
+	db, err := d.open(url)
+	Check(err)
+	c := &Connection{Name: name, db: db, dialect: d, tableMap: make(map[string]*Table), closed: make(chan struct{})}
+	suffix := strings.ToUpper(name)
+	c.ReadOnly = envIsSet("RS_READ_ONLY") || envIsSet("RS_READ_ONLY_"+suffix)
+	writableTables := os.Getenv("RS_WRITABLE_TABLES_" + suffix)
+	if writableTables != "" {
+		c.ReadOnly = true
+		c.writableTables = strings.Split(writableTables, ",")
+	}
+	c.UsesRoles = envIsSet("RS_DB_ROLES") || envIsSet("RS_DB_ROLES_"+suffix)
+	channels := []string{}
+	_, isPostgres := d.(postgresDialect)
+	if envIsSet("RS_LIVE_TRIGGERS") || envIsSet("RS_LIVE_TRIGGERS_"+suffix) {
+		if isPostgres {
+			c.LiveTriggers = true
+			c.liveTables = make(map[string]bool)
+			channels = append(channels, liveChannel)
+		} else {
+			log.Printf("Live update triggers are only supported on PostgreSQL, not on connection %s", name)
+		}
+	}
+	if envIsSet("RS_SCHEMA_TRIGGER") || envIsSet("RS_SCHEMA_TRIGGER_"+suffix) {
+		if !isPostgres {
+			log.Printf("Schema event triggers are only supported on PostgreSQL, not on connection %s", name)
+		} else if c.installSchemaTrigger() {
+			channels = append(channels, schemaChannel)
+		}
+	}
+	if len(channels) > 0 {
+		c.listen(url, channels...)
+	}
+	poll := defaultSchemaPoll
+	for _, key := range []string{"RS_SCHEMA_POLL", "RS_SCHEMA_POLL_" + suffix} {
+		if os.Getenv(key) != "" {
+			poll, err = time.ParseDuration(os.Getenv(key))
+			Check(err)
+		}
+	}
+	if poll > 0 {
+		c.pollCatalog(poll)
+	}
+	Connections[name] = c
+	log.Printf("Opened connection %s (read-only: %t, writable tables: %v)", name, c.ReadOnly, c.writableTables)
+	return c
+}
+
+func envIsSet(key string) bool {
+	set, _ := strconv.ParseBool(os.Getenv(key))
+	return set
+}
+
+func Open() {
+	Connections = make(map[string]*Connection)
+	defaultConnection := openConnection(DefaultConnectionName, os.Getenv("DATABASE_URL"))
+	for _, env := range os.Environ() {
+		key, url, _ := strings.Cut(env, "=")
+		name, found := strings.CutPrefix(key, "DATABASE_URL_")
+		if found && name != "" {
+			openConnection(strings.ToLower(name), url)
+		}
+	}
+
+	meta, metaDialect = defaultConnection.db, defaultConnection.dialect
+	metaURL := os.Getenv("RS_METADATA_URL")
+	if metaURL == "" && defaultConnection.ReadOnly {
+		// Sheets are still saved, but not through the read-only connection
+		metaURL = os.Getenv("DATABASE_URL")
+	}
+	if metaURL != "" {
+		var err error
+		metaDialect, err = dialectFor(metaURL)
+		Check(err)
+		meta, err = metaDialect.open(metaURL)
+		Check(err)
+	}
+}
+
+func Close() {
+	for _, c := range Connections {
+		close(c.closed)
+		if c.listener != nil {
+			Check(c.listener.Close())
+		}
+		Check(c.db.Close())
+	}
+	if !defaultConnection().hasMetadata() {
+		Check(meta.Close())
+	}
+}
+
+func defaultConnection() *Connection {
+	return Connections[DefaultConnectionName]
+}
+
+// Lists the connection names, with the default first
+func ConnectionNames() []string {
+	names := maps.Keys(Connections)
+	slices.Sort(names)
+	i := slices.Index(names, DefaultConnectionName)
+	return append([]string{DefaultConnectionName}, slices.Delete(names, i, i+1)...)
+}
+
+// Whether the metadata is saved in this database
+func (c *Connection) hasMetadata() bool {
+	return c.db == meta
+}
+
+// Applies an aggregate function, using the aggregates created in the database if any
+func (c *Connection) aggregate(function, expr string) string {
+	return c.dialect.aggregate(function, expr, c.hasMetadata())
+}
+
+// Returns the schema of tables named without one, such as public on PostgreSQL
+func (c *Connection) defaultSchema() string {
+	c.schemaOnce.Do(func() {
+		var err error
+		c.schema, err = c.dialect.defaultSchema(c.db)
+		if err != nil {
+			log.Printf("Cannot find the default schema of connection %s: %s", c.Name, err)
+		}
+	})
+	return c.schema
+}
+
+func (c *Connection) esc() escape.Dialect {
+	return c.dialect.Escape()
+}
+
+// Whether rows can be inserted into, updated in or deleted from the table
+func (c *Connection) Writable(tableName string) bool {
+	return !c.ReadOnly || slices.Contains(c.writableTables, tableName)
+}
 
-func Open() *sqlx.DB {
-	var err error
-	conn, err = sqlx.Open("pgx", os.Getenv("DATABASE_URL"))
+// Begins a transaction, which is read-only on a read-only connection
+func (c *Connection) Begin() *sqlx.Tx {
+	tx, err := c.dialect.begin(c.db, c.ReadOnly)
 	Check(err)
-	return conn
+	return tx
 }
 
-func Begin() *sqlx.Tx {
-	return conn.MustBegin()
+// Begins a transaction to write to tableNames, if they are all writable
+func (c *Connection) beginWrite(tableNames ...string) (*sqlx.Tx, error) {
+	for _, tableName := range tableNames {
+		if !c.Writable(tableName) {
+			return nil, fmt.Errorf("%s is read-only", tableName)
+		}
+	}
+	tx, err := c.dialect.begin(c.db, false)
+	if err == nil && c.LiveTriggers {
+		// The changes are published once committed, so the triggers need not notify them
+		_, err = tx.Exec("SELECT set_config('db_interface.published', 'on', true)")
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return tx, err
 }
 
 func Commit(tx *sqlx.Tx) {
@@ -0,0 +1,281 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSeparateMetadata(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DATABASE_URL", "sqlite://"+filepath.Join(dir, "shop.db"))
+	t.Setenv("RS_METADATA_URL", "sqlite://"+filepath.Join(dir, "meta.db"))
+	Open()
+	defer Close()
+	c := defaultConnection()
+	if c.hasMetadata() {
+		t.Fatal("Metadata opened in the database being edited")
+	}
+
+	InitSheetsTables()
+	InitPrefsTable()
+	CreateAggregates()
+	c.db.MustExec("CREATE TABLE items (id INTEGER PRIMARY KEY, price REAL)")
+	c.db.MustExec("INSERT INTO items (price) VALUES (2), (3)")
+	c.loadTables()
+
+	sheet := Sheet{}
+	sheet.SetTable("main.items")
+	sheet.LoadPrefs()
+	sheet.AddColumn("")
+	err := sheet.LoadRows(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = sheet.SetCell(0, 1, "=PRODUCT(price1:price2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sheet.ExtraCols[0].Cells[1].Value != "6" {
+		t.Errorf("Unexpected cell: %v", sheet.ExtraCols[0].Cells[1])
+	}
+
+	count := 0
+	Check(meta.Get(&count, "SELECT COUNT(*) FROM db_interface.sheetcells"))
+	if count != 1 {
+		t.Errorf("Expected 1 spreadsheet cell in the metadata, found %d", count)
+	}
+	Check(c.db.Get(&count, "SELECT COUNT(*) FROM pragma_database_list WHERE name = 'db_interface'"))
+	if count != 0 {
+		t.Error("db_interface attached to the database being edited")
+	}
+	_, err = os.Stat(filepath.Join(dir, "shop.db_interface.db"))
+	if !os.IsNotExist(err) {
+		t.Errorf("Unexpected db_interface file next to the database: %v", err)
+	}
+}
+
+func TestConnections(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DATABASE_URL", "sqlite://"+filepath.Join(dir, "shop.db"))
+	t.Setenv("DATABASE_URL_ANALYTICS", "sqlite://"+filepath.Join(dir, "analytics.db"))
+	Open()
+	defer Close()
+	if !slices.Equal(ConnectionNames(), []string{"default", "analytics"}) {
+		t.Fatalf("Unexpected connections: %v", ConnectionNames())
+	}
+
+	InitSheetsTables()
+	InitPrefsTable()
+	for i, c := range []*Connection{defaultConnection(), Connections["analytics"]} {
+		c.db.MustExec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)")
+		c.db.MustExec("INSERT INTO items (name) VALUES ($1)", c.Name)
+		if i == 1 {
+			c.db.MustExec("CREATE TABLE events (id INTEGER PRIMARY KEY)")
+		}
+	}
+	LoadSheets()
+
+	sheet := Sheet{ConnectionName: "analytics"}
+	sheet.SetTable("main.items")
+	sheet.LoadPrefs()
+	err := sheet.LoadRows(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sheet.Cells[0][1][0].Value != "analytics" {
+		t.Errorf("Loaded rows from the wrong connection: %v", sheet.Cells[0][1])
+	}
+
+	// Each connection has its own tables
+	if defaultConnection().Table("main.events") != nil {
+		t.Error("Table from analytics listed in the default connection")
+	}
+	if Connections["analytics"].Table("main.events") == nil {
+		t.Error("Missing table in the analytics connection")
+	}
+
+	Store.delete(sheet.Id)
+	LoadSheets()
+	loaded, _ := Store.Get(sheet.Id)
+	if loaded.ConnectionName != "analytics" || loaded.Table != Connections["analytics"].Table("main.items") {
+		t.Errorf("Sheet loaded on the wrong connection: %+v", loaded)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DATABASE_URL", "sqlite://"+filepath.Join(dir, "shop.db"))
+	t.Setenv("RS_WRITABLE_TABLES_DEFAULT", "main.items")
+	Open()
+	defer Close()
+	c := defaultConnection()
+	if !c.ReadOnly || c.hasMetadata() {
+		t.Fatalf("Unexpected connection: %+v", c)
+	}
+
+	InitSheetsTables()
+	InitPrefsTable()
+	c.db.MustExec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)")
+	c.db.MustExec("CREATE TABLE logs (id INTEGER PRIMARY KEY, message TEXT)")
+	c.db.MustExec("INSERT INTO items (name) VALUES ('a')")
+	c.db.MustExec("INSERT INTO logs (message) VALUES ('a')")
+	c.loadTables()
+
+	items := Sheet{}
+	items.SetTable("main.items")
+	items.LoadPrefs()
+	if items.ReadOnly() {
+		t.Error("Sheet on a writable table is read-only")
+	}
+	err := items.UpdateRows(map[string]map[string]string{
+		"main.items": {"name": "b"},
+	}, map[string]map[string]string{
+		"main.items": {"id": "1"},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	logs := Sheet{}
+	logs.SetTable("main.logs")
+	logs.LoadPrefs()
+	if !logs.ReadOnly() {
+		t.Error("Sheet on a read-only table is writable")
+	}
+	err = logs.UpdateRows(map[string]map[string]string{
+		"main.logs": {"message": "b"},
+	}, map[string]map[string]string{
+		"main.logs": {"id": "1"},
+	})
+	if err == nil {
+		t.Error("Updated a read-only table")
+	}
+	err = logs.InsertMultipleRows(map[string]map[string]string{
+		"main.logs": {"message": "c"},
+	}, make(map[string]map[string]string))
+	if err == nil {
+		t.Error("Inserted into a read-only table")
+	}
+
+	// Other transactions are read-only in the database too
+	tx := c.Begin()
+	_, err = tx.Exec("DELETE FROM logs")
+	Check(tx.Rollback())
+	if err == nil {
+		t.Error("Deleted rows in a read-only transaction")
+	}
+	count := 0
+	Check(c.db.Get(&count, "SELECT COUNT(*) FROM logs WHERE message = 'a'"))
+	if count != 1 {
+		t.Errorf("Read-only table changed: %d", count)
+	}
+}
+
+func TestDBRoles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DATABASE_URL", "sqlite://"+filepath.Join(dir, "shop.db"))
+	t.Setenv("RS_DB_ROLES_DEFAULT", "true")
+	Open()
+	defer Close()
+	c := defaultConnection()
+	if !c.UsesRoles {
+		t.Fatal("RS_DB_ROLES_DEFAULT ignored")
+	}
+
+	InitSheetsTables()
+	InitPrefsTable()
+	c.db.MustExec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)")
+	c.loadTables()
+	ann, _ := SetPassword("ann", "secret")
+	Check(SetDBRole("ann", "analyst"))
+	ann, _ = GetUser(ann.Id)
+	if ann.DBRole != "analyst" {
+		t.Errorf("Unexpected database role %s", ann.DBRole)
+	}
+	if SetDBRole("bob", "analyst") == nil {
+		t.Error("Set the database role of a missing user")
+	}
+
+	sheet := Sheet{}
+	sheet.SetTable("main.items")
+	sheet.LoadPrefs()
+	err := sheet.LoadRows(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Users without a role are refused instead of run as a role named after them
+	Check(SetDBRole("ann", ""))
+	noRole, _ := GetUser(ann.Id)
+	if noRole.DBRole != "" {
+		t.Errorf("Database role %s not cleared", noRole.DBRole)
+	}
+	unset := sheet.WithUser(noRole)
+	err = unset.LoadRows(10, 0)
+	if err == nil || !strings.Contains(err.Error(), "--set-db-role") {
+		t.Errorf("Unexpected error without a database role: %v", err)
+	}
+
+	// SQLite has no roles, so only queries not run for a user succeed
+	sheet = sheet.WithUser(ann)
+	err = sheet.LoadRows(10, 0)
+	if err == nil {
+		t.Error("Loaded rows without setting the role")
+	}
+	err = sheet.InsertMultipleRows(map[string]map[string]string{
+		"main.items": {"name": "a"},
+	}, make(map[string]map[string]string))
+	if err == nil {
+		t.Error("Inserted a row without setting the role")
+	}
+	err = sheet.UpdateRows(map[string]map[string]string{
+		"main.items": {"name": "b"},
+	}, map[string]map[string]string{
+		"main.items": {"id": "1"},
+	})
+	if err == nil {
+		t.Error("Updated a row without setting the role")
+	}
+	export, err := sheet.NewExport("csv", false)
+	if err == nil {
+		export.Write(io.Discard)
+		t.Error("Exported rows without setting the role")
+	}
+	_, err = sheet.Import(ImportFile{
+		Headers: []string{"name"},
+		Rows:    [][]string{{"c"}},
+	}, map[int]string{0: "main.items.name"}, false)
+	if err == nil {
+		t.Error("Imported rows without setting the role")
+	}
+	_, err = sheet.DeletePreview("main.items", map[string]string{"id": "1"})
+	if err == nil {
+		t.Error("Previewed a delete without setting the role")
+	}
+	err = sheet.DeleteRows(map[string]map[string]string{
+		"main.items": {"id": "1"},
+	})
+	if err == nil {
+		t.Error("Deleted a row without setting the role")
+	}
+	_, err = sheet.RowAt(5)
+	if err == nil {
+		t.Error("Read a row outside the page without setting the role")
+	}
+}
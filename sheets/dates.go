@@ -0,0 +1,455 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xuri/efp"
+)
+
+// Data types of date columns, as named by PostgreSQL
+const (
+	dateType        = "date"
+	timestampType   = "timestamp without time zone"
+	timestamptzType = "timestamp with time zone"
+	intervalType    = "interval"
+)
+
+func isDateType(dataType string) bool {
+	return dataType == dateType || dataType == timestampType || dataType == timestamptzType
+}
+
+// Day 0 of the serial numbers which dates are counted in, as in Excel
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// Formats of the dates and timestamps which the databases write as text. Those
+// with a time zone are converted to the sheet's.
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04:05Z07:00",
+}
+
+// Days in each unit of the intervals PostgreSQL writes, such as
+// 1 year 2 mons -3 days +04:05:06, which it also counts in when extracting an
+// interval's epoch
+var intervalUnits = map[string]float64{
+	"year": 365.25,
+	"mon":  30,
+	"day":  1,
+}
+
+// Minimum and maximum numbers of arguments of the date functions
+var dateFuncArgCounts = map[string][2]int{
+	"TODAY":   {0, 0},
+	"NOW":     {0, 0},
+	"DATE":    {3, 3},
+	"YEAR":    {1, 1},
+	"MONTH":   {1, 1},
+	"DAY":     {1, 1},
+	"WEEKDAY": {1, 2},
+	"DATEDIF": {3, 3},
+	"EDATE":   {2, 2},
+	"EOMONTH": {2, 2},
+}
+
+// Date functions which are taken in the database when they are applied to a
+// range of a table in an aggregate, such as SUM(YEAR(created_at:created_at))
+var datePartFuncs = map[string]string{
+	"YEAR":    "year",
+	"MONTH":   "month",
+	"DAY":     "day",
+	"WEEKDAY": "dow",
+}
+
+var locations sync.Map
+
+// The time zone the sheet's dates are shown and taken in
+func (s Sheet) location() *time.Location {
+	if loc, ok := locations.Load(s.Timezone); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		log.Printf("Using UTC for sheet %d: %s", s.Id, err)
+		loc = time.UTC
+	}
+	locations.Store(s.Timezone, loc)
+	return loc
+}
+
+func (s Sheet) timezoneName() string {
+	return s.location().String()
+}
+
+// Sets the time zone the sheet's dates are in by its IANA name, such as
+// Europe/Paris. Empty means UTC.
+func (s *Sheet) SetTimezone(name string) error {
+	if name == "Local" {
+		return fmt.Errorf("Unknown time zone %s", name)
+	}
+	_, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("Unknown time zone %s", name)
+	}
+	s.Timezone = name
+	s.SaveSheet()
+	return nil
+}
+
+// Dates are kept as their wall clock time in UTC, so that their serial numbers
+// and fields are those seen in the sheet's time zone
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+func dateSerial(t time.Time) float64 {
+	return float64(t.Unix()-excelEpoch.Unix()) / 86400
+}
+
+func serialDate(serial float64) time.Time {
+	return time.Unix(excelEpoch.Unix()+int64(math.Round(serial*86400)), 0).UTC()
+}
+
+// Dates at midnight are shown without their time
+func formatDate(t time.Time) string {
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func fromDate(t time.Time) Token {
+	token := fromFloat(dateSerial(t))
+	token.IsDate = true
+	token.TValue = formatDate(t)
+	return token
+}
+
+func fromSerial(serial float64) Token {
+	return fromDate(serialDate(serial))
+}
+
+// Parses a date or timestamp written by a database, converting it to loc if it
+// has a time zone
+func parseDate(value string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(layout, "Z07") {
+			t = t.In(loc)
+		}
+		return wallClock(t), true
+	}
+	return time.Time{}, false
+}
+
+// Parses an interval written by PostgreSQL into a number of days
+func parseInterval(value string) (float64, bool) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	days := 0.0
+	for i := 0; i < len(fields); i++ {
+		if strings.Contains(fields[i], ":") {
+			sign := 1.0
+			if strings.HasPrefix(fields[i], "-") {
+				sign = -1
+			}
+			parts := strings.Split(strings.TrimLeft(fields[i], "+-"), ":")
+			if len(parts) > 3 {
+				return 0, false
+			}
+			for k, part := range parts {
+				n, err := strconv.ParseFloat(part, 64)
+				if err != nil {
+					return 0, false
+				}
+				days += sign * n / 24 / math.Pow(60, float64(k))
+			}
+			continue
+		}
+		if i+1 == len(fields) {
+			return 0, false
+		}
+		n, err := strconv.ParseFloat(fields[i], 64)
+		unit, ok := intervalUnits[strings.TrimSuffix(fields[i+1], "s")]
+		if err != nil || !ok {
+			return 0, false
+		}
+		days += n * unit
+		i++
+	}
+	return days, true
+}
+
+// Returns the token for a cell of a column with dataType, parsing dates and
+// intervals, which count as numbers of days. Spreadsheet columns have no data
+// type, and their cells hold dates when they are written as one.
+func (s *Sheet) cellToken(value, dataType string) Token {
+	switch {
+	case value == "":
+	case isDateType(dataType) || dataType == "" && !fromString(value).IsNumeric:
+		if t, ok := parseDate(value, s.location()); ok {
+			return fromDate(t)
+		}
+	case dataType == intervalType:
+		if days, ok := parseInterval(value); ok {
+			return fromFloat(days)
+		}
+	}
+	return fromString(value)
+}
+
+// Returns the date a token holds, which may be a serial number or text written
+// as a date
+func (s *Sheet) tokenDate(token Token) (time.Time, error) {
+	if token.IsNumeric {
+		return serialDate(token.TFloat), nil
+	}
+	if t, ok := parseDate(token.TValue, s.location()); ok {
+		return t, nil
+	}
+	return time.Time{}, formulaError(codeValue, "%s is not a date", token.TValue)
+}
+
+func (s *Sheet) evalDate(arg []Token) (time.Time, error) {
+	token, err := s.evalTokens(arg)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return s.tokenDate(token)
+}
+
+// Evaluates a whole number, dropping any fraction as Excel does
+func (s *Sheet) evalInt(fName string, arg []Token) (int, error) {
+	token, err := s.evalTokens(arg)
+	if err != nil {
+		return 0, err
+	}
+	if !token.IsNumeric {
+		return 0, formulaError(codeValue, "%s needs a number, not %s", fName, token.TValue)
+	}
+	return int(math.Trunc(token.TFloat)), nil
+}
+
+func endOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC)
+}
+
+// Adds months to t, keeping its day unless the month is shorter
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	return first.AddDate(0, 0, min(t.Day(), endOfMonth(first).Day())-1)
+}
+
+// Counts the whole units between start and end as in Excel, where unit is one
+// of Y, M, D, or MD, YM and YD which ignore the larger units
+func dateDiff(start, end time.Time, unit string) (int, error) {
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	end = time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+	if end.Before(start) {
+		return 0, formulaError(codeNum, "the start date is after the end date")
+	}
+	months := (end.Year()-start.Year())*12 + int(end.Month()-start.Month())
+	if end.Day() < start.Day() {
+		months--
+	}
+	days := func(from time.Time) int {
+		return int(end.Sub(from).Hours() / 24)
+	}
+
+	switch strings.ToUpper(unit) {
+	case "Y":
+		return months / 12, nil
+	case "M":
+		return months, nil
+	case "D":
+		return days(start), nil
+	case "YM":
+		return months % 12, nil
+	case "MD":
+		return days(addMonths(start, months)), nil
+	case "YD":
+		return days(addMonths(start, months/12*12)), nil
+	}
+	return 0, formulaError(codeNum, "unknown unit %s", unit)
+}
+
+func (s *Sheet) evalDateFunction(fName string, arguments [][]Token) (Token, error) {
+	argCounts := dateFuncArgCounts[fName]
+	if len(arguments) == 1 && len(arguments[0]) == 0 && argCounts[0] == 0 {
+		arguments = nil
+	}
+	if len(arguments) < argCounts[0] || len(arguments) > argCounts[1] {
+		return Token{}, fmt.Errorf("wrong number of arguments for %s", fName)
+	}
+
+	switch fName {
+	case "TODAY":
+		now := time.Now().In(s.location())
+		return fromDate(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)), nil
+	case "NOW":
+		return fromDate(wallClock(time.Now().In(s.location()))), nil
+	case "DATE":
+		fields := [3]int{}
+		for i, arg := range arguments {
+			var err error
+			fields[i], err = s.evalInt(fName, arg)
+			if err != nil {
+				return Token{}, err
+			}
+		}
+		// As in Excel, years before 1900 count from 1900
+		if fields[0] >= 0 && fields[0] < 1900 {
+			fields[0] += 1900
+		}
+		return fromDate(time.Date(fields[0], time.Month(fields[1]), fields[2], 0, 0, 0, 0, time.UTC)), nil
+	}
+
+	t, err := s.evalDate(arguments[0])
+	if err != nil {
+		return Token{}, err
+	}
+	switch fName {
+	case "YEAR":
+		return fromFloat(float64(t.Year())), nil
+	case "MONTH":
+		return fromFloat(float64(t.Month())), nil
+	case "DAY":
+		return fromFloat(float64(t.Day())), nil
+	case "WEEKDAY":
+		returnType := 1
+		if len(arguments) > 1 {
+			returnType, err = s.evalInt(fName, arguments[1])
+			if err != nil {
+				return Token{}, err
+			}
+		}
+		// Counting from Sunday, Monday or Monday from 0
+		weekday := int(t.Weekday())
+		switch returnType {
+		case 1:
+			return fromFloat(float64(weekday + 1)), nil
+		case 2:
+			return fromFloat(float64((weekday+6)%7 + 1)), nil
+		case 3:
+			return fromFloat(float64((weekday + 6) % 7)), nil
+		}
+		return Token{}, formulaError(codeNum, "WEEKDAY supports the types 1, 2 and 3")
+	case "DATEDIF":
+		end, err := s.evalDate(arguments[1])
+		if err != nil {
+			return Token{}, err
+		}
+		unit, err := s.evalText(arguments[2])
+		if err != nil {
+			return Token{}, err
+		}
+		diff, err := dateDiff(t, end, unit)
+		if err != nil {
+			return Token{}, err
+		}
+		return fromFloat(float64(diff)), nil
+	}
+
+	// EDATE and EOMONTH
+	months, err := s.evalInt(fName, arguments[1])
+	if err != nil {
+		return Token{}, err
+	}
+	t = addMonths(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), months)
+	if fName == "EOMONTH" {
+		t = endOfMonth(t)
+	}
+	return fromDate(t), nil
+}
+
+// A range of one of the sheet's tables which an aggregate is taken of in the
+// database
+type sqlRange struct {
+	table      int
+	colName    string
+	start, end int
+	// Applied to sq.val, the column's values in the aggregate's query. Empty
+	// when the values are aggregated as they are.
+	expr string
+	// Whether the values are the serial numbers of dates
+	isDate bool
+}
+
+// Resolves an aggregate's argument if it is a range of one of the sheet's tables.
+// Date columns are aggregated as serial numbers, and date parts of them such as
+// YEAR(created_at:created_at) are taken in the database. ok is false for any
+// other argument.
+func (s *Sheet) sqlRangeArg(arg []Token) (r sqlRange, ok bool, err error) {
+	part := ""
+	if len(arg) == 3 && arg[0].TType == efp.TokenTypeFunction && arg[0].TSubType == efp.TokenSubTypeStart &&
+		arg[2].TType == efp.TokenTypeFunction && arg[2].TSubType == efp.TokenSubTypeStop {
+		part = datePartFuncs[strings.ToUpper(arg[0].TValue)]
+		if part == "" {
+			return sqlRange{}, false, nil
+		}
+		arg = arg[1:2]
+	}
+	if len(arg) != 1 || arg[0].TSubType != efp.TokenSubTypeRange {
+		return sqlRange{}, false, nil
+	}
+
+	colName, start, end, err := parseRange(arg[0].TValue)
+	if err != nil {
+		return sqlRange{}, false, err
+	}
+	tableIndex, colIndex, err := s.tableAndColIndex(colName)
+	if err != nil || tableIndex < 0 {
+		return sqlRange{}, false, err
+	}
+	r = sqlRange{table: tableIndex, colName: colName, start: start, end: end}
+
+	dataType := s.OrderedCols(nil)[tableIndex][colIndex].DataType
+	if !isDateType(dataType) {
+		if part != "" {
+			return sqlRange{}, false, formulaError(codeValue, "%s is not a date column", colName)
+		}
+		return r, true, nil
+	}
+	timezone := ""
+	if dataType == timestamptzType {
+		timezone = s.timezoneName()
+	}
+	switch part {
+	case "":
+		r.expr = s.Connection().dialect.datePart("serial", "sq.val", timezone)
+		r.isDate = true
+	case "dow":
+		// As WEEKDAY counts from 1 for Sunday
+		r.expr = fmt.Sprintf("(%s + 1)", s.Connection().dialect.datePart(part, "sq.val", timezone))
+	default:
+		r.expr = s.Connection().dialect.datePart(part, "sq.val", timezone)
+	}
+	return r, true, nil
+}
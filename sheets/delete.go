@@ -0,0 +1,354 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	"acb/db-interface/escape"
+	"acb/db-interface/fkeys"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/exp/maps"
+)
+
+// Number of referencing rows listed in a delete preview
+const deletePreviewRows = 10
+
+// Rows in another table which reference a row about to be deleted
+type DeleteEffect struct {
+	Fkey  fkeys.ForeignKey
+	Count int
+	// Primary keys of the first few affected rows, or their foreign key
+	// columns if the table has no primary key
+	KeyNames []string
+	Rows     [][]string
+}
+
+// Whether the effect makes the delete fail
+func (effect DeleteEffect) Blocks() bool {
+	return effect.Count > 0 && effect.Fkey.RestrictsDelete()
+}
+
+func (effect DeleteEffect) Summary() string {
+	switch {
+	case effect.Blocks():
+		return fmt.Sprintf("%d rows of %s reference this row, so it cannot be deleted (ON DELETE %s):",
+			effect.Count, effect.Fkey.SourceTableName, effect.Fkey.OnDelete)
+	case effect.Fkey.OnDelete == "CASCADE":
+		return fmt.Sprintf("%d rows of %s will also be deleted:", effect.Count, effect.Fkey.SourceTableName)
+	}
+	return fmt.Sprintf("%d rows of %s will have %s %s:",
+		effect.Count, effect.Fkey.SourceTableName, strings.Join(effect.Fkey.SourceColNames, ", "),
+		strings.ToLower(effect.Fkey.OnDelete))
+}
+
+// Identifies the ith affected row, e.g. id=3
+func (effect DeleteEffect) RowLabel(i int) string {
+	parts := make([]string, len(effect.KeyNames))
+	for j, name := range effect.KeyNames {
+		parts[j] = name + "=" + effect.Rows[i][j]
+	}
+	return strings.Join(parts, ", ")
+}
+
+func DeleteBlocked(effects []DeleteEffect) bool {
+	for _, effect := range effects {
+		if effect.Blocks() {
+			return true
+		}
+	}
+	return false
+}
+
+func (table *Table) primaryKeyNames() []string {
+	names := []string{}
+	for _, col := range table.Cols {
+		if col.IsPrimaryKey {
+			names = append(names, col.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Checks that primaryKeys identifies exactly one row of table, and returns
+// the key names in a fixed order
+func (table *Table) checkPrimaryKeys(primaryKeys map[string]string) ([]string, error) {
+	if !table.HasPrimaryKey {
+		return nil, errors.New("Cannot delete from table without primary key: " + table.FullName())
+	}
+	names := table.primaryKeyNames()
+	if len(names) != len(primaryKeys) {
+		return nil, fmt.Errorf("Expected primary key %v for %s, got %v", names, table.FullName(), primaryKeys)
+	}
+	for _, name := range names {
+		if _, ok := primaryKeys[name]; !ok {
+			return nil, fmt.Errorf("Missing primary key %s for %s", name, table.FullName())
+		}
+	}
+	return names, nil
+}
+
+func (sheet *Sheet) deletableTable(tx *sqlx.Tx, tableName string) (*Table, error) {
+	table := sheet.Connection().Table(tableName)
+	if table == nil || !slices.Contains(sheet.TableNames, tableName) {
+		return nil, fmt.Errorf("Table %s is not part of sheet %d", tableName, sheet.Id)
+	}
+	table.load(tx)
+	return table, nil
+}
+
+func (c *Connection) paramClauses(colNames []string) ([]escape.SafeSQL, error) {
+	clauses := make([]escape.SafeSQL, len(colNames))
+	for i, colName := range colNames {
+		var err error
+		clauses[i], err = c.esc().MakeParamClause(colName, i+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return clauses, nil
+}
+
+func (c *Connection) textCasts(colNames []string) ([]escape.SafeSQL, error) {
+	casts := make([]escape.SafeSQL, len(colNames))
+	for i, colName := range colNames {
+		var err error
+		casts[i], err = c.esc().MakeCast(colName, "text", "")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return casts, nil
+}
+
+// Selects colNames as text from the rows of tableName where each of keyNames
+// equals the corresponding value in keyValues. NULLs are selected as "".
+func (c *Connection) selectKeyed(tx *sqlx.Tx, tableName string, colNames, keyNames []string, keyValues []interface{}, limit int) ([][]string, error) {
+	rows, err := c.selectKeyedCells(tx, tableName, colNames, keyNames, keyValues, limit)
+	if err != nil {
+		return nil, err
+	}
+	result := make([][]string, len(rows))
+	for i, row := range rows {
+		result[i] = make([]string, len(row))
+		for j, cell := range row {
+			result[i][j] = cell.Value
+		}
+	}
+	return result, nil
+}
+
+func (c *Connection) selectKeyedCells(tx *sqlx.Tx, tableName string, colNames, keyNames []string, keyValues []interface{}, limit int) ([][]Cell, error) {
+	casts, err := c.textCasts(colNames)
+	if err != nil {
+		return nil, err
+	}
+	clauses, err := c.paramClauses(keyNames)
+	if err != nil {
+		return nil, err
+	}
+	query, err := c.esc().MakeSelectStmt([]string{tableName}, nil, casts, clauses, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(query, keyValues...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := [][]Cell{}
+	for len(result) < limit && rows.Next() {
+		values := make([]*string, len(colNames))
+		pointers := make([]interface{}, len(colNames))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return nil, err
+		}
+		row := make([]Cell, len(colNames))
+		for i, value := range values {
+			if value != nil {
+				row[i] = Cell{*value, true}
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+func (c *Connection) countKeyed(tx *sqlx.Tx, tableName string, keyNames []string, keyValues []interface{}) (int, error) {
+	clauses, err := c.paramClauses(keyNames)
+	if err != nil {
+		return 0, err
+	}
+	query, err := c.esc().MakeSelectStmt([]string{tableName}, nil, []escape.SafeSQL{escape.CountAll}, clauses, nil, false)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = tx.Get(&count, query, keyValues...)
+	return count, err
+}
+
+func keyValues(names []string, values map[string]string) []interface{} {
+	result := make([]interface{}, len(names))
+	for i, name := range names {
+		result[i] = values[name]
+	}
+	return result
+}
+
+// Lists the rows which reference the row of tableName identified by primaryKeys,
+// and so would be deleted, updated or would block the delete.
+// Only direct references are listed: cascades continue from the listed rows.
+func (sheet *Sheet) DeletePreview(tableName string, primaryKeys map[string]string) ([]DeleteEffect, error) {
+	c := sheet.Connection()
+	tx := c.Begin()
+	err := sheet.setRole(tx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	table, err := sheet.deletableTable(tx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	pkNames, err := table.checkPrimaryKeys(primaryKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	oids := maps.Keys(table.Fkeys)
+	slices.Sort(oids)
+	effects := []DeleteEffect{}
+	for _, oid := range oids {
+		fkey := table.Fkeys[oid]
+		if fkey.TargetTableName != tableName {
+			continue
+		}
+		referenced, err := c.selectKeyed(tx, tableName, fkey.TargetColNames, pkNames, keyValues(pkNames, primaryKeys), 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(referenced) == 0 {
+			return nil, fmt.Errorf("Row %v of %s no longer exists", primaryKeys, tableName)
+		}
+		referencedValues := make([]interface{}, len(referenced[0]))
+		for i, value := range referenced[0] {
+			if value == "" {
+				// NULLs are never referenced
+				referencedValues = nil
+				break
+			}
+			referencedValues[i] = value
+		}
+		if referencedValues == nil {
+			continue
+		}
+
+		effect := DeleteEffect{Fkey: fkey}
+		effect.Count, err = c.countKeyed(tx, fkey.SourceTableName, fkey.SourceColNames, referencedValues)
+		if err != nil {
+			return nil, err
+		}
+		if effect.Count == 0 {
+			continue
+		}
+		source := c.Table(fkey.SourceTableName)
+		source.load(tx)
+		effect.KeyNames = fkey.SourceColNames
+		if source.HasPrimaryKey {
+			effect.KeyNames = source.primaryKeyNames()
+		}
+		effect.Rows, err = c.selectKeyed(tx, fkey.SourceTableName, effect.KeyNames, fkey.SourceColNames, referencedValues, deletePreviewRows)
+		if err != nil {
+			return nil, err
+		}
+		effects = append(effects, effect)
+	}
+	log.Printf("Deleting %v from %s affects: %+v", primaryKeys, tableName, effects)
+	return effects, nil
+}
+
+func (table *Table) deleteRow(tx *sqlx.Tx, primaryKeys map[string]string) error {
+	pkNames, err := table.checkPrimaryKeys(primaryKeys)
+	if err != nil {
+		return err
+	}
+	query, err := table.connection.esc().MakeDeleteStmt(table.FullName(), pkNames)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(query, keyValues(pkNames, primaryKeys)...)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("Row %v of %s no longer exists", primaryKeys, table.FullName())
+	}
+	return nil
+}
+
+// Deletes one row from each table in primaryKeys, in a single transaction
+func (sheet *Sheet) DeleteRows(primaryKeys map[string]map[string]string) error {
+	log.Printf("DeleteRows(%v)", primaryKeys)
+	tx, err := sheet.Connection().beginWrite(maps.Keys(primaryKeys)...)
+	if err != nil {
+		return err
+	}
+	err = sheet.setRole(tx)
+	if err != nil {
+		return err
+	}
+	deleted, err := sheet.deleteRows(tx, primaryKeys)
+	if err != nil {
+		Check(tx.Rollback())
+		return err
+	}
+	return sheet.commitAudited(tx, sheet.auditEntries(AuditDelete, deleted))
+}
+
+// Deletes the rows and returns the values they had
+func (sheet *Sheet) deleteRows(tx *sqlx.Tx, primaryKeys map[string]map[string]string) ([]rowChange, error) {
+	deleted := []rowChange{}
+	for tableName, tablePrimaryKeys := range primaryKeys {
+		table, err := sheet.deletableTable(tx, tableName)
+		if err != nil {
+			return nil, err
+		}
+		colNames := maps.Keys(table.Cols)
+		slices.Sort(colNames)
+		oldValues, err := table.selectRow(tx, colNames, tablePrimaryKeys)
+		if err == nil {
+			err = table.deleteRow(tx, tablePrimaryKeys)
+		}
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, rowChange{tableName, tablePrimaryKeys, colNames, oldValues, nil})
+	}
+	return deleted, nil
+}
@@ -0,0 +1,80 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"testing"
+)
+
+func TestDeleteRows(t *testing.T) {
+	SetupTablesDB()
+	defer teardownTablesDB()
+	LoadExampleData()
+
+	customers := defaultConnection().Table("test.customers")
+	customers.load(nil)
+	orders := defaultConnection().Table("test.orders")
+	orders.load(nil)
+	sheet := Sheet{}
+	sheet.SetTable(customers.FullName())
+	for oid, fkey := range orders.Fkeys {
+		if fkey.TargetTableName == customers.FullName() {
+			err := sheet.SetJoin(0, oid)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	effects, err := sheet.DeletePreview(customers.FullName(), map[string]string{"id": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(effects) != 1 || effects[0].Count != 1 || !DeleteBlocked(effects) || effects[0].RowLabel(0) != "id=1" {
+		t.Fatalf("Unexpected preview for customer 1: %+v", effects)
+	}
+	err = sheet.DeleteRows(map[string]map[string]string{customers.FullName(): {"id": "1"}})
+	if err == nil {
+		t.Fatal("Deleted a customer with orders")
+	}
+
+	// order_products has no primary key, so its foreign key columns are listed
+	effects, err = sheet.DeletePreview(orders.FullName(), map[string]string{"id": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(effects) != 1 || effects[0].Count != 3 || effects[0].RowLabel(0) != "order_id=2" {
+		t.Fatalf("Unexpected preview for order 2: %+v", effects)
+	}
+
+	_, err = sheet.DeletePreview(customers.FullName(), map[string]string{})
+	if err == nil {
+		t.Fatal("Previewed a delete without a primary key")
+	}
+
+	effects, err = sheet.DeletePreview(customers.FullName(), map[string]string{"id": "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(effects) != 0 {
+		t.Fatalf("Unexpected preview for customer 5: %+v", effects)
+	}
+	err = sheet.DeleteRows(map[string]map[string]string{customers.FullName(): {"id": "5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sheet.DeleteRows(map[string]map[string]string{customers.FullName(): {"id": "5"}})
+	if err == nil {
+		t.Fatal("Deleted customer 5 twice")
+	}
+}
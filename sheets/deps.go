@@ -0,0 +1,283 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"log"
+	"slices"
+	"sort"
+
+	"github.com/xuri/efp"
+)
+
+// A spreadsheet cell at row j of column i, counting from 0
+type cellRef struct {
+	i, j int
+}
+
+// A spreadsheet cell with its position, as sent to the page after recalculating it
+type ExtraCell struct {
+	I int `json:"i"`
+	J int `json:"j"`
+	SheetCell
+}
+
+// The cells a formula references: rows first to last, counting from 0, of
+// column col of a table, or of the spreadsheet when table is -1 as in
+// tableAndColIndex
+type cellRange struct {
+	table, col  int
+	first, last int
+}
+
+func (r cellRange) contains(table, col, row int) bool {
+	return r.table == table && r.col == col && row >= r.first && row <= r.last
+}
+
+// Returns the cells referenced by tokens. References which don't resolve are left
+// for evaluation to report.
+func (s *Sheet) formulaRanges(tokens []Token) []cellRange {
+	ranges := []cellRange{}
+	for _, t := range tokens {
+		if t.TType != efp.TokenTypeOperand || t.TSubType != efp.TokenSubTypeRange {
+			continue
+		}
+		// Lookups can reference ranges of several columns
+		r, err := s.lookupRange([]Token{t})
+		if err != nil {
+			continue
+		}
+		for _, col := range r.cols {
+			if col.otherTable == "" {
+				ranges = append(ranges, cellRange{col.table, col.col, r.first, r.last})
+			}
+		}
+	}
+	return ranges
+}
+
+// The spreadsheet cells with formulas on the loaded page, and the cells each
+// formula references
+type depGraph struct {
+	refs map[cellRef][]cellRange
+	// Rows of the cells in each column, in order
+	rows map[int][]int
+}
+
+func (s *Sheet) pageGraph() depGraph {
+	graph := depGraph{make(map[cellRef][]cellRange), make(map[int][]int)}
+	for i, col := range s.ExtraCols {
+		for k, cell := range col.Cells {
+			if cell.Formula == "" {
+				continue
+			}
+			j := s.Offset + k
+			graph.refs[cellRef{i, j}] = s.formulaRanges(parseFormula(cell.Formula))
+			graph.rows[i] = append(graph.rows[i], j)
+		}
+	}
+	return graph
+}
+
+// Returns every cell of the graph in order
+func (g depGraph) nodes() []cellRef {
+	nodes := []cellRef{}
+	for i, rows := range g.rows {
+		for _, j := range rows {
+			nodes = append(nodes, cellRef{i, j})
+		}
+	}
+	sortRefs(nodes)
+	return nodes
+}
+
+func sortRefs(refs []cellRef) {
+	slices.SortFunc(refs, func(a, b cellRef) int {
+		if a.i != b.i {
+			return a.i - b.i
+		}
+		return a.j - b.j
+	})
+}
+
+// Returns the cells of the graph whose formulas reference ref
+func (g depGraph) references(ref cellRef) []cellRef {
+	referenced := []cellRef{}
+	for _, r := range g.refs[ref] {
+		if r.table >= 0 {
+			continue
+		}
+		rows := g.rows[r.col]
+		for k := sort.SearchInts(rows, r.first); k < len(rows) && rows[k] <= r.last; k++ {
+			referenced = append(referenced, cellRef{r.col, rows[k]})
+		}
+	}
+	return referenced
+}
+
+// Returns the cells of the graph whose formulas reference the cell at row of
+// column col of table, directly or through other cells, in order
+func (g depGraph) dependents(table, col, row int) []cellRef {
+	found := []cellRef{}
+	seen := make(map[cellRef]bool)
+	var visit func(table, col, row int)
+	visit = func(table, col, row int) {
+		for ref, ranges := range g.refs {
+			if seen[ref] {
+				continue
+			}
+			for _, r := range ranges {
+				if r.contains(table, col, row) {
+					seen[ref] = true
+					found = append(found, ref)
+					visit(-1, ref.i, ref.j)
+					break
+				}
+			}
+		}
+	}
+	visit(table, col, row)
+	sortRefs(found)
+	return found
+}
+
+// Orders nodes so that each cell comes after the cells it references, and returns
+// the cells which reference themselves through a cycle. Cells outside nodes are
+// taken to be evaluated already.
+func (g depGraph) evalOrder(nodes []cellRef) ([]cellRef, map[cellRef]bool) {
+	included := make(map[cellRef]bool)
+	for _, ref := range nodes {
+		included[ref] = true
+	}
+
+	// Tarjan's algorithm finds the strongly connected components after the
+	// components they reference
+	order := make([]cellRef, 0, len(nodes))
+	circular := make(map[cellRef]bool)
+	index := make(map[cellRef]int)
+	lowLink := make(map[cellRef]int)
+	onStack := make(map[cellRef]bool)
+	stack := []cellRef{}
+	var connect func(ref cellRef)
+	connect = func(ref cellRef) {
+		index[ref] = len(index)
+		lowLink[ref] = index[ref]
+		stack = append(stack, ref)
+		onStack[ref] = true
+		for _, next := range g.references(ref) {
+			if !included[next] {
+				continue
+			}
+			if next == ref {
+				circular[ref] = true
+			}
+			if _, visited := index[next]; !visited {
+				connect(next)
+				lowLink[ref] = min(lowLink[ref], lowLink[next])
+			} else if onStack[next] {
+				lowLink[ref] = min(lowLink[ref], index[next])
+			}
+		}
+		if lowLink[ref] != index[ref] {
+			return
+		}
+		k := slices.Index(stack, ref)
+		component := stack[k:]
+		stack = stack[:k]
+		for _, member := range component {
+			onStack[member] = false
+			if len(component) > 1 {
+				circular[member] = true
+			}
+		}
+		order = append(order, component...)
+	}
+	for _, ref := range nodes {
+		if _, visited := index[ref]; !visited {
+			connect(ref)
+		}
+	}
+	return order, circular
+}
+
+// Evaluates the cells of nodes on the loaded page after the cells they reference
+func (s *Sheet) recalculate(graph depGraph, nodes []cellRef) {
+	order, circular := graph.evalOrder(nodes)
+	for _, ref := range order {
+		cell := &s.ExtraCols[ref.i].Cells[ref.j-s.Offset]
+		delete(s.pending, ref)
+		if circular[ref] {
+			*cell = errorCell(cell.Formula, errCircular)
+			continue
+		}
+		done := s.startEval(ref)
+		evaluated, err := s.evalFormula(cell.Formula)
+		done()
+		if err != nil {
+			log.Printf("Error evaluating cell %d,%d (%s): %s", ref.i, ref.j, cell.Formula, err)
+			evaluated = errorCell(cell.Formula, err)
+		}
+		*cell = evaluated
+	}
+}
+
+// Marks ref as being evaluated until the returned function is called, so that
+// formulas reaching it again fail with errCircular
+func (s *Sheet) startEval(ref cellRef) func() {
+	if s.evaluating == nil {
+		s.evaluating = make(map[cellRef]bool)
+	}
+	s.evaluating[ref] = true
+	return func() { delete(s.evaluating, ref) }
+}
+
+// Returns the loaded cell at row j of column i, evaluating it if it's still
+// pending, and failing with the error its formula evaluated to
+func (s *Sheet) pageCell(i, j int) (SheetCell, error) {
+	ref := cellRef{i, j}
+	cell := &s.ExtraCols[i].Cells[j-s.Offset]
+	if s.evaluating[ref] {
+		return *cell, errCircular
+	}
+	if s.pending[ref] {
+		delete(s.pending, ref)
+		evaluated, err := s.evalStoredFormula(i, j, cell.Formula)
+		if err != nil {
+			return SheetCell{}, err
+		}
+		*cell = evaluated
+	}
+	if cell.Error != nil {
+		return *cell, cell.Error
+	}
+	return *cell, nil
+}
+
+func (s *Sheet) extraCell(ref cellRef) ExtraCell {
+	return ExtraCell{ref.i, ref.j, s.ExtraCols[ref.i].Cells[ref.j-s.Offset]}
+}
+
+// Returns the spreadsheet cells on the loaded page which reference row of the
+// database column colName of tableName, directly or through other cells
+func (s *Sheet) Dependents(tableName, colName string, row int) []ExtraCell {
+	tableIndex := slices.Index(s.TableNames, tableName)
+	if tableIndex < 0 || len(s.ExtraCols) == 0 {
+		return nil
+	}
+	colIndex := slices.IndexFunc(s.OrderedCols(nil)[tableIndex], func(col Column) bool { return col.Name == colName })
+	cells := []ExtraCell{}
+	for _, ref := range s.pageGraph().dependents(tableIndex, colIndex, row) {
+		cells = append(cells, s.extraCell(ref))
+	}
+	return cells
+}
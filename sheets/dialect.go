@@ -0,0 +1,307 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"acb/db-interface/escape"
+	"acb/db-interface/fkeys"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Everything which differs between the databases a sheet can be stored in.
+// SQL in this package is written for PostgreSQL, with $1, $2, ... parameters,
+// and a dialect covers whatever cannot be written portably.
+type Dialect interface {
+	// How identifiers and casts are written by package escape
+	Escape() escape.Dialect
+	open(url string) (*sqlx.DB, error)
+	// Begins a transaction, which cannot write to the database if readOnly is set
+	begin(db *sqlx.DB, readOnly bool) (*sqlx.Tx, error)
+	// Runs the rest of the transaction as the database role
+	setRole(tx *sqlx.Tx, role string) error
+	// Runs the rest of the transaction as the connection's own user again
+	resetRole(tx *sqlx.Tx) error
+	createSchema(db *sqlx.DB, name string)
+	// The schema of tables named without one
+	defaultSchema(db sqlx.Queryer) (string, error)
+	// Translates a PostgreSQL column type used by the metadata and example tables
+	sqlType(pgType string) string
+	// Names a table in the REFERENCES clause of a table in the same schema
+	referencedTable(fullName string) string
+
+	// Ends an INSERT into a metadata table so that it instead updates the row
+	// matching on conflictCols. The assignments follow.
+	upsert(conflictCols ...string) string
+	// Runs an INSERT (or upsert) into a metadata table and returns the row's id
+	insertReturningId(db sqlx.Ext, query string, args ...interface{}) (int, error)
+
+	// Creates the aggregates needed by formulas
+	createAggregates(db *sqlx.DB)
+	// Applies a formula's aggregate function, such as SUM or PRODUCT, to expr.
+	// hasAggregates is whether createAggregates was run on the database.
+	aggregate(function, expr string, hasAggregates bool) string
+	// Takes a part of the dates or timestamps in expr as a number: the year, month,
+	// day, dow (the day of the week from 0 for Sunday) or serial, the number of
+	// days since 1899-12-30 which formulas count dates in. Timestamps with time
+	// zones are taken in timezone, unless it is empty.
+	datePart(part, expr, timezone string) string
+
+	// Inserts a row and returns the returning columns of the new row as text
+	insertRow(tx *sqlx.Tx, table *Table, values map[string]interface{}, returning []string) ([]interface{}, error)
+
+	// Lists the user-visible tables, each with a unique Oid
+	loadTables(db sqlx.Queryer) []Table
+	loadCols(tx *sqlx.Tx, table *Table) []Column
+	// Returns the primary key columns of table, and the foreign keys from or to
+	// it keyed by a unique id
+	loadConstraints(tx *sqlx.Tx, table *Table) ([]string, map[int64]fkeys.ForeignKey)
+	// Returns a value which changes whenever the user-visible tables, their columns
+	// or their constraints do
+	catalogFingerprint(db sqlx.Queryer) (string, error)
+}
+
+// Dialects by URL scheme. URLs without a scheme are PostgreSQL connection strings.
+var dialects = map[string]Dialect{
+	"postgres":   postgresDialect{},
+	"postgresql": postgresDialect{},
+	"mysql":      mysqlDialect{},
+	"sqlite":     sqliteDialect{},
+}
+
+func dialectFor(url string) (Dialect, error) {
+	scheme, _, found := strings.Cut(url, "://")
+	if !found {
+		return postgresDialect{}, nil
+	}
+	d, ok := dialects[scheme]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported database: %s", scheme)
+	}
+	return d, nil
+}
+
+// Inserts a row with INSERT ... RETURNING
+func insertReturning(tx *sqlx.Tx, esc escape.Dialect, table *Table, values map[string]interface{}, returning []string) ([]interface{}, error) {
+	query, err := esc.MakeInsertStmt(table.FullName(), values, returning)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.NamedQuery(query, values)
+	if err != nil {
+		return nil, err
+	}
+	var result []interface{}
+	if len(returning) > 0 {
+		if !rows.Next() {
+			panic("No ID returned by insert")
+		}
+		result, err = rows.SliceScan()
+	}
+	Check(rows.Close())
+	return result, err
+}
+
+// Multiplies expr over the rows without a product aggregate, taking the sign from
+// the number of negative values. An empty product is 1, as with db_interface.mul.
+func productFromLogs(expr string) string {
+	return fmt.Sprintf(`COALESCE(CASE WHEN SUM(CASE WHEN %[1]s = 0 THEN 1 ELSE 0 END) > 0 THEN 0
+		ELSE EXP(SUM(LN(ABS(%[1]s)))) * (1 - 2 * MOD(SUM(CASE WHEN %[1]s < 0 THEN 1 ELSE 0 END), 2)) END, 1)`, expr)
+}
+
+// Stands in for an oid on databases which have none
+func nameId(parts ...string) int64 {
+	h := fnv.New32a()
+	h.Write([]byte(strings.Join(parts, ".")))
+	return int64(h.Sum32() & 0x7fffffff)
+}
+
+// Rewrites the $1, $2, ... parameters in query, outside of quotes, as ?.
+// Returns the number of each parameter in order of appearance.
+func questionMarkParams(query string) (string, []int) {
+	var b strings.Builder
+	order := []int{}
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '$':
+			end := i + 1
+			for end < len(query) && query[end] >= '0' && query[end] <= '9' {
+				end++
+			}
+			if end > i+1 {
+				n, _ := strconv.Atoi(query[i+1 : end])
+				order = append(order, n)
+				b.WriteByte('?')
+				i = end - 1
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), order
+}
+
+func reorderArgs(args []driver.NamedValue, order []int) ([]driver.NamedValue, error) {
+	reordered := make([]driver.NamedValue, len(order))
+	for i, n := range order {
+		if n < 1 || n > len(args) {
+			return nil, fmt.Errorf("Missing query parameter $%d", n)
+		}
+		reordered[i] = driver.NamedValue{Ordinal: i + 1, Value: args[n-1].Value}
+	}
+	return reordered, nil
+}
+
+// Lets drivers which expect ? parameters run the $n parameters used in this package
+type questionMarkDriver struct {
+	driver.Driver
+}
+
+func (d questionMarkDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return questionMarkConn{c}, nil
+}
+
+type questionMarkConn struct {
+	driver.Conn
+}
+
+func (c questionMarkConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c questionMarkConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	rewritten, order := questionMarkParams(query)
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, rewritten)
+	} else {
+		stmt, err = c.Conn.Prepare(rewritten)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return questionMarkStmt{stmt, order}, nil
+}
+
+func (c questionMarkConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c questionMarkConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rewritten, order := questionMarkParams(query)
+	reordered, err := reorderArgs(args, order)
+	if err != nil {
+		return nil, err
+	}
+	return queryer.QueryContext(ctx, rewritten, reordered)
+}
+
+func (c questionMarkConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rewritten, order := questionMarkParams(query)
+	reordered, err := reorderArgs(args, order)
+	if err != nil {
+		return nil, err
+	}
+	return execer.ExecContext(ctx, rewritten, reordered)
+}
+
+func (c questionMarkConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c questionMarkConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c questionMarkConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+type questionMarkStmt struct {
+	driver.Stmt
+	order []int
+}
+
+// The arguments are checked against the $n parameters by reorderArgs instead
+func (s questionMarkStmt) NumInput() int {
+	return -1
+}
+
+func (s questionMarkStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	reordered, err := reorderArgs(args, s.order)
+	if err != nil {
+		return nil, err
+	}
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return execer.ExecContext(ctx, reordered)
+	}
+	return s.Stmt.Exec(namedValuesToValues(reordered))
+}
+
+func (s questionMarkStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	reordered, err := reorderArgs(args, s.order)
+	if err != nil {
+		return nil, err
+	}
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return queryer.QueryContext(ctx, reordered)
+	}
+	return s.Stmt.Query(namedValuesToValues(reordered))
+}
+
+func namedValuesToValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
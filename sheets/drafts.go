@@ -0,0 +1,300 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/maps"
+)
+
+// An insert, update or delete held back in a draft
+type DraftChange struct {
+	// AuditInsert, AuditUpdate or AuditDelete
+	Action string
+	// Values by table and column of the inserted rows, or the new value of the
+	// updated cell
+	Values map[string]map[string]string
+	// Primary keys by table of the updated or deleted row, or of the rows the
+	// inserted rows are linked to
+	PrimaryKeys map[string]map[string]string
+	// The value the updated cell was loaded with
+	Original Cell
+}
+
+// The edits, inserts and deletes made to a sheet in draft mode, which are
+// committed together or discarded
+type Draft struct {
+	lock    sync.Mutex
+	changes []DraftChange
+}
+
+// Returns the sheet with its changes held back in draft, which can be nil
+func (s Sheet) WithDraft(draft *Draft) Sheet {
+	s.draft = draft
+	return s
+}
+
+// Whether the sheet's changes are held back in a draft
+func (s Sheet) Drafting() bool {
+	return s.draft != nil
+}
+
+// Returns the changes in the draft in the order they were made
+func (s Sheet) DraftChanges() []DraftChange {
+	if s.draft == nil {
+		return nil
+	}
+	s.draft.lock.Lock()
+	defer s.draft.lock.Unlock()
+	return slices.Clone(s.draft.changes)
+}
+
+func copyNestedMap(m map[string]map[string]string) map[string]map[string]string {
+	copied := make(map[string]map[string]string)
+	for key, inner := range m {
+		copied[key] = maps.Clone(inner)
+	}
+	return copied
+}
+
+// Holds back setting a cell. A cell set again keeps the value it was first loaded with.
+func (s Sheet) DraftUpdate(tableName, colName, value string, original Cell, primaryKeys map[string]string) {
+	s.draft.lock.Lock()
+	defer s.draft.lock.Unlock()
+	for i, change := range s.draft.changes {
+		if change.Action == AuditUpdate && maps.Equal(change.PrimaryKeys[tableName], primaryKeys) {
+			if _, ok := change.Values[tableName][colName]; ok {
+				s.draft.changes[i].Values[tableName][colName] = value
+				return
+			}
+		}
+	}
+	s.draft.changes = append(s.draft.changes, DraftChange{
+		Action:      AuditUpdate,
+		Values:      map[string]map[string]string{tableName: {colName: value}},
+		PrimaryKeys: map[string]map[string]string{tableName: maps.Clone(primaryKeys)},
+		Original:    original,
+	})
+}
+
+// Holds back inserting rows, as InsertMultipleRows would
+func (s Sheet) DraftInsert(values, referencedValues map[string]map[string]string) {
+	s.draft.lock.Lock()
+	defer s.draft.lock.Unlock()
+	s.draft.changes = append(s.draft.changes, DraftChange{
+		Action:      AuditInsert,
+		Values:      copyNestedMap(values),
+		PrimaryKeys: copyNestedMap(referencedValues),
+	})
+}
+
+// Holds back deleting a row
+func (s Sheet) DraftDelete(tableName string, primaryKeys map[string]string) {
+	s.draft.lock.Lock()
+	defer s.draft.lock.Unlock()
+	s.draft.changes = append(s.draft.changes, DraftChange{
+		Action:      AuditDelete,
+		PrimaryKeys: map[string]map[string]string{tableName: maps.Clone(primaryKeys)},
+	})
+}
+
+// Returns the value a cell of the row identified by primaryKeys is set to in the draft
+func (s Sheet) DraftValue(tableName, colName string, primaryKeys map[string]string) (string, bool) {
+	value, found := "", false
+	for _, change := range s.DraftChanges() {
+		if change.Action == AuditUpdate && maps.Equal(change.PrimaryKeys[tableName], primaryKeys) {
+			if changed, ok := change.Values[tableName][colName]; ok {
+				value, found = changed, true
+			}
+		}
+	}
+	return value, found
+}
+
+// Whether the row of tableName identified by primaryKeys is deleted in the draft
+func (s Sheet) DraftDeleted(tableName string, primaryKeys map[string]string) bool {
+	for _, change := range s.DraftChanges() {
+		if change.Action == AuditDelete && maps.Equal(change.PrimaryKeys[tableName], primaryKeys) {
+			return true
+		}
+	}
+	return false
+}
+
+// Makes every change in the draft in a single transaction, and empties the draft
+// if they all succeed
+func (sheet *Sheet) CommitDraft() error {
+	sheet.draft.lock.Lock()
+	defer sheet.draft.lock.Unlock()
+	changes := sheet.draft.changes
+	log.Printf("Committing draft of sheet %d: %+v", sheet.Id, changes)
+	tableNames := []string{}
+	for _, change := range changes {
+		for tableName := range change.Values {
+			tableNames = append(tableNames, tableName)
+		}
+		if change.Action == AuditDelete {
+			tableNames = append(tableNames, maps.Keys(change.PrimaryKeys)...)
+		}
+	}
+	tx, err := sheet.Connection().beginWrite(tableNames...)
+	if err != nil {
+		return err
+	}
+	err = sheet.setRole(tx)
+	if err != nil {
+		return err
+	}
+
+	entries := []AuditEntry{}
+	for i, change := range changes {
+		var rows []rowChange
+		switch change.Action {
+		case AuditUpdate:
+			originals := make(map[string]map[string]Cell)
+			for tableName, tableValues := range change.Values {
+				for colName := range tableValues {
+					addToNestedMap(originals, tableName, colName, change.Original)
+				}
+			}
+			rows, err = sheet.updateRowsTx(tx, copyNestedMap(change.Values), change.PrimaryKeys, originals)
+		case AuditInsert:
+			// Inserting fills in the values linking the rows
+			rows, err = sheet.insertMultipleRows(tx, copyNestedMap(change.Values), copyNestedMap(change.PrimaryKeys))
+		case AuditDelete:
+			rows, err = sheet.deleteRows(tx, change.PrimaryKeys)
+		}
+		if err != nil {
+			Check(tx.Rollback())
+			return fmt.Errorf("Draft change %d (%s) failed, so nothing was saved: %w", i+1, change.Action, err)
+		}
+		entries = append(entries, sheet.auditEntries(change.Action, rows)...)
+	}
+	err = sheet.commitAudited(tx, entries)
+	if err == nil {
+		sheet.draft.changes = nil
+	}
+	return err
+}
+
+// Throws away every change in the draft
+func (s Sheet) DiscardDraft() {
+	s.draft.lock.Lock()
+	defer s.draft.lock.Unlock()
+	s.draft.changes = nil
+}
+
+// Returns the primary key of table i in row j of the loaded page, where cols are
+// the sheet's ordered columns. Nil if a primary key column isn't shown.
+func (s Sheet) RowKey(cols [][]Column, i, j int) map[string]string {
+	table := s.Connection().Table(s.TableNames[i])
+	key := make(map[string]string)
+	for k, col := range cols[i] {
+		if col.IsPrimaryKey {
+			key[col.Name] = s.Cells[i][k][j].Value
+		}
+	}
+	if table == nil || len(key) != len(table.primaryKeyNames()) {
+		return nil
+	}
+	return key
+}
+
+// Returns cell k of table i in row j of the loaded page, as it is set in the draft
+func (s Sheet) DisplayedCell(cols [][]Column, i, k, j int) Cell {
+	cell := s.Cells[i][k][j]
+	if !s.Drafting() {
+		return cell
+	}
+	key := s.RowKey(cols, i, j)
+	if key == nil {
+		return cell
+	}
+	value, ok := s.DraftValue(s.TableNames[i], cols[i][k].Name, key)
+	if ok {
+		return Cell{value, value != ""}
+	}
+	return cell
+}
+
+// Whether table i's part of row j of the loaded page is deleted in the draft
+func (s Sheet) RowDeleted(cols [][]Column, i, j int) bool {
+	if !s.Drafting() {
+		return false
+	}
+	key := s.RowKey(cols, i, j)
+	return key != nil && s.DraftDeleted(s.TableNames[i], key)
+}
+
+// Returns the values of the rows inserted in the draft
+func (s Sheet) DraftInserts() []map[string]map[string]string {
+	inserts := []map[string]map[string]string{}
+	for _, change := range s.DraftChanges() {
+		if change.Action == AuditInsert {
+			inserts = append(inserts, change.Values)
+		}
+	}
+	return inserts
+}
+
+func describeValue(value string) string {
+	if value == "" {
+		return "NULL"
+	}
+	return fmt.Sprintf("%q", value)
+}
+
+// Describes the change for review, with a line per table
+func (change DraftChange) Describe() []string {
+	lines := []string{}
+	switch change.Action {
+	case AuditUpdate:
+		for tableName, tableValues := range change.Values {
+			for colName, value := range tableValues {
+				original := "NULL"
+				if change.Original.NotNull {
+					original = fmt.Sprintf("%q", change.Original.Value)
+				}
+				lines = append(lines, fmt.Sprintf("Set %s of %s %s from %s to %s", colName, tableName,
+					primaryKeyJSON(change.PrimaryKeys[tableName]), original, describeValue(value)))
+			}
+		}
+	case AuditInsert:
+		tableNames := maps.Keys(change.Values)
+		slices.Sort(tableNames)
+		for _, tableName := range tableNames {
+			if isEmpty(change.Values[tableName]) {
+				continue
+			}
+			colNames := maps.Keys(change.Values[tableName])
+			slices.Sort(colNames)
+			values := []string{}
+			for _, colName := range colNames {
+				if change.Values[tableName][colName] != "" {
+					values = append(values, colName+" = "+describeValue(change.Values[tableName][colName]))
+				}
+			}
+			lines = append(lines, fmt.Sprintf("Insert into %s: %s", tableName, strings.Join(values, ", ")))
+		}
+	case AuditDelete:
+		for tableName, primaryKeys := range change.PrimaryKeys {
+			lines = append(lines, fmt.Sprintf("Delete %s from %s", primaryKeyJSON(primaryKeys), tableName))
+		}
+	}
+	return lines
+}
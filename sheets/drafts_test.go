@@ -0,0 +1,76 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDraft(t *testing.T) {
+	c := setupTestDB(t, "items (id SERIAL PRIMARY KEY, name VARCHAR(255))")
+	c.db.MustExec("INSERT INTO test.items (name) VALUES ('pen'), ('ink')")
+
+	sheet := Sheet{}
+	sheet.SetTable("test.items")
+	sheet.LoadPrefs()
+	sheet = sheet.WithDraft(&Draft{})
+	count := func() int {
+		n := 0
+		Check(c.db.Get(&n, "SELECT COUNT(*) FROM test.items"))
+		return n
+	}
+
+	sheet.DraftUpdate("test.items", "name", "pencil", Cell{"pen", true}, map[string]string{"id": "1"})
+	sheet.DraftUpdate("test.items", "name", "quill", Cell{"pencil", true}, map[string]string{"id": "1"})
+	sheet.DraftInsert(map[string]map[string]string{"test.items": {"name": "paper"}}, map[string]map[string]string{})
+	sheet.DraftDelete("test.items", map[string]string{"id": "2"})
+	if len(sheet.DraftChanges()) != 3 {
+		t.Fatalf("Expected setting a cell twice to be one change: %+v", sheet.DraftChanges())
+	}
+	if value, ok := sheet.DraftValue("test.items", "name", map[string]string{"id": "1"}); !ok || value != "quill" {
+		t.Errorf("Unexpected draft value %s", value)
+	}
+	if !sheet.DraftDeleted("test.items", map[string]string{"id": "2"}) {
+		t.Error("Deleted row not in the draft")
+	}
+	if count() != 2 {
+		t.Error("Draft changed the table before it was committed")
+	}
+
+	Check(sheet.CommitDraft())
+	names := []string{}
+	Check(c.db.Select(&names, "SELECT name FROM test.items ORDER BY id"))
+	if len(names) != 2 || names[0] != "quill" || names[1] != "paper" {
+		t.Errorf("Unexpected rows after committing: %v", names)
+	}
+	if len(sheet.DraftChanges()) != 0 || len(LoadAuditLog(AuditFilter{}, 100, 0)) != 4 {
+		t.Error("Committed draft not emptied or audited")
+	}
+
+	// A failing change rolls back the whole draft
+	sheet.DraftInsert(map[string]map[string]string{"test.items": {"name": "ruler"}}, map[string]map[string]string{})
+	sheet.DraftUpdate("test.items", "name", "brush", Cell{"pen", true}, map[string]string{"id": "1"})
+	err := sheet.CommitDraft()
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Errorf("Expected a conflict, got %v", err)
+	}
+	if count() != 2 || len(sheet.DraftChanges()) != 2 {
+		t.Error("Failed draft partly committed or emptied")
+	}
+	sheet.DiscardDraft()
+	if len(sheet.DraftChanges()) != 0 {
+		t.Error("Draft not discarded")
+	}
+}
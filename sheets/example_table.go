@@ -12,39 +12,56 @@
 // If not, see https://www.gnu.org/licenses/agpl-3.0.html
 package sheets
 
+import "fmt"
+
+// Creates the example tables in the default connection
 func SetupTablesDB() {
 	Open()
+	c := defaultConnection()
 	InitSheetsTables()
+	InitPrefsTable()
 	teardownTablesDB()
-	conn.MustExec("CREATE SCHEMA IF NOT EXISTS test")
-	conn.MustExec(
+	c.dialect.createSchema(c.db, "test")
+	c.db.MustExec(fmt.Sprintf(
 		`CREATE TABLE IF NOT EXISTS test.customers (
-    		id SERIAL PRIMARY KEY
+    		id %s PRIMARY KEY
 			, name VARCHAR(255)
-		)`)
-	conn.MustExec(
+		)`,
+		c.dialect.sqlType("SERIAL")))
+	c.db.MustExec(fmt.Sprintf(
 		`CREATE TABLE IF NOT EXISTS test.orders (
-    		id SERIAL PRIMARY KEY 
-			, total DECIMAL
+    		id %s PRIMARY KEY 
+			, total %s
             , status VARCHAR(255)
-            , customer_id INT NOT NULL REFERENCES test.customers(id)
-		)`)
-	conn.MustExec(
+            , customer_id INT NOT NULL
+            , FOREIGN KEY (customer_id) REFERENCES %s(id)
+		)`,
+		c.dialect.sqlType("SERIAL"),
+		c.dialect.sqlType("DECIMAL"),
+		c.dialect.referencedTable("test.customers")))
+	c.db.MustExec(fmt.Sprintf(
 		`CREATE TABLE IF NOT EXISTS test.products (
-    		id SERIAL PRIMARY KEY 
+    		id %s PRIMARY KEY 
 			, name VARCHAR(255)
-            , price DECIMAL
-		)`)
-	conn.MustExec(
+            , price %s
+		)`,
+		c.dialect.sqlType("SERIAL"),
+		c.dialect.sqlType("DECIMAL")))
+	c.db.MustExec(fmt.Sprintf(
 		`CREATE TABLE IF NOT EXISTS test.order_products (
-			order_id INT NOT NULL REFERENCES test.orders(id)
-			, product_id INT NOT NULL REFERENCES test.products(id)
-		)`)
-	loadTables()
+			order_id INT NOT NULL
+			, product_id INT NOT NULL
+			, FOREIGN KEY (order_id) REFERENCES %s(id)
+			, FOREIGN KEY (product_id) REFERENCES %s(id)
+		)`,
+		c.dialect.referencedTable("test.orders"),
+		c.dialect.referencedTable("test.products")))
+	c.loadTables()
 }
 
 func LoadExampleData() {
-	conn.MustExec(
+	c := defaultConnection()
+	c.db.MustExec(
 		`INSERT INTO test.customers (name)
 			VALUES ('Alice')
 				, ('Bob')
@@ -56,7 +73,7 @@ func LoadExampleData() {
 				, ('Herald')
 				, ('Irina')
 		`)
-	conn.MustExec(
+	c.db.MustExec(
 		`INSERT INTO test.orders (customer_id, total, status)
 			VALUES (1, 123.45, 'unfilled')
 				, (2, 2010.99, 'shipped')
@@ -71,7 +88,7 @@ func LoadExampleData() {
 				, (8, 169.01, 'unfilled')
 				, (9, 41.55, 'unfilled')
 		`)
-	conn.MustExec(
+	c.db.MustExec(
 		`INSERT INTO test.products (name, price)
 			VALUES ('ACME Widget', 123.45)
 				, ('Steel Bolt', 10)
@@ -81,7 +98,7 @@ func LoadExampleData() {
 				, ('Pillow', 30.56)
 				, ('Digital Download', 0.99)
 		`)
-	conn.MustExec(
+	c.db.MustExec(
 		`INSERT INTO test.order_products (order_id, product_id)
 			VALUES (1, 1)
 				, (2, 2)
@@ -123,9 +140,11 @@ func LoadExampleData() {
 }
 
 func teardownTablesDB() {
-	conn.MustExec("DELETE FROM db_interface.sheets WHERE schemaname='test'")
-	conn.MustExec("DROP TABLE IF EXISTS test.customers CASCADE")
-	conn.MustExec("DROP TABLE IF EXISTS test.orders CASCADE")
-	conn.MustExec("DROP TABLE IF EXISTS test.products CASCADE")
-	conn.MustExec("DROP TABLE IF EXISTS test.order_products CASCADE")
+	c := defaultConnection()
+	meta.MustExec("DELETE FROM db_interface.sheets WHERE schemaname='test'")
+	// Referencing tables first, since only PostgreSQL supports CASCADE
+	c.db.MustExec("DROP TABLE IF EXISTS test.order_products")
+	c.db.MustExec("DROP TABLE IF EXISTS test.orders")
+	c.db.MustExec("DROP TABLE IF EXISTS test.products")
+	c.db.MustExec("DROP TABLE IF EXISTS test.customers")
 }
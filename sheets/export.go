@@ -0,0 +1,283 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/xuri/excelize/v2"
+)
+
+type ExportFormat struct {
+	ContentType string
+	Extension   string
+}
+
+var ExportFormats = map[string]ExportFormat{
+	"csv":  {"text/csv", ".csv"},
+	"tsv":  {"text/tab-separated-values", ".tsv"},
+	"xlsx": {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ".xlsx"},
+}
+
+var numericTypes = []string{
+	"smallint", "integer", "bigint", "numeric", "real", "double precision",
+}
+
+type exportWriter interface {
+	WriteHeader(names []string) error
+	WriteRow(cells []Cell) error
+	Close() error
+}
+
+type csvExportWriter struct {
+	*csv.Writer
+}
+
+func (w csvExportWriter) WriteHeader(names []string) error {
+	return w.Write(names)
+}
+
+func (w csvExportWriter) WriteRow(cells []Cell) error {
+	values := make([]string, len(cells))
+	for i, cell := range cells {
+		values[i] = cell.Value
+	}
+	return w.Write(values)
+}
+
+func (w csvExportWriter) Close() error {
+	w.Flush()
+	return w.Error()
+}
+
+type xlsxExportWriter struct {
+	out     io.Writer
+	file    *excelize.File
+	stream  *excelize.StreamWriter
+	numeric []bool
+	row     int
+}
+
+func (w *xlsxExportWriter) writeValues(values []interface{}) error {
+	w.row++
+	cellName, err := excelize.CoordinatesToCellName(1, w.row)
+	if err != nil {
+		return err
+	}
+	return w.stream.SetRow(cellName, values)
+}
+
+func (w *xlsxExportWriter) WriteHeader(names []string) error {
+	return w.writeValues(stringsToInterfaces(names))
+}
+
+func (w *xlsxExportWriter) WriteRow(cells []Cell) error {
+	values := make([]interface{}, len(cells))
+	for i, cell := range cells {
+		if !cell.NotNull {
+			continue
+		}
+		values[i] = cell.Value
+		if w.numeric[i] {
+			f, err := strconv.ParseFloat(cell.Value, 64)
+			if err == nil {
+				values[i] = f
+			}
+		}
+	}
+	return w.writeValues(values)
+}
+
+func (w *xlsxExportWriter) Close() error {
+	err := w.stream.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = w.file.WriteTo(w.out)
+	if err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+func newExportWriter(w io.Writer, format string, numeric []bool) (exportWriter, error) {
+	switch format {
+	case "csv":
+		return csvExportWriter{csv.NewWriter(w)}, nil
+	case "tsv":
+		writer := csv.NewWriter(w)
+		writer.Comma = '\t'
+		return csvExportWriter{writer}, nil
+	case "xlsx":
+		file := excelize.NewFile()
+		stream, err := file.NewStreamWriter("Sheet1")
+		if err != nil {
+			return nil, err
+		}
+		return &xlsxExportWriter{w, file, stream, numeric, 0}, nil
+	}
+	return nil, fmt.Errorf("unsupported export format: %s", format)
+}
+
+type Export struct {
+	sheet           *Sheet
+	format          string
+	includeFormulas bool
+	cols            [][]Column
+	tx              *sqlx.Tx
+	rows            *sqlx.Rows
+}
+
+// Runs the sheet's query without a row limit, in a transaction which Write
+// finishes. Only the rows up to the last one with a spreadsheet cell are read
+// here, and the spreadsheet columns are evaluated against them. Write streams
+// the rest, so the whole table is never held in memory.
+func (sheet *Sheet) NewExport(format string, includeFormulas bool) (*Export, error) {
+	_, ok := ExportFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+
+	err := sheet.LoadJoins()
+	if err != nil {
+		return nil, err
+	}
+	sheet.LoadPrefs()
+	cols := sheet.OrderedCols(nil)
+	query, err := sheet.selectQuery(cols, false)
+	if err != nil {
+		return nil, err
+	}
+	tx := sheet.Connection().Begin()
+	err = sheet.setRole(tx)
+	if err != nil {
+		return nil, err
+	}
+	sheet.TotalRows, err = sheet.countRows(tx, cols)
+	if err != nil {
+		Check(tx.Rollback())
+		return nil, err
+	}
+	rows, err := tx.Queryx(query)
+	if err != nil {
+		Check(tx.Rollback())
+		return nil, fmt.Errorf("Error running %s: %w", query, err)
+	}
+
+	extraRows := sheet.extraRowCount()
+	pageRows := [][][]Cell{}
+	for len(pageRows) < extraRows && rows.Next() {
+		row, err := scanRow(rows, cols)
+		if err != nil {
+			rows.Close()
+			Check(tx.Rollback())
+			return nil, err
+		}
+		pageRows = append(pageRows, row)
+	}
+	sheet.clearPage(cols, len(pageRows))
+	sheet.setPage(cols, 0, pageRows)
+	sheet.loadExtraCols()
+	return &Export{sheet, format, includeFormulas, cols, tx, rows}, nil
+}
+
+func (e *Export) header() ([]string, []bool) {
+	names := []string{}
+	numeric := []bool{}
+	for i, tableName := range e.sheet.TableNames {
+		for _, col := range e.cols[i] {
+			name := col.Name
+			if len(e.sheet.TableNames) > 1 {
+				name = tableName + "." + col.Name
+			}
+			names = append(names, name)
+			numeric = append(numeric, slices.Contains(numericTypes, col.DataType))
+		}
+	}
+	for _, col := range e.sheet.ExtraCols {
+		names = append(names, col.Name)
+		numeric = append(numeric, true)
+		if e.includeFormulas {
+			names = append(names, col.Name+" (formula)")
+			numeric = append(numeric, false)
+		}
+	}
+	return names, numeric
+}
+
+func (e *Export) Write(w io.Writer) error {
+	defer e.tx.Rollback()
+	defer e.rows.Close()
+
+	names, numeric := e.header()
+	writer, err := newExportWriter(w, e.format, numeric)
+	if err != nil {
+		return err
+	}
+	err = writer.WriteHeader(names)
+	if err != nil {
+		return err
+	}
+
+	// The rows read by NewExport, next to their spreadsheet cells
+	for k := 0; k < e.sheet.RowCount; k++ {
+		cells := make([]Cell, 0, len(names))
+		for _, tableCells := range e.sheet.Cells {
+			for _, colCells := range tableCells {
+				cells = append(cells, colCells[k])
+			}
+		}
+		for _, col := range e.sheet.ExtraCols {
+			sheetCell := col.Cells[k]
+			cells = append(cells, sheetCell.Cell)
+			if e.includeFormulas {
+				cells = append(cells, Cell{sheetCell.Formula, sheetCell.Formula != ""})
+			}
+		}
+		err = writer.WriteRow(cells)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Then the rest, whose spreadsheet cells are all empty
+	count := e.sheet.RowCount
+	for e.rows.Next() {
+		row, err := scanRow(e.rows, e.cols)
+		if err != nil {
+			return err
+		}
+		cells := make([]Cell, 0, len(names))
+		for _, tableCells := range row {
+			cells = append(cells, tableCells...)
+		}
+		cells = append(cells, make([]Cell, len(names)-len(cells))...)
+		err = writer.WriteRow(cells)
+		if err != nil {
+			return err
+		}
+		count++
+	}
+	err = e.rows.Err()
+	if err != nil {
+		return err
+	}
+	log.Printf("Exported %d rows from sheet %d as %s", count, e.sheet.Id, e.format)
+	return writer.Close()
+}
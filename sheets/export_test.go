@@ -0,0 +1,144 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func writeExport(t *testing.T, format string) []byte {
+	buf := bytes.Buffer{}
+	writer, err := newExportWriter(&buf, format, []bool{false, true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.WriteHeader([]string{"name", "total"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.WriteRow([]Cell{{"bob, jr.", true}, {"12.5", true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.WriteRow([]Cell{{"alice", true}, {"", false}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExportDelimited(t *testing.T) {
+	expected := "name,total\n\"bob, jr.\",12.5\nalice,\n"
+	actual := string(writeExport(t, "csv"))
+	if actual != expected {
+		t.Errorf("%q != %q", actual, expected)
+	}
+
+	expected = "name\ttotal\nbob, jr.\t12.5\nalice\t\n"
+	actual = string(writeExport(t, "tsv"))
+	if actual != expected {
+		t.Errorf("%q != %q", actual, expected)
+	}
+}
+
+func TestExportXLSX(t *testing.T) {
+	file, err := excelize.OpenReader(bytes.NewReader(writeExport(t, "xlsx")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := file.GetRows("Sheet1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[1][0] != "bob, jr." || rows[1][1] != "12.5" || rows[2][0] != "alice" {
+		t.Fatalf("Unexpected rows: %v", rows)
+	}
+	cellType, err := file.GetCellType("Sheet1", "B2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cellType == excelize.CellTypeSharedString || cellType == excelize.CellTypeInlineString {
+		t.Errorf("Numeric value exported as text")
+	}
+}
+
+func TestUnsupportedExportFormat(t *testing.T) {
+	_, err := newExportWriter(&bytes.Buffer{}, "pdf", nil)
+	if err == nil {
+		t.Error("Unexpected success")
+	}
+}
+
+func TestNewExport(t *testing.T) {
+	SetupTablesDB()
+	defer teardownTablesDB()
+	LoadExampleData()
+
+	tableName := "test.customers"
+	sheet := Sheet{}
+	sheet.SetTable(tableName)
+	sheet.LoadPrefs()
+	sheet.SavePref(Pref{TableName: tableName, ColumnName: "id", Hide: true})
+	sheet.SavePref(Pref{TableName: tableName, ColumnName: "name", Filter: "> 'E'"})
+	sheet.AddColumn("")
+	err := sheet.LoadRows(2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j := 0; j < 2; j++ {
+		_, _, err = sheet.SetCell(0, j, fmt.Sprintf("=LEN(name%d)", j+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Saved past the page loaded when the cell was set, referencing a row
+	// after the last formula, which is streamed
+	err = sheet.LoadRows(2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = sheet.SetCell(0, 3, "=LEN(name5)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := sheet.NewExport("csv", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sheet.RowCount != 4 {
+		t.Errorf("Read %d rows before streaming instead of those up to the last formula", sheet.RowCount)
+	}
+	buf := bytes.Buffer{}
+	err = export.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "name,A,A (formula)\n" +
+		"Erin,4,=LEN(name1)\n" +
+		"Finnegan,8,=LEN(name2)\n" +
+		"George,,\n" +
+		"Herald,5,=LEN(name5)\n" +
+		"Irina,,\n"
+	if buf.String() != expected {
+		t.Errorf("%q != %q", buf.String(), expected)
+	}
+}
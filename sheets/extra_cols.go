@@ -13,71 +13,110 @@
 package sheets
 
 import (
+	"fmt"
 	"log"
 	"slices"
 )
 
 func initExtraColsTables() {
-	conn.MustExec(`
+	meta.MustExec(fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS db_interface.sheetcols (
-			id SERIAL PRIMARY KEY
+			id %s PRIMARY KEY
 			, sheet_id INT NOT NULL
 			, i INTEGER NOT NULL
 			, colname VARCHAR(255) NOT NULL
 			, UNIQUE (sheet_id, i)
 			, CONSTRAINT fk_sheets
 				FOREIGN KEY (sheet_id)
-					REFERENCES db_interface.sheets(id) ON DELETE CASCADE
-		)`)
+					REFERENCES %s(id) ON DELETE CASCADE
+		)`,
+		metaDialect.sqlType("SERIAL"),
+		metaDialect.referencedTable("db_interface.sheets")))
 	log.Println("SheetCols table exists")
 
-	conn.MustExec(`
+	meta.MustExec(fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS db_interface.sheetcells (
-			id SERIAL PRIMARY KEY
+			id %s PRIMARY KEY
 			, sheetcol_id INT NOT NULL
 			, j INTEGER NOT NULL
 			, formula VARCHAR(255) NOT NULL
 			, UNIQUE (sheetcol_id, j)
 			, CONSTRAINT fk_sheetcol
 				FOREIGN KEY (sheetcol_id)
-					REFERENCES db_interface.sheetcols(id) ON DELETE CASCADE
-		)`)
+					REFERENCES %s(id) ON DELETE CASCADE
+		)`,
+		metaDialect.sqlType("SERIAL"),
+		metaDialect.referencedTable("db_interface.sheetcols")))
 	log.Println("SheetCells table exists")
 }
 
 func (s *Sheet) loadCells() {
+	s.loadFormulas()
+	// Cells are evaluated after the cells they reference
+	graph := s.pageGraph()
+	s.recalculate(graph, graph.nodes())
+	log.Println("Loaded custom column cells")
+}
+
+// Loads the formulas of the cells next to the loaded rows without evaluating
+// them. Each is evaluated when first referenced, unless recalculated before.
+func (s *Sheet) loadFormulas() {
 	for i, col := range s.ExtraCols {
 		col.Cells = make([]SheetCell, s.RowCount)
 		s.ExtraCols[i] = col
 	}
 
-	rows, err := conn.Query(`
+	rows, err := meta.Query(`
 		SELECT i, j, Formula
 		FROM db_interface.sheetcells
 			JOIN db_interface.sheetcols
 			ON sheetcol_id = db_interface.sheetcols.id
 		WHERE sheet_id = $1
+			AND j >= $2
+			AND j < $3
 		ORDER BY i, j`,
-		s.Id)
+		s.Id,
+		s.Offset,
+		s.Offset+s.RowCount)
 	Check(err)
 
+	// Only rows shown next to the loaded database rows are evaluated, since a
+	// formula's relative references only have values there. Exports load every
+	// row up to the last saved formula.
+	s.pending = make(map[cellRef]bool)
 	var formula string
 	var i, j int
 	for rows.Next() {
 		err = rows.Scan(&i, &j, &formula)
 		Check(err)
-		s.ExtraCols[i].Cells[j], err = s.evalFormula(formula)
-		if err != nil {
-			log.Printf("Error loading cell %d,%d (%s): %s", i, j, formula, err)
-		}
+		s.ExtraCols[i].Cells[j-s.Offset].Formula = formula
+		s.pending[cellRef{i, j}] = true
 	}
+}
 
-	log.Println("Loaded custom column cells")
+// Returns the number of spreadsheet rows up to the last one with a saved formula
+func (s *Sheet) extraRowCount() int {
+	var count int
+	err := meta.Get(&count, `
+		SELECT COALESCE(MAX(j) + 1, 0)
+		FROM db_interface.sheetcells
+			JOIN db_interface.sheetcols
+			ON sheetcol_id = db_interface.sheetcols.id
+		WHERE sheet_id = $1`,
+		s.Id)
+	Check(err)
+	return count
 }
 
 func (s *Sheet) loadExtraCols() {
+	s.selectExtraCols()
+	s.loadCells()
+}
+
+// Loads the spreadsheet columns without their cells
+func (s *Sheet) selectExtraCols() {
 	s.ExtraCols = make([]SheetColumn, 0, 20)
-	err := conn.Select(&s.ExtraCols, `
+	err := meta.Select(&s.ExtraCols, `
 		SELECT id
 			, colname AS "name"
 		FROM db_interface.sheetcols
@@ -86,52 +125,72 @@ func (s *Sheet) loadExtraCols() {
 		s.Id)
 	Check(err)
 	log.Printf("Loaded %d custom columns", len(s.ExtraCols))
-
-	SheetMap[s.Id] = *s
-	s.loadCells()
 }
 
 func (s *Sheet) saveCol(i int) {
 	log.Printf("Saving extra column %d", i)
 	col := s.ExtraCols[i]
-	row := conn.QueryRow(`
+	var err error
+	col.Id, err = metaDialect.insertReturningId(meta, `
 		INSERT INTO db_interface.sheetcols (
 			sheet_id
 			, i
 			, colname
 		) VALUES (
 			$1, $2, $3
-		) ON CONFLICT (sheet_id, i) DO
-		UPDATE SET colname = $3
-		RETURNING id`,
+		) `+metaDialect.upsert("sheet_id", "i")+`
+			colname = $3`,
 		s.Id,
 		i,
 		col.Name)
-	err := row.Scan(&col.Id)
 	Check(err)
 	s.ExtraCols[i] = col
 }
 
-func (s *Sheet) SetCell(i, j int, formula string) (SheetCell, error) {
-	return s.setCellTokens(i, j, formula, parseFormula(formula))
+// Saves formula into the cell at row j of column i and recalculates the cells on
+// the loaded page which reference it. Returns the cell and the recalculated cells.
+// Other cells are only evaluated if those reference them and they aren't already.
+func (s *Sheet) SetCell(i, j int, formula string) (SheetCell, []ExtraCell, error) {
+	cell, err := s.setCellTokens(i, j, formula, parseFormula(formula))
+	if err != nil {
+		return SheetCell{}, nil, err
+	}
+	graph := s.pageGraph()
+	dependents := graph.dependents(-1, i, j)
+	s.recalculate(graph, dependents)
+	recalculated := make([]ExtraCell, 0, len(dependents))
+	for _, ref := range dependents {
+		if ref == (cellRef{i, j}) {
+			// The cell references itself through a cycle
+			cell = s.ExtraCols[i].Cells[j-s.Offset]
+			continue
+		}
+		recalculated = append(recalculated, s.extraCell(ref))
+	}
+	return cell, recalculated, nil
 }
 
 func (s *Sheet) setCellTokens(i, j int, formula string, tokens []Token) (SheetCell, error) {
 	column := s.ExtraCols[i]
+	done := s.startEval(cellRef{i, j})
 	cell, err := s.evalTokensToCell(formula, tokens)
+	done()
 	if err != nil {
 		return SheetCell{}, err
 	}
-	column.Cells[j] = cell
+	if j >= s.Offset && j < s.Offset+len(column.Cells) {
+		column.Cells[j-s.Offset] = cell
+		delete(s.pending, cellRef{i, j})
+	}
 	//log.Printf("Saving cell %v (%d,%d) into column id=%d", cell, i, j, s.ExtraCols[i].Id)
-	conn.MustExec(`
+	meta.MustExec(`
 		INSERT INTO db_interface.sheetcells (
 		    sheetcol_id
 		    , j
 		    , formula
 		) VALUES ($1, $2, $3)
-		ON CONFLICT (sheetcol_id, j) DO
-		UPDATE SET formula = $3`,
+		`+metaDialect.upsert("sheetcol_id", "j")+`
+			formula = $3`,
 		s.ExtraCols[i].Id,
 		j,
 		formula)
@@ -157,7 +216,7 @@ func (s *Sheet) AddColumn(name string) {
 
 	s.ExtraCols = append(s.ExtraCols, SheetColumn{Name: name, Cells: make([]SheetCell, 100)})
 	s.saveCol(len(s.ExtraCols) - 1)
-	SheetMap[s.Id] = *s
+	Store.put(*s)
 }
 
 func (s *Sheet) RenameCol(i int, name string) {
@@ -165,26 +224,28 @@ func (s *Sheet) RenameCol(i int, name string) {
 	col.Name = name
 	s.ExtraCols[i] = col
 	s.saveCol(i)
+	Store.put(*s)
 }
 
 func (s *Sheet) DeleteColumn(i int) {
-	conn.MustExec(
+	meta.MustExec(
 		"DELETE FROM db_interface.sheetcols WHERE id = $1",
 		s.ExtraCols[i].Id)
-	conn.MustExec(`
+	meta.MustExec(`
 		UPDATE db_interface.sheetcols
 		SET i = i + 1
 		WHERE sheet_id = $1 and i > $2`,
 		s.Id,
 		i)
 	s.ExtraCols = slices.Delete(s.ExtraCols, i, i+1)
-	SheetMap[s.Id] = *s
+	Store.put(*s)
 }
 
 func (s *Sheet) FillColumnDown(i, j int, formula string) error {
 	col := s.ExtraCols[i]
 	tokens := parseFormula(formula)
-	for k := range col.Cells[j:] {
+	// Fills to the end of the loaded page
+	for k := 0; j+k < s.Offset+len(col.Cells); k++ {
 		translatedTokens, err := translateTokens(tokens, k)
 		if err != nil {
 			return err
@@ -13,6 +13,7 @@
 package sheets
 
 import (
+	"slices"
 	"strconv"
 	"testing"
 )
@@ -66,3 +67,152 @@ func TestFillColumnDown(t *testing.T) {
 		}
 	}
 }
+
+func TestRecalculateDependents(t *testing.T) {
+	SetupTablesDB()
+	defer teardownTablesDB()
+
+	sheet := Sheet{RowCount: 10}
+	sheet.SetTable("test.customers")
+	sheet.AddColumn("")
+	sheet.AddColumn("")
+	sheet.AddColumn("")
+	sheet.SetCell(0, 0, "1")
+	sheet.SetCell(1, 0, "=A1+1")
+	sheet.SetCell(2, 0, "=B1*2")
+	// References a cell of a later column
+	sheet.SetCell(0, 1, "=C1+1")
+
+	cell, recalculated, err := sheet.SetCell(0, 0, "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cell.Value != "5" {
+		t.Errorf("edited cell: %s != 5", cell.Value)
+	}
+	expected := []ExtraCell{
+		{0, 1, SheetCell{Cell{"13", true}, "=C1+1", nil}},
+		{1, 0, SheetCell{Cell{"6", true}, "=A1+1", nil}},
+		{2, 0, SheetCell{Cell{"12", true}, "=B1*2", nil}},
+	}
+	if !slices.Equal(recalculated, expected) {
+		t.Errorf("%v != %v", recalculated, expected)
+	}
+
+	sheet.loadCells()
+	for _, c := range expected {
+		if value := sheet.ExtraCols[c.I].Cells[c.J].Value; value != c.Value {
+			t.Errorf("after loading, cell %d,%d: %s != %s", c.I, c.J, value, c.Value)
+		}
+	}
+
+	sheet.ExtraCols[0].Cells[2].Formula = "=id1+1"
+	dependents := sheet.Dependents("test.customers", "id", 0)
+	if len(dependents) != 1 || dependents[0].I != 0 || dependents[0].J != 2 {
+		t.Errorf("cells referencing id1: %v", dependents)
+	}
+}
+
+func TestRecalculateOnlyDependents(t *testing.T) {
+	SetupTablesDB()
+	defer teardownTablesDB()
+	LoadExampleData()
+
+	sheet := Sheet{RowCount: 10}
+	sheet.SetTable("test.customers")
+	sheet.AddColumn("")
+	sheet.AddColumn("")
+	sheet.AddColumn("")
+	sheet.SetCell(0, 0, "1")
+	sheet.SetCell(0, 1, "7")
+	sheet.SetCell(0, 2, "=A2+1")
+	sheet.SetCell(1, 0, "=A1+A3")
+	sheet.SetCell(2, 0, "=B1*2")
+	sheet.SetCell(1, 1, "=A2*3")
+
+	err := sheet.LoadPageFormulas(10, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, recalculated, err := sheet.SetCell(0, 0, "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ExtraCell{
+		{1, 0, SheetCell{Cell{"13", true}, "=A1+A3", nil}},
+		{2, 0, SheetCell{Cell{"26", true}, "=B1*2", nil}},
+	}
+	if !slices.Equal(recalculated, expected) {
+		t.Errorf("%v != %v", recalculated, expected)
+	}
+	// A3 and the A2 it references are evaluated for B1, but B2 references neither
+	if cell := sheet.ExtraCols[0].Cells[2]; cell.Value != "8" {
+		t.Errorf("A3: %s != 8", cell.Value)
+	}
+	if cell := sheet.ExtraCols[1].Cells[1]; cell.NotNull || cell.Formula != "=A2*3" {
+		t.Errorf("B2 evaluated: %+v", cell)
+	}
+}
+
+func TestCircularReferences(t *testing.T) {
+	SetupTablesDB()
+	defer teardownTablesDB()
+
+	sheet := Sheet{RowCount: 10}
+	sheet.SetTable("test.customers")
+	sheet.AddColumn("")
+	sheet.AddColumn("")
+	sheet.AddColumn("")
+	sheet.SetCell(0, 0, "=B1")
+	sheet.SetCell(2, 0, "=A1+1")
+	cell, recalculated, err := sheet.SetCell(1, 0, "=A1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cell.Value != codeCircular {
+		t.Errorf("B1: %s != %s", cell.Value, codeCircular)
+	}
+	for _, c := range recalculated {
+		if c.Value != codeCircular {
+			t.Errorf("cell %d,%d: %s != %s", c.I, c.J, c.Value, codeCircular)
+		}
+	}
+	if len(recalculated) != 2 {
+		t.Errorf("recalculated %v", recalculated)
+	}
+
+	sheet.loadCells()
+	for i := 0; i < 3; i++ {
+		if value := sheet.ExtraCols[i].Cells[0].Value; value != codeCircular {
+			t.Errorf("after loading, cell %d,0: %s != %s", i, value, codeCircular)
+		}
+	}
+
+	// Breaking the cycle recalculates the cells in it
+	_, recalculated, err = sheet.SetCell(1, 0, "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range recalculated {
+		expected := map[int]string{0: "3", 2: "4"}[c.I]
+		if c.Value != expected {
+			t.Errorf("cell %d,%d: %s != %s", c.I, c.J, c.Value, expected)
+		}
+	}
+
+	cell, _, err = sheet.SetCell(0, 1, "=SUM(A1:A3)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cell.Value != codeCircular {
+		t.Errorf("A2: %s != %s", cell.Value, codeCircular)
+	}
+}
+
+func TestFormatCount(t *testing.T) {
+	for n, expected := range map[int]string{0: "0", 999: "999", 1000: "1,000", 48312: "48,312", 1234567: "1,234,567"} {
+		if formatCount(n) != expected {
+			t.Errorf("%s != %s", formatCount(n), expected)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Codes of the errors formulas evaluate to, shown in place of their values
+const (
+	codeDivZero  = "#DIV/0!"
+	codeRef      = "#REF!"
+	codeName     = "#NAME?"
+	codeValue    = "#VALUE!"
+	codeNA       = "#N/A"
+	codeNum      = "#NUM!"
+	codeCircular = "#CIRCULAR!"
+)
+
+// An error a formula evaluates to, as in Excel. It is saved as the value of the
+// cell, and every formula using the cell evaluates to it as well unless it
+// handles it with IFERROR or ISERROR.
+type FormulaError struct {
+	Code string
+	// Explains the error to the user
+	Cause string
+}
+
+func (e *FormulaError) Error() string {
+	return e.Code + " " + e.Cause
+}
+
+func formulaError(code, format string, a ...any) *FormulaError {
+	return &FormulaError{code, fmt.Sprintf(format, a...)}
+}
+
+// Evaluated by a cell which references itself, directly or through other cells
+var errCircular = formulaError(codeCircular, "The formula references its own cell")
+
+// Returns err as a formula error, taking any other failure to evaluate a
+// formula as #VALUE!
+func asFormulaError(err error) *FormulaError {
+	var formulaErr *FormulaError
+	if errors.As(err, &formulaErr) {
+		return formulaErr
+	}
+	return formulaError(codeValue, "%s", err)
+}
+
+func errorCell(formula string, err error) SheetCell {
+	formulaErr := asFormulaError(err)
+	return SheetCell{Cell{formulaErr.Code, true}, formula, formulaErr}
+}
+
+func (s *Sheet) evalIfError(fName string, arguments [][]Token) (Token, error) {
+	if fName == "IFERROR" && len(arguments) != 2 || fName == "ISERROR" && len(arguments) != 1 {
+		return Token{}, fmt.Errorf("wrong number of arguments for %s", fName)
+	}
+
+	val, err := s.evalTokens(arguments[0])
+	var formulaErr *FormulaError
+	isError := errors.As(err, &formulaErr)
+	if err != nil && !isError {
+		return Token{}, err
+	}
+
+	if fName == "ISERROR" {
+		return fromBool(isError), nil
+	}
+	if isError {
+		return s.evalTokens(arguments[1])
+	}
+	return val, nil
+}
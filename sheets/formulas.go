@@ -15,6 +15,7 @@ package sheets
 import (
 	"acb/db-interface/escape"
 	"acb/db-interface/fkeys"
+	"database/sql"
 	"errors"
 	"fmt"
 	"log"
@@ -31,19 +32,20 @@ type Token struct {
 	efp.Token
 	IsNumeric bool
 	IsBool    bool
-	TFloat    float64
-	TBool     bool
+	// Dates are numeric, with TFloat counting days from 1899-12-30 as in Excel
+	IsDate bool
+	TFloat float64
+	TBool  bool
 }
 
+// Aggregates are created in the db_interface schema, so they are left out of the
+// databases which the metadata is not saved in
 func CreateAggregates() {
-	conn.MustExec(
-		`CREATE OR REPLACE AGGREGATE db_interface.mul(numeric) (
-			STYPE = numeric,
-			INITCOND = 1,
-			SFUNC = numeric_mul,
-			COMBINEFUNC = numeric_mul,
-			PARALLEL = SAFE
-		)`)
+	for _, c := range Connections {
+		if c.hasMetadata() {
+			c.dialect.createAggregates(c.db)
+		}
+	}
 }
 
 func parseFormula(formula string) []Token {
@@ -71,6 +73,7 @@ func fromString(val string) Token {
 		efp.Token{val, efp.TokenTypeOperand, efp.TokenSubTypeNumber},
 		err == nil,
 		false,
+		false,
 		float,
 		false,
 	}
@@ -81,6 +84,7 @@ func fromFloat(val float64) Token {
 		efp.Token{formatFloat(val), efp.TokenTypeOperand, efp.TokenSubTypeNumber},
 		true,
 		false,
+		false,
 		val,
 		false,
 	}
@@ -91,11 +95,28 @@ func fromBool(val bool) Token {
 		efp.Token{fmt.Sprintf("%t", val), efp.TokenTypeOperand, efp.TokenSubTypeNumber},
 		false,
 		true,
+		false,
 		0,
 		val,
 	}
 }
 
+// Returns the value of t in arithmetic, where empty values count as 0, logical
+// ones as 1 or 0 and text as the number it spells if any
+func (t Token) number() (float64, error) {
+	switch {
+	case t.IsNumeric:
+		return t.TFloat, nil
+	case t.TSubType == efp.TokenSubTypeText && fromString(t.TValue).IsNumeric:
+		return fromString(t.TValue).TFloat, nil
+	case t.IsBool && t.TBool:
+		return 1, nil
+	case t.IsBool || t.TValue == "":
+		return 0, nil
+	}
+	return 0, formulaError(codeValue, "%s is not a number", t.TValue)
+}
+
 func formatFloat(val float64) string {
 	return strconv.FormatFloat(val, 'f', -1, 64)
 }
@@ -199,27 +220,46 @@ func (s *Sheet) infixOperator(t1, t2 Token, operator string) (Token, error) {
 	if err != nil {
 		return Token{}, err
 	}
+	if operator == "&" {
+		return fromText(a.TValue + b.TValue), nil
+	}
+	x, err := a.number()
+	if err != nil {
+		return Token{}, err
+	}
+	y, err := b.number()
+	if err != nil {
+		return Token{}, err
+	}
 
 	var f float64
 	switch operator {
 	case "*":
-		f = a.TFloat * b.TFloat
+		f = x * y
 	case "/":
-		f = a.TFloat / b.TFloat
+		if y == 0 {
+			return Token{}, formulaError(codeDivZero, "division by zero")
+		}
+		f = x / y
 	case "+":
-		f = a.TFloat + b.TFloat
+		f = x + y
 	case "-":
-		f = a.TFloat - b.TFloat
+		f = x - y
 	default:
 		return Token{}, errors.New("invalid infix operator")
 	}
+	// Adding days to a date or subtracting them from it gives a date, and the
+	// difference of two dates a number of days
+	if operator == "+" && a.IsDate != b.IsDate || operator == "-" && a.IsDate && !b.IsDate {
+		return fromSerial(f), nil
+	}
 	return fromFloat(f), nil
 }
 
 func (s *Sheet) tableAndColIndex(colName string) (int, int, error) {
 	split := strings.Split(colName, ".")
 	if len(split) < 1 || len(split) > 3 {
-		return -1, -1, fmt.Errorf("columns must be specified as <col>, <table>.<col> or <schema>.<table>.<col>: %s", colName)
+		return -1, -1, formulaError(codeName, "columns must be specified as <col>, <table>.<col> or <schema>.<table>.<col>: %s", colName)
 	}
 	if len(split) > 1 {
 		var tableName string
@@ -227,19 +267,19 @@ func (s *Sheet) tableAndColIndex(colName string) (int, int, error) {
 			tableName = split[0] + "." + split[1]
 			colName = split[2]
 		} else {
-			tableName = "public." + split[0]
+			tableName = s.Connection().defaultSchema() + "." + split[0]
 			colName = split[1]
 		}
 		tableIndex := slices.Index(s.TableNames, tableName)
 		if tableIndex < 0 {
-			return -1, -1, errors.New("no such table " + tableName)
+			return -1, -1, formulaError(codeRef, "no such table %s", tableName)
 		}
 		for i, col := range s.OrderedCols(nil)[tableIndex] {
 			if colName == col.Name {
 				return tableIndex, i, nil
 			}
 		}
-		return -1, -1, fmt.Errorf("no column %s on table %s", split[2], tableName)
+		return -1, -1, formulaError(codeRef, "no column %s on table %s", colName, tableName)
 	} else {
 		for i, cols := range s.OrderedCols(nil) {
 			for j, col := range cols {
@@ -253,7 +293,7 @@ func (s *Sheet) tableAndColIndex(colName string) (int, int, error) {
 				return -1, i, nil
 			}
 		}
-		return -1, -1, fmt.Errorf("no such column %s", split[0])
+		return -1, -1, formulaError(codeRef, "no such column %s", split[0])
 	}
 }
 
@@ -267,11 +307,11 @@ func (s *Sheet) evalToken(token Token) (Token, error) {
 	if token.TSubType == efp.TokenSubTypeRange {
 		colName, index, err := parseColumnAndIndex(token.TValue, 0)
 		if err != nil {
-			return Token{}, err
+			return Token{}, formulaError(codeName, "%s is not a cell reference", token.TValue)
 		}
 		index-- // formulas index from 1
 		if index < 0 {
-			return Token{}, errors.New("negative indices not allowed")
+			return Token{}, formulaError(codeRef, "rows are numbered from 1")
 		}
 
 		tableIndex, colIndex, err := s.tableAndColIndex(colName)
@@ -279,17 +319,22 @@ func (s *Sheet) evalToken(token Token) (Token, error) {
 			return Token{}, err
 		}
 		if tableIndex >= 0 {
-			if index >= s.RowCount {
-				return Token{}, fmt.Errorf("row index out of range: %d", index)
+			row, err := s.RowAt(index)
+			if err != nil {
+				return Token{}, err
 			}
-			return fromString(s.Cells[tableIndex][colIndex][index].Value), nil
+			if row == nil {
+				return Token{}, formulaError(codeRef, "there is no row %d", index+1)
+			}
+			dataType := s.OrderedCols(nil)[tableIndex][colIndex].DataType
+			return s.cellToken(row[tableIndex][colIndex].Value, dataType), nil
 		} else {
-			extraCol := s.ExtraCols[colIndex]
-			if index >= len(extraCol.Cells) {
-				// Not an error to reference beyond the sheet
-				return Token{}, nil
+			// Not an error to reference beyond the sheet
+			cell, err := s.extraCellAt(colIndex, index)
+			if err != nil {
+				return Token{}, err
 			}
-			return fromString(extraCol.Cells[index].Value), nil
+			return s.cellToken(cell.Value, ""), nil
 		}
 	}
 	return Token{}, errors.New("invalid formula " + token.TValue)
@@ -324,7 +369,7 @@ var associativeFuncs = map[string]SQLAndGoFunc{
 		math.MaxFloat64,
 	},
 	"PRODUCT": {
-		"db_interface.mul",
+		"PRODUCT",
 		"numeric",
 		func(a, b float64) float64 {
 			return a * b
@@ -335,61 +380,85 @@ var associativeFuncs = map[string]SQLAndGoFunc{
 
 func (s *Sheet) evalAssociativeFunc(fDefs SQLAndGoFunc, arguments [][]Token) (Token, error) {
 	val := fDefs.initialVal
+	// Whether any of the values are dates, or anything else
+	sawDate, sawOther := false, false
 
 	for _, arg := range arguments {
 		argVal := fDefs.initialVal
 
-		if len(arg) == 1 && arg[0].TSubType == efp.TokenSubTypeRange {
+		r, isTableRange, err := s.sqlRangeArg(arg)
+		if err != nil {
+			return Token{}, err
+		}
+		if isTableRange {
+			cast, expr := fDefs.sqlCast, "sq.val"
+			if r.expr != "" {
+				cast, expr = "", r.expr
+			}
+			alias, err := s.Connection().esc().MakeCast(r.colName, cast, "val")
+			subquery, err := s.Connection().esc().MakeSelectStmt(
+				s.TableNames,
+				s.joins(),
+				[]escape.SafeSQL{alias},
+				[]escape.SafeSQL{},
+				[]escape.SafeSQL{},
+				true)
+			query := fmt.Sprintf(
+				"SELECT %s FROM (%s) sq",
+				s.Connection().aggregate(fDefs.sqlName, expr),
+				subquery)
+			log.Printf("Executing %s (%d, %d)", query, r.end-r.start+1, r.start-1)
+			var result sql.NullFloat64
+			err = s.queryRow(query, []interface{}{r.end - r.start + 1, r.start - 1}, &result)
+			if err != nil {
+				return Token{}, err
+			}
+			if result.Valid {
+				argVal = result.Float64
+				sawDate, sawOther = sawDate || r.isDate, sawOther || !r.isDate
+			}
+		} else if len(arg) == 1 && arg[0].TSubType == efp.TokenSubTypeRange {
 			colName, start, end, err := parseRange(arg[0].TValue)
 			if err != nil {
 				return Token{}, err
 			}
-			tableIndex, colIndex, err := s.tableAndColIndex(colName)
+			_, colIndex, err := s.tableAndColIndex(colName)
+			if err != nil {
+				return Token{}, err
+			}
+			cells, err := s.extraCellRange(colIndex, start, end)
 			if err != nil {
 				return Token{}, err
 			}
-			if tableIndex >= 0 {
-				alias, err := escape.MakeCast(colName, fDefs.sqlCast, "val")
-				subquery, err := escape.MakeSelectStmt(
-					s.TableNames,
-					s.joins(),
-					[]escape.SafeSQL{alias},
-					[]escape.SafeSQL{},
-					[]escape.SafeSQL{},
-					true)
-				query := fmt.Sprintf(
-					"SELECT %s(sq.val) FROM (%s) sq",
-					fDefs.sqlName,
-					subquery)
-				log.Printf("Executing %s (%d, %d)", query, end-start+1, start-1)
-				row := conn.QueryRow(query, end-start+1, start-1)
-				err = row.Scan(&argVal)
-				Check(err)
-			} else {
-				for _, cell := range s.ExtraCols[colIndex].Cells {
-					if cell.NotNull {
-						cellVal, err := strconv.ParseFloat(cell.Value, 64)
-						if err != nil {
-							return Token{}, err
-						}
-						argVal = fDefs.goFunc(argVal, cellVal)
+			for _, cell := range cells {
+				if cell.NotNull {
+					cellToken := s.cellToken(cell.Value, "")
+					if !cellToken.IsNumeric {
+						return Token{}, formulaError(codeValue, "%s in %s is not a number", cell.Value, colName)
 					}
+					argVal = fDefs.goFunc(argVal, cellToken.TFloat)
+					sawDate, sawOther = sawDate || cellToken.IsDate, sawOther || !cellToken.IsDate
 				}
 			}
 		} else {
 			argValToken, err := s.evalTokens(arg)
 			if err != nil {
-				return Token{}, nil
+				return Token{}, err
 			}
 			if !argValToken.IsNumeric {
-				return Token{}, errors.New("invalid non-numeric argument to SUM: " + argValToken.TValue)
+				return Token{}, formulaError(codeValue, "%s is not a number", argValToken.TValue)
 			}
 			argVal = argValToken.TFloat
+			sawDate, sawOther = sawDate || argValToken.IsDate, sawOther || !argValToken.IsDate
 		}
 
 		val = fDefs.goFunc(val, argVal)
 	}
 
+	// The earliest or latest of dates is a date
+	if sawDate && !sawOther && (fDefs.sqlName == "MIN" || fDefs.sqlName == "MAX") {
+		return fromSerial(val), nil
+	}
 	return fromFloat(val), nil
 }
 
@@ -420,7 +489,15 @@ func (s *Sheet) evalLogicalExpression(tokens []Token) (bool, error) {
 		}
 	}
 	if operator == "" {
-		return false, errors.New("not a logical expression")
+		// A function such as ISERROR
+		val, err := s.evalTokens(tokens)
+		if err != nil {
+			return false, err
+		}
+		if !val.IsBool {
+			return false, formulaError(codeValue, "%s is not TRUE or FALSE", val.TValue)
+		}
+		return val.TBool, nil
 	}
 
 	firstVal, err := s.evalTokens(first)
@@ -439,10 +516,10 @@ func (s *Sheet) evalLogicalExpression(tokens []Token) (bool, error) {
 	}
 
 	if !firstVal.IsNumeric {
-		return false, fmt.Errorf("non-numeric arugment to %s: %s", operator, firstVal.TValue)
+		return false, formulaError(codeValue, "non-numeric argument to %s: %s", operator, firstVal.TValue)
 	}
 	if !secondVal.IsNumeric {
-		return false, fmt.Errorf("non-numeric arugment to %s: %s", operator, secondVal.TValue)
+		return false, formulaError(codeValue, "non-numeric argument to %s: %s", operator, secondVal.TValue)
 	}
 
 	switch operator {
@@ -483,51 +560,64 @@ func (s *Sheet) evalAverage(arguments [][]Token) (Token, error) {
 	for _, arg := range arguments {
 		argVal := 0.0
 		argCount := 0
-		if len(arg) == 1 && arg[0].TSubType == efp.TokenSubTypeRange {
+		r, isTableRange, err := s.sqlRangeArg(arg)
+		if err != nil {
+			return Token{}, err
+		}
+		if isTableRange {
+			expr := "sq.val"
+			if r.expr != "" {
+				expr = r.expr
+			}
+			alias, err := s.Connection().esc().MakeCast(r.colName, "", "val")
+			if err != nil {
+				return Token{}, err
+			}
+			subquery, err := s.Connection().esc().MakeSelectStmt(
+				[]string{s.TableNames[r.table]},
+				[]fkeys.ForeignKey{},
+				[]escape.SafeSQL{alias},
+				[]escape.SafeSQL{},
+				[]escape.SafeSQL{},
+				true)
+			query := fmt.Sprintf("SELECT SUM(%s), COUNT(*) FROM (%s) sq", expr, subquery)
+			log.Printf("Executing %s (%d, %d)", query, r.end-r.start+1, r.start-1)
+			var sum sql.NullFloat64
+			err = s.queryRow(query, []interface{}{r.end - r.start + 1, r.start - 1}, &sum, &argCount)
+			if err != nil {
+				return Token{}, err
+			}
+			argVal = sum.Float64
+		} else if len(arg) == 1 && arg[0].TSubType == efp.TokenSubTypeRange {
 			colName, start, end, err := parseRange(arg[0].TValue)
 			if err != nil {
 				return Token{}, err
 			}
-			tableIndex, colIndex, err := s.tableAndColIndex(colName)
+			_, colIndex, err := s.tableAndColIndex(colName)
 			if err != nil {
 				return Token{}, err
 			}
-			if tableIndex >= 0 {
-				alias, err := escape.MakeCast(colName, "", "val")
-				if err != nil {
-					return Token{}, err
-				}
-				subquery, err := escape.MakeSelectStmt(
-					[]string{s.TableNames[tableIndex]},
-					[]fkeys.ForeignKey{},
-					[]escape.SafeSQL{alias},
-					[]escape.SafeSQL{},
-					[]escape.SafeSQL{},
-					true)
-				query := fmt.Sprintf("SELECT SUM(sq.val), COUNT(*) FROM (%s) sq", subquery)
-				log.Printf("Executing %s (%d, %d)", query, end-start+1, start-1)
-				row := conn.QueryRow(query, end-start+1, start-1)
-				err = row.Scan(&argVal, &argCount)
-				Check(err)
-			} else {
-				for _, cell := range s.ExtraCols[colIndex].Cells {
-					if cell.NotNull {
-						cellVal, err := strconv.ParseFloat(cell.Value, 64)
-						if err != nil {
-							return Token{}, err
-						}
-						argVal += cellVal
-						argCount += 1
+			cells, err := s.extraCellRange(colIndex, start, end)
+			if err != nil {
+				return Token{}, err
+			}
+			for _, cell := range cells {
+				if cell.NotNull {
+					cellToken := s.cellToken(cell.Value, "")
+					if !cellToken.IsNumeric {
+						return Token{}, formulaError(codeValue, "%s in %s is not a number", cell.Value, colName)
 					}
+					argVal += cellToken.TFloat
+					argCount += 1
 				}
 			}
 		} else {
 			argValToken, err := s.evalTokens(arg)
 			if err != nil {
-				return Token{}, nil
+				return Token{}, err
 			}
 			if !argValToken.IsNumeric {
-				return Token{}, errors.New("invalid non-numeric argument to SUM: " + argValToken.TValue)
+				return Token{}, formulaError(codeValue, "%s is not a number", argValToken.TValue)
 			}
 			argVal = argValToken.TFloat
 			argCount = 1
@@ -537,6 +627,9 @@ func (s *Sheet) evalAverage(arguments [][]Token) (Token, error) {
 		count += argCount
 	}
 
+	if count == 0 {
+		return Token{}, formulaError(codeDivZero, "there are no values to average")
+	}
 	return fromFloat(sum / float64(count)), nil
 }
 
@@ -550,7 +643,7 @@ func (s *Sheet) evalRegexMatch(arguments [][]Token) (Token, error) {
 		return Token{}, err
 	}
 	if textToken.IsNumeric {
-		return Token{}, errors.New("only text can be searched with REGEXMATCH")
+		return Token{}, formulaError(codeValue, "only text can be searched with REGEXMATCH")
 	}
 	text := textToken.Token.TValue
 
@@ -559,13 +652,13 @@ func (s *Sheet) evalRegexMatch(arguments [][]Token) (Token, error) {
 		return Token{}, err
 	}
 	if regexToken.IsNumeric {
-		return Token{}, errors.New("invalid regex")
+		return Token{}, formulaError(codeValue, "invalid regex")
 	}
 	regex := regexToken.Token.TValue
 
 	matched, err := regexp.MatchString(regex, text)
 	if err != nil {
-		return Token{}, err
+		return Token{}, formulaError(codeValue, "invalid regex: %s", err)
 	}
 
 	return fromBool(matched), nil
@@ -612,12 +705,12 @@ func (s *Sheet) evalAggIf(fName string, arguments [][]Token) (Token, error) {
 	sum := 0.0
 	count := 0
 	if conditionTableIndex >= 0 {
-		alias, err := escape.MakeCast(sumColName, "", "val")
+		alias, err := s.Connection().esc().MakeCast(sumColName, "", "val")
 		if err != nil {
 			return Token{}, err
 		}
-		filterClause, err := escape.MakeFilterClause(conditionColName, criteria)
-		subquery, err := escape.MakeSelectStmt(
+		filterClause, err := s.Connection().esc().MakeFilterClause(conditionColName, criteria)
+		subquery, err := s.Connection().esc().MakeSelectStmt(
 			s.TableNames,
 			s.joins(),
 			[]escape.SafeSQL{alias},
@@ -628,12 +721,22 @@ func (s *Sheet) evalAggIf(fName string, arguments [][]Token) (Token, error) {
 			"SELECT COALESCE(SUM(sq.val), 0), COUNT(*) FROM (%s) sq",
 			subquery)
 		log.Printf("Executing %s (%d, %d)", query, end-start+1, start-1)
-		row := conn.QueryRow(query, end-start+1, start-1)
-		err = row.Scan(&sum, &count)
-		Check(err)
+		err = s.queryRow(query, []interface{}{end - start + 1, start - 1}, &sum, &count)
+		if err != nil {
+			return Token{}, err
+		}
 	} else {
-		for i := start - 1; i < min(end, len(s.ExtraCols[conditionColIndex].Cells)); i++ {
-			conditionExpression := "=" + s.ExtraCols[conditionColIndex].Cells[i].Value + criteria
+		conditionCells, err := s.extraCellRange(conditionColIndex, start, end)
+		if err != nil {
+			return Token{}, err
+		}
+		sumCells, err := s.extraCellRange(sumColIndex, start, end)
+		if err != nil {
+			return Token{}, err
+		}
+		for k, conditionCell := range conditionCells {
+			i := start - 1 + k
+			conditionExpression := "=" + conditionCell.Value + criteria
 			conditionVal, err := s.evalLogicalExpression(parseFormula(conditionExpression))
 			if err != nil {
 				return Token{}, fmt.Errorf("error evaluating %s condition %s: %w", fName, conditionExpression, err)
@@ -645,10 +748,13 @@ func (s *Sheet) evalAggIf(fName string, arguments [][]Token) (Token, error) {
 					continue
 				}
 
-				sumString := s.ExtraCols[sumColIndex].Cells[i].Value
+				sumString := ""
+				if k < len(sumCells) {
+					sumString = sumCells[k].Value
+				}
 				sumVal, err := strconv.ParseFloat(sumString, 64)
 				if err != nil {
-					return Token{}, fmt.Errorf("non-numeric value in sum: %s (from %s%d)", sumString, sumColName, i+1)
+					return Token{}, formulaError(codeValue, "non-numeric value in sum: %s (from %s%d)", sumString, sumColName, i+1)
 				}
 				sum += sumVal
 			}
@@ -661,7 +767,7 @@ func (s *Sheet) evalAggIf(fName string, arguments [][]Token) (Token, error) {
 		return fromFloat(float64(count)), nil
 	} else if fName == "AVERAGEIF" {
 		if count == 0 {
-			return Token{}, errors.New("no rows match condition")
+			return Token{}, formulaError(codeDivZero, "no rows match condition")
 		}
 		return fromFloat(sum / float64(count)), nil
 	}
@@ -693,7 +799,27 @@ func (s *Sheet) evalFunction(fName string, arguments [][]Token) (Token, error) {
 		return s.evalAggIf(fName, arguments)
 	}
 
-	return Token{}, errors.New("unsupported function: " + fName)
+	if fName == "IFERROR" || fName == "ISERROR" {
+		return s.evalIfError(fName, arguments)
+	}
+
+	if fName == "XLOOKUP" || fName == "VLOOKUP" || fName == "MATCH" || fName == "INDEX" {
+		return s.evalLookup(fName, arguments)
+	}
+
+	if _, isTextFunc := textFuncArgCounts[fName]; isTextFunc {
+		return s.evalTextFunction(fName, arguments)
+	}
+
+	if _, isDateFunc := dateFuncArgCounts[fName]; isDateFunc {
+		return s.evalDateFunction(fName, arguments)
+	}
+
+	if fName == "NA" {
+		return Token{}, formulaError(codeNA, "no value is available")
+	}
+
+	return Token{}, formulaError(codeName, "unsupported function: %s", fName)
 }
 
 func (s *Sheet) evalTokens(tokens []Token) (Token, error) {
@@ -717,7 +843,7 @@ func (s *Sheet) evalTokens(tokens []Token) (Token, error) {
 		}
 
 		if !val.IsNumeric {
-			return Token{}, errors.New("attempting to negate non-numeric value")
+			return Token{}, formulaError(codeValue, "attempting to negate non-numeric value")
 		}
 
 		return fromFloat(-val.TFloat), nil
@@ -844,15 +970,43 @@ func (s *Sheet) evalTokens(tokens []Token) (Token, error) {
 		}
 	}
 
+	// Concatenation
+	for i, t := range tokens {
+		if t.TType == efp.TokenTypeOperatorInfix && t.TValue == "&" {
+			if i == 0 {
+				return Token{}, errors.New("cannot start expression with infix operator")
+			}
+			if len(tokens) <= i+1 {
+				return Token{}, fmt.Errorf("missing second operand for %s", t.TValue)
+			}
+			val, err := s.infixOperator(tokens[i-1], tokens[i+1], t.TValue)
+			if err != nil {
+				return Token{}, err
+			}
+
+			tokens[i-1] = val
+			for j, nt := range tokens[i+2:] {
+				tokens[i+j] = nt
+			}
+			return s.evalTokens(tokens[:len(tokens)-2])
+		}
+	}
+
 	return Token{}, errors.New("not implemented")
 }
 
+// Evaluates tokens into a cell, which holds the error they evaluate to if any.
+// Fails if the formula can't be evaluated at all, e.g. due to a syntax error.
 func (s *Sheet) evalTokensToCell(formula string, tokens []Token) (SheetCell, error) {
 	token, err := s.evalTokens(tokens)
+	var formulaErr *FormulaError
+	if errors.As(err, &formulaErr) {
+		return errorCell(formula, formulaErr), nil
+	}
 	if err != nil {
-		return SheetCell{Cell{}, formula}, err
+		return SheetCell{Cell{}, formula, nil}, err
 	}
-	return SheetCell{Cell{token.TValue, token.TValue != ""}, formula}, nil
+	return SheetCell{Cell{token.TValue, token.TValue != ""}, formula, nil}, nil
 }
 
 func (s *Sheet) evalFormula(formula string) (SheetCell, error) {
@@ -13,30 +13,33 @@
 package sheets
 
 import (
+	"math"
 	"testing"
 )
 
 func setupFormulasDB() func() {
 	Open()
 	InitSheetsTables()
-	conn.MustExec("CREATE SCHEMA IF NOT EXISTS test")
-	conn.MustExec(
+	InitPrefsTable()
+	c := defaultConnection()
+	c.dialect.createSchema(c.db, "test")
+	c.db.MustExec(
 		`CREATE TABLE IF NOT EXISTS test.foo (
 			bar INT
 			, baz FLOAT
 		)`)
-	conn.MustExec(
+	c.db.MustExec(
 		`INSERT INTO test.foo VALUES
 			(1, 2)
 			, (3, 4)
 			, (5, 6)
 		`)
 	CreateAggregates()
-	loadTables()
+	c.loadTables()
 
 	return func() {
-		conn.MustExec("DROP TABLE IF EXISTS test.foo CASCADE")
-		Check(conn.Close())
+		c.db.MustExec("DROP TABLE IF EXISTS test.foo")
+		Close()
 	}
 }
 
@@ -169,6 +172,324 @@ func TestEvalWithDB(t *testing.T) {
 	checkFormulas(t, sheet, formulasAndValues)
 }
 
+func TestLookups(t *testing.T) {
+	teardown := setupFormulasDB()
+	defer teardown()
+	c := defaultConnection()
+	c.db.MustExec(`CREATE TABLE test.tiers (bar INT, tier VARCHAR(255))`)
+	defer c.db.MustExec("DROP TABLE test.tiers")
+	c.db.MustExec(`INSERT INTO test.tiers VALUES (1, 'bronze'), (3, 'silver'), (5, 'gold')`)
+	c.loadTables()
+
+	sheet := Sheet{}
+	sheet.SetTable("test.foo")
+	sheet.LoadRows(100, 0)
+	sheet.ExtraCols = []SheetColumn{
+		{Name: "A", Cells: []SheetCell{{Cell: Cell{"x", true}}, {Cell: Cell{"y", true}}, {Cell: Cell{"z", true}}}},
+		{Name: "B", Cells: []SheetCell{{Cell: Cell{"10", true}}, {Cell: Cell{"20", true}}, {Cell: Cell{"30", true}}}},
+	}
+
+	tiers := "test.tiers.tier:test.tiers.tier"
+	formulasAndValues := map[string]string{
+		"XLOOKUP(\"y\",A1:A3,B1:B3)":                                "20",
+		"XLOOKUP(bar1*10,B1:B3,A1:A3)":                              "x",
+		"XLOOKUP(\"y\",A1:A3,bar1:bar3)":                            "3",
+		"XLOOKUP(\"w\",A1:A3,B1:B3)":                                "#N/A",
+		"XLOOKUP(\"w\",A1:A3,B1:B3,\"none\")":                       "none",
+		"VLOOKUP(\"z\",A1:B3,2,FALSE)":                              "30",
+		"VLOOKUP(\"z\",A1:B3,3)":                                    "#REF!",
+		"MATCH(\"z\",A:A,0)":                                        "3",
+		"INDEX(B1:B3,2)":                                            "20",
+		"INDEX(A1:B3,3,2)":                                          "30",
+		"IFERROR(MATCH(\"w\",A1:A3,0),0)":                           "0",
+		"XLOOKUP(3,test.tiers.bar:test.tiers.bar," + tiers + ")":    "silver",
+		"XLOOKUP(bar3,test.tiers.bar:test.tiers.bar," + tiers + ")": "gold",
+		"XLOOKUP(4,test.tiers.bar:test.tiers.bar," + tiers + ")":    "#N/A",
+		"VLOOKUP(5,test.tiers.bar:test.tiers.tier,2,FALSE)":         "gold",
+		"XLOOKUP(5,bar:bar," + tiers + ")":                          "#REF!",
+		"XLOOKUP(3,bar:bar,bar:bar)":                                "3",
+		"MATCH(5,bar:bar,0)":                                        "3",
+		"MATCH(3,bar2:bar3)":                                        "1",
+		"MATCH(3,bar:bar,1)":                                        "#VALUE!",
+		"MATCH(4,bar:bar,0)":                                        "#N/A",
+		"INDEX(bar:bar,2)":                                          "3",
+		"INDEX(bar1:baz3,3,1)":                                      "5",
+		"INDEX(bar:bar,4)":                                          "#REF!",
+	}
+	checkFormulas(t, sheet, formulasAndValues)
+}
+
+// Tables named without a schema are in the connection's default schema
+// Aggregating a column which the user's role cannot read is an error, not a crash
+func TestAggregateWithoutPrivilege(t *testing.T) {
+	defer setupFormulasDB()()
+	c := defaultConnection()
+	if _, ok := c.dialect.(postgresDialect); !ok {
+		t.Skip("Only Postgres has column privileges")
+	}
+	c.db.MustExec("CREATE ROLE rs_bar_reader")
+	defer c.db.MustExec("DROP ROLE rs_bar_reader")
+	defer c.db.MustExec("DROP OWNED BY rs_bar_reader")
+	c.db.MustExec("GRANT rs_bar_reader TO CURRENT_USER")
+	c.db.MustExec("GRANT USAGE ON SCHEMA test TO rs_bar_reader")
+	c.db.MustExec("GRANT SELECT (bar) ON test.foo TO rs_bar_reader")
+	c.UsesRoles = true
+	defer func() { c.UsesRoles = false }()
+
+	user, _ := SetPassword("ann", "secret")
+	Check(SetDBRole("ann", "rs_bar_reader"))
+	user, _ = GetUser(user.Id)
+	sheet := Sheet{}
+	sheet.SetTable("test.foo")
+	sheet = sheet.WithUser(user)
+	checkFormulas(t, sheet, map[string]string{"SUM(foo.bar1:foo.bar3)": "9"})
+	for _, formula := range []string{"SUM(foo.baz1:foo.baz3)", "AVERAGE(foo.baz1:foo.baz3)"} {
+		_, err := sheet.evalFormula("=" + formula)
+		if err == nil {
+			t.Errorf("%s: read a column without the privilege", formula)
+		}
+	}
+}
+
+func TestDefaultSchemaReferences(t *testing.T) {
+	teardown := setupFormulasDB()
+	defer teardown()
+	c := defaultConnection()
+	c.db.MustExec(`CREATE TABLE tiers (bar INT, tier VARCHAR(255))`)
+	defer c.db.MustExec("DROP TABLE tiers")
+	c.db.MustExec(`INSERT INTO tiers VALUES (1, 'bronze'), (3, 'silver'), (5, 'gold')`)
+	c.loadTables()
+
+	sheet := Sheet{}
+	sheet.SetTable(c.defaultSchema() + ".tiers")
+	sheet.LoadRows(100, 0)
+	formulasAndValues := map[string]string{
+		"tiers.tier2":                "silver",
+		"SUM(tiers.bar1:tiers.bar3)": "9",
+		"XLOOKUP(5,tiers.bar:tiers.bar,tiers.tier:tiers.tier)": "gold",
+	}
+	checkFormulas(t, sheet, formulasAndValues)
+}
+
+func TestTextFunctions(t *testing.T) {
+	sheet := Sheet{
+		ExtraCols: []SheetColumn{
+			{
+				Name: "A",
+				Cells: []SheetCell{
+					{
+						Cell:    Cell{Value: "  Ada   Lovelace ", NotNull: true},
+						Formula: "  Ada   Lovelace ",
+					},
+					{
+						Cell:    Cell{Value: "ada@example.com", NotNull: true},
+						Formula: "ada@example.com",
+					},
+					{
+						Cell:    Cell{Value: "1234.5", NotNull: true},
+						Formula: "1234.5",
+					},
+				},
+			},
+			{
+				Name: "B",
+				Cells: []SheetCell{
+					{
+						Cell:    Cell{Value: "x", NotNull: true},
+						Formula: "x",
+					},
+					{},
+					{
+						Cell:    Cell{Value: "y", NotNull: true},
+						Formula: "y",
+					},
+				},
+			},
+		},
+	}
+	formulasAndValues := map[string]string{
+		"\"foo\"&\"bar\"":                     "foobar",
+		"\"n=\"&1+2":                          "n=3",
+		"B1&A3":                               "x1234.5",
+		"(\"1\"&\"2\")*2":                     "24",
+		"CONCAT(\"a\",1,TRUE)":                "a1TRUE",
+		"CONCAT(A3:B3,\"!\")":                 "1234.5y!",
+		"CONCAT(B1:B3)":                       "xy",
+		"LEFT(\"héllo\",2)":                   "hé",
+		"LEFT(\"foo\")":                       "f",
+		"LEFT(\"foo\",10)":                    "foo",
+		"RIGHT(A2,11)":                        "example.com",
+		"MID(\"foobar\",3,2)":                 "ob",
+		"MID(\"foobar\",5,10)":                "ar",
+		"MID(\"foobar\",10,1)":                "",
+		"LEN(A2)":                             "15",
+		"LEN(\"\")":                           "0",
+		"UPPER(\"foo\")":                      "FOO",
+		"LOWER(\"FoO\")":                      "foo",
+		"TRIM(A1)":                            "Ada Lovelace",
+		"LEN(TRIM(A1))":                       "12",
+		"SUBSTITUTE(\"a-b-c\",\"-\",\"+\")":   "a+b+c",
+		"SUBSTITUTE(\"a-b-c\",\"-\",\"+\",2)": "a-b+c",
+		"SUBSTITUTE(\"a-b-c\",\"-\",\"+\",3)": "a-b-c",
+		"TEXT(A3,\"0.00\")":                   "1234.50",
+		"TEXT(A3,\"#,##0\")":                  "1,235",
+		"TEXT(A3,\"$#,##0.00\")":              "$1,234.50",
+		"TEXT(0.256,\"0.0%\")":                "25.6%",
+		"TEXT(5,\"000\")":                     "005",
+		"TEXT(-1.5,\"0.##\")":                 "-1.5",
+		"SPLIT(\"a,b,c\",\",\")":              "a",
+		"SPLIT(\"a,b,c\",\",\",3)":            "c",
+		"REGEXEXTRACT(A2,\"@(.*)\")":          "example.com",
+		"REGEXEXTRACT(A2,\"[a-z]+\")":         "ada",
+		"REGEXREPLACE(A2,\"@.*\",\"\")":       "ada",
+		"REGEXREPLACE(\"2024-01-31\",\"(\\d+)-(\\d+)-(\\d+)\",\"$3/$2/$1\")": "31/01/2024",
+		"UPPER(LEFT(TRIM(A1),1))&LOWER(MID(TRIM(A1),2,2))":                   "Ada",
+		"IF(LEFT(A2,3)=\"ada\",1,2)":                                         "1",
+		"LEFT(\"foo\",-1)":                                                   "#VALUE!",
+		"TEXT(\"foo\",\"0\")":                                                "#VALUE!",
+		"SPLIT(\"a,b\",\",\",3)":                                             "#REF!",
+		"REGEXEXTRACT(\"foo\",\"\\d\")":                                      "#N/A",
+		"REGEXREPLACE(\"foo\",\"(\",\"\")":                                   "#VALUE!",
+		"CONCAT(A:A)":                                                        "#VALUE!",
+	}
+	checkFormulas(t, sheet, formulasAndValues)
+}
+
+func TestDates(t *testing.T) {
+	sheet := Sheet{
+		Timezone: "Asia/Tokyo",
+		ExtraCols: []SheetColumn{
+			{Name: "A", Cells: []SheetCell{{Cell: Cell{"2024-02-29", true}}, {Cell: Cell{"2023-12-01", true}}}},
+			{Name: "B", Cells: []SheetCell{{Cell: Cell{"2024-01-31 20:00:00+00", true}}}},
+		},
+	}
+	formulasAndValues := map[string]string{
+		"DATE(2024,1,31)":               "2024-01-31",
+		"DATE(2024,1,31)+1":             "2024-02-01",
+		"1+DATE(2024,1,31)":             "2024-02-01",
+		"DATE(2024,3,1)-1":              "2024-02-29",
+		"DATE(2024,3,1)-DATE(2024,1,1)": "60",
+		"DATE(2024,13,1)":               "2025-01-01",
+		"DATE(124,1,0)":                 "2023-12-31",
+		"YEAR(A1)":                      "2024",
+		"MONTH(A1)":                     "2",
+		"DAY(A1)":                       "29",
+		"YEAR(\"2024-05-06\")":          "2024",
+		"A1+1":                          "2024-03-01",
+		"IF(A1>DATE(2024,1,1),1,0)":     "1",
+		"MAX(A1:A2)":                    "2024-02-29",
+		"MIN(A1:A2,DATE(2024,1,1))":     "2023-12-01",
+		"B1":                            "2024-02-01 05:00:00",
+		"DAY(B1)":                       "1",
+		"B1+0.5":                        "2024-02-01 17:00:00",
+		"WEEKDAY(DATE(2024,1,31))":      "4",
+		"WEEKDAY(DATE(2024,1,31),2)":    "3",
+		"WEEKDAY(DATE(2024,1,31),3)":    "2",
+		"DATEDIF(DATE(2020,5,15),DATE(2024,2,10),\"Y\")":  "3",
+		"DATEDIF(DATE(2020,5,15),DATE(2024,2,10),\"M\")":  "44",
+		"DATEDIF(DATE(2020,5,15),DATE(2024,2,10),\"D\")":  "1366",
+		"DATEDIF(DATE(2020,5,15),DATE(2024,2,10),\"YM\")": "8",
+		"DATEDIF(DATE(2020,5,15),DATE(2024,2,10),\"MD\")": "26",
+		"DATEDIF(DATE(2020,5,15),DATE(2024,2,10),\"YD\")": "271",
+		"EDATE(DATE(2024,1,31),1)":                        "2024-02-29",
+		"EDATE(DATE(2024,3,31),-1)":                       "2024-02-29",
+		"EOMONTH(DATE(2024,1,15),1)":                      "2024-02-29",
+		"EOMONTH(DATE(2023,12,5),0)":                      "2023-12-31",
+		"TEXT(DATE(2024,2,5),\"dd/mm/yyyy\")":             "05/02/2024",
+		"TEXT(DATE(2024,2,5),\"d mmmm yyyy\")":            "5 February 2024",
+		"TEXT(DATE(2024,2,5),\"ddd mmm d\")":              "Mon Feb 5",
+		"TEXT(B1,\"h:mm AM/PM\")":                         "5:00 AM",
+		"TEXT(B1,\"hh:mm:ss\")":                           "05:00:00",
+		"IF(NOW()-TODAY()<1,1,0)":                         "1",
+		"IF(TODAY()<=NOW(),1,0)":                          "1",
+		"YEAR(\"foo\")":                                   "#VALUE!",
+		"WEEKDAY(A1,4)":                                   "#NUM!",
+		"DATEDIF(A1,A2,\"D\")":                            "#NUM!",
+	}
+	checkFormulas(t, sheet, formulasAndValues)
+}
+
+func TestDatesWithDB(t *testing.T) {
+	teardown := setupFormulasDB()
+	defer teardown()
+	c := defaultConnection()
+	c.db.MustExec(`CREATE TABLE test.events (n INT, happened_at TIMESTAMP, due DATE)`)
+	defer c.db.MustExec("DROP TABLE test.events")
+	c.db.MustExec(`INSERT INTO test.events VALUES
+		(1, '2024-01-31 20:00:00', '2024-02-29')
+		, (2, '2023-06-15 08:30:00', '2023-12-01')
+		, (3, '2024-03-01 00:00:00', '2024-03-31')`)
+	c.loadTables()
+
+	sheet := Sheet{}
+	sheet.SetTable("test.events")
+	sheet.LoadRows(100, 0)
+
+	formulasAndValues := map[string]string{
+		"happened_at1":                 "2024-01-31 20:00:00",
+		"due1+1":                       "2024-03-01",
+		"YEAR(due2)":                   "2023",
+		"DATEDIF(due2,due1,\"M\")":     "2",
+		"MIN(happened_at:happened_at)": "2023-06-15 08:30:00",
+		"MAX(due:due)":                 "2024-03-31",
+		"MAX(due:due)-MIN(due:due)":    "121",
+		"SUM(n:n)":                     "6",
+		"SUM(YEAR(due:due))":           "6071",
+		"MAX(MONTH(due:due))":          "12",
+		"MIN(DAY(due1:due2))":          "1",
+		"SUM(WEEKDAY(due:due))":        "12",
+		"AVERAGE(DAY(due2:due3))":      "16",
+		"SUM(YEAR(n:n))":               "#VALUE!",
+	}
+	checkFormulas(t, sheet, formulasAndValues)
+}
+
+func TestSetTimezone(t *testing.T) {
+	teardown := setupFormulasDB()
+	defer teardown()
+
+	sheet := Sheet{}
+	sheet.SetTable("test.foo")
+	if sheet.SetTimezone("Mars/Olympus_Mons") == nil {
+		t.Error("Expected an error for an unknown time zone")
+	}
+	err := sheet.SetTimezone("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	LoadSheets()
+	if loaded, _ := Store.Get(sheet.Id); loaded.Timezone != "Europe/Paris" || loaded.timezoneName() != "Europe/Paris" {
+		t.Errorf("Time zone not saved: %s", loaded.Timezone)
+	}
+	if (Sheet{}).timezoneName() != "UTC" {
+		t.Errorf("Sheets should default to UTC, not %s", (Sheet{}).timezoneName())
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	intervals := map[string]float64{
+		"3 days":                        3,
+		"1 day":                         1,
+		"12:00:00":                      0.5,
+		"-1 days +06:00:00":             -0.75,
+		"-00:30:00":                     -1.0 / 48,
+		"00:00:01.5":                    1.5 / 86400,
+		"1 mon":                         30,
+		"1 year 2 mons 3 days 04:00:00": 365.25 + 60 + 3 + 4.0/24,
+	}
+	for interval, expected := range intervals {
+		actual, ok := parseInterval(interval)
+		if !ok || math.Abs(actual-expected) > 1e-9 {
+			t.Errorf("%s: %f != %f", interval, actual, expected)
+		}
+	}
+	for _, invalid := range []string{"", "foo", "3", "3 weeks"} {
+		if _, ok := parseInterval(invalid); ok {
+			t.Errorf("Unexpected success: %s", invalid)
+		}
+	}
+}
+
 func TestRoundTripSerialization(t *testing.T) {
 	formulas := []string{
 		"=SUM(A:A)",
@@ -191,3 +512,54 @@ func TestParsingErrors(t *testing.T) {
 	}
 	checkFormulaErrors(t, Sheet{}, formulasAndErrors)
 }
+
+func TestFormulaErrors(t *testing.T) {
+	sheet := Sheet{
+		ExtraCols: []SheetColumn{
+			{
+				Name: "A",
+				Cells: []SheetCell{
+					{
+						Cell:    Cell{Value: "1", NotNull: true},
+						Formula: "1",
+					},
+					errorCell("=1/0", formulaError(codeDivZero, "division by zero")),
+					{
+						Cell:    Cell{Value: "foo", NotNull: true},
+						Formula: "foo",
+					},
+				},
+			},
+		},
+	}
+	formulasAndValues := map[string]string{
+		"1/0":                   "#DIV/0!",
+		"A2":                    "#DIV/0!",
+		"A2+1":                  "#DIV/0!",
+		"SUM(A1:A3)":            "#DIV/0!",
+		"A3*2":                  "#VALUE!",
+		"-A3":                   "#VALUE!",
+		"AVERAGE(A4:A5)":        "#DIV/0!",
+		"B1":                    "#REF!",
+		"A0":                    "#REF!",
+		"FOO(1)":                "#NAME?",
+		"NA()":                  "#N/A",
+		"IF(A3>1,1,2)":          "#VALUE!",
+		"IFERROR(A2,0)":         "0",
+		"IFERROR(A1+1,0)":       "2",
+		"IFERROR(1/0,NA())":     "#N/A",
+		"ISERROR(A2)":           "true",
+		"ISERROR(A1)":           "false",
+		"IF(ISERROR(A2),3,4)":   "3",
+		"SUM(IFERROR(A2,5),A1)": "6",
+	}
+	checkFormulas(t, sheet, formulasAndValues)
+
+	cell, err := sheet.evalFormula("=A2*2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cell.Error == nil || cell.Error.Cause != "division by zero" {
+		t.Errorf("Unexpected error for A2*2: %v", cell.Error)
+	}
+}
@@ -0,0 +1,200 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Number of changes a session can undo
+const historyLength = 100
+
+// The rows inserted or updated together through a sheet
+type Change struct {
+	SheetId int
+	// AuditInsert or AuditUpdate
+	Action string
+	rows   []rowChange
+}
+
+// The changes made in one session, which can be undone and redone, oldest first
+type History struct {
+	lock       sync.Mutex
+	undo, redo []Change
+}
+
+// Returns the sheet with changes to it recorded in history, which can be nil
+func (s Sheet) WithHistory(history *History) Sheet {
+	s.history = history
+	return s
+}
+
+func (h *History) record(change Change) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.undo = append(h.undo, change)
+	if len(h.undo) > historyLength {
+		h.undo = h.undo[1:]
+	}
+	// A new change can't be followed by redoing one undone before it
+	h.redo = slices.DeleteFunc(h.redo, func(undone Change) bool {
+		return undone.SheetId == change.SheetId
+	})
+}
+
+// Reverts the latest change the session made to the sheet
+func (sheet *Sheet) Undo() error {
+	return sheet.replay(true)
+}
+
+// Makes the latest change the session undid on the sheet again
+func (sheet *Sheet) Redo() error {
+	return sheet.replay(false)
+}
+
+func (sheet *Sheet) replay(undo bool) error {
+	verb := "redo"
+	if undo {
+		verb = "undo"
+	}
+	if sheet.Drafting() {
+		return fmt.Errorf("Cannot %s in draft mode, discard the draft instead", verb)
+	}
+	h := sheet.history
+	if h == nil {
+		return fmt.Errorf("Nothing to %s", verb)
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	from, to := &h.redo, &h.undo
+	if undo {
+		from, to = to, from
+	}
+	i := len(*from) - 1
+	for i >= 0 && (*from)[i].SheetId != sheet.Id {
+		i--
+	}
+	if i < 0 {
+		return fmt.Errorf("Nothing to %s", verb)
+	}
+	change := (*from)[i]
+	// A change which can't be replayed is dropped, so that earlier ones still can be
+	*from = slices.Delete(*from, i, i+1)
+	err := sheet.applyChange(change, undo)
+	if err != nil {
+		return fmt.Errorf("Cannot %s: %w", verb, err)
+	}
+	*to = append(*to, change)
+	return nil
+}
+
+// Checks that the row still has the values expected of it
+func (table *Table) checkUnchanged(tx *sqlx.Tx, row rowChange, expected []Cell) error {
+	current, err := table.selectRow(tx, row.ColNames, row.Key)
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(current, expected) {
+		return fmt.Errorf("Row %s of %s has since been changed by someone else", primaryKeyJSON(row.Key), table.FullName())
+	}
+	return nil
+}
+
+func (row rowChange) values(cells []Cell) map[string]string {
+	values := make(map[string]string)
+	for i, colName := range row.ColNames {
+		values[colName] = cells[i].Value
+	}
+	return values
+}
+
+// Applies the inverse of change if undo is set, or change itself, in one transaction
+func (sheet *Sheet) applyChange(change Change, undo bool) error {
+	log.Printf("Replaying %+v, undo: %t", change, undo)
+	c := sheet.Connection()
+	rows := slices.Clone(change.rows)
+	tableNames := []string{}
+	for _, row := range rows {
+		if len(row.Key) == 0 {
+			return errors.New("Cannot change rows of a table without primary key: " + row.TableName)
+		}
+		if c.Table(row.TableName) == nil {
+			return fmt.Errorf("Table %s no longer exists", row.TableName)
+		}
+		tableNames = append(tableNames, row.TableName)
+	}
+	if undo {
+		// Rows referencing others were inserted after them
+		slices.Reverse(rows)
+	}
+	tx, err := c.beginWrite(tableNames...)
+	if err != nil {
+		return err
+	}
+	err = sheet.setRole(tx)
+	if err != nil {
+		return err
+	}
+
+	action := change.Action
+	applied := make([]rowChange, len(rows))
+	for i, row := range rows {
+		table := c.Table(row.TableName)
+		table.load(tx)
+		switch {
+		case change.Action == AuditUpdate && undo:
+			err = table.checkUnchanged(tx, row, row.NewValues)
+			if err == nil {
+				err = table.updateRow(tx, row.values(row.OldValues), row.Key, nil)
+			}
+			applied[i] = rowChange{row.TableName, row.Key, row.ColNames, row.NewValues, row.OldValues}
+		case change.Action == AuditUpdate:
+			err = table.checkUnchanged(tx, row, row.OldValues)
+			if err == nil {
+				err = table.updateRow(tx, row.values(row.NewValues), row.Key, nil)
+			}
+			applied[i] = row
+		case undo:
+			action = AuditDelete
+			err = table.checkUnchanged(tx, row, row.NewValues)
+			if err == nil {
+				err = table.deleteRow(tx, row.Key)
+			}
+			applied[i] = rowChange{row.TableName, row.Key, row.ColNames, row.NewValues, nil}
+		default:
+			values := row.values(row.NewValues)
+			for colName, value := range row.Key {
+				values[colName] = value
+			}
+			_, err = sheet.InsertRow(tx, row.TableName, values, nil)
+			if err != nil {
+				err = fmt.Errorf("Row %s of %s has since been inserted by someone else: %w", primaryKeyJSON(row.Key), row.TableName, err)
+			}
+			applied[i] = row
+		}
+		if err != nil {
+			Check(tx.Rollback())
+			return err
+		}
+	}
+	return sheet.commitAudited(tx, sheet.auditEntries(action, applied))
+}
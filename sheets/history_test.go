@@ -0,0 +1,105 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"testing"
+)
+
+func TestUndoRedo(t *testing.T) {
+	c := setupTestDB(t, "items (id SERIAL PRIMARY KEY, name VARCHAR(255), price INTEGER)")
+
+	sheet := Sheet{}
+	sheet.SetTable("test.items")
+	sheet.LoadPrefs()
+	sheet = sheet.WithHistory(&History{})
+	row := func() (string, string) {
+		var name, price *string
+		err := c.db.QueryRow("SELECT name, price FROM test.items WHERE id = 1").Scan(&name, &price)
+		if err != nil {
+			return "missing", "missing"
+		}
+		if price == nil {
+			return *name, "NULL"
+		}
+		return *name, *price
+	}
+	update := func(values map[string]string) {
+		Check(sheet.UpdateRows(map[string]map[string]string{
+			"test.items": values,
+		}, map[string]map[string]string{
+			"test.items": {"id": "1"},
+		}))
+	}
+
+	if sheet.Undo() == nil {
+		t.Error("Undid a change that was never made")
+	}
+	Check(sheet.InsertMultipleRows(map[string]map[string]string{
+		"test.items": {"name": "pen", "price": ""},
+	}, make(map[string]map[string]string)))
+	update(map[string]string{"name": "pencil", "price": "2"})
+	update(map[string]string{"price": "3"})
+
+	Check(sheet.Undo())
+	if name, price := row(); name != "pencil" || price != "2" {
+		t.Errorf("Expected pencil for 2 after undoing, got %s for %s", name, price)
+	}
+	Check(sheet.Undo())
+	if name, price := row(); name != "pen" || price != "NULL" {
+		t.Errorf("Expected pen for NULL after undoing, got %s for %s", name, price)
+	}
+	Check(sheet.Undo())
+	if name, _ := row(); name != "missing" {
+		t.Errorf("Inserted row not deleted: %s", name)
+	}
+	if sheet.Undo() == nil {
+		t.Error("Undid more changes than were made")
+	}
+
+	Check(sheet.Redo())
+	Check(sheet.Redo())
+	if name, price := row(); name != "pencil" || price != "2" {
+		t.Errorf("Expected pencil for 2 after redoing, got %s for %s", name, price)
+	}
+
+	// Someone else's change isn't overwritten
+	c.db.MustExec("UPDATE test.items SET price = 4 WHERE id = 1")
+	err := sheet.Undo()
+	if err == nil {
+		t.Error("Undid a change to a row which has since been changed")
+	}
+	if name, price := row(); name != "pencil" || price != "4" {
+		t.Errorf("Expected pencil for 4 after a failed undo, got %s for %s", name, price)
+	}
+
+	// A new change can't be followed by a redo
+	update(map[string]string{"price": "5"})
+	if sheet.Redo() == nil {
+		t.Error("Redid a change undone before a new one")
+	}
+	other := Sheet{}
+	other.SetTable("test.items")
+	other = other.WithHistory(sheet.history)
+	if other.Undo() == nil {
+		t.Error("Undid a change made on another sheet")
+	}
+
+	deleted := false
+	for _, entry := range LoadAuditLog(AuditFilter{}, 100, 0) {
+		deleted = deleted || entry.Action == AuditDelete
+	}
+	if !deleted {
+		t.Error("Undoing the insert not in the audit log")
+	}
+}
@@ -0,0 +1,380 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/exp/maps"
+)
+
+type ImportFile struct {
+	Name    string
+	Headers []string
+	Rows    [][]string
+}
+
+type ImportResult struct {
+	Inserted  int
+	Committed bool
+	// Keyed by the index of the row in ImportFile.Rows
+	Errors map[int]error
+}
+
+// An uploaded file kept while the user maps its columns
+type pendingImport struct {
+	file    ImportFile
+	savedAt time.Time
+}
+
+// How long an uploaded file is kept for if its import is never finished
+const pendingImportAge = 30 * time.Minute
+
+var pendingImports = make(map[string]pendingImport)
+var pendingImportsLock sync.Mutex
+
+var booleanLiterals = []string{
+	"t", "true", "y", "yes", "on", "1",
+	"f", "false", "n", "no", "off", "0",
+}
+
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func ParseImportFile(filename string, r io.Reader) (ImportFile, error) {
+	file := ImportFile{Name: filename}
+	var records [][]string
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv", ".tsv", ".txt":
+		reader := csv.NewReader(r)
+		if strings.ToLower(filepath.Ext(filename)) == ".tsv" {
+			reader.Comma = '\t'
+		}
+		reader.FieldsPerRecord = -1
+		var err error
+		records, err = reader.ReadAll()
+		if err != nil {
+			return file, err
+		}
+	case ".xlsx":
+		workbook, err := excelize.OpenReader(r)
+		if err != nil {
+			return file, err
+		}
+		defer workbook.Close()
+		records, err = workbook.GetRows(workbook.GetSheetName(workbook.GetActiveSheetIndex()))
+		if err != nil {
+			return file, err
+		}
+	default:
+		return file, fmt.Errorf("unsupported file type: %s (expected .csv, .tsv or .xlsx)", filename)
+	}
+
+	if len(records) == 0 {
+		return file, errors.New("file is empty")
+	}
+	file.Headers = records[0]
+	if len(file.Headers) > 0 {
+		file.Headers[0] = strings.TrimPrefix(file.Headers[0], "\ufeff")
+	}
+	for _, record := range records[1:] {
+		row := make([]string, len(file.Headers))
+		copy(row, record)
+		file.Rows = append(file.Rows, row)
+	}
+	log.Printf("Parsed %d rows with headers %v from %s", len(file.Rows), file.Headers, filename)
+	return file, nil
+}
+
+// Keeps an uploaded file around while the user maps its columns
+func SavePendingImport(file ImportFile) string {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	Check(err)
+	id := hex.EncodeToString(buf)
+
+	pendingImportsLock.Lock()
+	defer pendingImportsLock.Unlock()
+	evictPendingImports(time.Now())
+	pendingImports[id] = pendingImport{file, time.Now()}
+	return id
+}
+
+func GetPendingImport(id string) (ImportFile, bool) {
+	pendingImportsLock.Lock()
+	defer pendingImportsLock.Unlock()
+	evictPendingImports(time.Now())
+	pending, ok := pendingImports[id]
+	return pending.file, ok
+}
+
+// Forgets the files uploaded more than pendingImportAge before now. The caller
+// holds pendingImportsLock.
+func evictPendingImports(now time.Time) {
+	for id, pending := range pendingImports {
+		if now.Sub(pending.savedAt) > pendingImportAge {
+			delete(pendingImports, id)
+		}
+	}
+}
+
+func DeletePendingImport(id string) {
+	pendingImportsLock.Lock()
+	defer pendingImportsLock.Unlock()
+	delete(pendingImports, id)
+}
+
+// Returns every column that an imported value can be written to, as <schema>.<table>.<col>,
+// including columns hidden in the sheet
+func (sheet *Sheet) ImportTargets() []string {
+	targets := []string{}
+	for _, tableName := range sheet.TableNames {
+		if !sheet.Connection().Writable(tableName) {
+			continue
+		}
+		table := sheet.Connection().Table(tableName)
+		if table == nil {
+			// Dropped since the sheet was opened
+			continue
+		}
+		table.load(nil)
+		cols := maps.Values(table.Cols)
+		sort.Slice(cols, func(i, j int) bool {
+			return cols[i].Index < cols[j].Index
+		})
+		for _, col := range cols {
+			targets = append(targets, tableName+"."+col.Name)
+		}
+	}
+	return targets
+}
+
+// Matches headers to columns using the same naming rules as formulas:
+// <col>, <table>.<col> or <schema>.<table>.<col>
+func (sheet *Sheet) DefaultImportMapping(headers []string) map[int]string {
+	targets := sheet.ImportTargets()
+	defaultSchema := sheet.Connection().defaultSchema()
+	mapping := make(map[int]string)
+	for i, header := range headers {
+		header = strings.TrimSpace(header)
+		// A header naming the column exactly, or without the default schema,
+		// wins over one which only ends the same
+		k := slices.IndexFunc(targets, func(target string) bool {
+			return target == header || target == defaultSchema+"."+header
+		})
+		if k < 0 {
+			k = slices.IndexFunc(targets, func(target string) bool {
+				return strings.HasSuffix(target, "."+header)
+			})
+		}
+		if k >= 0 {
+			mapping[i] = targets[k]
+		}
+	}
+	return mapping
+}
+
+func (col Column) validate(value string) error {
+	if value == "" {
+		return nil
+	}
+	var err error
+	switch col.DataType {
+	case "smallint", "integer", "bigint":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "numeric", "real", "double precision":
+		_, err = strconv.ParseFloat(value, 64)
+	case "boolean":
+		if !slices.Contains(booleanLiterals, strings.ToLower(value)) {
+			err = errors.New("not a boolean")
+		}
+	case "date", "timestamp without time zone", "timestamp with time zone":
+		err = errors.New("not a date")
+		for _, layout := range timestampLayouts {
+			_, parseErr := time.Parse(layout, value)
+			if parseErr == nil {
+				err = nil
+				break
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s for %s: %q", col.DataType, col.Name, value)
+	}
+	return nil
+}
+
+// Columns which insertMultipleRows fills in from the rows it inserts in other tables
+func linkedCols(requiredCols map[string]map[string]map[string]string) map[string]map[string]bool {
+	linked := make(map[string]map[string]bool)
+	for _, colMapping := range requiredCols {
+		for _, tableMapping := range colMapping {
+			for tableName, colName := range tableMapping {
+				addToNestedMap(linked, tableName, colName, true)
+			}
+		}
+	}
+	return linked
+}
+
+func (sheet *Sheet) importRowValues(headers []string, row []string, mapping map[int]string, linked map[string]map[string]bool) (map[string]map[string]string, error) {
+	values := make(map[string]map[string]string)
+	for i, target := range mapping {
+		if target == "" || i >= len(row) {
+			continue
+		}
+		lastDot := strings.LastIndex(target, ".")
+		tableName, colName := target[:lastDot], target[lastDot+1:]
+		table := sheet.Connection().Table(tableName)
+		if table == nil {
+			return nil, fmt.Errorf("no such table %s", tableName)
+		}
+		col, ok := table.Cols[colName]
+		if !ok {
+			return nil, fmt.Errorf("no column %s on table %s", colName, tableName)
+		}
+		err := col.validate(row[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", headers[i], err)
+		}
+		addToNestedMap(values, tableName, colName, row[i])
+	}
+
+	for tableName, tableValues := range values {
+		if isEmpty(tableValues) {
+			continue
+		}
+		for _, col := range sheet.Connection().Tables()[tableName].Cols {
+			if col.IsNullable || col.HasDefault || linked[tableName][col.Name] {
+				continue
+			}
+			if tableValues[col.Name] == "" {
+				return nil, fmt.Errorf("missing value for required column %s.%s", tableName, col.Name)
+			}
+		}
+	}
+	return values, nil
+}
+
+// Inserts every row of file in a single transaction, which is rolled back if
+// dryRun is set or any row fails.
+func (sheet *Sheet) Import(file ImportFile, mapping map[int]string, dryRun bool) (ImportResult, error) {
+	result := ImportResult{Errors: make(map[int]error)}
+	if len(mapping) == 0 {
+		return result, errors.New("no columns are mapped")
+	}
+
+	targets := sheet.ImportTargets()
+	tableNames := []string{}
+	for _, target := range mapping {
+		if target == "" {
+			continue
+		}
+		if !slices.Contains(targets, target) {
+			return result, fmt.Errorf("cannot import into %s", target)
+		}
+		tableName := target[:strings.LastIndex(target, ".")]
+		if !slices.Contains(tableNames, tableName) {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	tx, err := sheet.Connection().beginWrite(tableNames...)
+	if err != nil {
+		return result, err
+	}
+	err = sheet.setRole(tx)
+	if err != nil {
+		return result, err
+	}
+	finished := false
+	defer func() {
+		if !finished {
+			Check(tx.Rollback())
+		}
+	}()
+	sheet.OrderedCols(tx)
+	_, requiredCols, err := sheet.sortedTablesAndReqCols(tx)
+	if err != nil {
+		return result, err
+	}
+	linked := linkedCols(requiredCols)
+	inserted := []rowChange{}
+
+	for i, row := range file.Rows {
+		values, err := sheet.importRowValues(file.Headers, row, mapping, linked)
+		if err != nil {
+			result.Errors[i] = err
+			continue
+		}
+		_, err = tx.Exec("SAVEPOINT import_row")
+		if err != nil {
+			return result, err
+		}
+		rows, err := sheet.insertMultipleRows(tx, values, make(map[string]map[string]string))
+		if err != nil {
+			result.Errors[i] = err
+			_, err = tx.Exec("ROLLBACK TO SAVEPOINT import_row")
+		} else {
+			result.Inserted++
+			inserted = append(inserted, rows...)
+			_, err = tx.Exec("RELEASE SAVEPOINT import_row")
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	log.Printf("Import of %s: %d rows inserted, %d errors", file.Name, result.Inserted, len(result.Errors))
+
+	if dryRun || len(result.Errors) > 0 {
+		return result, nil
+	}
+	// Committing finishes tx even if it fails
+	finished = true
+	err = sheet.commitAudited(tx, sheet.auditEntries(AuditInsert, inserted))
+	if err != nil {
+		return result, err
+	}
+	result.Committed = true
+	return result, nil
+}
+
+// Returns the indices of up to limit rows which failed, in file order
+func (result ImportResult) ErrorRows(limit int) []int {
+	rows := maps.Keys(result.Errors)
+	slices.Sort(rows)
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows
+}
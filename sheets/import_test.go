@@ -0,0 +1,179 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseImportFile(t *testing.T) {
+	file, err := ParseImportFile("orders.csv", strings.NewReader("\ufeffname,total\nbob,1\nalice\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Headers) != 2 || file.Headers[0] != "name" {
+		t.Errorf("Unexpected headers: %v", file.Headers)
+	}
+	if len(file.Rows) != 2 || file.Rows[1][0] != "alice" || file.Rows[1][1] != "" {
+		t.Errorf("Unexpected rows: %v", file.Rows)
+	}
+
+	xlsx := writeExport(t, "xlsx")
+	file, err = ParseImportFile("orders.xlsx", bytes.NewReader(xlsx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Rows) != 2 || file.Rows[0][0] != "bob, jr." || file.Rows[0][1] != "12.5" {
+		t.Errorf("Unexpected rows: %v", file.Rows)
+	}
+
+	_, err = ParseImportFile("orders.pdf", strings.NewReader(""))
+	if err == nil {
+		t.Error("Unexpected success parsing pdf")
+	}
+}
+
+func TestValidateImportValues(t *testing.T) {
+	valid := map[string][]string{
+		"integer":                  {"1", "-20", ""},
+		"numeric":                  {"1.5", "2"},
+		"boolean":                  {"true", "F", "yes"},
+		"date":                     {"2023-11-20"},
+		"timestamp with time zone": {"2023-11-20 10:00:00+00", "2023-11-20T10:00:00Z"},
+		"character varying":        {"anything"},
+	}
+	for dataType, values := range valid {
+		for _, value := range values {
+			err := Column{Name: "col", DataType: dataType}.validate(value)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	invalid := map[string][]string{
+		"integer": {"1.5", "one"},
+		"numeric": {"1,5"},
+		"boolean": {"maybe"},
+		"date":    {"20/11/2023"},
+	}
+	for dataType, values := range invalid {
+		for _, value := range values {
+			err := Column{Name: "col", DataType: dataType}.validate(value)
+			if err == nil {
+				t.Errorf("%s should not be a valid %s", value, dataType)
+			}
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	SetupTablesDB()
+	defer teardownTablesDB()
+
+	customers := defaultConnection().Table("test.customers")
+	customers.load(nil)
+	orders := defaultConnection().Table("test.orders")
+	orders.load(nil)
+	sheet := Sheet{}
+	sheet.SetTable(customers.FullName())
+	for oid, fkey := range orders.Fkeys {
+		if fkey.TargetTableName == customers.FullName() {
+			err := sheet.SetJoin(0, oid)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	file, err := ParseImportFile("import.csv", strings.NewReader(
+		"name,total,status\nalice,10,unfilled\nbob,,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping := sheet.DefaultImportMapping(file.Headers)
+	if mapping[0] != "test.customers.name" || mapping[1] != "test.orders.total" {
+		t.Fatalf("Unexpected mapping: %v", mapping)
+	}
+
+	for _, target := range []string{"name", "test.products.name", "test.customers.missing"} {
+		_, err := sheet.Import(file, map[int]string{0: target}, true)
+		if err == nil {
+			t.Errorf("Imported into %s, which the sheet cannot write", target)
+		}
+	}
+	dropped := Sheet{TableNames: []string{"test.dropped"}}
+	if targets := dropped.ImportTargets(); len(targets) != 0 {
+		t.Errorf("Unexpected targets in a dropped table: %v", targets)
+	}
+
+	result, err := sheet.Import(file, mapping, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inserted != 2 || len(result.Errors) != 0 || result.Committed {
+		t.Fatalf("Unexpected dry run result: %+v", result)
+	}
+	sheet.LoadRows(100, 0)
+	if sheet.RowCount != 0 {
+		t.Fatalf("Dry run inserted %d rows", sheet.RowCount)
+	}
+
+	result, err = sheet.Import(file, mapping, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Committed {
+		t.Fatalf("Import not committed: %+v", result)
+	}
+	sheet.LoadRows(100, 0)
+	if sheet.RowCount != 2 {
+		t.Fatalf("Unexpected number of rows after import: %d", sheet.RowCount)
+	}
+
+	// One invalid row rolls back the whole import
+	file, err = ParseImportFile("import.csv", strings.NewReader("name,total\ncharles,1\ndevon,abc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = sheet.Import(file, sheet.DefaultImportMapping(file.Headers), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Committed || result.Errors[1] == nil {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+	sheet.LoadRows(100, 0)
+	if sheet.RowCount != 2 {
+		t.Fatalf("Failed import changed the number of rows to %d", sheet.RowCount)
+	}
+}
+
+func TestPendingImportsExpire(t *testing.T) {
+	file := ImportFile{Name: "import.csv", Headers: []string{"name"}}
+	id := SavePendingImport(file)
+	defer DeletePendingImport(id)
+	if pending, ok := GetPendingImport(id); !ok || pending.Name != file.Name {
+		t.Fatalf("Pending import %s not found", id)
+	}
+
+	pendingImportsLock.Lock()
+	pendingImports[id] = pendingImport{file, time.Now().Add(-pendingImportAge - time.Minute)}
+	pendingImportsLock.Unlock()
+	if _, ok := GetPendingImport(id); ok {
+		t.Error("Pending import kept after it expired")
+	}
+}
@@ -0,0 +1,184 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"acb/db-interface/escape"
+	"encoding/json"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The channel the triggers installed by RS_LIVE_TRIGGERS notify
+const liveChannel = "db_interface_changes"
+
+// A change to a table, pushed to the sheets showing it
+type LiveChange struct {
+	ConnectionName string `json:"-"`
+	TableName      string `json:"table"`
+	// The updated row's primary key and new cells. Nil if rows were inserted or
+	// deleted, or changed outside of sheets, so that every row has to be reloaded.
+	Key   map[string]string `json:"key,omitempty"`
+	Cells map[string]Cell   `json:"cells,omitempty"`
+}
+
+func (change LiveChange) ReloadRows() bool {
+	return change.Cells == nil
+}
+
+type subscription struct {
+	connectionName string
+	tableNames     []string
+}
+
+var subscriptions = make(map[chan LiveChange]subscription)
+var subscriptionsLock sync.Mutex
+
+// Returns the changes made to the sheet's tables from now on, until unsubscribe is called
+func (s Sheet) Subscribe() (changes <-chan LiveChange, unsubscribe func()) {
+	ch := make(chan LiveChange, 16)
+	subscriptionsLock.Lock()
+	defer subscriptionsLock.Unlock()
+	subscriptions[ch] = subscription{s.Connection().Name, slices.Clone(s.TableNames)}
+	return ch, func() {
+		subscriptionsLock.Lock()
+		defer subscriptionsLock.Unlock()
+		delete(subscriptions, ch)
+	}
+}
+
+func publish(change LiveChange) {
+	subscriptionsLock.Lock()
+	defer subscriptionsLock.Unlock()
+	for ch, sub := range subscriptions {
+		if sub.connectionName != change.ConnectionName || !slices.Contains(sub.tableNames, change.TableName) {
+			continue
+		}
+		select {
+		case ch <- change:
+		default:
+			// A slow browser misses changes rather than holding up everyone else
+			log.Printf("Dropped live change to %s", change.TableName)
+		}
+	}
+}
+
+// Pushes the changes recorded in entries, which were just committed
+func (c *Connection) publishEntries(entries []AuditEntry) {
+	changes := []LiveChange{}
+	for _, entry := range entries {
+		// With database roles, values are only shown to those who can load them
+		if entry.Action != AuditUpdate || c.UsesRoles {
+			reload := LiveChange{ConnectionName: c.Name, TableName: entry.TableName}
+			if !slices.ContainsFunc(changes, func(change LiveChange) bool {
+				return change.TableName == entry.TableName && change.ReloadRows()
+			}) {
+				changes = append(changes, reload)
+			}
+			continue
+		}
+		key := make(map[string]string)
+		Check(json.Unmarshal([]byte(entry.PrimaryKey), &key))
+		i := slices.IndexFunc(changes, func(change LiveChange) bool {
+			return change.TableName == entry.TableName && primaryKeyJSON(change.Key) == entry.PrimaryKey && !change.ReloadRows()
+		})
+		if i < 0 {
+			changes = append(changes, LiveChange{c.Name, entry.TableName, key, make(map[string]Cell)})
+			i = len(changes) - 1
+		}
+		changes[i].Cells[entry.ColumnName] = entry.NewValue
+	}
+	for _, change := range changes {
+		publish(change)
+	}
+}
+
+func (s Sheet) installLiveTriggers() {
+	for _, tableName := range s.TableNames {
+		s.Connection().installLiveTrigger(tableName)
+	}
+}
+
+// Installs a trigger on the table which notifies the listener of changes made
+// outside of sheets. Transactions started by beginWrite turn the trigger off,
+// since their changes are published directly.
+func (c *Connection) installLiveTrigger(tableName string) {
+	c.liveLock.Lock()
+	defer c.liveLock.Unlock()
+	if !c.LiveTriggers || c.liveTables[tableName] {
+		return
+	}
+	if c.Table(tableName) == nil {
+		return
+	}
+	table, err := escape.Postgres.EscapeIdentifier(tableName)
+	Check(err)
+	c.dialect.createSchema(c.db, "db_interface")
+	tx := c.db.MustBegin()
+	_, err = tx.Exec(`
+		CREATE OR REPLACE FUNCTION db_interface.notify_change() RETURNS trigger AS $$
+		BEGIN
+			IF current_setting('db_interface.published', true) IS DISTINCT FROM 'on' THEN
+				PERFORM pg_notify('` + liveChannel + `', TG_TABLE_SCHEMA || '.' || TG_TABLE_NAME);
+			END IF;
+			RETURN NULL;
+		END $$ LANGUAGE plpgsql`)
+	if err == nil {
+		_, err = tx.Exec("DROP TRIGGER IF EXISTS db_interface_notify ON " + table.String())
+	}
+	if err == nil {
+		_, err = tx.Exec(`CREATE TRIGGER db_interface_notify
+			AFTER INSERT OR UPDATE OR DELETE ON ` + table.String() + `
+			FOR EACH STATEMENT EXECUTE FUNCTION db_interface.notify_change()`)
+	}
+	if err != nil {
+		Check(tx.Rollback())
+		log.Printf("Cannot install the live update trigger on %s: %s", tableName, err)
+		return
+	}
+	Commit(tx)
+	c.liveTables[tableName] = true
+	log.Printf("Installed the live update trigger on %s", tableName)
+}
+
+// Publishes the changes the triggers notify and refreshes the catalog after DDL,
+// until the listener is closed
+func (c *Connection) listen(url string, channels ...string) {
+	c.listener = pq.NewListener(url, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Listener of %s: %s", c.Name, err)
+		}
+	})
+	for _, channel := range channels {
+		Check(c.listener.Listen(channel))
+	}
+	go func() {
+		for n := range c.listener.Notify {
+			switch {
+			case n == nil:
+				// After reconnecting, when notifications may have been missed
+				if slices.Contains(channels, schemaChannel) {
+					c.checkCatalog()
+				}
+			case n.Channel == schemaChannel:
+				c.checkCatalog()
+			default:
+				publish(LiveChange{ConnectionName: c.Name, TableName: n.Extra})
+			}
+		}
+	}()
+}
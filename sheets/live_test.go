@@ -0,0 +1,71 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"testing"
+)
+
+func TestLiveChanges(t *testing.T) {
+	setupTestDB(t,
+		"items (id SERIAL PRIMARY KEY, name VARCHAR(255), price INTEGER)",
+		"others (id SERIAL PRIMARY KEY, name VARCHAR(255))")
+
+	sheet := Sheet{}
+	sheet.SetTable("test.items")
+	sheet.LoadPrefs()
+	other := Sheet{}
+	other.SetTable("test.others")
+	changes, unsubscribe := sheet.Subscribe()
+	otherChanges, unsubscribeOther := other.Subscribe()
+	defer unsubscribeOther()
+
+	Check(sheet.InsertMultipleRows(map[string]map[string]string{
+		"test.items": {"name": "pen"},
+	}, make(map[string]map[string]string)))
+	change := <-changes
+	if change.TableName != "test.items" || !change.ReloadRows() {
+		t.Errorf("Expected rows of test.items to be reloaded, got %+v", change)
+	}
+
+	Check(sheet.UpdateRows(map[string]map[string]string{
+		"test.items": {"name": "pencil", "price": "2"},
+	}, map[string]map[string]string{
+		"test.items": {"id": "1"},
+	}))
+	change = <-changes
+	if change.ReloadRows() || change.Key["id"] != "1" {
+		t.Fatalf("Expected the cells of row 1, got %+v", change)
+	}
+	if change.Cells["name"] != (Cell{"pencil", true}) || change.Cells["price"] != (Cell{"2", true}) {
+		t.Errorf("Expected pencil for 2, got %v", change.Cells)
+	}
+
+	select {
+	case change = <-otherChanges:
+		t.Errorf("Sheet on another table got %+v", change)
+	default:
+	}
+
+	unsubscribe()
+	Check(sheet.UpdateRows(map[string]map[string]string{
+		"test.items": {"name": "pen"},
+	}, map[string]map[string]string{
+		"test.items": {"id": "1"},
+	}))
+	select {
+	case change = <-changes:
+		t.Errorf("Got %+v after unsubscribing", change)
+	default:
+	}
+}
@@ -0,0 +1,406 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"acb/db-interface/escape"
+
+	"github.com/xuri/efp"
+)
+
+// A column which a lookup function searches or returns values from: a spreadsheet
+// column, a column of one of the sheet's tables, or a column of another table on
+// the sheet's connection, which has no rows in the sheet
+type lookupColumn struct {
+	// As in tableAndColIndex, with table -1 for spreadsheet columns. Columns of
+	// other tables are counted in the order of the table.
+	table, col int
+	// Full name of the column, unless it is a spreadsheet column
+	identifier string
+	// Full name of the table which isn't in the sheet
+	otherTable string
+}
+
+func (col lookupColumn) isSpreadsheet() bool {
+	return col.identifier == ""
+}
+
+// Rows first to last, counting from 0, of adjacent columns
+type lookupRange struct {
+	cols        []lookupColumn
+	first, last int
+}
+
+func (r lookupRange) isWholeColumn() bool {
+	return r.first == 0 && r.last == math.MaxInt-1
+}
+
+// Returns the columns of table in its order
+func orderedTableCols(table *Table) []Column {
+	cols := make([]Column, 0, len(table.Cols))
+	for _, col := range table.Cols {
+		cols = append(cols, col)
+	}
+	sort.Slice(cols, func(i, j int) bool { return cols[i].Index < cols[j].Index })
+	return cols
+}
+
+func (s *Sheet) lookupColumn(colName string) (lookupColumn, error) {
+	tableIndex, colIndex, err := s.tableAndColIndex(colName)
+	if err == nil && tableIndex < 0 {
+		return lookupColumn{table: -1, col: colIndex}, nil
+	}
+	if err == nil {
+		name := s.TableNames[tableIndex] + "." + s.OrderedCols(nil)[tableIndex][colIndex].Name
+		return lookupColumn{tableIndex, colIndex, name, ""}, nil
+	}
+
+	// Other tables are named as in the sheet's tables
+	split := strings.Split(colName, ".")
+	if len(split) < 2 || len(split) > 3 {
+		return lookupColumn{}, err
+	}
+	tableName := strings.Join(split[:len(split)-1], ".")
+	if len(split) == 2 {
+		tableName = s.Connection().defaultSchema() + "." + tableName
+	}
+	table := s.Connection().LoadedTable(tableName)
+	if table == nil {
+		return lookupColumn{}, err
+	}
+	for i, col := range orderedTableCols(table) {
+		if col.Name == split[len(split)-1] {
+			return lookupColumn{-1, i, tableName + "." + col.Name, tableName}, nil
+		}
+	}
+	return lookupColumn{}, err
+}
+
+// Returns the data type of col, which is empty for spreadsheet columns
+func (s *Sheet) lookupDataType(col lookupColumn) string {
+	switch {
+	case col.otherTable != "":
+		return orderedTableCols(s.Connection().LoadedTable(col.otherTable))[col.col].DataType
+	case col.isSpreadsheet():
+		return ""
+	}
+	return s.OrderedCols(nil)[col.table][col.col].DataType
+}
+
+// Resolves a range argument, which may span adjacent columns of one table or of
+// the spreadsheet, e.g. A1:C10
+func (s *Sheet) lookupRange(arg []Token) (lookupRange, error) {
+	if len(arg) != 1 || arg[0].TType != efp.TokenTypeOperand || arg[0].TSubType != efp.TokenSubTypeRange {
+		return lookupRange{}, formulaError(codeValue, "expected a range, e.g. A1:A10")
+	}
+	var firstName, lastName string
+	var first, last int
+	var err error
+	if start, end, found := strings.Cut(arg[0].TValue, ":"); found {
+		firstName, first, err = parseColumnAndIndex(start, 1)
+		if err != nil {
+			return lookupRange{}, formulaError(codeName, "%s is not a range", arg[0].TValue)
+		}
+		lastName, last, err = parseColumnAndIndex(end, math.MaxInt)
+	} else {
+		firstName, first, err = parseColumnAndIndex(start, 0)
+		lastName, last = firstName, first
+	}
+	if err != nil {
+		return lookupRange{}, formulaError(codeName, "%s is not a range", arg[0].TValue)
+	}
+	if first < 1 || last < first {
+		return lookupRange{}, formulaError(codeRef, "invalid rows in %s", arg[0].TValue)
+	}
+
+	firstCol, err := s.lookupColumn(firstName)
+	if err != nil {
+		return lookupRange{}, err
+	}
+	lastCol, err := s.lookupColumn(lastName)
+	if err != nil {
+		return lookupRange{}, err
+	}
+	if firstCol.table != lastCol.table || firstCol.otherTable != lastCol.otherTable ||
+		firstCol.isSpreadsheet() != lastCol.isSpreadsheet() || lastCol.col < firstCol.col {
+		return lookupRange{}, formulaError(codeRef, "%s must span columns of one table from left to right", arg[0].TValue)
+	}
+
+	cols := []lookupColumn{firstCol}
+	for i := firstCol.col + 1; i < lastCol.col; i++ {
+		switch {
+		case firstCol.isSpreadsheet():
+			cols = append(cols, lookupColumn{table: -1, col: i})
+		case firstCol.otherTable != "":
+			name := firstCol.otherTable + "." + orderedTableCols(s.Connection().LoadedTable(firstCol.otherTable))[i].Name
+			cols = append(cols, lookupColumn{-1, i, name, firstCol.otherTable})
+		default:
+			name := s.TableNames[firstCol.table] + "." + s.OrderedCols(nil)[firstCol.table][i].Name
+			cols = append(cols, lookupColumn{firstCol.table, i, name, ""})
+		}
+	}
+	if lastCol != firstCol {
+		cols = append(cols, lastCol)
+	}
+	return lookupRange{cols, first - 1, last - 1}, nil
+}
+
+// Runs a lookup query as the sheet's user and scans its only row into dest
+func (s *Sheet) queryLookup(dest any, query string, args ...any) error {
+	tx := s.Connection().Begin()
+	defer tx.Rollback()
+	err := s.setRole(tx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Executing %s %v", query, args)
+	return tx.Get(dest, query, args...)
+}
+
+func lookupEqual(value string, key Token) bool {
+	token := fromString(value)
+	if token.IsNumeric && key.IsNumeric {
+		return token.TFloat == key.TFloat
+	}
+	return value == key.TValue
+}
+
+// Returns the position of the first cell in the first column of r equal to key,
+// counting from 0
+func (s *Sheet) matchPosition(key Token, r lookupRange) (int, error) {
+	col := r.cols[0]
+	switch {
+	case col.otherTable != "":
+		return 0, formulaError(codeRef, "%s is not in the sheet, so its rows have no positions", col.otherTable)
+	case col.isSpreadsheet():
+		cells, err := s.extraCellRange(col.col, r.first+1, r.last+1)
+		if err != nil {
+			return 0, err
+		}
+		for k, cell := range cells {
+			if cell.NotNull && lookupEqual(cell.Value, key) {
+				return k, nil
+			}
+		}
+		return 0, formulaError(codeNA, "%s was not found", key.TValue)
+	}
+
+	// Rows are numbered in the sheet's order, as when they are referenced
+	c := s.Connection()
+	_, filterClauses, order, err := s.queryClauses(s.OrderedCols(nil))
+	if err != nil {
+		return 0, err
+	}
+	orderExprs, err := c.orderExpressions(order, false)
+	if err != nil {
+		return 0, err
+	}
+	query, err := c.esc().MakeMatchStmt(s.TableNames, s.joins(), col.identifier, filterClauses, orderExprs)
+	if err != nil {
+		return 0, err
+	}
+	var row int
+	err = s.queryLookup(&row, query, key.TValue, r.first+1, min(r.last+1, math.MaxInt32))
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, formulaError(codeNA, "%s was not found in %s", key.TValue, col.identifier)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return row - 1 - r.first, nil
+}
+
+// Returns the cell at position k of column i of r
+func (s *Sheet) lookupCell(r lookupRange, i, k int) (Token, error) {
+	col := r.cols[i]
+	j := r.first + k
+	if k < 0 || j > r.last {
+		return Token{}, formulaError(codeRef, "position %d is outside the range", k+1)
+	}
+	switch {
+	case col.otherTable != "":
+		return Token{}, formulaError(codeRef, "%s is not in the sheet, so its rows have no positions", col.otherTable)
+	case col.isSpreadsheet():
+		cell, err := s.extraCellAt(col.col, j)
+		if err != nil {
+			return Token{}, err
+		}
+		return s.cellToken(cell.Value, ""), nil
+	}
+	row, err := s.RowAt(j)
+	if err != nil {
+		return Token{}, err
+	}
+	if row == nil {
+		return Token{}, formulaError(codeRef, "there is no row %d", j+1)
+	}
+	return s.cellToken(row[col.table][col.col].Value, s.lookupDataType(col)), nil
+}
+
+// Returns the value in column i of values next to the first cell of keys equal to key
+func (s *Sheet) lookup(key Token, keys, values lookupRange, i int) (Token, error) {
+	keyCol, valueCol := keys.cols[0], values.cols[i]
+	if keyCol.isSpreadsheet() || valueCol.isSpreadsheet() || keyCol.otherTable != valueCol.otherTable ||
+		!keys.isWholeColumn() || !values.isWholeColumn() {
+		k, err := s.matchPosition(key, keys)
+		if err != nil {
+			return Token{}, err
+		}
+		return s.lookupCell(values, i, k)
+	}
+
+	// Whole columns are searched in the database, including rows the sheet filters out
+	c := s.Connection()
+	tableNames, joins := s.TableNames, s.joins()
+	if keyCol.otherTable != "" {
+		tableNames, joins = []string{keyCol.otherTable}, nil
+	}
+	cast, err := c.esc().MakeCast(valueCol.identifier, "text", "val")
+	if err != nil {
+		return Token{}, err
+	}
+	query, err := c.esc().MakeLookupStmt(tableNames, joins, keyCol.identifier, []escape.SafeSQL{cast})
+	if err != nil {
+		return Token{}, err
+	}
+	var val sql.NullString
+	err = s.queryLookup(&val, query, key.TValue)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Token{}, formulaError(codeNA, "%s was not found in %s", key.TValue, keyCol.identifier)
+	}
+	if err != nil {
+		return Token{}, err
+	}
+	return s.cellToken(val.String, s.lookupDataType(valueCol)), nil
+}
+
+// Evaluates an argument which must be a whole number of at least 1
+func (s *Sheet) evalPosition(fName string, arg []Token) (int, error) {
+	token, err := s.evalTokens(arg)
+	if err != nil {
+		return 0, err
+	}
+	if !token.IsNumeric || token.TFloat != math.Trunc(token.TFloat) {
+		return 0, formulaError(codeValue, "%s needs a whole number, not %s", fName, token.TValue)
+	}
+	if token.TFloat < 1 {
+		return 0, formulaError(codeRef, "%s counts from 1", fName)
+	}
+	return int(token.TFloat), nil
+}
+
+// Fails unless the optional argument at index asks for exact matches with 0 or
+// FALSE, which are the only ones supported
+func (s *Sheet) checkExactMatch(fName string, arguments [][]Token, index int) error {
+	if len(arguments) <= index {
+		return nil
+	}
+	token, err := s.evalTokens(arguments[index])
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(token.TValue, "FALSE") && !(token.IsNumeric && token.TFloat == 0) {
+		return formulaError(codeValue, "%s only finds exact matches, so it needs 0 or FALSE", fName)
+	}
+	return nil
+}
+
+func (s *Sheet) evalLookup(fName string, arguments [][]Token) (Token, error) {
+	argCounts := map[string][2]int{"XLOOKUP": {3, 4}, "VLOOKUP": {3, 4}, "MATCH": {2, 3}, "INDEX": {2, 3}}[fName]
+	if len(arguments) < argCounts[0] || len(arguments) > argCounts[1] {
+		return Token{}, fmt.Errorf("wrong number of arguments for %s", fName)
+	}
+
+	if fName == "INDEX" {
+		r, err := s.lookupRange(arguments[0])
+		if err != nil {
+			return Token{}, err
+		}
+		row, err := s.evalPosition(fName, arguments[1])
+		if err != nil {
+			return Token{}, err
+		}
+		col := 1
+		if len(arguments) > 2 {
+			col, err = s.evalPosition(fName, arguments[2])
+			if err != nil {
+				return Token{}, err
+			}
+		}
+		if col > len(r.cols) {
+			return Token{}, formulaError(codeRef, "the range has %d columns", len(r.cols))
+		}
+		return s.lookupCell(r, col-1, row-1)
+	}
+
+	key, err := s.evalTokens(arguments[0])
+	if err != nil {
+		return Token{}, err
+	}
+	r, err := s.lookupRange(arguments[1])
+	if err != nil {
+		return Token{}, err
+	}
+
+	switch fName {
+	case "MATCH":
+		err = s.checkExactMatch(fName, arguments, 2)
+		if err != nil {
+			return Token{}, err
+		}
+		if len(r.cols) != 1 {
+			return Token{}, formulaError(codeValue, "MATCH searches a single column")
+		}
+		k, err := s.matchPosition(key, r)
+		if err != nil {
+			return Token{}, err
+		}
+		return fromFloat(float64(k + 1)), nil
+	case "VLOOKUP":
+		col, err := s.evalPosition(fName, arguments[2])
+		if err != nil {
+			return Token{}, err
+		}
+		if col > len(r.cols) {
+			return Token{}, formulaError(codeRef, "the range has %d columns", len(r.cols))
+		}
+		err = s.checkExactMatch(fName, arguments, 3)
+		if err != nil {
+			return Token{}, err
+		}
+		keys := lookupRange{r.cols[:1], r.first, r.last}
+		return s.lookup(key, keys, r, col-1)
+	}
+
+	values, err := s.lookupRange(arguments[2])
+	if err != nil {
+		return Token{}, err
+	}
+	if len(r.cols) != 1 || len(values.cols) != 1 {
+		return Token{}, formulaError(codeValue, "XLOOKUP ranges must be single columns")
+	}
+	val, err := s.lookup(key, r, values, 0)
+	var formulaErr *FormulaError
+	if len(arguments) > 3 && errors.As(err, &formulaErr) && formulaErr.Code == codeNA {
+		return s.evalTokens(arguments[3])
+	}
+	return val, err
+}
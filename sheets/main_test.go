@@ -0,0 +1,72 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Without a DATABASE_URL, the tests run against a temporary SQLite file
+func TestMain(m *testing.M) {
+	if os.Getenv("DATABASE_URL") != "" {
+		os.Exit(m.Run())
+	}
+	dir, err := os.MkdirTemp("", "relational-sheets")
+	Check(err)
+	os.Setenv("DATABASE_URL", "sqlite://"+filepath.Join(dir, "test.db"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Opens the configured database for a test, with empty metadata tables and a
+// fresh sheet store, and creates tables in the test schema. Each table is
+// given as its name followed by its columns, where SERIAL and REFERENCES test.
+// are translated for the dialect. The tables are dropped when the test ends.
+func setupTestDB(t *testing.T, tables ...string) *Connection {
+	Open()
+	c := defaultConnection()
+	InitSheetsTables()
+	InitPrefsTable()
+	for _, table := range []string{
+		"sheetcells", "sheetcols", "column_prefs", "sheet_links", "sheet_shares",
+		"audit_log", "sheets", "users",
+	} {
+		meta.MustExec("DELETE FROM db_interface." + table)
+	}
+	Store = newSheetStore()
+
+	c.dialect.createSchema(c.db, "test")
+	dropTables := func() {
+		// Referencing tables first
+		for k := len(tables) - 1; k >= 0; k-- {
+			name, _, _ := strings.Cut(tables[k], " ")
+			c.db.MustExec("DROP TABLE IF EXISTS test." + name)
+		}
+	}
+	dropTables()
+	for _, table := range tables {
+		table = strings.ReplaceAll(table, "SERIAL", c.dialect.sqlType("SERIAL"))
+		table = strings.ReplaceAll(table, "REFERENCES test.", "REFERENCES "+c.dialect.referencedTable("test."))
+		c.db.MustExec("CREATE TABLE test." + table)
+	}
+	c.loadTables()
+	t.Cleanup(func() {
+		dropTables()
+		Close()
+	})
+	return c
+}
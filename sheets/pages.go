@@ -0,0 +1,277 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+
+	"acb/db-interface/escape"
+)
+
+// Limits how many off-page cells a formula can evaluate through chains of references
+const maxEvalDepth = 100
+
+func formatCount(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// Describes the loaded rows, e.g. "Rows 101–200 of 48,312"
+func (s Sheet) PageSummary() string {
+	if s.RowCount == 0 {
+		return fmt.Sprintf("No rows of %s", formatCount(s.TotalRows))
+	}
+	return fmt.Sprintf("Rows %s–%s of %s",
+		formatCount(s.Offset+1), formatCount(s.Offset+s.RowCount), formatCount(s.TotalRows))
+}
+
+func (s Sheet) TotalSummary() string {
+	return formatCount(s.TotalRows) + " rows"
+}
+
+func (s Sheet) LastPageOffset(limit int) int {
+	if limit <= 0 || s.TotalRows == 0 {
+		return 0
+	}
+	return (s.TotalRows - 1) / limit * limit
+}
+
+// Identifies a row by its values for the sheet's sort columns, followed by every
+// primary key, as text. NULLs are nil.
+type Cursor struct {
+	Values []*string
+	// Whether to load the rows before the cursor instead of after it. With no
+	// values, this loads the rows at the end of the sheet.
+	Before bool
+}
+
+// Keyset pagination needs a primary key on every table to give each row a unique cursor
+func (s Sheet) UsesKeyset() bool {
+	for _, tableName := range s.TableNames {
+		table := s.Connection().Table(tableName)
+		if table == nil || !table.HasPrimaryKey {
+			return false
+		}
+	}
+	return len(s.TableNames) > 0
+}
+
+// Returns the cursor for the first or last loaded row as JSON, or "" if there is none
+func (s Sheet) PageCursor(last bool) string {
+	key := s.firstKey
+	if last {
+		key = s.lastKey
+	}
+	if key == nil || !s.UsesKeyset() {
+		return ""
+	}
+	values := make([]*string, len(key))
+	for i, cell := range key {
+		if cell.NotNull {
+			values[i] = &key[i].Value
+		}
+	}
+	cursor, err := json.Marshal(values)
+	Check(err)
+	return string(cursor)
+}
+
+// Makes the clause selecting the rows after (or before) the cursor in the given order,
+// along with its arguments, which follow the limit and offset
+func (cursor Cursor) keyset(c *Connection, order []escape.KeysetColumn) ([]interface{}, escape.SafeSQL, error) {
+	args := []interface{}{}
+	cols := make([]escape.KeysetColumn, len(cursor.Values))
+	for i, value := range cursor.Values {
+		cols[i] = order[i]
+		cols[i].Ascending = order[i].Ascending != cursor.Before
+		cols[i].IsNull = value == nil
+		if value != nil {
+			args = append(args, *value)
+		}
+	}
+	clause, err := c.esc().MakeKeysetClause(cols, 3)
+	return args, clause, err
+}
+
+// Returns the database row at index in the sheet's order, whether or not it is
+// in the loaded page. Returns nil if there is no such row.
+func (s *Sheet) RowAt(index int) ([][]Cell, error) {
+	if index >= s.Offset && index < s.Offset+s.RowCount {
+		row := make([][]Cell, len(s.Cells))
+		for i, tableCells := range s.Cells {
+			row[i] = make([]Cell, len(tableCells))
+			for j, cells := range tableCells {
+				row[i][j] = cells[index-s.Offset]
+			}
+		}
+		return row, nil
+	}
+	if row, ok := s.rowCache[index]; ok {
+		return row, nil
+	}
+
+	cols := s.OrderedCols(nil)
+	query, err := s.selectQuery(cols, true)
+	if err != nil {
+		return nil, err
+	}
+	tx := s.Connection().Begin()
+	err = s.setRole(tx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Queryx(query, 1, index)
+	if err != nil {
+		return nil, fmt.Errorf("Error running %s: %w", query, err)
+	}
+	defer rows.Close()
+	var row [][]Cell
+	if rows.Next() {
+		row, err = scanRow(rows, cols)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.rowCache == nil {
+		s.rowCache = make(map[int][][]Cell)
+	}
+	s.rowCache[index] = row
+	return row, rows.Err()
+}
+
+// Loads the formulas saved in spreadsheet column i between rows first and last, counting from 0
+func (s *Sheet) storedFormulas(i, first, last int) (map[int]string, error) {
+	rows, err := meta.Query(`
+		SELECT j, formula
+		FROM db_interface.sheetcells
+		WHERE sheetcol_id = $1
+			AND j BETWEEN $2 AND $3`,
+		s.ExtraCols[i].Id,
+		first,
+		min(last, math.MaxInt32))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	formulas := make(map[int]string)
+	for rows.Next() {
+		var j int
+		var formula string
+		err = rows.Scan(&j, &formula)
+		if err != nil {
+			return nil, err
+		}
+		formulas[j] = formula
+	}
+	return formulas, rows.Err()
+}
+
+func (s *Sheet) evalStoredFormula(i, j int, formula string) (SheetCell, error) {
+	if s.evaluating[cellRef{i, j}] {
+		return errorCell(formula, errCircular), nil
+	}
+	if s.evalDepth >= maxEvalDepth {
+		return SheetCell{}, errors.New("too many nested references")
+	}
+	s.evalDepth++
+	defer func() { s.evalDepth-- }()
+	defer s.startEval(cellRef{i, j})()
+	cell, err := s.evalFormula(formula)
+	if err != nil {
+		log.Printf("Error evaluating cell %d,%d (%s): %s", i, j, formula, err)
+		cell = errorCell(formula, err)
+	}
+	return cell, nil
+}
+
+// Returns the cell at row j of spreadsheet column i, counting from 0. Cells outside
+// the loaded page are evaluated from their saved formulas.
+func (s *Sheet) extraCellAt(i, j int) (SheetCell, error) {
+	col := s.ExtraCols[i]
+	if j >= s.Offset && j < s.Offset+len(col.Cells) {
+		return s.pageCell(i, j)
+	}
+	if s.Id == 0 {
+		// Unsaved sheets have no cells outside the page
+		return SheetCell{}, nil
+	}
+	formulas, err := s.storedFormulas(i, j, j)
+	if err != nil {
+		return SheetCell{}, err
+	}
+	formula, ok := formulas[j]
+	if !ok {
+		return SheetCell{}, nil
+	}
+	cell, err := s.evalStoredFormula(i, j, formula)
+	if err == nil && cell.Error != nil {
+		err = cell.Error
+	}
+	return cell, err
+}
+
+// Returns the cells of spreadsheet column i from row start to end inclusive, counting
+// from 1 as in formulas. The result stops after the last non-empty cell.
+func (s *Sheet) extraCellRange(i, start, end int) ([]SheetCell, error) {
+	first, last := start-1, end-1
+	cells := []SheetCell{}
+	set := func(j int, cell SheetCell) {
+		for len(cells) <= j-first {
+			cells = append(cells, SheetCell{})
+		}
+		cells[j-first] = cell
+	}
+
+	col := s.ExtraCols[i]
+	pageEnd := s.Offset + len(col.Cells)
+	if s.Id != 0 && (first < s.Offset || last >= pageEnd) {
+		formulas, err := s.storedFormulas(i, first, last)
+		if err != nil {
+			return nil, err
+		}
+		for j, formula := range formulas {
+			if j >= s.Offset && j < pageEnd {
+				continue
+			}
+			cell, err := s.evalStoredFormula(i, j, formula)
+			if err != nil {
+				return nil, err
+			}
+			if cell.Error != nil {
+				return nil, cell.Error
+			}
+			set(j, cell)
+		}
+	}
+	for k, cell := range col.Cells {
+		j := s.Offset + k
+		if j >= first && j <= last && (cell.NotNull || cell.Formula != "") {
+			cell, err := s.pageCell(i, j)
+			if err != nil {
+				return nil, err
+			}
+			set(j, cell)
+		}
+	}
+	return cells, nil
+}
@@ -0,0 +1,307 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"acb/db-interface/escape"
+	"acb/db-interface/fkeys"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+type postgresDialect struct{}
+
+// pg_constraint.confdeltype codes
+var deleteActions = map[string]string{
+	"a": "NO ACTION",
+	"r": "RESTRICT",
+	"c": "CASCADE",
+	"n": "SET NULL",
+	"d": "SET DEFAULT",
+}
+
+func (postgresDialect) Escape() escape.Dialect {
+	return escape.Postgres
+}
+
+func (postgresDialect) open(url string) (*sqlx.DB, error) {
+	return sqlx.Open("pgx", url)
+}
+
+func (postgresDialect) begin(db *sqlx.DB, readOnly bool) (*sqlx.Tx, error) {
+	tx, err := db.Beginx()
+	if err != nil || !readOnly {
+		return tx, err
+	}
+	_, err = tx.Exec("SET TRANSACTION READ ONLY")
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (postgresDialect) setRole(tx *sqlx.Tx, role string) error {
+	escaped, err := escape.Postgres.EscapeIdentifier(role)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("SET LOCAL ROLE " + escaped.String())
+	return err
+}
+
+func (postgresDialect) resetRole(tx *sqlx.Tx) error {
+	_, err := tx.Exec("RESET ROLE")
+	return err
+}
+
+func (postgresDialect) createSchema(db *sqlx.DB, name string) {
+	schema, err := escape.Postgres.EscapeIdentifier(name)
+	Check(err)
+	db.MustExec("CREATE SCHEMA IF NOT EXISTS " + schema.String())
+}
+
+func (postgresDialect) defaultSchema(db sqlx.Queryer) (string, error) {
+	schema := ""
+	err := sqlx.Get(db, &schema, "SELECT current_schema()")
+	return schema, err
+}
+
+func (postgresDialect) sqlType(pgType string) string {
+	return pgType
+}
+
+func (postgresDialect) referencedTable(fullName string) string {
+	return fullName
+}
+
+func (postgresDialect) upsert(conflictCols ...string) string {
+	return fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET", strings.Join(conflictCols, ", "))
+}
+
+func (postgresDialect) insertReturningId(db sqlx.Ext, query string, args ...interface{}) (int, error) {
+	id := 0
+	err := db.QueryRowx(query+" RETURNING id", args...).Scan(&id)
+	return id, err
+}
+
+func (postgresDialect) createAggregates(db *sqlx.DB) {
+	db.MustExec(
+		`CREATE OR REPLACE AGGREGATE db_interface.mul(numeric) (
+			STYPE = numeric,
+			INITCOND = 1,
+			SFUNC = numeric_mul,
+			COMBINEFUNC = numeric_mul,
+			PARALLEL = SAFE
+		)`)
+}
+
+func (postgresDialect) aggregate(function, expr string, hasAggregates bool) string {
+	if function == "PRODUCT" {
+		if !hasAggregates {
+			return productFromLogs(expr)
+		}
+		function = "db_interface.mul"
+	}
+	return fmt.Sprintf("%s(%s)", function, expr)
+}
+
+func (postgresDialect) datePart(part, expr, timezone string) string {
+	if timezone != "" {
+		expr = fmt.Sprintf("(%s AT TIME ZONE %s)", expr, pq.QuoteLiteral(timezone))
+	}
+	if part == "serial" {
+		return fmt.Sprintf("(EXTRACT(EPOCH FROM CAST(%s AS timestamp)) / 86400 + 25569)", expr)
+	}
+	return fmt.Sprintf("EXTRACT(%s FROM %s)", strings.ToUpper(part), expr)
+}
+
+func (postgresDialect) insertRow(tx *sqlx.Tx, table *Table, values map[string]interface{}, returning []string) ([]interface{}, error) {
+	return insertReturning(tx, escape.Postgres, table, values, returning)
+}
+
+func (postgresDialect) loadTables(db sqlx.Queryer) []Table {
+	tables := make([]Table, 0)
+	err := sqlx.Select(db, &tables, `
+		SELECT COALESCE(tablename, '') tablename
+			, COALESCE(schemaname, '') schemaname
+			, oid
+		FROM pg_catalog.pg_tables
+		LEFT JOIN pg_catalog.pg_class
+			ON relname = tablename AND relkind = 'r'
+		WHERE schemaname != 'pg_catalog'
+			AND schemaname != 'information_schema'
+			AND schemaname != 'db_interface'
+		ORDER BY schemaname, tablename DESC`)
+	Check(err)
+	return tables
+}
+
+func (postgresDialect) catalogFingerprint(db sqlx.Queryer) (string, error) {
+	var fingerprint string
+	err := sqlx.Get(db, &fingerprint, `
+		SELECT md5(COALESCE((
+			SELECT string_agg(
+				nspname || '.' || relname || ' ' || attname || ' ' || atttypid || ' ' || attnotnull || ' ' || atthasdef,
+				',' ORDER BY nspname, relname, attnum)
+			FROM pg_catalog.pg_class
+			JOIN pg_catalog.pg_namespace
+				ON pg_namespace.oid = relnamespace
+			JOIN pg_catalog.pg_attribute
+				ON attrelid = pg_class.oid AND attnum > 0 AND NOT attisdropped
+			WHERE relkind IN ('r', 'p')
+				AND nspname NOT IN ('pg_catalog', 'information_schema', 'db_interface')
+		), '') || COALESCE((
+			SELECT string_agg(oid::text, ',' ORDER BY oid)
+			FROM pg_catalog.pg_constraint
+			WHERE contype IN ('p', 'f')
+		), ''))`)
+	return fingerprint, err
+}
+
+func (postgresDialect) loadCols(tx *sqlx.Tx, table *Table) []Column {
+	cols := make([]Column, 0, 100)
+	err := tx.Select(&cols, `
+		SELECT column_name "name"
+			   , is_nullable = 'YES' isnullable
+			   , column_default IS NOT NULL hasdefault
+			   , data_type datatype
+			   , ordinal_position "index"
+		FROM information_schema.columns
+		WHERE table_name = $1
+		AND table_schema = $2`,
+		table.TableName,
+		table.SchemaName)
+	Check(err)
+	return cols
+}
+
+func (postgresDialect) loadConstraints(tx *sqlx.Tx, t *Table) ([]string, map[int64]fkeys.ForeignKey) {
+	// Query the primary keys, but returns IDs instead of column names
+	pKeyAttNums := pq.Int64Array{}
+	err := tx.Get(&pKeyAttNums, `
+		SELECT conkey
+		FROM pg_catalog.pg_constraint
+		WHERE conrelid = $1
+			AND contype = 'p'`,
+		t.Oid)
+	if err != nil && err.Error() != "sql: no rows in result set" {
+		panic(err)
+	}
+
+	// Query the foreign keys, but returns IDs instead of column names
+	rawFkeys := make([]struct {
+		Oid       int64
+		Conrelid  int64
+		Confrelid int64
+		// PostgreSQL integers are 64-bit, so there is no IntArray type
+		// This is why we use int64 for most ints here
+		Conkey      pq.Int64Array
+		Confkey     pq.Int64Array
+		Confdeltype string
+	}, 0, 20)
+	err = tx.Select(&rawFkeys, `
+		SELECT oid
+		    , conrelid
+			, confrelid
+			, conkey
+			, confkey
+			, confdeltype::text confdeltype
+		FROM pg_catalog.pg_constraint
+		WHERE (conrelid = $1 OR confrelid = $1)
+			AND contype = 'f'`,
+		t.Oid)
+	Check(err)
+	log.Printf("Retrieved %d fkeys relating to %s (%d): %+v", len(rawFkeys), t.FullName(), t.Oid, rawFkeys)
+
+	// Get the names for each column referenced in an fkey
+	idsToNames := make(map[[2]int64]string)
+	relIds := make([]string, 1, 20)
+	relIds[0] = strconv.FormatInt(t.Oid, 10)
+	for _, rawFkey := range rawFkeys {
+		// may add duplicates but that's OK
+		relIds = append(
+			append(relIds,
+				strconv.FormatInt(rawFkey.Conrelid, 10)),
+			strconv.FormatInt(rawFkey.Confrelid, 10))
+	}
+	// Safe since we know relIds are from strconv.FormatInt
+	query := fmt.Sprintf(`
+		SELECT attrelid
+		    , attnum
+		    , attname
+		FROM pg_catalog.pg_attribute
+		WHERE attrelid IN (%s)`,
+		strings.Join(relIds, ","))
+	log.Println("Executing: " + query)
+	rows, err := tx.Query(query)
+	Check(err)
+	for rows.Next() {
+		var relId, attNum int64
+		var colName string
+		err := rows.Scan(&relId, &attNum, &colName)
+		Check(err)
+		idsToNames[[2]int64{relId, attNum}] = colName
+	}
+
+	primaryKey := make([]string, len(pKeyAttNums))
+	for i, attNum := range pKeyAttNums {
+		primaryKey[i] = idsToNames[[2]int64{t.Oid, attNum}]
+	}
+
+	tableFkeys := make(map[int64]fkeys.ForeignKey)
+	for _, rawFkey := range rawFkeys {
+		fkey := fkeys.ForeignKey{OnDelete: deleteActions[rawFkey.Confdeltype]}
+		if rawFkey.Conrelid == t.Oid {
+			fkey.SourceTableName = t.FullName()
+			for _, t2 := range t.connection.Tables() {
+				if t2.Oid == rawFkey.Confrelid {
+					fkey.TargetTableName = t2.FullName()
+				}
+			}
+		} else {
+			if rawFkey.Confrelid != t.Oid {
+				panic("SQL WHERE clause violated -- unexpected table oid")
+			}
+
+			fkey.TargetTableName = t.FullName()
+			for _, t2 := range t.connection.Tables() {
+				if t2.Oid == rawFkey.Conrelid {
+					fkey.SourceTableName = t2.FullName()
+				}
+			}
+		}
+		for _, attnum := range rawFkey.Conkey {
+			fkey.SourceColNames = append(fkey.SourceColNames, idsToNames[[2]int64{rawFkey.Conrelid, attnum}])
+		}
+		for _, attnum := range rawFkey.Confkey {
+			fkey.TargetColNames = append(fkey.TargetColNames, idsToNames[[2]int64{rawFkey.Confrelid, attnum}])
+		}
+
+		if fkey.SourceTableName == "" {
+			panic(fmt.Sprintf("Unexpected source table oid %d on %s (%d)", rawFkey.Conrelid, t.FullName(), t.Oid))
+		} else if fkey.TargetTableName == "" {
+			panic(fmt.Sprintf("Unexpected target table oid %d on %s (%d)", rawFkey.Confrelid, t.FullName(), t.Oid))
+		}
+
+		tableFkeys[rawFkey.Oid] = fkey
+	}
+	return primaryKey, tableFkeys
+}
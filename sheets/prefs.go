@@ -13,6 +13,7 @@
 package sheets
 
 import (
+	"fmt"
 	"log"
 )
 
@@ -28,47 +29,49 @@ type Pref struct {
 }
 
 func InitPrefsTable() {
-	conn.MustExec(`
+	meta.MustExec(fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS db_interface.column_prefs (
-			id SERIAL PRIMARY KEY
+			id %s PRIMARY KEY
 			, sheet_id INT NOT NULL
 			, tablename VARCHAR(255) NOT NULL
 			, columnname VARCHAR(255) NOT NULL
 			, hide boolean NOT NULL DEFAULT false
 			, editable boolean NOT NULL DEFAULT false
-			, index int NOT NULL
+			, "index" int NOT NULL
 		    , sorton boolean NOT NULL
 		    , ascending boolean NOT NULL
 			, filter VARCHAR(255) NOT NULL
 			, UNIQUE(sheet_id, tablename, columnname)
 			, CONSTRAINT fk_sheets
 				FOREIGN KEY (sheet_id)
-					REFERENCES db_interface.sheets(id) ON DELETE CASCADE
-		)`)
+					REFERENCES %s(id) ON DELETE CASCADE
+		)`,
+		metaDialect.sqlType("SERIAL"),
+		metaDialect.referencedTable("db_interface.sheets")))
 	log.Println("Column prefs table exists")
 }
 
 func (sheet *Sheet) SavePref(pref Pref) {
 	sheet.PrefsMap[pref.TableName+"."+pref.ColumnName] = pref
 
-	conn.MustExec(`
+	meta.MustExec(`
 		INSERT INTO db_interface.column_prefs (
 			sheet_id
 			, tablename
 			, columnname
 			, hide
 			, editable
-			, index
+			, "index"
 			, sorton
 			, ascending
 			, filter
 		) VALUES (
 			$1, $2, $3, $4, $5, $6, $7, $8, $9
 		)
-		ON CONFLICT ("sheet_id", "tablename", "columnname") DO
-		UPDATE SET hide = $4
+		`+metaDialect.upsert("sheet_id", "tablename", "columnname")+`
+			hide = $4
 			, editable = $5
-			, index = $6
+			, "index" = $6
 			, sorton = $7
 			, ascending = $8
 			, filter = $9`,
@@ -85,12 +88,12 @@ func (sheet *Sheet) SavePref(pref Pref) {
 
 func (s *Sheet) LoadPrefs() {
 	prefs := []Pref{}
-	err := conn.Select(&prefs, `
+	err := meta.Select(&prefs, `
 		SELECT tablename
 		    , columnname
 			, hide
 			, editable
-			, index
+			, "index"
 			, sorton
 			, ascending
 			, filter
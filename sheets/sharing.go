@@ -0,0 +1,284 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// What a user can do with a sheet. Each role can do everything the ones before it can.
+type Role int
+
+const (
+	NoRole Role = iota
+	// Can open and export the sheet
+	Viewer
+	// Can edit the sheet's rows, columns and settings
+	Editor
+	// Can share the sheet
+	Owner
+)
+
+var roleNames = []string{"", "viewer", "editor", "owner"}
+
+func (role Role) String() string {
+	return roleNames[role]
+}
+
+func ParseRole(name string) (Role, error) {
+	for i, roleName := range roleNames {
+		if name == roleName {
+			return Role(i), nil
+		}
+	}
+	return NoRole, fmt.Errorf("No such role %s", name)
+}
+
+// A user a sheet is shared with
+type Share struct {
+	UserName string
+	Role     Role
+}
+
+// A link which lets anyone view a sheet until it expires
+type Link struct {
+	Token   string
+	Expires time.Time
+}
+
+func initSharingTables() {
+	addMetadataColumn("db_interface.sheets", "ownerid", "INT")
+	meta.MustExec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS db_interface.sheet_shares (
+			id %s PRIMARY KEY
+			, sheet_id INT NOT NULL
+			, user_id INT NOT NULL
+			, "role" VARCHAR(16) NOT NULL
+			, UNIQUE(sheet_id, user_id)
+			, CONSTRAINT fk_shares_sheets
+				FOREIGN KEY (sheet_id)
+					REFERENCES %s(id) ON DELETE CASCADE
+			, CONSTRAINT fk_shares_users
+				FOREIGN KEY (user_id)
+					REFERENCES %s(id) ON DELETE CASCADE
+		)`,
+		metaDialect.sqlType("SERIAL"),
+		metaDialect.referencedTable("db_interface.sheets"),
+		metaDialect.referencedTable("db_interface.users")))
+	meta.MustExec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS db_interface.sheet_links (
+			id %s PRIMARY KEY
+			, sheet_id INT NOT NULL
+			, token VARCHAR(64) NOT NULL UNIQUE
+			, expires BIGINT NOT NULL
+			, CONSTRAINT fk_links_sheets
+				FOREIGN KEY (sheet_id)
+					REFERENCES %s(id) ON DELETE CASCADE
+		)`,
+		metaDialect.sqlType("SERIAL"),
+		metaDialect.referencedTable("db_interface.sheets")))
+	log.Println("Sharing tables exist")
+}
+
+// Returns the user's role on the sheet. Sheets created before they had owners
+// can be viewed by everyone until they're claimed with ClaimSheets.
+func (s Sheet) Role(user User) Role {
+	if s.OwnerId == 0 {
+		return Viewer
+	}
+	if s.OwnerId == user.Id {
+		return Owner
+	}
+	name := ""
+	err := meta.Get(&name, `
+		SELECT "role" FROM db_interface.sheet_shares WHERE sheet_id = $1 AND user_id = $2`,
+		s.Id, user.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return NoRole
+	}
+	Check(err)
+	role, err := ParseRole(name)
+	Check(err)
+	return role
+}
+
+// Makes the named user the owner of every sheet which has none, returning how
+// many they claimed. Anyone could share those sheets or link to them before, so
+// their shares and links are removed.
+func ClaimSheets(userName string) (int, error) {
+	userId := 0
+	err := meta.Get(&userId, `SELECT id FROM db_interface.users WHERE "name" = $1`, userName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("No such user %s", userName)
+	}
+	Check(err)
+
+	tx := meta.MustBegin()
+	tx.MustExec(`
+		DELETE FROM db_interface.sheet_shares
+		WHERE sheet_id IN (SELECT id FROM db_interface.sheets WHERE ownerid IS NULL)`)
+	tx.MustExec(`
+		DELETE FROM db_interface.sheet_links
+		WHERE sheet_id IN (SELECT id FROM db_interface.sheets WHERE ownerid IS NULL)`)
+	result := tx.MustExec(`UPDATE db_interface.sheets SET ownerid = $1 WHERE ownerid IS NULL`, userId)
+	claimed, err := result.RowsAffected()
+	Check(err)
+	Commit(tx)
+
+	for _, sheet := range Store.All() {
+		if sheet.OwnerId == 0 {
+			sheet.OwnerId = userId
+			Store.put(sheet)
+		}
+	}
+	return int(claimed), nil
+}
+
+// Returns the sheets which the user can view
+func VisibleSheets(user User) map[int]Sheet {
+	shared := []int{}
+	Check(meta.Select(&shared, `
+		SELECT sheet_id FROM db_interface.sheet_shares WHERE user_id = $1`,
+		user.Id))
+	visible := make(map[int]Sheet)
+	for id, sheet := range Store.All() {
+		if sheet.OwnerId == 0 || sheet.OwnerId == user.Id || slices.Contains(shared, id) {
+			visible[id] = sheet
+		}
+	}
+	return visible
+}
+
+// Returns the sheets which the user can edit, whose changes they may audit
+func EditableSheets(user User) map[int]Sheet {
+	editable := VisibleSheets(user)
+	for id, sheet := range editable {
+		if sheet.Role(user) < Editor {
+			delete(editable, id)
+		}
+	}
+	return editable
+}
+
+// Gives the named user a role on the sheet, or stops sharing it with them if
+// role is NoRole
+func (s Sheet) Share(userName string, role Role) error {
+	userId := 0
+	err := meta.Get(&userId, `SELECT id FROM db_interface.users WHERE "name" = $1`, userName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("No such user %s", userName)
+	}
+	Check(err)
+	if userId == s.OwnerId {
+		return fmt.Errorf("%s owns the sheet", userName)
+	}
+
+	if role == NoRole {
+		meta.MustExec(`
+			DELETE FROM db_interface.sheet_shares WHERE sheet_id = $1 AND user_id = $2`,
+			s.Id, userId)
+		log.Printf("Unshared sheet %d with %s", s.Id, userName)
+		return nil
+	}
+	meta.MustExec(`
+		INSERT INTO db_interface.sheet_shares (sheet_id, user_id, "role")
+		VALUES ($1, $2, $3)
+		`+metaDialect.upsert("sheet_id", "user_id")+`
+			"role" = $3`,
+		s.Id, userId, role.String())
+	log.Printf("Shared sheet %d with %s as %s", s.Id, userName, role)
+	return nil
+}
+
+// Lists the users the sheet is shared with, by name
+func (s Sheet) Shares() []Share {
+	rows, err := meta.Query(`
+		SELECT u."name", s."role"
+		FROM db_interface.sheet_shares s
+		JOIN db_interface.users u ON u.id = s.user_id
+		WHERE s.sheet_id = $1
+		ORDER BY u."name"`,
+		s.Id)
+	Check(err)
+	defer rows.Close()
+	shares := []Share{}
+	for rows.Next() {
+		share := Share{}
+		name := ""
+		Check(rows.Scan(&share.UserName, &name))
+		share.Role, err = ParseRole(name)
+		Check(err)
+		shares = append(shares, share)
+	}
+	Check(rows.Err())
+	return shares
+}
+
+// Creates a link which lets anyone view the sheet until expires
+func (s Sheet) CreateLink(expires time.Time) Link {
+	token := make([]byte, 24)
+	_, err := rand.Read(token)
+	Check(err)
+	link := Link{Token: hex.EncodeToString(token), Expires: expires.Truncate(time.Second)}
+	meta.MustExec(`
+		INSERT INTO db_interface.sheet_links (sheet_id, token, expires) VALUES ($1, $2, $3)`,
+		s.Id, link.Token, link.Expires.Unix())
+	log.Printf("Created a link to sheet %d expiring at %s", s.Id, link.Expires)
+	return link
+}
+
+// Lists the sheet's links which haven't expired by now
+func (s Sheet) Links(now time.Time) []Link {
+	rows, err := meta.Query(`
+		SELECT token, expires FROM db_interface.sheet_links
+		WHERE sheet_id = $1 AND expires > $2
+		ORDER BY expires`,
+		s.Id, now.Unix())
+	Check(err)
+	defer rows.Close()
+	links := []Link{}
+	for rows.Next() {
+		link := Link{}
+		var expires int64
+		Check(rows.Scan(&link.Token, &expires))
+		link.Expires = time.Unix(expires, 0)
+		links = append(links, link)
+	}
+	Check(rows.Err())
+	return links
+}
+
+func (s Sheet) DeleteLink(token string) {
+	meta.MustExec(`
+		DELETE FROM db_interface.sheet_links WHERE sheet_id = $1 AND token = $2`,
+		s.Id, token)
+}
+
+// Returns the id of the sheet the link is to, unless it has expired by now
+func LinkedSheetId(token string, now time.Time) (int, error) {
+	id := 0
+	err := meta.Get(&id, `
+		SELECT sheet_id FROM db_interface.sheet_links WHERE token = $1 AND expires > $2`,
+		token, now.Unix())
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("The link has expired")
+	}
+	return id, err
+}
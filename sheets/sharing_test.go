@@ -0,0 +1,121 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSharing(t *testing.T) {
+	setupTestDB(t, "items (id SERIAL PRIMARY KEY, name VARCHAR(255))")
+
+	ann, _ := SetPassword("ann", "secret")
+	bob, _ := SetPassword("bob", "secret")
+	cat, _ := SetPassword("cat", "secret")
+	sheet := Sheet{OwnerId: ann.Id}
+	sheet.SetTable("test.items")
+	legacy := Sheet{}
+	legacy.SetTable("test.items")
+
+	if sheet.Role(ann) != Owner || sheet.Role(bob) != NoRole || legacy.Role(bob) != Viewer {
+		t.Fatal("Unexpected roles before sharing")
+	}
+	if len(VisibleSheets(bob)) != 1 {
+		t.Errorf("Unexpected sheets visible to bob: %v", VisibleSheets(bob))
+	}
+
+	Check(sheet.Share("bob", Editor))
+	Check(sheet.Share("cat", Viewer))
+	if sheet.Role(bob) != Editor || sheet.Role(cat) != Viewer {
+		t.Errorf("Unexpected roles %s and %s", sheet.Role(bob), sheet.Role(cat))
+	}
+	if len(VisibleSheets(bob)) != 2 {
+		t.Errorf("Shared sheet not visible to bob: %v", VisibleSheets(bob))
+	}
+	if len(EditableSheets(bob)) != 1 || len(EditableSheets(cat)) != 0 {
+		t.Errorf("Unexpected sheets editable by bob and cat: %v, %v", EditableSheets(bob), EditableSheets(cat))
+	}
+	Check(sheet.Share("bob", Viewer))
+	if _, ok := EditableSheets(bob)[sheet.Id]; ok {
+		t.Error("Sheet still editable by bob as a viewer")
+	}
+	shares := sheet.Shares()
+	if len(shares) != 2 || shares[0] != (Share{"bob", Viewer}) || shares[1] != (Share{"cat", Viewer}) {
+		t.Errorf("Unexpected shares: %v", shares)
+	}
+	Check(sheet.Share("cat", NoRole))
+	if sheet.Role(cat) != NoRole || len(sheet.Shares()) != 1 {
+		t.Error("Sheet still shared with cat")
+	}
+	if sheet.Share("ann", Viewer) == nil || sheet.Share("dan", Viewer) == nil {
+		t.Error("Shared with the owner or a missing user")
+	}
+
+	viewed := sheet.WithRole(sheet.Role(bob))
+	if viewed.CanEdit() || viewed.Writable("test.items") || !viewed.ReadOnly() || viewed.CanShare() {
+		t.Error("Viewer can edit the sheet")
+	}
+	if !sheet.CanEdit() || !sheet.WithRole(Owner).CanShare() {
+		t.Error("Owner cannot edit the sheet")
+	}
+
+	// Links
+	now := time.Now()
+	link := sheet.CreateLink(now.Add(time.Hour))
+	expired := sheet.CreateLink(now.Add(-time.Hour))
+	id, err := LinkedSheetId(link.Token, now)
+	if err != nil || id != sheet.Id {
+		t.Errorf("Link to %d instead of %d: %v", id, sheet.Id, err)
+	}
+	_, err = LinkedSheetId(expired.Token, now)
+	if err == nil {
+		t.Error("Expired link accepted")
+	}
+	links := sheet.Links(now)
+	if len(links) != 1 || links[0] != link {
+		t.Errorf("Unexpected links: %v", links)
+	}
+	sheet.DeleteLink(link.Token)
+	_, err = LinkedSheetId(link.Token, now)
+	if err == nil {
+		t.Error("Deleted link accepted")
+	}
+
+	Store.delete(sheet.Id)
+	LoadSheets()
+	if loaded, _ := Store.Get(sheet.Id); loaded.OwnerId != ann.Id {
+		t.Errorf("Owner not loaded: %+v", loaded)
+	}
+
+	// Sheets without an owner are claimed, dropping what anyone could share of them
+	Check(legacy.Share("bob", Editor))
+	legacyLink := legacy.CreateLink(now.Add(time.Hour))
+	if _, err := ClaimSheets("dan"); err == nil {
+		t.Error("Claimed sheets for a missing user")
+	}
+	claimed, err := ClaimSheets("cat")
+	if err != nil || claimed != 1 {
+		t.Fatalf("Claimed %d sheets: %v", claimed, err)
+	}
+	legacy, _ = Store.Get(legacy.Id)
+	if legacy.Role(cat) != Owner || legacy.Role(bob) != NoRole {
+		t.Errorf("Unexpected roles %s and %s on a claimed sheet", legacy.Role(cat), legacy.Role(bob))
+	}
+	if _, err := LinkedSheetId(legacyLink.Token, now); err == nil {
+		t.Error("Link kept on a claimed sheet")
+	}
+	if sheet, _ := Store.Get(sheet.Id); sheet.OwnerId != ann.Id {
+		t.Errorf("Claimed a sheet which was already owned: %+v", sheet)
+	}
+}
@@ -13,18 +13,23 @@
 package sheets
 
 import (
+	"database/sql"
+	"fmt"
 	"log"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 )
 
 type SheetCell struct {
 	Cell
 	Formula string
+	// Set when the formula evaluates to an error, whose code is the value
+	Error *FormulaError `json:",omitempty"`
 }
 
 type SheetColumn struct {
-	Id int
+	Id    int
 	Name  string
 	Cells []SheetCell
 }
@@ -38,11 +43,33 @@ type Sheet struct {
 	PrefsMap   map[string]Pref
 	ExtraCols  []SheetColumn
 	RowCount   int
-	Cells	   [][][]Cell
+	Cells      [][][]Cell
+	// The name of the connection to the sheet's database. Empty means the default.
+	ConnectionName string
+	// The user who created the sheet. Zero for sheets created before sheets had owners.
+	OwnerId int
+	// Name of the time zone the sheet's dates are in, such as Europe/Paris. Empty means UTC.
+	Timezone string
+	// The user the sheet was opened for and their role, zero if it wasn't opened for anyone
+	user User
+	role Role
+	// The session's undo history, nil if changes aren't recorded
+	history *History
+	// The session's draft of changes to the sheet, nil unless in draft mode
+	draft *Draft
+	// Index of the first loaded row and the number of rows matching the filters
+	Offset    int
+	TotalRows int
+	rowCache  map[int][][]Cell
+	// Sort column values of the first and last loaded rows
+	firstKey, lastKey []Cell
+	evalDepth         int
+	// Spreadsheet cells whose formulas are being evaluated
+	evaluating map[cellRef]bool
+	// Spreadsheet cells on the page whose formulas are loaded but not yet evaluated
+	pending map[cellRef]bool
 }
 
-var SheetMap = make(map[int]Sheet)
-
 const defaultColNameChars string = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
 func (s Sheet) VisibleName() string {
@@ -52,27 +79,142 @@ func (s Sheet) VisibleName() string {
 	return s.Name
 }
 
+func (s Sheet) Connection() *Connection {
+	if s.ConnectionName == "" {
+		return defaultConnection()
+	}
+	return Connections[s.ConnectionName]
+}
+
+// Names the sheet's connection when there is more than one to choose from
+func (s Sheet) ConnectionLabel() string {
+	if len(Connections) < 2 {
+		return ""
+	}
+	return s.Connection().Name
+}
+
+// Returns the sheet as opened by a user with role
+func (s Sheet) WithRole(role Role) Sheet {
+	s.role = role
+	return s
+}
+
+// Returns the sheet as opened by user, whose database role it is queried as
+func (s Sheet) WithUser(user User) Sheet {
+	s.user = user
+	return s
+}
+
+// Runs the rest of tx as the database role of the user the sheet was opened for,
+// if its connection uses roles. Rolls tx back if the role can't be set.
+func (s Sheet) setRole(tx *sqlx.Tx) error {
+	if !s.Connection().UsesRoles || s.user.Id == 0 {
+		return nil
+	}
+	if s.user.DBRole == "" {
+		// Never guess a role from the user's name, which may come from an identity provider
+		Check(tx.Rollback())
+		return fmt.Errorf("%s has no database role: set one with relational-sheets --set-db-role %s <role>",
+			s.user.Name, s.user.Name)
+	}
+	err := s.Connection().dialect.setRole(tx, s.user.DBRole)
+	if err != nil {
+		Check(tx.Rollback())
+		return fmt.Errorf("Cannot run as database role %s: %w", s.user.DBRole, err)
+	}
+	return nil
+}
+
+// Runs the rest of tx as the connection's own user, undoing setRole
+func (s Sheet) resetRole(tx *sqlx.Tx) error {
+	if !s.Connection().UsesRoles || s.user.Id == 0 {
+		return nil
+	}
+	return s.Connection().dialect.resetRole(tx)
+}
+
+// Runs a query returning a single row as the user's database role, scanning
+// the row into dest
+func (s Sheet) queryRow(query string, args []interface{}, dest ...interface{}) error {
+	tx := s.Connection().Begin()
+	err := s.setRole(tx)
+	if err != nil {
+		return err
+	}
+	// Only reads, and a failed query would stop Postgres committing
+	defer tx.Rollback()
+	return tx.QueryRow(query, args...).Scan(dest...)
+}
+
+// Whether the sheet can be changed by the user it was opened for
+func (s Sheet) CanEdit() bool {
+	return s.role == NoRole || s.role >= Editor
+}
+
+func (s Sheet) CanShare() bool {
+	return s.role == NoRole || s.role == Owner
+}
+
+// Whether cells of the table can be edited and its rows inserted or deleted
+func (s Sheet) Writable(tableName string) bool {
+	return s.CanEdit() && s.Connection().Writable(tableName)
+}
+
+// Whether none of the sheet's tables can be written to
+func (s Sheet) ReadOnly() bool {
+	for _, tableName := range s.TableNames {
+		if s.Writable(tableName) {
+			return false
+		}
+	}
+	return true
+}
+
+// Adds a column to a metadata table created by an earlier version
+func addMetadataColumn(table, column, definition string) {
+	_, err := meta.Exec(fmt.Sprintf("SELECT %s FROM %s WHERE 1 = 0", column, table))
+	if err == nil {
+		return
+	}
+	meta.MustExec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	log.Printf("Added %s to %s", column, table)
+}
+
 func initSheetsTable() {
-	conn.MustExec(`
-		CREATE SCHEMA IF NOT EXISTS db_interface;
+	metaDialect.createSchema(meta, "db_interface")
+	meta.MustExec(fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS db_interface.sheets (
-			id SERIAL PRIMARY KEY
+			id %s PRIMARY KEY
 			, "name" VARCHAR(255) NOT NULL
 			, schemaname VARCHAR(255) NOT NULL
 			, tablename VARCHAR(255) NOT NULL
-		    , joinoids INTEGER ARRAY
-			, tablenames VARCHAR(255) ARRAY NOT NULL
-		)`)
+		    , joinoids %s
+			, tablenames %s NOT NULL
+			, connectionname VARCHAR(255) NOT NULL DEFAULT 'default'
+			, timezone VARCHAR(255) NOT NULL DEFAULT ''
+		)`,
+		metaDialect.sqlType("SERIAL"),
+		metaDialect.sqlType("INTEGER ARRAY"),
+		metaDialect.sqlType("VARCHAR(255) ARRAY")))
+	addMetadataColumn("db_interface.sheets", "connectionname", "VARCHAR(255) NOT NULL DEFAULT 'default'")
+	addMetadataColumn("db_interface.sheets", "timezone", "VARCHAR(255) NOT NULL DEFAULT ''")
 	log.Println("Sheets table exists")
 }
 
 func InitSheetsTables() {
 	initSheetsTable()
 	initExtraColsTables()
+	initUsersTable()
+	initSharingTables()
+	initAuditTable()
 }
 
 func (s Sheet) TableFullName() string {
-	if s.Table == nil {
+	if s.Table == nil && len(s.TableNames) > 0 {
+		// The table has been dropped
+		return s.TableNames[0]
+	} else if s.Table == nil {
 		return ""
 	}
 	return s.Table.FullName()
@@ -80,72 +222,98 @@ func (s Sheet) TableFullName() string {
 
 func (s *Sheet) SaveSheet() {
 	if s.Id == 0 {
-		row := conn.QueryRow(`
+		var err error
+		s.Id, err = metaDialect.insertReturningId(meta, `
 			INSERT INTO db_interface.sheets (
 				"name"
 				, schemaname
 				, tablename
 				, joinoids
 				, tablenames
+				, connectionname
+				, ownerid
+				, timezone
 			) VALUES (
-				$1, $2, $3, $4, $5
-			) RETURNING id`,
+				$1, $2, $3, $4, $5, $6, $7, $8
+			)`,
 			s.Name,
 			s.Table.SchemaName,
 			s.Table.TableName,
 			s.JoinOids,
-			s.TableNames)
-		err := row.Scan(&s.Id)
+			s.TableNames,
+			s.Connection().Name,
+			sql.NullInt64{Int64: int64(s.OwnerId), Valid: s.OwnerId != 0},
+			s.Timezone)
 		Check(err)
 		log.Printf("Inserted sheet %d", s.Id)
 	} else {
-		conn.MustExec(`
+		meta.MustExec(`
 			UPDATE db_interface.sheets SET
 				"name" = $1
 				, schemaname = $2
 				, tablename = $3
 			    , joinoids = $4
 				, tablenames = $5
-			WHERE id = $6`,
+				, timezone = $6
+			WHERE id = $7`,
 			s.Name,
 			s.Table.SchemaName,
 			s.Table.TableName,
 			s.JoinOids,
 			s.TableNames,
+			s.Timezone,
 			s.Id)
 		log.Printf("Updated sheet %d", s.Id)
 	}
-	SheetMap[s.Id] = *s
+	Store.put(*s)
+	s.installLiveTriggers()
 }
 
 func LoadSheets() {
-	loadTables()
-	rows, err := conn.Query(`
+	for _, c := range Connections {
+		c.loadTables()
+	}
+	rows, err := meta.Query(`
 		SELECT id
 		     , "name"
 		     , tableName
 		     , schemaname
 			 , joinoids
 			 , tablenames
+			 , connectionname
+			 , COALESCE(ownerid, 0)
+			 , timezone
 		FROM db_interface.sheets`)
 	Check(err)
+	loaded := []Sheet{}
 	for rows.Next() {
 		sheet := Sheet{}
 		var tableName, schemaName string
-		err = rows.Scan(&sheet.Id, &sheet.Name, &tableName, &schemaName, &sheet.JoinOids, &sheet.TableNames)
+		err = rows.Scan(&sheet.Id, &sheet.Name, &tableName, &schemaName, &sheet.JoinOids, &sheet.TableNames, &sheet.ConnectionName, &sheet.OwnerId, &sheet.Timezone)
 		Check(err)
-		sheet.Table = TableMap[schemaName+"."+tableName]
-		SheetMap[sheet.Id] = sheet
+		c := sheet.Connection()
+		if c == nil {
+			log.Printf("Skipping sheet %d on missing connection %s", sheet.Id, sheet.ConnectionName)
+			continue
+		}
+		sheet.Table = c.Table(schemaName + "." + tableName)
+		loaded = append(loaded, sheet)
+	}
+	Check(rows.Err())
+	for _, sheet := range loaded {
+		sheet.selectExtraCols()
+		Store.put(sheet)
+		sheet.installLiveTriggers()
 		log.Printf("Loaded sheet: %+v", sheet)
 	}
-	log.Printf("Loaded %d sheets", len(SheetMap))
+	log.Printf("Loaded %d sheets", len(loaded))
 }
 
 func (s *Sheet) SetTable(name string) {
 	if name == s.TableFullName() {
 		return
 	}
-	s.Table = TableMap[name]
+	s.Table = s.Connection().Table(name)
 	s.JoinOids = []int64{}
 	s.TableNames = []string{name}
 	s.SaveSheet()
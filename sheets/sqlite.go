@@ -0,0 +1,405 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"acb/db-interface/escape"
+	"acb/db-interface/fkeys"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"modernc.org/sqlite"
+)
+
+// Tables in the database file are in the "main" schema. Every other schema,
+// including db_interface, is a sidecar file next to it which is attached to
+// each connection, e.g. shop.db_interface.db for shop.db.
+// SQLite follows PostgreSQL for upserts, RETURNING and $1 parameters.
+type sqliteDialect struct{}
+
+// PRAGMA table_info types to the PostgreSQL names used elsewhere
+var sqliteTypes = map[string]string{
+	"int":               "integer",
+	"integer":           "integer",
+	"mediumint":         "integer",
+	"bigint":            "bigint",
+	"smallint":          "smallint",
+	"tinyint":           "smallint",
+	"decimal":           "numeric",
+	"numeric":           "numeric",
+	"real":              "real",
+	"float":             "double precision",
+	"double":            "double precision",
+	"double precision":  "double precision",
+	"char":              "character",
+	"character":         "character",
+	"varchar":           "character varying",
+	"character varying": "character varying",
+	"bool":              "boolean",
+	"datetime":          "timestamp without time zone",
+	"timestamp":         "timestamp without time zone",
+}
+
+func init() {
+	sqlx.BindDriver("sqlite", sqlx.DOLLAR)
+	sqlite.RegisterConnectionHook(attachSchemas)
+	sqlite.MustRegisterFunction("mul", &sqlite.FunctionImpl{
+		NArgs:         1,
+		Deterministic: true,
+		MakeAggregate: func(ctx sqlite.FunctionContext) (sqlite.AggregateFunction, error) {
+			return &productAggregate{product: 1}, nil
+		},
+	})
+}
+
+// Multiplies the non-NULL values, like db_interface.mul on PostgreSQL
+type productAggregate struct {
+	product float64
+}
+
+func (p *productAggregate) Step(ctx *sqlite.FunctionContext, args []driver.Value) error {
+	switch value := args[0].(type) {
+	case int64:
+		p.product *= float64(value)
+	case float64:
+		p.product *= value
+	case nil:
+	default:
+		return fmt.Errorf("Cannot multiply %v", value)
+	}
+	return nil
+}
+
+func (p *productAggregate) WindowInverse(ctx *sqlite.FunctionContext, args []driver.Value) error {
+	return fmt.Errorf("mul cannot be used as a window function")
+}
+
+func (p *productAggregate) WindowValue(ctx *sqlite.FunctionContext) (driver.Value, error) {
+	return p.product, nil
+}
+
+func (p *productAggregate) Final(ctx *sqlite.FunctionContext) {
+}
+
+func sqlitePath(url string) string {
+	return strings.TrimPrefix(url, "sqlite://")
+}
+
+func sidecarPath(path, schema string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + schema + ext
+}
+
+// Finds the schemas which have a sidecar file. The db_interface file is only
+// created when the metadata is saved alongside the database.
+func sidecarSchemas(path string) []string {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	schemas := []string{}
+	matches, err := filepath.Glob(stem + ".*" + ext)
+	Check(err)
+	for _, match := range matches {
+		schema := strings.TrimSuffix(strings.TrimPrefix(match, stem+"."), ext)
+		if !strings.ContainsAny(schema, ".\"") {
+			schemas = append(schemas, schema)
+		}
+	}
+	return schemas
+}
+
+func attachSchemas(conn sqlite.ExecQuerierContext, dsn string) error {
+	path, _, _ := strings.Cut(dsn, "?")
+	statements := []string{
+		"PRAGMA foreign_keys = ON",
+		"PRAGMA busy_timeout = 5000",
+	}
+	for _, schema := range sidecarSchemas(path) {
+		statements = append(statements, fmt.Sprintf(
+			`ATTACH DATABASE '%s' AS "%s"`,
+			strings.ReplaceAll(sidecarPath(path, schema), "'", "''"),
+			schema))
+	}
+	for _, statement := range statements {
+		_, err := conn.ExecContext(context.Background(), statement, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %w", statement, err)
+		}
+	}
+	return nil
+}
+
+func (sqliteDialect) Escape() escape.Dialect {
+	return escape.SQLite
+}
+
+func (sqliteDialect) open(url string) (*sqlx.DB, error) {
+	return sqlx.Open("sqlite", sqlitePath(url))
+}
+
+// SQLite has no read-only transactions, so query_only is set on the connection
+// for each transaction instead
+func (sqliteDialect) begin(db *sqlx.DB, readOnly bool) (*sqlx.Tx, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(fmt.Sprintf("PRAGMA query_only = %t", readOnly))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (sqliteDialect) setRole(tx *sqlx.Tx, role string) error {
+	return errors.New("SQLite has no database roles")
+}
+
+func (sqliteDialect) resetRole(tx *sqlx.Tx) error {
+	return nil
+}
+
+// Creates the schema's sidecar file, which new connections then attach
+func (sqliteDialect) createSchema(db *sqlx.DB, name string) {
+	path := ""
+	err := db.Get(&path, "SELECT file FROM pragma_database_list WHERE name = 'main'")
+	Check(err)
+	file, err := os.OpenFile(sidecarPath(path, name), os.O_RDONLY|os.O_CREATE, 0644)
+	Check(err)
+	Check(file.Close())
+	// Close the idle connections, which were opened without the schema
+	db.SetMaxIdleConns(0)
+	db.SetMaxIdleConns(2)
+}
+
+func (sqliteDialect) defaultSchema(db sqlx.Queryer) (string, error) {
+	return "main", nil
+}
+
+func (sqliteDialect) sqlType(pgType string) string {
+	switch {
+	case pgType == "SERIAL":
+		// An INTEGER PRIMARY KEY is generated from the rowid
+		return "INTEGER"
+	case strings.HasSuffix(pgType, " ARRAY"):
+		return "TEXT"
+	}
+	return pgType
+}
+
+// Foreign keys can only reference tables in the same file
+func (sqliteDialect) referencedTable(fullName string) string {
+	_, tableName, _ := strings.Cut(fullName, ".")
+	return tableName
+}
+
+func (sqliteDialect) upsert(conflictCols ...string) string {
+	return postgresDialect{}.upsert(conflictCols...)
+}
+
+func (sqliteDialect) insertReturningId(db sqlx.Ext, query string, args ...interface{}) (int, error) {
+	return postgresDialect{}.insertReturningId(db, query, args...)
+}
+
+// The mul aggregate is registered with the driver
+func (sqliteDialect) createAggregates(db *sqlx.DB) {
+}
+
+func (sqliteDialect) aggregate(function, expr string, hasAggregates bool) string {
+	if function == "PRODUCT" {
+		function = "mul"
+	}
+	return fmt.Sprintf("%s(%s)", function, expr)
+}
+
+// SQLite stores dates as text without time zones
+func (sqliteDialect) datePart(part, expr, timezone string) string {
+	if part == "serial" {
+		return fmt.Sprintf("(julianday(%s) - 2415018.5)", expr)
+	}
+	format := map[string]string{"year": "%Y", "month": "%m", "day": "%d", "dow": "%w"}[part]
+	return fmt.Sprintf("CAST(strftime('%s', %s) AS INTEGER)", format, expr)
+}
+
+func (sqliteDialect) insertRow(tx *sqlx.Tx, table *Table, values map[string]interface{}, returning []string) ([]interface{}, error) {
+	return insertReturning(tx, escape.SQLite, table, values, returning)
+}
+
+func (sqliteDialect) loadTables(db sqlx.Queryer) []Table {
+	tables := make([]Table, 0)
+	err := sqlx.Select(db, &tables, `
+		SELECT name tablename
+			, schema schemaname
+		FROM pragma_table_list
+		WHERE type = 'table'
+			AND schema NOT IN ('temp', 'db_interface')
+			AND name NOT LIKE 'sqlite_%'
+		ORDER BY schema, name DESC`)
+	Check(err)
+	for i, table := range tables {
+		tables[i].Oid = nameId(table.SchemaName, table.TableName)
+	}
+	return tables
+}
+
+// Every attached file counts its schema changes
+func (sqliteDialect) catalogFingerprint(db sqlx.Queryer) (string, error) {
+	schemas := []string{}
+	err := sqlx.Select(db, &schemas, `
+		SELECT name
+		FROM pragma_database_list
+		WHERE name NOT IN ('temp', 'db_interface')
+		ORDER BY name`)
+	if err != nil {
+		return "", err
+	}
+	versions := make([]string, len(schemas))
+	for i, schema := range schemas {
+		var version int
+		err = sqlx.Get(db, &version, fmt.Sprintf(`PRAGMA "%s".schema_version`, schema))
+		if err != nil {
+			return "", err
+		}
+		versions[i] = fmt.Sprintf("%s %d", schema, version)
+	}
+	return strings.Join(versions, ","), nil
+}
+
+type sqliteColumn struct {
+	Cid     int
+	Name    string
+	Type    string
+	NotNull bool    `db:"notnull"`
+	Default *string `db:"dflt_value"`
+	Pk      int
+}
+
+func sqliteTableInfo(tx *sqlx.Tx, schemaName, tableName string) []sqliteColumn {
+	cols := []sqliteColumn{}
+	err := tx.Select(&cols, `
+		SELECT cid, name, type, "notnull", dflt_value, pk
+		FROM pragma_table_info($1, $2)
+		ORDER BY cid`,
+		tableName,
+		schemaName)
+	Check(err)
+	return cols
+}
+
+func sqlitePrimaryKey(cols []sqliteColumn) []string {
+	primaryKey := []string{}
+	for pk := 1; pk <= len(cols); pk++ {
+		for _, col := range cols {
+			if col.Pk == pk {
+				primaryKey = append(primaryKey, col.Name)
+			}
+		}
+	}
+	return primaryKey
+}
+
+func (sqliteDialect) loadCols(tx *sqlx.Tx, table *Table) []Column {
+	info := sqliteTableInfo(tx, table.SchemaName, table.TableName)
+	// A lone INTEGER PRIMARY KEY is an alias for the generated rowid
+	rowid := len(sqlitePrimaryKey(info)) == 1
+	cols := make([]Column, len(info))
+	for i, col := range info {
+		declared, _, _ := strings.Cut(strings.ToLower(col.Type), "(")
+		declared = strings.TrimSpace(declared)
+		dataType, ok := sqliteTypes[declared]
+		if !ok {
+			dataType = declared
+		}
+		cols[i] = Column{
+			Name:       col.Name,
+			IsNullable: !col.NotNull,
+			HasDefault: col.Default != nil || rowid && col.Pk == 1 && declared == "integer",
+			DataType:   dataType,
+			Index:      col.Cid + 1,
+		}
+	}
+	return cols
+}
+
+// Lists the foreign keys from source, keyed by a unique id
+func sqliteForeignKeys(tx *sqlx.Tx, source *Table) map[int64]fkeys.ForeignKey {
+	rows, err := tx.Query(`
+		SELECT id, "table", "from", "to", on_delete
+		FROM pragma_foreign_key_list($1, $2)
+		ORDER BY id, seq`,
+		source.TableName,
+		source.SchemaName)
+	Check(err)
+	defer rows.Close()
+
+	sourceFkeys := make(map[int64]fkeys.ForeignKey)
+	for rows.Next() {
+		var id int
+		var targetTable, sourceCol, onDelete string
+		var targetCol *string
+		err = rows.Scan(&id, &targetTable, &sourceCol, &targetCol, &onDelete)
+		Check(err)
+		key := nameId(source.SchemaName, source.TableName, strconv.Itoa(id))
+		fkey := sourceFkeys[key]
+		fkey.SourceTableName = source.FullName()
+		fkey.TargetTableName = source.SchemaName + "." + targetTable
+		for name := range source.connection.Tables() {
+			if strings.EqualFold(name, fkey.TargetTableName) {
+				fkey.TargetTableName = name
+			}
+		}
+		fkey.SourceColNames = append(fkey.SourceColNames, sourceCol)
+		if targetCol != nil {
+			fkey.TargetColNames = append(fkey.TargetColNames, *targetCol)
+		}
+		fkey.OnDelete = onDelete
+		sourceFkeys[key] = fkey
+	}
+	Check(rows.Err())
+
+	// Foreign keys without target columns reference the primary key
+	for key, fkey := range sourceFkeys {
+		if len(fkey.TargetColNames) == 0 {
+			target := source.connection.Table(fkey.TargetTableName)
+			fkey.TargetColNames = sqlitePrimaryKey(sqliteTableInfo(tx, target.SchemaName, target.TableName))
+			sourceFkeys[key] = fkey
+		}
+	}
+	return sourceFkeys
+}
+
+func (sqliteDialect) loadConstraints(tx *sqlx.Tx, t *Table) ([]string, map[int64]fkeys.ForeignKey) {
+	primaryKey := sqlitePrimaryKey(sqliteTableInfo(tx, t.SchemaName, t.TableName))
+
+	tableFkeys := sqliteForeignKeys(tx, t)
+	for _, source := range t.connection.Tables() {
+		if source.SchemaName != t.SchemaName || source == t {
+			continue
+		}
+		for key, fkey := range sqliteForeignKeys(tx, source) {
+			if fkey.TargetTableName == t.FullName() {
+				tableFkeys[key] = fkey
+			}
+		}
+	}
+	log.Printf("Retrieved %d fkeys relating to %s (%d): %+v", len(tableFkeys), t.FullName(), t.Oid, tableFkeys)
+	return primaryKey, tableFkeys
+}
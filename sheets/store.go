@@ -0,0 +1,121 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"slices"
+	"sync"
+)
+
+// The saved sheets, shared by every request. Each request gets its own copy of a
+// sheet to load rows into, and changes to a sheet's definition are put back in the
+// store while holding the sheet's lock.
+type SheetStore struct {
+	lock   sync.RWMutex
+	sheets map[int]*storedSheet
+}
+
+type storedSheet struct {
+	// Held by the request changing the sheet
+	lock  sync.Mutex
+	sheet Sheet
+}
+
+var Store = newSheetStore()
+
+func newSheetStore() *SheetStore {
+	return &SheetStore{sheets: make(map[int]*storedSheet)}
+}
+
+// Returns the sheet's definition, without anything loaded by a request
+func (s Sheet) definition() Sheet {
+	extraCols := make([]SheetColumn, len(s.ExtraCols))
+	for i, col := range s.ExtraCols {
+		extraCols[i] = SheetColumn{Id: col.Id, Name: col.Name}
+	}
+	return Sheet{
+		Name:           s.Name,
+		Id:             s.Id,
+		Table:          s.Table,
+		JoinOids:       slices.Clone(s.JoinOids),
+		TableNames:     slices.Clone(s.TableNames),
+		ExtraCols:      extraCols,
+		ConnectionName: s.ConnectionName,
+		OwnerId:        s.OwnerId,
+		Timezone:       s.Timezone,
+	}
+}
+
+// Returns a copy of the sheet, which the caller can change without affecting anyone else
+func (store *SheetStore) Get(id int) (Sheet, bool) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	stored, ok := store.sheets[id]
+	if !ok {
+		return Sheet{}, false
+	}
+	return stored.sheet.definition(), true
+}
+
+// Returns copies of every sheet by id
+func (store *SheetStore) All() map[int]Sheet {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	all := make(map[int]Sheet, len(store.sheets))
+	for id, stored := range store.sheets {
+		all[id] = stored.sheet.definition()
+	}
+	return all
+}
+
+func (store *SheetStore) put(sheet Sheet) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	stored, ok := store.sheets[sheet.Id]
+	if !ok {
+		stored = &storedSheet{}
+		store.sheets[sheet.Id] = stored
+	}
+	stored.sheet = sheet.definition()
+}
+
+func (store *SheetStore) delete(id int) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.sheets, id)
+}
+
+// Locks the sheet until the returned function is called, so that requests changing
+// it take turns, each starting from the sheet as the last one left it
+func (store *SheetStore) Lock(id int) (unlock func()) {
+	store.lock.RLock()
+	stored, ok := store.sheets[id]
+	store.lock.RUnlock()
+	if !ok {
+		return func() {}
+	}
+	stored.lock.Lock()
+	return stored.lock.Unlock
+}
+
+// Points the sheets on the connection at its refreshed tables, or at nil if their
+// table was dropped
+func (store *SheetStore) refreshTables(c *Connection) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, stored := range store.sheets {
+		if stored.sheet.Connection() == c && len(stored.sheet.TableNames) > 0 {
+			stored.sheet.Table = c.Table(stored.sheet.TableNames[0])
+		}
+	}
+}
@@ -18,12 +18,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"sort"
-	"strconv"
-	"strings"
 
 	"github.com/jmoiron/sqlx"
-	"github.com/lib/pq"
 
 	"golang.org/x/exp/maps"
 )
@@ -36,6 +34,7 @@ type TableNames struct {
 type Column struct {
 	Name         string
 	IsNullable   bool
+	HasDefault   bool
 	DataType     string
 	IsPrimaryKey bool
 	Index        int
@@ -47,6 +46,9 @@ type Table struct {
 	Cols          map[string]Column
 	Fkeys         map[int64]fkeys.ForeignKey
 	Oid           int64
+	connection    *Connection
+	// Whether Cols, Fkeys and HasPrimaryKey have been loaded
+	loaded bool
 }
 
 type Cell struct {
@@ -54,10 +56,9 @@ type Cell struct {
 	NotNull bool
 }
 
-var TableMap = make(map[string]*Table)
-
-func (table Table) FullName() string {
-	return fmt.Sprintf("%s.%s", table.SchemaName, table.TableName)
+// Only the names are copied, since the rest of a Table is written while it loads
+func (names TableNames) FullName() string {
+	return fmt.Sprintf("%s.%s", names.SchemaName, names.TableName)
 }
 
 func (sheet Sheet) OrderedCols(tx *sqlx.Tx) [][]Column {
@@ -65,8 +66,12 @@ func (sheet Sheet) OrderedCols(tx *sqlx.Tx) [][]Column {
 	cols := make([][]Column, len(sheet.TableNames))
 
 	for i, tableName := range sheet.TableNames {
-		table := TableMap[tableName]
-		table.loadCols(tx)
+		table := sheet.Connection().Table(tableName)
+		if table == nil {
+			// Dropped since the sheet was loaded
+			continue
+		}
+		table.load(tx)
 		cols[i] = make([]Column, 0, len(table.Cols))
 		for _, col := range table.Cols {
 			if !sheet.PrefsMap[tableName+"."+col.Name].Hide {
@@ -78,7 +83,6 @@ func (sheet Sheet) OrderedCols(tx *sqlx.Tx) [][]Column {
 			indexK := sheet.PrefsMap[table.FullName()+"."+cols[i][k].Name].Index | cols[i][k].Index
 			return indexJ < indexK
 		})
-		table.loadConstraints(tx)
 	}
 
 	return cols
@@ -95,7 +99,7 @@ func (sheet *Sheet) sortedTablesAndReqCols(tx *sqlx.Tx) ([]string, map[string]ma
 		var join fkeys.ForeignKey
 		var joinFound bool
 		for _, potentialJoinTableName := range sheet.TableNames[:i+1] {
-			potentialJoinTable := TableMap[potentialJoinTableName]
+			potentialJoinTable := sheet.Connection().Table(potentialJoinTableName)
 			join, joinFound = potentialJoinTable.Fkeys[joinOid]
 			if joinFound {
 				break
@@ -123,221 +127,120 @@ func (sheet Sheet) GetCol(name string) Column {
 	return sheet.Table.Cols[name]
 }
 
-func loadTables() {
-	tables := make([]Table, 0)
-	err := conn.Select(&tables, `
-		SELECT COALESCE(tablename, '') tablename
-			, COALESCE(schemaname, '') schemaname
-			, oid
-		FROM pg_catalog.pg_tables
-		LEFT JOIN pg_catalog.pg_class
-			ON relname = tablename AND relkind = 'r'
-		WHERE schemaname != 'pg_catalog'
-			AND schemaname != 'information_schema'
-			AND schemaname != 'db_interface'
-		ORDER BY schemaname, tablename DESC`)
+// Returns the named table, or nil if the database has no such table
+func (c *Connection) Table(name string) *Table {
+	return c.Tables()[name]
+}
+
+// Returns the database's tables by full name. The map is replaced rather than
+// changed when the catalog is refreshed, so it can be read without locking.
+func (c *Connection) Tables() map[string]*Table {
+	c.catalogLock.RLock()
+	defer c.catalogLock.RUnlock()
+	return c.tableMap
+}
+
+func (c *Connection) loadTables() {
+	fingerprint, err := c.dialect.catalogFingerprint(c.db)
 	Check(err)
+	tables := c.dialect.loadTables(c.db)
+	tableMap := make(map[string]*Table, len(tables))
 	for i, table := range tables {
 		//log.Printf("Loading table %s (%d)", table.FullName(), table.Oid)
-		TableMap[table.FullName()] = &tables[i]
+		tables[i].connection = c
+		tableMap[table.FullName()] = &tables[i]
 	}
-	log.Printf("Retrieved %d Tables", len(TableMap))
+	c.catalogLock.Lock()
+	c.tableMap, c.fingerprint = tableMap, fingerprint
+	c.catalogLock.Unlock()
+	log.Printf("Retrieved %d Tables from %s", len(tableMap), c.Name)
 }
 
-func (table *Table) loadCols(tx *sqlx.Tx) {
-	if len(table.Cols) > 0 {
+// Loads the table's columns and constraints the first time it's used. Tables are
+// shared by every sheet on the connection, so they aren't changed once loaded.
+func (table *Table) load(tx *sqlx.Tx) {
+	c := table.connection
+	c.loadLock.Lock()
+	defer c.loadLock.Unlock()
+	if table.loaded {
 		return
 	}
-	table.Cols = make(map[string]Column)
 
 	if tx == nil {
-		tx = Begin()
+		tx = c.Begin()
 		defer Commit(tx)
 	}
 
-	cols := make([]Column, 0, 100)
-	err := tx.Select(&cols, `
-		SELECT column_name "name"
-			   , is_nullable = 'YES' isnullable
-			   , data_type datatype
-			   , ordinal_position "index"
-		FROM information_schema.columns
-		WHERE table_name = $1
-		AND table_schema = $2`,
-		table.TableName,
-		table.SchemaName)
-	Check(err)
+	cols := c.dialect.loadCols(tx, table)
 	log.Printf("Retrieved %d columns from %s", len(cols), table.FullName())
+	primaryKey, tableFkeys := c.dialect.loadConstraints(tx, table)
+	table.Cols = make(map[string]Column)
 	for _, col := range cols {
+		// Flag the primary keys in table.Cols
+		col.IsPrimaryKey = slices.Contains(primaryKey, col.Name)
 		table.Cols[col.Name] = col
 	}
-}
-
-func (t *Table) loadConstraints(tx *sqlx.Tx) {
-	if len(t.Fkeys) > 0 {
-		return
-	}
-	log.Printf("Loading constraints for table %s (%d)", t.FullName(), t.Oid)
-	t.Fkeys = make(map[int64]fkeys.ForeignKey)
-
-	if tx == nil {
-		tx = Begin()
-		defer Commit(tx)
-	}
-
-	// Query the primary keys, but returns IDs instead of column names
-	pKeyAttNums := pq.Int64Array{}
-	err := tx.Get(&pKeyAttNums, `
-		SELECT conkey
-		FROM pg_catalog.pg_constraint
-		WHERE conrelid = $1
-			AND contype = 'p'`,
-		t.Oid)
-	if err != nil && err.Error() != "sql: no rows in result set" {
-		panic(err)
-	}
-
-	// Query the foreign keys, but returns IDs instead of column names
-	rawFkeys := make([]struct {
-		Oid       int64
-		Conrelid  int64
-		Confrelid int64
-		// PostgreSQL integers are 64-bit, so there is no IntArray type
-		// This is why we use int64 for most ints here
-		Conkey  pq.Int64Array
-		Confkey pq.Int64Array
-	}, 0, 20)
-	err = tx.Select(&rawFkeys, `
-		SELECT oid
-		    , conrelid
-			, confrelid
-			, conkey
-			, confkey
-		FROM pg_catalog.pg_constraint
-		WHERE (conrelid = $1 OR confrelid = $1)
-			AND contype = 'f'`,
-		t.Oid)
-	Check(err)
-	log.Printf("Retrieved %d fkeys relating to %s (%d): %+v", len(rawFkeys), t.FullName(), t.Oid, rawFkeys)
-
-	// Get the names for each column referenced in an fkey
-	idsToNames := make(map[[2]int64]string)
-	relIds := make([]string, 1, 20)
-	relIds[0] = strconv.FormatInt(t.Oid, 10)
-	for _, rawFkey := range rawFkeys {
-		// may add duplicates but that's OK
-		relIds = append(
-			append(relIds,
-				strconv.FormatInt(rawFkey.Conrelid, 10)),
-			strconv.FormatInt(rawFkey.Confrelid, 10))
-	}
-	// Safe since we know relIds are from strconv.FormatInt
-	query := fmt.Sprintf(`
-		SELECT attrelid
-		    , attnum
-		    , attname
-		FROM pg_catalog.pg_attribute
-		WHERE attrelid IN (%s)`,
-		strings.Join(relIds, ","))
-	log.Println("Executing: " + query)
-	rows, err := tx.Query(query)
-	Check(err)
-	for rows.Next() {
-		var relId, attNum int64
-		var colName string
-		err := rows.Scan(&relId, &attNum, &colName)
-		Check(err)
-		idsToNames[[2]int64{relId, attNum}] = colName
-	}
-
-	// Flag the primary keys in t.Cols
-	t.loadCols(tx)
-	if len(pKeyAttNums) > 0 {
-		for _, attNum := range pKeyAttNums {
-			colName := idsToNames[[2]int64{t.Oid, attNum}]
-			col := t.Cols[colName]
-			col.IsPrimaryKey = true
-			t.Cols[colName] = col
-		}
-		t.HasPrimaryKey = true
-		log.Printf("Retrieved primary key for %s", t.FullName())
+	table.Fkeys = tableFkeys
+	table.HasPrimaryKey = len(primaryKey) > 0
+	if table.HasPrimaryKey {
+		log.Printf("Retrieved primary key for %s", table.FullName())
 	} else {
-		log.Printf("No primary key for %s", t.FullName())
+		log.Printf("No primary key for %s", table.FullName())
 	}
+	table.loaded = true
+}
 
-	// Populate t.Fkeys
-	for _, rawFkey := range rawFkeys {
-		fkey := fkeys.ForeignKey{}
-		if rawFkey.Conrelid == t.Oid {
-			fkey.SourceTableName = t.FullName()
-			for _, t2 := range TableMap {
-				if t2.Oid == rawFkey.Confrelid {
-					fkey.TargetTableName = t2.FullName()
-				}
-			}
-			for _, attnum := range rawFkey.Conkey {
-				fkey.SourceColNames = append(fkey.SourceColNames, idsToNames[[2]int64{rawFkey.Conrelid, attnum}])
-			}
-			for _, attnum := range rawFkey.Confkey {
-				fkey.TargetColNames = append(fkey.TargetColNames, idsToNames[[2]int64{rawFkey.Confrelid, attnum}])
-			}
-		} else {
-			if rawFkey.Confrelid != t.Oid {
-				panic("SQL WHERE clause violated -- unexpected table oid")
-			}
-
-			fkey.TargetTableName = t.FullName()
-			for _, t2 := range TableMap {
-				if t2.Oid == rawFkey.Conrelid {
-					fkey.SourceTableName = t2.FullName()
-				}
-			}
-			for _, attnum := range rawFkey.Conkey {
-				fkey.SourceColNames = append(fkey.SourceColNames, idsToNames[[2]int64{rawFkey.Conrelid, attnum}])
-			}
-			for _, attnum := range rawFkey.Confkey {
-				fkey.TargetColNames = append(fkey.TargetColNames, idsToNames[[2]int64{rawFkey.Confrelid, attnum}])
-			}
-		}
-
-		if fkey.SourceTableName == "" {
-			panic(fmt.Sprintf("Unexpected source table oid %d on %s (%d)", rawFkey.Conrelid, t.FullName(), t.Oid))
-		} else if fkey.TargetTableName == "" {
-			panic(fmt.Sprintf("Unexpected target table oid %d on %s (%d)", rawFkey.Confrelid, t.FullName(), t.Oid))
-		}
-
-		t.Fkeys[rawFkey.Oid] = fkey
+// Returns the named table with its columns and constraints loaded, or nil if there's no such table
+func (c *Connection) LoadedTable(name string) *Table {
+	table := c.Table(name)
+	if table == nil {
+		return nil
 	}
+	table.load(nil)
+	return table
 }
 
-func (sheet *Sheet) LoadJoins() {
-	tx := Begin()
+// Finds the tables joined by the sheet's JoinOids and loads their columns and
+// constraints. Fails if a table or join has been dropped since the sheet was saved.
+func (sheet *Sheet) LoadJoins() error {
+	c := sheet.Connection()
+	tableName := sheet.TableFullName()
+	if len(sheet.TableNames) > 0 {
+		tableName = sheet.TableNames[0]
+	}
+	table := c.Table(tableName)
+	if table == nil {
+		return fmt.Errorf("Table %s no longer exists", tableName)
+	}
+	sheet.Table = table
+	tables := c.Tables()
+	tx := c.Begin()
 	defer Commit(tx)
 
-	table := sheet.Table
 	sheet.TableNames = make([]string, 1+len(sheet.JoinOids))
 	sheet.TableNames[0] = table.FullName()
-	table.loadConstraints(tx)
+	table.load(tx)
 	for i, joinOid := range sheet.JoinOids {
 		joinFound := false
-		for _, tableName := range sheet.TableNames {
-			join, ok := TableMap[tableName].Fkeys[joinOid]
+		for _, tableName := range sheet.TableNames[:i+1] {
+			join, ok := tables[tableName].Fkeys[joinOid]
 			if ok {
 				joinFound = true
 				if join.SourceTableName == table.FullName() {
-					table = TableMap[join.TargetTableName]
+					table = tables[join.TargetTableName]
 				} else {
-					table = TableMap[join.SourceTableName]
+					table = tables[join.SourceTableName]
 				}
 				break
 			}
 		}
-		if !joinFound {
-			panic(fmt.Sprintf("Unable to load join %d on %s (have %v)", joinOid, table.FullName(), table.Fkeys))
+		if !joinFound || table == nil {
+			return fmt.Errorf("A join of %s no longer exists", sheet.TableNames[0])
 		}
 		sheet.TableNames[i+1] = table.FullName()
-		table.loadConstraints(tx)
+		table.load(tx)
 	}
+	return nil
 }
 
 func (sheet *Sheet) SetJoin(fkeyIndex int, oid int64) error {
@@ -347,7 +250,7 @@ func (sheet *Sheet) SetJoin(fkeyIndex int, oid int64) error {
 		sheet.TableNames = sheet.TableNames[:fkeyIndex+2]
 	}
 	for _, tableName := range sheet.TableNames {
-		table := TableMap[tableName]
+		table := sheet.Connection().Table(tableName)
 		fkey, ok := table.Fkeys[oid]
 		if ok {
 			if fkeyIndex >= len(sheet.JoinOids) {
@@ -362,8 +265,8 @@ func (sheet *Sheet) SetJoin(fkeyIndex int, oid int64) error {
 			}
 			log.Printf("Sheet now joins %v", sheet.TableNames)
 			sheet.SaveSheet()
-			newTable := TableMap[sheet.TableNames[fkeyIndex+1]]
-			newTable.loadConstraints(nil)
+			newTable := sheet.Connection().Table(sheet.TableNames[fkeyIndex+1])
+			newTable.load(nil)
 			return nil
 		} else {
 			log.Printf("no such fkey %d in %v", oid, table.Fkeys)
@@ -375,109 +278,292 @@ func (sheet *Sheet) SetJoin(fkeyIndex int, oid int64) error {
 func (sheet *Sheet) joins() []fkeys.ForeignKey {
 	joins := make([]fkeys.ForeignKey, len(sheet.JoinOids))
 	for i, joinOid := range sheet.JoinOids {
-		joins[i] = TableMap[sheet.TableNames[i+1]].Fkeys[joinOid]
+		joins[i] = sheet.Connection().Table(sheet.TableNames[i+1]).Fkeys[joinOid]
 	}
 	return joins
 }
 
-func (sheet *Sheet) LoadRows(limit int, offset int) error {
-	sheet.LoadJoins()
-	sheet.LoadPrefs()
-	cols := sheet.OrderedCols(nil)
-	sheet.Cells = make([][][]Cell, len(sheet.TableNames))
+// Returns the selected columns (each cast to text and followed by whether it is not null),
+// filters and sort order for the sheet's query
+func (sheet *Sheet) queryClauses(cols [][]Column) ([]escape.SafeSQL, []escape.SafeSQL, []escape.KeysetColumn, error) {
+	c := sheet.Connection()
 	casts := []escape.SafeSQL{}
-	orderExpressions := []escape.SafeSQL{}
+	order := []escape.KeysetColumn{}
 	filterClauses := []escape.SafeSQL{}
 	for i, tableName := range sheet.TableNames {
-		sheet.Cells[i] = make([][]Cell, len(cols[i]))
-		for j, col := range cols[i] {
-			sheet.Cells[i][j] = make([]Cell, 0, limit)
+		for _, col := range cols[i] {
 			name := tableName + "." + col.Name
-			cast, err := escape.MakeCast(name, "text", "")
-			if err != nil {
-				return err
-			}
-			casts = append(casts, cast)
-			cast, err = escape.MakeNotNull(name)
+			colCasts, err := c.textAndNotNull(name)
 			if err != nil {
-				return err
+				return nil, nil, nil, err
 			}
-			casts = append(casts, cast)
+			casts = append(casts, colCasts...)
 
 			pref := sheet.PrefsMap[name]
 			if pref.SortOn {
-				colOrder, err := escape.MakeOrderExpr(name, pref.Ascending)
-				if err != nil {
-					return err
-				}
-				orderExpressions = append(orderExpressions, colOrder)
+				order = append(order, escape.KeysetColumn{Identifier: name, Ascending: pref.Ascending})
 			}
 			if pref.Filter != "" {
-				filter, err := escape.MakeFilterClause(name, pref.Filter)
+				filter, err := c.esc().MakeFilterClause(name, pref.Filter)
 				if err != nil {
-					return err
+					return nil, nil, nil, err
 				}
 				filterClauses = append(filterClauses, filter)
 			}
 		}
 	}
 
-	query, err := escape.MakeSelectStmt(sheet.TableNames, sheet.joins(), casts, filterClauses, orderExpressions, true)
+	// Break ties with the primary keys so that rows keep their order between pages
+	for _, tableName := range sheet.TableNames {
+		for _, colName := range c.Tables()[tableName].primaryKeyNames() {
+			order = append(order, escape.KeysetColumn{Identifier: tableName + "." + colName, Ascending: true})
+		}
+	}
+	return casts, filterClauses, order, nil
+}
+
+func (c *Connection) textAndNotNull(name string) ([]escape.SafeSQL, error) {
+	cast, err := c.esc().MakeCast(name, "text", "")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	rows, err := conn.Queryx(query, limit, offset)
+	notNull, err := c.esc().MakeNotNull(name)
 	if err != nil {
-		return fmt.Errorf("Error running %s: %w", query, err)
+		return nil, err
 	}
+	return []escape.SafeSQL{cast, notNull}, nil
+}
 
-	sheet.RowCount = 0
-	for rows.Next() {
-		scanResult, err := rows.SliceScan()
+func (c *Connection) orderExpressions(order []escape.KeysetColumn, reverse bool) ([]escape.SafeSQL, error) {
+	expressions := make([]escape.SafeSQL, len(order))
+	for i, col := range order {
+		var err error
+		expressions[i], err = c.esc().MakeOrderExpr(col.Identifier, col.Ascending != reverse)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		index := 0
+	}
+	return expressions, nil
+}
+
+func (sheet *Sheet) selectQuery(cols [][]Column, limit bool) (string, error) {
+	casts, filterClauses, order, err := sheet.queryClauses(cols)
+	if err != nil {
+		return "", err
+	}
+	orderExprs, err := sheet.Connection().orderExpressions(order, false)
+	if err != nil {
+		return "", err
+	}
+	return sheet.Connection().esc().MakeSelectStmt(sheet.TableNames, sheet.joins(), casts, filterClauses, orderExprs, limit)
+}
+
+// Counts every row matching the sheet's filters
+func (sheet *Sheet) countRows(tx *sqlx.Tx, cols [][]Column) (int, error) {
+	_, filterClauses, _, err := sheet.queryClauses(cols)
+	if err != nil {
+		return 0, err
+	}
+	query, err := sheet.Connection().esc().MakeSelectStmt(sheet.TableNames, sheet.joins(), []escape.SafeSQL{escape.CountAll}, filterClauses, nil, false)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = tx.Get(&count, query)
+	if err != nil {
+		return 0, fmt.Errorf("Error running %s: %w", query, err)
+	}
+	return count, nil
+}
+
+// Converts the text and not null pairs selected by textAndNotNull into cells
+// Drivers differ in how they scan text and booleans into interface{}
+func scannedString(value interface{}) string {
+	if b, ok := value.([]byte); ok {
+		return string(b)
+	}
+	return value.(string)
+}
+
+func scannedBool(value interface{}) bool {
+	if n, ok := value.(int64); ok {
+		return n != 0
+	}
+	return value.(bool)
+}
+
+func scanCells(scanResult []interface{}, n int) []Cell {
+	cells := make([]Cell, n)
+	for i := range cells {
+		if scannedBool(scanResult[2*i+1]) {
+			cells[i] = Cell{scannedString(scanResult[2*i]), true}
+		}
+	}
+	return cells
+}
+
+// Splits a row selected by selectQuery into one slice of cells per table
+func splitRow(scanResult []interface{}, cols [][]Column) [][]Cell {
+	row := make([][]Cell, len(cols))
+	index := 0
+	for i := range cols {
+		row[i] = scanCells(scanResult[2*index:], len(cols[i]))
+		index += len(cols[i])
+	}
+	return row
+}
+
+func scanRow(rows *sqlx.Rows, cols [][]Column) ([][]Cell, error) {
+	scanResult, err := rows.SliceScan()
+	if err != nil {
+		return nil, err
+	}
+	return splitRow(scanResult, cols), nil
+}
+
+// Empties the loaded page, leaving room for limit rows
+func (sheet *Sheet) clearPage(cols [][]Column, limit int) {
+	sheet.Cells = make([][][]Cell, len(sheet.TableNames))
+	for i := range sheet.TableNames {
+		sheet.Cells[i] = make([][]Cell, len(cols[i]))
+		for j := range cols[i] {
+			sheet.Cells[i][j] = make([]Cell, 0, limit)
+		}
+	}
+}
+
+// Makes pageRows the loaded page, the first of which is at offset in the sheet's order
+func (sheet *Sheet) setPage(cols [][]Column, offset int, pageRows [][][]Cell) {
+	sheet.Offset = offset
+	sheet.RowCount = len(pageRows)
+	sheet.rowCache = make(map[int][][]Cell)
+	for _, row := range pageRows {
 		for i := range sheet.TableNames {
 			for j := range cols[i] {
-				val := ""
-				isNotNull := scanResult[2*index+1].(bool)
-				if isNotNull {
-					val = scanResult[2*index].(string)
-				}
-				sheet.Cells[i][j] = append(sheet.Cells[i][j], Cell{val, isNotNull})
-				index++
+				sheet.Cells[i][j] = append(sheet.Cells[i][j], row[i][j])
 			}
 		}
-		sheet.RowCount++
 	}
-	log.Printf("Retrieved %d rows from %s", sheet.RowCount, sheet.Table.FullName())
-	Check(rows.Close())
+}
 
-	sheet.loadExtraCols()
-	return nil
+func (sheet *Sheet) LoadRows(limit int, offset int) error {
+	return sheet.LoadPage(limit, offset, nil)
 }
 
-func (sheet *Sheet) InsertRow(tx *sqlx.Tx, tableName string, values map[string]string, returning []string) ([]interface{}, error) {
-	nonEmptyValues := prepareValues(values, false)
-	query, err := escape.MakeInsertStmt(tableName, nonEmptyValues, returning)
+// Loads up to limit rows, the first of which is at offset in the sheet's order.
+// If a cursor is given and the sheet can use keyset pagination, the rows are found
+// by comparing against the cursor instead of skipping the first offset rows.
+func (sheet *Sheet) LoadPage(limit int, offset int, cursor *Cursor) error {
+	return sheet.loadPage(limit, offset, cursor, true)
+}
+
+// Loads the page like LoadPage, but leaves its spreadsheet cells to be evaluated
+// when referenced, so that SetCell only evaluates the cells it needs
+func (sheet *Sheet) LoadPageFormulas(limit int, offset int, cursor *Cursor) error {
+	return sheet.loadPage(limit, offset, cursor, false)
+}
+
+func (sheet *Sheet) loadPage(limit int, offset int, cursor *Cursor, evaluate bool) error {
+	err := sheet.LoadJoins()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	log.Println("Values:", nonEmptyValues)
-	rows, err := tx.NamedQuery(query, nonEmptyValues)
+	sheet.LoadPrefs()
+	cols := sheet.OrderedCols(nil)
+	sheet.clearPage(cols, limit)
+
+	tx := sheet.Connection().Begin()
+	defer tx.Rollback()
+	err = sheet.setRole(tx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	var result []interface{}
-	if len(returning) > 0 {
-		if !rows.Next() {
-			panic("No ID returned by insert")
+	sheet.TotalRows, err = sheet.countRows(tx, cols)
+	if err != nil {
+		return err
+	}
+	casts, filterClauses, order, err := sheet.queryClauses(cols)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{limit, offset}
+	reverse := false
+	if cursor != nil && sheet.UsesKeyset() && (len(cursor.Values) == 0 || len(cursor.Values) == len(order)) {
+		keysetArgs, keysetClause, err := cursor.keyset(sheet.Connection(), order)
+		if err != nil {
+			return err
+		}
+		if len(cursor.Values) > 0 {
+			filterClauses = append(filterClauses, keysetClause)
+		}
+		reverse = cursor.Before
+		if reverse {
+			// Stop at offset when loading backwards, e.g. a short last page
+			args[0] = max(min(limit, sheet.TotalRows-offset), 0)
+		}
+		args[1] = 0
+		args = append(args, keysetArgs...)
+	}
+
+	// The sort columns are selected again to make cursors from the first and last rows
+	for _, col := range order {
+		colCasts, err := sheet.Connection().textAndNotNull(col.Identifier)
+		if err != nil {
+			return err
+		}
+		casts = append(casts, colCasts...)
+	}
+	orderExprs, err := sheet.Connection().orderExpressions(order, reverse)
+	if err != nil {
+		return err
+	}
+	query, err := sheet.Connection().esc().MakeSelectStmt(sheet.TableNames, sheet.joins(), casts, filterClauses, orderExprs, true)
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Queryx(query, args...)
+	if err != nil {
+		return fmt.Errorf("Error running %s: %w", query, err)
+	}
+
+	pageRows := [][][]Cell{}
+	keys := [][]Cell{}
+	for rows.Next() {
+		scanResult, err := rows.SliceScan()
+		if err != nil {
+			return err
 		}
-		result, err = rows.SliceScan()
+		pageRows = append(pageRows, splitRow(scanResult, cols))
+		keys = append(keys, scanCells(scanResult[len(casts)-2*len(order):], len(order)))
 	}
 	Check(rows.Close())
-	return result, err
+	Commit(tx)
+	if reverse {
+		slices.Reverse(pageRows)
+		slices.Reverse(keys)
+	}
+
+	sheet.setPage(cols, offset, pageRows)
+	sheet.firstKey, sheet.lastKey = nil, nil
+	if len(keys) > 0 {
+		sheet.firstKey, sheet.lastKey = keys[0], keys[len(keys)-1]
+	}
+	log.Printf("Retrieved %d rows from %s", sheet.RowCount, sheet.Table.FullName())
+
+	sheet.selectExtraCols()
+	if evaluate {
+		sheet.loadCells()
+	} else {
+		sheet.loadFormulas()
+	}
+	return nil
+}
+
+func (sheet *Sheet) InsertRow(tx *sqlx.Tx, tableName string, values map[string]string, returning []string) ([]interface{}, error) {
+	nonEmptyValues := prepareValues(values, false)
+	log.Println("Values:", nonEmptyValues)
+	table := sheet.Connection().Table(tableName)
+	return table.connection.dialect.insertRow(tx, table, nonEmptyValues, returning)
 }
 
 func addToNestedMap[V any](m map[string]map[string]V, k1, k2 string, v V) {
@@ -550,15 +636,41 @@ func prepareValues(values map[string]string, allowEmpty bool) map[string]interfa
 }
 
 func (sheet *Sheet) InsertMultipleRows(values map[string]map[string]string, referencedValues map[string]map[string]string) error {
-	tx := Begin()
-	defer Commit(tx)
+	tableNames := []string{}
+	for tableName, tableValues := range values {
+		if !isEmpty(tableValues) {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	tx, err := sheet.Connection().beginWrite(tableNames...)
+	if err != nil {
+		return err
+	}
+	err = sheet.setRole(tx)
+	if err != nil {
+		return err
+	}
+	inserted, err := sheet.insertMultipleRows(tx, values, referencedValues)
+	if err != nil {
+		Check(tx.Rollback())
+		return err
+	}
+	err = sheet.commitAudited(tx, sheet.auditEntries(AuditInsert, inserted))
+	if err == nil {
+		sheet.history.record(Change{sheet.Id, AuditInsert, inserted})
+	}
+	return err
+}
 
+// Inserts the rows and returns them with their primary keys
+func (sheet *Sheet) insertMultipleRows(tx *sqlx.Tx, values map[string]map[string]string, referencedValues map[string]map[string]string) ([]rowChange, error) {
 	tableNames, requiredCols, err := sheet.sortedTablesAndReqCols(tx)
 	log.Printf("Sorted tables: %v", tableNames)
 	log.Printf("Required cols: %v", requiredCols)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	inserted := []rowChange{}
 
 	for _, tableName := range tableNames {
 		tableValues := values[tableName]
@@ -578,42 +690,187 @@ func (sheet *Sheet) InsertMultipleRows(values map[string]map[string]string, refe
 		}
 
 		tableRequiredCols := maps.Keys(requiredCols[tableName])
-		row, err := sheet.InsertRow(tx, tableName, tableValues, tableRequiredCols)
+		// The primary key is returned too, to identify the row in the audit log and history
+		table := sheet.Connection().Table(tableName)
+		table.load(tx)
+		returning := slices.Clone(tableRequiredCols)
+		for _, colName := range table.primaryKeyNames() {
+			if !slices.Contains(returning, colName) {
+				returning = append(returning, colName)
+			}
+		}
+		row, err := sheet.InsertRow(tx, tableName, tableValues, returning)
+		if err != nil {
+			return nil, err
+		}
 		for i, colName := range tableRequiredCols {
 			log.Printf("Setting %s.%s to %s", tableName, colName, row[i].(string))
 			addToNestedMap(referencedValues, tableName, colName, row[i].(string))
 		}
-		Check(err)
+
+		primaryKeys := make(map[string]string)
+		for i, colName := range returning {
+			if table.Cols[colName].IsPrimaryKey {
+				primaryKeys[colName] = row[i].(string)
+			}
+		}
+		colNames := []string{}
+		for colName, value := range tableValues {
+			if value != "" {
+				colNames = append(colNames, colName)
+			}
+		}
+		slices.Sort(colNames)
+		newValues := make([]Cell, len(colNames))
+		for i, colName := range colNames {
+			newValues[i] = Cell{tableValues[colName], true}
+		}
+		if table.HasPrimaryKey {
+			// As the database wrote them, so they can be compared with later
+			newValues, err = table.selectRow(tx, colNames, primaryKeys)
+			if err != nil {
+				return nil, err
+			}
+		}
+		inserted = append(inserted, rowChange{tableName, primaryKeys, colNames, nil, newValues})
 	}
-	return nil
+	return inserted, nil
+}
+
+// Returned when a cell was changed in the database after the user loaded it
+type ConflictError struct {
+	TableName, ColName string
+	// The cell's value in the database
+	Current Cell
 }
 
-func (table *Table) updateRow(values map[string]string, primaryKeys map[string]string) error {
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s of %s has since been changed by someone else", e.ColName, e.TableName)
+}
+
+// Updates the row identified by primaryKeys, unless a column in originals no longer
+// has the value the user loaded. Returns a ConflictError if one doesn't.
+func (table *Table) updateRow(tx *sqlx.Tx, values map[string]string, primaryKeys map[string]string, originals map[string]Cell) error {
 	if len(primaryKeys) == 0 {
 		return errors.New("Cannot update table without primary key: " + table.FullName())
 	}
 
-	query, err := escape.MakeUpdateStmt(table.FullName(), values, primaryKeys)
+	expected := make(map[string]*string)
+	for colName, original := range originals {
+		expected[colName] = nil
+		if original.NotNull {
+			expected[colName] = &original.Value
+		}
+	}
+	query, err := table.connection.esc().MakeUpdateStmt(table.FullName(), values, primaryKeys, expected)
 	if err != nil {
 		return err
 	}
 	prepared := prepareValues(values, true)
+	for colName, value := range expected {
+		if value != nil {
+			// Empty strings are compared, not converted to NULL
+			prepared["original_"+colName] = *value
+		}
+	}
 	log.Println("Values:", prepared)
-	_, err = conn.NamedExec(query, prepared)
-	return err
+	result, err := tx.NamedExec(query, prepared)
+	if err != nil || len(originals) == 0 {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil || count > 0 {
+		return err
+	}
+
+	colNames := maps.Keys(originals)
+	slices.Sort(colNames)
+	current, err := table.selectRow(tx, colNames, primaryKeys)
+	if err != nil {
+		return err
+	}
+	for i, colName := range colNames {
+		if current[i] != originals[colName] {
+			return &ConflictError{table.FullName(), colName, current[i]}
+		}
+	}
+	return fmt.Errorf("Row %v of %s was not updated", primaryKeys, table.FullName())
 }
 
 func (sheet *Sheet) UpdateRows(values map[string]map[string]string, primaryKeys map[string]map[string]string) error {
-	log.Printf("UpdateRows(%v, %v)", values, primaryKeys)
+	_, err := sheet.updateRows(values, primaryKeys, nil)
+	return err
+}
+
+// Sets a cell of the row identified by primaryKeys, unless its value in the
+// database is no longer original. Returns the value the database stored.
+func (sheet *Sheet) UpdateCell(tableName, colName, value string, original Cell, primaryKeys map[string]string) (Cell, error) {
+	updated, err := sheet.updateRows(
+		map[string]map[string]string{tableName: {colName: value}},
+		map[string]map[string]string{tableName: primaryKeys},
+		map[string]map[string]Cell{tableName: {colName: original}})
+	if err != nil {
+		return Cell{}, err
+	}
+	return updated[0].NewValues[0], nil
+}
+
+// Tables whose values are all empty aren't updated, unless they have originals:
+// a cell which is cleared is set to NULL
+func updatedTables(values map[string]map[string]string, originals map[string]map[string]Cell) []string {
+	tableNames := []string{}
 	for tableName, tableValues := range values {
-		if isEmpty(tableValues) {
-			continue
+		if !isEmpty(tableValues) || originals[tableName] != nil {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	return tableNames
+}
+
+// Updates the rows in tx and returns their old and new values
+func (sheet *Sheet) updateRowsTx(tx *sqlx.Tx, values map[string]map[string]string, primaryKeys map[string]map[string]string, originals map[string]map[string]Cell) ([]rowChange, error) {
+	updated := []rowChange{}
+	for _, tableName := range updatedTables(values, originals) {
+		table := sheet.Connection().Table(tableName)
+		colNames := maps.Keys(values[tableName])
+		slices.Sort(colNames)
+		oldValues, err := table.selectRow(tx, colNames, primaryKeys[tableName])
+		newValues := []Cell{}
+		if err == nil {
+			err = table.updateRow(tx, values[tableName], primaryKeys[tableName], originals[tableName])
+		}
+		if err == nil {
+			newValues, err = table.selectRow(tx, colNames, primaryKeys[tableName])
 		}
-		table := TableMap[tableName]
-		err := table.updateRow(tableValues, primaryKeys[tableName])
 		if err != nil {
-			return err
+			return nil, err
 		}
+		updated = append(updated, rowChange{tableName, primaryKeys[tableName], colNames, oldValues, newValues})
 	}
-	return nil
+	return updated, nil
+}
+
+// Updates the rows in one transaction, checking the columns in originals weren't
+// changed since they were loaded
+func (sheet *Sheet) updateRows(values map[string]map[string]string, primaryKeys map[string]map[string]string, originals map[string]map[string]Cell) ([]rowChange, error) {
+	log.Printf("UpdateRows(%v, %v, %v)", values, primaryKeys, originals)
+	tx, err := sheet.Connection().beginWrite(updatedTables(values, originals)...)
+	if err != nil {
+		return nil, err
+	}
+	err = sheet.setRole(tx)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := sheet.updateRowsTx(tx, values, primaryKeys, originals)
+	if err != nil {
+		Check(tx.Rollback())
+		return nil, err
+	}
+	err = sheet.commitAudited(tx, sheet.auditEntries(AuditUpdate, updated))
+	if err != nil {
+		return nil, err
+	}
+	sheet.history.record(Change{sheet.Id, AuditUpdate, updated})
+	return updated, nil
 }
@@ -13,6 +13,9 @@
 package sheets
 
 import (
+	"encoding/json"
+	"errors"
+	"slices"
 	"testing"
 )
 
@@ -26,7 +29,7 @@ func TestSingleTableSheet(t *testing.T) {
 	sheet.LoadRows(100, 0)
 
 	// Insert
-	tx := Begin()
+	tx := defaultConnection().Begin()
 	row, err := sheet.InsertRow(tx, tableName, map[string]string{"name": "test"}, []string{"id"})
 	if err != nil {
 		t.Fatal(err)
@@ -41,10 +44,10 @@ func TestSingleTableSheet(t *testing.T) {
 	}
 
 	// Update
-	err = TableMap[tableName].updateRow(map[string]string{
-		"name": "test2",
-	}, map[string]string{
-		"id": "1",
+	err = sheet.UpdateRows(map[string]map[string]string{
+		tableName: {"name": "test2"},
+	}, map[string]map[string]string{
+		tableName: {"id": "1"},
 	})
 	if err != nil {
 		t.Error(err)
@@ -84,14 +87,14 @@ func TestMultiTableSheet(t *testing.T) {
 	SetupTablesDB()
 	defer teardownTablesDB()
 
-	customers := TableMap["test.customers"]
-	customers.loadConstraints(nil)
-	orders := TableMap["test.orders"]
-	orders.loadConstraints(nil)
-	products := TableMap["test.products"]
-	products.loadConstraints(nil)
-	order_products := TableMap["test.order_products"]
-	order_products.loadConstraints(nil)
+	customers := defaultConnection().Table("test.customers")
+	customers.load(nil)
+	orders := defaultConnection().Table("test.orders")
+	orders.load(nil)
+	products := defaultConnection().Table("test.products")
+	products.load(nil)
+	order_products := defaultConnection().Table("test.order_products")
+	order_products.load(nil)
 	sheet := Sheet{}
 	sheet.SetTable(customers.FullName())
 	sheet.JoinOids = make([]int64, 0, 100)
@@ -172,14 +175,14 @@ func TestAlternateJoinOrder(t *testing.T) {
 	SetupTablesDB()
 	defer teardownTablesDB()
 
-	customers := TableMap["test.customers"]
-	customers.loadConstraints(nil)
-	orders := TableMap["test.orders"]
-	orders.loadConstraints(nil)
-	products := TableMap["test.products"]
-	products.loadConstraints(nil)
-	order_products := TableMap["test.order_products"]
-	order_products.loadConstraints(nil)
+	customers := defaultConnection().Table("test.customers")
+	customers.load(nil)
+	orders := defaultConnection().Table("test.orders")
+	orders.load(nil)
+	products := defaultConnection().Table("test.products")
+	products.load(nil)
+	order_products := defaultConnection().Table("test.order_products")
+	order_products.load(nil)
 	sheet := Sheet{}
 	sheet.SetTable(orders.FullName())
 	sheet.JoinOids = make([]int64, 0, 100)
@@ -256,3 +259,181 @@ func TestAlternateJoinOrder(t *testing.T) {
 	}
 }
 
+func TestPagination(t *testing.T) {
+	SetupTablesDB()
+	defer teardownTablesDB()
+	LoadExampleData()
+
+	tableName := "test.customers"
+	sheet := Sheet{}
+	sheet.SetTable(tableName)
+	sheet.LoadPrefs()
+	sheet.SavePref(Pref{TableName: tableName, ColumnName: "id", SortOn: true, Ascending: true})
+	err := sheet.LoadRows(4, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sheet.RowCount != 4 || sheet.TotalRows != 9 || sheet.Cells[0][0][0].Value != "5" {
+		t.Fatalf("Unexpected page: %d of %d rows starting with %v", sheet.RowCount, sheet.TotalRows, sheet.Cells[0][0][0])
+	}
+	if sheet.PageSummary() != "Rows 5–8 of 9" || sheet.LastPageOffset(4) != 8 {
+		t.Errorf("Unexpected summary: %s", sheet.PageSummary())
+	}
+
+	// References outside the page are loaded from the database
+	cell, err := sheet.evalFormula("=test.customers.id1+test.customers.id9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cell.Value != "10" {
+		t.Errorf("Unexpected value for id1+id9: %s", cell.Value)
+	}
+	cell, err = sheet.evalFormula("=test.customers.id10")
+	if err != nil || cell.Value != codeRef {
+		t.Errorf("Unexpected value referencing a row past the end of the table: %s (%v)", cell.Value, err)
+	}
+
+	// Spreadsheet cells are saved by their row in the whole sheet
+	sheet.AddColumn("")
+	sheet.LoadRows(4, 4)
+	_, _, err = sheet.SetCell(0, 5, "6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet.LoadRows(4, 0)
+	cell, err = sheet.evalFormula("=A6*2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cell.Value != "12" {
+		t.Errorf("Unexpected value for A6*2 from the first page: %s", cell.Value)
+	}
+	sheet.LoadRows(4, 4)
+	if sheet.ExtraCols[0].Cells[1].Value != "6" {
+		t.Errorf("Unexpected cell on the second page: %v", sheet.ExtraCols[0].Cells[1])
+	}
+
+	// The total only counts filtered rows
+	sheet.SavePref(Pref{TableName: tableName, ColumnName: "name", Filter: "> 'E'"})
+	sheet.LoadRows(4, 0)
+	if sheet.TotalRows != 5 {
+		t.Errorf("Unexpected total with filter: %d", sheet.TotalRows)
+	}
+}
+
+func TestKeysetPagination(t *testing.T) {
+	SetupTablesDB()
+	defer teardownTablesDB()
+	LoadExampleData()
+
+	// Sort on a column with duplicates and NULLs so that the primary key breaks ties
+	defaultConnection().db.MustExec("UPDATE test.orders SET total = NULL WHERE id IN (3, 8)")
+	tableName := "test.orders"
+	sheet := Sheet{}
+	sheet.SetTable(tableName)
+	sheet.LoadPrefs()
+	sheet.SavePref(Pref{TableName: tableName, ColumnName: "total", SortOn: true, Ascending: false})
+
+	ids := func() []string {
+		values := []string{}
+		for _, cell := range sheet.Cells[0][0] {
+			values = append(values, cell.Value)
+		}
+		return values
+	}
+	expectPage := func(offset int) {
+		actual := ids()
+		err := sheet.LoadRows(5, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := ids()
+		if !slices.Equal(actual, expected) {
+			t.Fatalf("Keyset page at %d: %v != %v", offset, actual, expected)
+		}
+	}
+
+	err := sheet.LoadRows(5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sheet.UsesKeyset() {
+		t.Fatal("Keyset pagination not used on a table with a primary key")
+	}
+	next := func(cursor string, before bool) *Cursor {
+		parsed := Cursor{Before: before}
+		err := json.Unmarshal([]byte(cursor), &parsed.Values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &parsed
+	}
+
+	err = sheet.LoadPage(5, 5, next(sheet.PageCursor(true), false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPage(5)
+
+	err = sheet.LoadPage(5, 10, next(sheet.PageCursor(true), false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sheet.RowCount != 2 {
+		t.Fatalf("Unexpected number of rows on the last page: %d", sheet.RowCount)
+	}
+	expectPage(10)
+
+	err = sheet.LoadPage(5, 5, next(sheet.PageCursor(false), true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPage(5)
+
+	// Loading backwards with no cursor loads the last page
+	err = sheet.LoadPage(5, sheet.LastPageOffset(5), &Cursor{Before: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPage(10)
+}
+
+func TestUpdateCellConflict(t *testing.T) {
+	SetupTablesDB()
+	defer teardownTablesDB()
+
+	tableName := "test.customers"
+	sheet := Sheet{}
+	sheet.SetTable(tableName)
+	c := defaultConnection()
+	c.db.MustExec("INSERT INTO test.customers (name) VALUES ('ann')")
+	pks := map[string]string{"id": "1"}
+
+	stored, err := sheet.UpdateCell(tableName, "name", "bob", Cell{"ann", true}, pks)
+	if err != nil || stored != (Cell{"bob", true}) {
+		t.Fatalf("Unexpected result of updating: %v, %v", stored, err)
+	}
+
+	// Someone else changes the cell after it was loaded
+	c.db.MustExec("UPDATE test.customers SET name = 'cat' WHERE id = 1")
+	_, err = sheet.UpdateCell(tableName, "name", "dan", Cell{"bob", true}, pks)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.Current != (Cell{"cat", true}) {
+		t.Fatalf("Expected a conflict with cat, got %v", err)
+	}
+	name := ""
+	Check(c.db.Get(&name, "SELECT name FROM test.customers WHERE id = 1"))
+	if name != "cat" {
+		t.Errorf("Conflicting update overwrote the cell with %s", name)
+	}
+
+	// Clearing a cell sets it to NULL, which is then the original
+	stored, err = sheet.UpdateCell(tableName, "name", "", Cell{"cat", true}, pks)
+	if err != nil || stored.NotNull {
+		t.Fatalf("Unexpected result of clearing: %v, %v", stored, err)
+	}
+	_, err = sheet.UpdateCell(tableName, "name", "eve", Cell{}, pks)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,404 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xuri/efp"
+)
+
+// Minimum and maximum numbers of arguments of the text functions, where -1 means
+// any number
+var textFuncArgCounts = map[string][2]int{
+	"CONCAT":       {1, -1},
+	"LEFT":         {1, 2},
+	"RIGHT":        {1, 2},
+	"MID":          {3, 3},
+	"LEN":          {1, 1},
+	"UPPER":        {1, 1},
+	"LOWER":        {1, 1},
+	"TRIM":         {1, 1},
+	"SUBSTITUTE":   {3, 4},
+	"TEXT":         {2, 2},
+	"SPLIT":        {2, 3},
+	"REGEXEXTRACT": {2, 2},
+	"REGEXREPLACE": {3, 3},
+}
+
+func fromText(val string) Token {
+	return Token{Token: efp.Token{val, efp.TokenTypeOperand, efp.TokenSubTypeText}}
+}
+
+func (s *Sheet) evalText(arg []Token) (string, error) {
+	token, err := s.evalTokens(arg)
+	if err != nil {
+		return "", err
+	}
+	return token.TValue, nil
+}
+
+// Evaluates a number of characters, dropping any fraction as Excel does
+func (s *Sheet) evalLength(fName string, arg []Token) (int, error) {
+	token, err := s.evalTokens(arg)
+	if err != nil {
+		return 0, err
+	}
+	if !token.IsNumeric || token.TFloat < 0 {
+		return 0, formulaError(codeValue, "%s needs a number of characters, not %s", fName, token.TValue)
+	}
+	return int(math.Min(token.TFloat, math.MaxInt32)), nil
+}
+
+// Concatenates the arguments, which may be ranges with an end, row by row
+func (s *Sheet) evalConcat(arguments [][]Token) (Token, error) {
+	var builder strings.Builder
+	for _, arg := range arguments {
+		if len(arg) != 1 || arg[0].TSubType != efp.TokenSubTypeRange || !strings.Contains(arg[0].TValue, ":") {
+			text, err := s.evalText(arg)
+			if err != nil {
+				return Token{}, err
+			}
+			builder.WriteString(text)
+			continue
+		}
+
+		r, err := s.lookupRange(arg)
+		if err != nil {
+			return Token{}, err
+		}
+		if r.last == math.MaxInt-1 {
+			return Token{}, formulaError(codeValue, "CONCAT needs ranges with a last row, not %s", arg[0].TValue)
+		}
+		for k := 0; k <= r.last-r.first; k++ {
+			for i := range r.cols {
+				token, err := s.lookupCell(r, i, k)
+				if err != nil {
+					return Token{}, err
+				}
+				builder.WriteString(token.TValue)
+			}
+		}
+	}
+	return fromText(builder.String()), nil
+}
+
+// Replaces the instance-th occurrence of from in text with to, or every
+// occurrence if instance is 0
+func substitute(text, from, to string, instance int) string {
+	if instance == 0 || from == "" {
+		return strings.ReplaceAll(text, from, to)
+	}
+	offset := 0
+	for n := 1; ; n++ {
+		i := strings.Index(text[offset:], from)
+		if i < 0 {
+			return text
+		}
+		if n == instance {
+			return text[:offset+i] + to + text[offset+i+len(from):]
+		}
+		offset += i + len(from)
+	}
+}
+
+var numberFormatRegexp = regexp.MustCompile(`[0#][0#,]*(\.[0#]*)?`)
+
+// Formats val with an Excel number format such as 0.00, #,##0 or 0%. Text
+// around the digits, e.g. a currency symbol, is kept as it is.
+func formatNumber(val float64, format string) (string, error) {
+	loc := numberFormatRegexp.FindStringIndex(format)
+	if loc == nil {
+		return "", formulaError(codeValue, "unsupported format %s", format)
+	}
+	prefix, digits, suffix := format[:loc[0]], format[loc[0]:loc[1]], format[loc[1]:]
+	if strings.Contains(prefix+suffix, "%") {
+		val *= 100
+	}
+
+	integerPart, fractionPart, _ := strings.Cut(digits, ".")
+	// Halves are rounded away from zero, as in Excel
+	scale := math.Pow(10, float64(len(fractionPart)))
+	formatted := strconv.FormatFloat(math.Round(math.Abs(val)*scale)/scale, 'f', len(fractionPart), 64)
+	integer, fraction, _ := strings.Cut(formatted, ".")
+	minDigits := strings.Count(integerPart, "0")
+	if len(integer) < minDigits {
+		integer = strings.Repeat("0", minDigits-len(integer)) + integer
+	} else if minDigits == 0 && integer == "0" {
+		integer = ""
+	}
+	if strings.Contains(integerPart, ",") {
+		for i := len(integer) - 3; i > 0; i -= 3 {
+			integer = integer[:i] + "," + integer[i:]
+		}
+	}
+
+	// Optional fraction digits are dropped when they are zeros
+	required := strings.Count(fractionPart, "0")
+	for len(fraction) > required && fraction[len(fraction)-1] == '0' {
+		fraction = fraction[:len(fraction)-1]
+	}
+	if fraction != "" {
+		integer += "." + fraction
+	}
+	if val < 0 && strings.Trim(integer, "0.,") != "" {
+		integer = "-" + integer
+	}
+	return prefix + integer + suffix, nil
+}
+
+func isDateFormat(format string) bool {
+	return !strings.ContainsAny(format, "0#") && strings.ContainsAny(strings.ToLower(format), "ymdhs")
+}
+
+func lowerByte(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// A run of one of the letters of a date format, or a character copied as it is
+type dateFormatPart struct {
+	letter  byte
+	n       int
+	literal byte
+}
+
+// Formats t with an Excel date format such as yyyy-mm-dd, d mmmm yyyy or
+// h:mm AM/PM. As in Excel, m and mm are minutes after hours or before seconds,
+// and months otherwise.
+func formatDateText(t time.Time, format string) string {
+	parts := []dateFormatPart{}
+	twelveHour := false
+	for i := 0; i < len(format); {
+		c := lowerByte(format[i])
+		switch {
+		case len(format) >= i+5 && strings.EqualFold(format[i:i+5], "AM/PM"):
+			parts = append(parts, dateFormatPart{letter: 'a'})
+			twelveHour = true
+			i += 5
+		case strings.IndexByte("ymdhs", c) >= 0:
+			n := 1
+			for i+n < len(format) && lowerByte(format[i+n]) == c {
+				n++
+			}
+			parts = append(parts, dateFormatPart{letter: c, n: n})
+			i += n
+		default:
+			parts = append(parts, dateFormatPart{literal: format[i]})
+			i++
+		}
+	}
+
+	// The letter of the closest run before or after part k
+	neighbour := func(k, step int) byte {
+		for k += step; k >= 0 && k < len(parts); k += step {
+			if parts[k].letter != 0 {
+				return parts[k].letter
+			}
+		}
+		return 0
+	}
+	pad := func(n, width int) string {
+		if width > 1 {
+			return fmt.Sprintf("%02d", n)
+		}
+		return strconv.Itoa(n)
+	}
+
+	var builder strings.Builder
+	for k, part := range parts {
+		switch part.letter {
+		case 0:
+			builder.WriteByte(part.literal)
+		case 'a':
+			builder.WriteString(t.Format("PM"))
+		case 'y':
+			if part.n <= 2 {
+				builder.WriteString(t.Format("06"))
+			} else {
+				builder.WriteString(t.Format("2006"))
+			}
+		case 'd':
+			switch part.n {
+			case 1, 2:
+				builder.WriteString(pad(t.Day(), part.n))
+			case 3:
+				builder.WriteString(t.Format("Mon"))
+			default:
+				builder.WriteString(t.Format("Monday"))
+			}
+		case 'h':
+			hour := t.Hour()
+			if twelveHour {
+				hour = (hour+11)%12 + 1
+			}
+			builder.WriteString(pad(hour, part.n))
+		case 's':
+			builder.WriteString(pad(t.Second(), part.n))
+		case 'm':
+			switch {
+			case part.n <= 2 && (neighbour(k, -1) == 'h' || neighbour(k, 1) == 's'):
+				builder.WriteString(pad(t.Minute(), part.n))
+			case part.n <= 2:
+				builder.WriteString(pad(int(t.Month()), part.n))
+			case part.n == 3:
+				builder.WriteString(t.Format("Jan"))
+			default:
+				builder.WriteString(t.Format("January"))
+			}
+		}
+	}
+	return builder.String()
+}
+
+func (s *Sheet) evalTextFunction(fName string, arguments [][]Token) (Token, error) {
+	argCounts := textFuncArgCounts[fName]
+	if len(arguments) < argCounts[0] || argCounts[1] >= 0 && len(arguments) > argCounts[1] {
+		return Token{}, fmt.Errorf("wrong number of arguments for %s", fName)
+	}
+	if fName == "CONCAT" {
+		return s.evalConcat(arguments)
+	}
+
+	value, err := s.evalTokens(arguments[0])
+	if err != nil {
+		return Token{}, err
+	}
+	text := value.TValue
+	runes := []rune(text)
+
+	switch fName {
+	case "LEFT", "RIGHT":
+		n := 1
+		if len(arguments) > 1 {
+			n, err = s.evalLength(fName, arguments[1])
+			if err != nil {
+				return Token{}, err
+			}
+		}
+		n = min(n, len(runes))
+		if fName == "LEFT" {
+			return fromText(string(runes[:n])), nil
+		}
+		return fromText(string(runes[len(runes)-n:])), nil
+	case "MID":
+		start, err := s.evalPosition(fName, arguments[1])
+		if err != nil {
+			return Token{}, err
+		}
+		n, err := s.evalLength(fName, arguments[2])
+		if err != nil {
+			return Token{}, err
+		}
+		start = min(start-1, len(runes))
+		return fromText(string(runes[start : start+min(n, len(runes)-start)])), nil
+	case "LEN":
+		return fromFloat(float64(utf8.RuneCountInString(text))), nil
+	case "UPPER":
+		return fromText(strings.ToUpper(text)), nil
+	case "LOWER":
+		return fromText(strings.ToLower(text)), nil
+	case "TRIM":
+		return fromText(strings.Join(strings.Fields(text), " ")), nil
+	case "SUBSTITUTE":
+		from, err := s.evalText(arguments[1])
+		if err != nil {
+			return Token{}, err
+		}
+		to, err := s.evalText(arguments[2])
+		if err != nil {
+			return Token{}, err
+		}
+		instance := 0
+		if len(arguments) > 3 {
+			instance, err = s.evalPosition(fName, arguments[3])
+			if err != nil {
+				return Token{}, err
+			}
+		}
+		return fromText(substitute(text, from, to, instance)), nil
+	case "TEXT":
+		format, err := s.evalText(arguments[1])
+		if err != nil {
+			return Token{}, err
+		}
+		if isDateFormat(format) {
+			t, err := s.tokenDate(value)
+			if err != nil {
+				return Token{}, err
+			}
+			return fromText(formatDateText(t, format)), nil
+		}
+		val, err := value.number()
+		if err != nil {
+			return Token{}, err
+		}
+		formatted, err := formatNumber(val, format)
+		if err != nil {
+			return Token{}, err
+		}
+		return fromText(formatted), nil
+	case "SPLIT":
+		// A cell holds a single value, so SPLIT returns one of the parts
+		delimiter, err := s.evalText(arguments[1])
+		if err != nil {
+			return Token{}, err
+		}
+		if delimiter == "" {
+			return Token{}, formulaError(codeValue, "SPLIT needs a delimiter")
+		}
+		n := 1
+		if len(arguments) > 2 {
+			n, err = s.evalPosition(fName, arguments[2])
+			if err != nil {
+				return Token{}, err
+			}
+		}
+		parts := strings.Split(text, delimiter)
+		if n > len(parts) {
+			return Token{}, formulaError(codeRef, "%s has only %d parts", text, len(parts))
+		}
+		return fromText(parts[n-1]), nil
+	}
+
+	// Regular expressions
+	pattern, err := s.evalText(arguments[1])
+	if err != nil {
+		return Token{}, err
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return Token{}, formulaError(codeValue, "invalid regex: %s", err)
+	}
+	if fName == "REGEXREPLACE" {
+		replacement, err := s.evalText(arguments[2])
+		if err != nil {
+			return Token{}, err
+		}
+		return fromText(regex.ReplaceAllString(text, replacement)), nil
+	}
+	// The first group if there is one, as in Google Sheets
+	match := regex.FindStringSubmatch(text)
+	if match == nil {
+		return Token{}, formulaError(codeNA, "%s does not match %s", text, pattern)
+	}
+	return fromText(match[min(1, len(match)-1)]), nil
+}
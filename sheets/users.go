@@ -0,0 +1,143 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Someone who can log in, either with a password or through the OIDC provider
+type User struct {
+	Id   int
+	Name string
+	// The database role the user's queries run as on connections which use roles.
+	// Until it's set, such connections refuse to run queries for the user.
+	DBRole string
+}
+
+var errLogin = errors.New("Incorrect user name or password")
+
+// Compared against when there is no such user, so that it takes as long as a wrong password
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	Check(err)
+	return hash
+})
+
+func initUsersTable() {
+	meta.MustExec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS db_interface.users (
+			id %s PRIMARY KEY
+			, "name" VARCHAR(255) NOT NULL UNIQUE
+			, passwordhash VARCHAR(255)
+			, oidcsubject VARCHAR(255) UNIQUE
+			, dbrole VARCHAR(255)
+		)`,
+		metaDialect.sqlType("SERIAL")))
+	addMetadataColumn("db_interface.users", "dbrole", "VARCHAR(255)")
+	log.Println("Users table exists")
+}
+
+// Whether anyone can log in with a password
+func HasUsers() bool {
+	count := 0
+	Check(meta.Get(&count, "SELECT COUNT(*) FROM db_interface.users WHERE passwordhash IS NOT NULL"))
+	return count > 0
+}
+
+// Adds a user who logs in with a password, or changes their password
+func SetPassword(name, password string) (User, error) {
+	if name == "" || password == "" {
+		return User{}, errors.New("A user name and password are required")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+	user := User{Name: name}
+	user.Id, err = metaDialect.insertReturningId(meta, `
+		INSERT INTO db_interface.users ("name", passwordhash)
+		VALUES ($1, $2)
+		`+metaDialect.upsert("name")+`
+			passwordhash = $2`,
+		name, string(hash))
+	return user, err
+}
+
+// Returns the user if the password is theirs
+func Login(name, password string) (User, error) {
+	user := User{}
+	hash := sql.NullString{}
+	err := meta.QueryRowx(`
+		SELECT id, "name", COALESCE(dbrole, ''), passwordhash FROM db_interface.users WHERE "name" = $1`,
+		name).Scan(&user.Id, &user.Name, &user.DBRole, &hash)
+	if errors.Is(err, sql.ErrNoRows) || !hash.Valid {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return User{}, errLogin
+	}
+	Check(err)
+	if bcrypt.CompareHashAndPassword([]byte(hash.String), []byte(password)) != nil {
+		return User{}, errLogin
+	}
+	return user, nil
+}
+
+// Returns the user logged in through the OIDC provider as subject, adding them
+// under name the first time
+func OIDCUser(subject, name string) (User, error) {
+	user := User{}
+	err := meta.Get(&user, `
+		SELECT id, "name", COALESCE(dbrole, '') AS dbrole FROM db_interface.users WHERE oidcsubject = $1`,
+		subject)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return user, err
+	}
+	user.Name = name
+	user.Id, err = metaDialect.insertReturningId(meta, `
+		INSERT INTO db_interface.users ("name", oidcsubject) VALUES ($1, $2)`,
+		name, subject)
+	if err != nil {
+		return User{}, fmt.Errorf("Cannot add user %s: %w", name, err)
+	}
+	log.Printf("Added user %s for %s", name, subject)
+	return user, nil
+}
+
+func GetUser(id int) (User, error) {
+	user := User{}
+	err := meta.Get(&user, `
+		SELECT id, "name", COALESCE(dbrole, '') AS dbrole FROM db_interface.users WHERE id = $1`,
+		id)
+	return user, err
+}
+
+// Sets the database role the user's queries run as, or clears it if dbRole is empty
+func SetDBRole(name, dbRole string) error {
+	result, err := meta.Exec(`
+		UPDATE db_interface.users SET dbrole = $1 WHERE "name" = $2`,
+		sql.NullString{String: dbRole, Valid: dbRole != ""}, name)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n == 0 {
+		err = fmt.Errorf("No such user %s", name)
+	}
+	return err
+}
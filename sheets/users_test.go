@@ -0,0 +1,75 @@
+// This file is part of Relational Sheets.
+//
+// Relational Sheets is free software: you can redistribute it and/or modify it under the
+// terms of the GNU Affero General Public License as published by the Free Software Foundation,
+// either version 3 of the License, or (at your option) any later version.
+//
+// Relational Sheets is distributed in the hope that it will be useful, but WITHOUT ANY WARRANTY;
+// without even the implied warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+// See the GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with Relational Sheets.
+// If not, see https://www.gnu.org/licenses/agpl-3.0.html
+package sheets
+
+import (
+	"testing"
+)
+
+func TestUsers(t *testing.T) {
+	setupTestDB(t)
+	if HasUsers() {
+		t.Fatal("Users in a new database")
+	}
+
+	ann, err := SetPassword("ann", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !HasUsers() {
+		t.Error("Added user not found")
+	}
+	user, err := Login("ann", "secret")
+	if err != nil || user != ann {
+		t.Errorf("Logged in as %+v instead of %+v: %v", user, ann, err)
+	}
+	_, err = Login("ann", "wrong")
+	if err != errLogin {
+		t.Error("Logged in with the wrong password")
+	}
+	_, err = Login("bob", "secret")
+	if err != errLogin {
+		t.Error("Logged in as a missing user")
+	}
+
+	_, err = SetPassword("ann", "changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = Login("ann", "changed")
+	if err != nil || user != ann {
+		t.Errorf("Password change added %+v: %v", user, err)
+	}
+
+	// OIDC users have no password, and keep their name
+	bob, err := OIDCUser("https://idp.example.com 42", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = OIDCUser("https://idp.example.com 42", "bob@example.com")
+	if err != nil || user != bob {
+		t.Errorf("Logged in as %+v instead of %+v: %v", user, bob, err)
+	}
+	_, err = Login("bob", "")
+	if err != errLogin {
+		t.Error("Logged in without a password")
+	}
+	_, err = OIDCUser("https://idp.example.com 43", "ann")
+	if err == nil {
+		t.Error("Added a user with a taken name")
+	}
+	user, err = GetUser(bob.Id)
+	if err != nil || user != bob {
+		t.Errorf("Got %+v instead of %+v: %v", user, bob, err)
+	}
+}
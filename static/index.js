@@ -1,6 +1,16 @@
 let shiftPressed = false;
 document.addEventListener("keydown", function (event) {
     shiftPressed = event.shiftKey;
+    // Inputs keep their own undo
+    if (!(event.ctrlKey || event.metaKey) || event.target.tagName === "INPUT") {
+        return;
+    }
+    let key = event.key.toLowerCase();
+    let id = key === "y" || (key === "z" && event.shiftKey) ? "redo" : key === "z" ? "undo" : null;
+    if (id && document.getElementById(id)) {
+        event.preventDefault();
+        htmx.trigger("#" + id, "click");
+    }
 });
 document.addEventListener("keyup", function (event) {
     shiftPressed = false;
@@ -33,3 +43,86 @@ document.addEventListener("click", function (event) {
         elem.remove();
     });
 });
+
+// Shows the changes made to the open sheet by other people as they happen
+let refreshPending = false;
+function refreshRows() {
+    let focused = document.activeElement;
+    refreshPending = focused && focused.tagName === "INPUT" && focused.closest("#table") !== null;
+    if (!refreshPending) {
+        htmx.trigger("#refresh", "click");
+    }
+}
+function updateCell(row, tableName, colName, cell) {
+    let td = row.querySelector(`td[data-col="${CSS.escape(tableName + " " + colName)}"]`);
+    let input = td && td.querySelector("input[name=value]");
+    // Edits being typed or drafted are kept
+    if (!td || input === document.activeElement || (input && input.classList.contains("is-draft"))) {
+        return;
+    }
+    td.classList.toggle("is-null", !cell.NotNull);
+    td.querySelector(".width-control").textContent = cell.Value;
+    if (input) {
+        input.value = cell.Value;
+        input.classList.remove("is-danger", "is-warning");
+        let vals = JSON.parse(input.getAttribute("hx-vals"));
+        vals.original = cell.Value;
+        if (cell.NotNull) {
+            delete vals.original_null;
+        } else {
+            vals.original_null = "true";
+        }
+        input.setAttribute("hx-vals", JSON.stringify(vals));
+    } else {
+        td.querySelector(":scope > span:not(.width-control)").textContent = cell.Value;
+    }
+}
+// Shows the new values of spreadsheet cells after a cell they reference is edited
+document.addEventListener("cellsRecalculated", function (event) {
+    event.detail.cells.forEach(function (cell) {
+        let td = document.querySelector(`#table tr[data-row="${cell.j}"] td[data-extra-col="${cell.i}"]`);
+        if (!td) {
+            return;
+        }
+        td.classList.toggle("is-null", !cell.NotNull);
+        let value = td.querySelector(".extra-cell-value");
+        value.textContent = cell.Value;
+        value.classList.toggle("has-text-danger", cell.Error !== undefined);
+        if (cell.Error) {
+            value.title = cell.Error.Cause;
+        } else {
+            value.removeAttribute("title");
+        }
+    });
+});
+document.addEventListener("DOMContentLoaded", function () {
+    let sheetId = document.querySelector("body > [name=sheet_id]").value;
+    if (sheetId === "0") {
+        return;
+    }
+    let params = new URLSearchParams({ sheet_id: sheetId });
+    let link = document.querySelector("body > [name=link]");
+    if (link) {
+        params.set("link", link.value);
+    }
+    let events = new EventSource("/events?" + params);
+    events.addEventListener("cell", function (event) {
+        let change = JSON.parse(event.data);
+        document.querySelectorAll("#table tr.body-row").forEach(function (row) {
+            let matches = Object.entries(change.key).every(function ([name, value]) {
+                let pk = row.querySelector(`[name="${CSS.escape("pk-" + change.table + " " + name)}"]`);
+                return pk && pk.value === value;
+            });
+            if (matches) {
+                Object.entries(change.cells).forEach(([colName, cell]) => updateCell(row, change.table, colName, cell));
+            }
+        });
+    });
+    events.addEventListener("rows", refreshRows);
+});
+document.addEventListener("focusout", function (event) {
+    if (refreshPending && event.target.closest("#table")) {
+        // After the edit being left is posted
+        setTimeout(refreshRows, 500);
+    }
+});